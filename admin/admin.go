@@ -0,0 +1,592 @@
+// admin.go - HTTP admin API for inspecting the simulator
+package admin
+
+import (
+	"SPModbus/config"
+	"SPModbus/handler"
+	"SPModbus/mlog"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ConnectionInfo describes one connected Modbus client.
+type ConnectionInfo struct {
+	Remote       string    `json:"remote"`
+	ConnectedAt  time.Time `json:"connected_at"`
+	LastActivity time.Time `json:"last_activity"`
+	Requests     uint64    `json:"requests"`
+}
+
+// ConnectionLister reports the connected Modbus clients. It is implemented
+// by the Modbus server, which the admin package can't import.
+type ConnectionLister interface {
+	Connections() []ConnectionInfo
+}
+
+// Capabilities describes what the running simulator supports, derived from
+// its active configuration. Function codes and unit IDs are ints so they
+// encode as JSON numbers rather than base64 bytes.
+type Capabilities struct {
+	FunctionCodes        []int    `json:"function_codes"`
+	Units                []int    `json:"units"`
+	MultiUnit            bool     `json:"multi_unit"`
+	TLS                  bool     `json:"tls"`
+	Persistence          bool     `json:"persistence"`
+	Simulations          int      `json:"simulations"`
+	FaultInjection       []string `json:"fault_injection"`
+	DeviceIdentification bool     `json:"device_identification"`
+	ReadOnly             bool     `json:"read_only"`
+	Standby              bool     `json:"standby"`
+	Replica              bool     `json:"replica"`
+	Syslog               bool     `json:"syslog"`
+	WebSocket            bool     `json:"websocket"`
+}
+
+// CapabilityReporter reports the simulator's capabilities. Like
+// ConnectionLister it is implemented by the Modbus server.
+type CapabilityReporter interface {
+	Capabilities() Capabilities
+}
+
+// MetricsVersion identifies the layout of Metrics. It changes only when a
+// field is removed or changes meaning; consumers should ignore fields they
+// don't know.
+const MetricsVersion = 1
+
+// Metrics is a snapshot of the simulator's counters. The health logger, the
+// stats dump and GET /metrics are all built from it. Durations encode as
+// nanoseconds.
+type Metrics struct {
+	Version          int                 `json:"version"`
+	Timestamp        time.Time           `json:"timestamp"`
+	Uptime           time.Duration       `json:"uptime_ns"`
+	RequestsHandled  uint64              `json:"requests_handled"`
+	Errors           uint64              `json:"errors"`
+	CounterOverflows uint64              `json:"counter_overflows"`
+	Connections      int                 `json:"connections"`
+	Functions        map[string]uint64   `json:"functions"` // by function code, e.g. "0x03"
+	Units            map[int]UnitMetrics `json:"units"`
+	State            string              `json:"state"` // starting, degraded, running or stopped
+	StateSince       time.Time           `json:"state_since"`
+}
+
+// UnitMetrics holds the counters of one unit ID.
+type UnitMetrics struct {
+	RequestsHandled     uint64        `json:"requests_handled"`
+	Errors              uint64        `json:"errors"`
+	CounterOverflows    uint64        `json:"counter_overflows"`
+	FirstRequestLatency time.Duration `json:"first_request_latency_ns"` // 0 until the first request succeeded
+	Maintenance         bool          `json:"maintenance"`
+}
+
+// MetricsReporter takes metrics snapshots. Like ConnectionLister it is
+// implemented by the Modbus server.
+type MetricsReporter interface {
+	MetricsSnapshot() Metrics
+}
+
+// Health describes the Modbus server's lifecycle state and when it was
+// entered.
+type Health struct {
+	State string    `json:"state"` // starting, degraded, running or stopped
+	Since time.Time `json:"since"`
+}
+
+// HealthReporter reports the lifecycle state. Like ConnectionLister it is
+// implemented by the Modbus server.
+type HealthReporter interface {
+	Health() Health
+}
+
+type Server struct {
+	config       *config.Config
+	logger       *mlog.Logger
+	handler      *handler.ModbusHandler
+	connections  ConnectionLister
+	capabilities CapabilityReporter
+	metrics      MetricsReporter
+	health       HealthReporter
+	tunnel       Tunnel
+	http         *http.Server
+	annotations  map[annotationKey]config.RegisterAnnotation
+	stopping     chan struct{} // closed on Stop to end event streams
+}
+
+func NewServer(config *config.Config, handler *handler.ModbusHandler, logger *mlog.Logger) *Server {
+	s := &Server{
+		config:      config,
+		logger:      logger,
+		handler:     handler,
+		annotations: indexAnnotations(config.Modbus.Annotations),
+		stopping:    make(chan struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /info", s.handleInfo)
+	mux.HandleFunc("GET /registers/holding/raw", s.handleHoldingRaw)
+	mux.HandleFunc("GET /registers/map", s.handleRegisterMap)
+	mux.HandleFunc("GET /registers/{type}", s.handleRegisters)
+	mux.HandleFunc("GET /registers/holding/{addr}/history", s.handleHistory)
+	mux.HandleFunc("POST /registers/increment", s.handleIncrement)
+	mux.HandleFunc("GET /registers/protected", s.handleProtected)
+	mux.HandleFunc("GET /registers/by-name/{name}", s.handleAliasRead)
+	mux.HandleFunc("POST /registers/by-name/{name}", s.handleAliasWrite)
+	mux.HandleFunc("POST /registers/protect", s.handleProtect)
+	mux.HandleFunc("GET /connections", s.handleConnections)
+	mux.HandleFunc("GET /capabilities", s.handleCapabilities)
+	mux.HandleFunc("GET /metrics", s.handleMetrics)
+	mux.HandleFunc("GET /health", s.handleHealth)
+	mux.HandleFunc("GET /events/stream", s.handleEventStream)
+	mux.HandleFunc("GET /transaction", s.handleTransaction)
+	mux.HandleFunc("POST /transaction/{action}", s.handleTransactionAction)
+
+	routes := compress(mux)
+	if path := config.Admin.WebSocketPath; path != "" {
+		routes = s.tunnelAt(path, routes)
+	}
+	s.http = &http.Server{Handler: routes}
+	// Shutdown waits for active requests, which streams never finish on
+	// their own
+	s.http.RegisterOnShutdown(sync.OnceFunc(func() { close(s.stopping) }))
+	return s
+}
+
+// SetConnections sets the source of GET /connections. Without one the
+// endpoint reports no connections.
+func (s *Server) SetConnections(l ConnectionLister) {
+	s.connections = l
+}
+
+// SetCapabilities sets the source of GET /capabilities. Without one the
+// endpoint is unavailable.
+func (s *Server) SetCapabilities(c CapabilityReporter) {
+	s.capabilities = c
+}
+
+// SetMetrics sets the source of GET /metrics. Without one the endpoint is
+// unavailable.
+func (s *Server) SetMetrics(m MetricsReporter) {
+	s.metrics = m
+}
+
+// SetHealth sets the source of GET /health. Without one the endpoint is
+// unavailable.
+func (s *Server) SetHealth(h HealthReporter) {
+	s.health = h
+}
+
+// Handler returns the admin API routes, mainly for tests.
+func (s *Server) Handler() http.Handler {
+	return s.http.Handler
+}
+
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.config.Admin.Address)
+	if err != nil {
+		return fmt.Errorf("failed to start admin API: %w", err)
+	}
+
+	go func() {
+		if err := s.http.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("Admin API stopped", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+	}()
+
+	s.logger.Info("Admin API started", map[string]interface{}{
+		"address": listener.Addr().String(),
+	})
+	return nil
+}
+
+func (s *Server) Stop(ctx context.Context) error {
+	return s.http.Shutdown(ctx)
+}
+
+// handleInfo identifies the simulator instance.
+func (s *Server) handleInfo(w http.ResponseWriter, r *http.Request) {
+	stats := s.handler.GetStats()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"name":    s.config.Name,
+		"unit_id": s.config.Modbus.UnitID,
+		"uptime":  time.Since(stats.StartTime).String(),
+	})
+}
+
+// handleConnections lists the connected Modbus clients.
+func (s *Server) handleConnections(w http.ResponseWriter, r *http.Request) {
+	conns := []ConnectionInfo{}
+	if s.connections != nil {
+		conns = s.connections.Connections()
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"count":       len(conns),
+		"connections": conns,
+	})
+}
+
+// handleCapabilities reports the features of the running simulator.
+func (s *Server) handleCapabilities(w http.ResponseWriter, r *http.Request) {
+	if s.capabilities == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("capabilities not available"))
+		return
+	}
+	writeJSON(w, http.StatusOK, s.capabilities.Capabilities())
+}
+
+// handleHealth reports the lifecycle state, answering 503 unless the server
+// is running so load balancers and probes can use the status alone.
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if s.health == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("health not available"))
+		return
+	}
+	health := s.health.Health()
+	status := http.StatusOK
+	if health.State != "running" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, health)
+}
+
+// handleMetrics reports a snapshot of the simulator's counters.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if s.metrics == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("metrics not available"))
+		return
+	}
+	writeJSON(w, http.StatusOK, s.metrics.MetricsSnapshot())
+}
+
+// handleHoldingRaw returns the big-endian wire bytes of a holding register
+// range, inverted like client reads. The other register endpoints show stored
+// values.
+func (s *Server) handleHoldingRaw(w http.ResponseWriter, r *http.Request) {
+	addr, err := queryUint16(r, "addr", 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	count, err := queryUint16(r, "count", 1)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	raw, err := s.handler.WireHoldingRegisters(addr, count)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"address": addr,
+		"count":   count,
+		"hex":     hex.EncodeToString(raw),
+	})
+}
+
+// handleRegisters returns a register range, labeled and decoded according to
+// any configured annotations.
+func (s *Server) handleRegisters(w http.ResponseWriter, r *http.Request) {
+	regType := r.PathValue("type")
+
+	addr, err := queryUint16(r, "addr", 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	count, err := queryUint16(r, "count", 1)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var views []registerView
+	switch regType {
+	case "holding", "input":
+		regs, err := s.handler.ReadRegisters(regType, addr, count)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		for i, value := range regs {
+			views = append(views, s.registerView(regType, addr+uint16(i), value))
+		}
+	case "coil", "discrete":
+		bits, err := s.handler.ReadBits(regType, addr, count)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		for i, value := range bits {
+			views = append(views, s.bitView(regType, addr+uint16(i), value))
+		}
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown register type '%s'", regType))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"type":      regType,
+		"registers": views,
+	})
+}
+
+func (s *Server) registerView(regType string, addr uint16, value uint16) registerView {
+	view := registerView{Address: addr, Value: value}
+
+	a, ok := s.annotations[annotationKey{regType, addr}]
+	if !ok {
+		return view
+	}
+	view.Name = a.Name
+	view.DataType = a.DataType
+
+	if a.DataType != "" {
+		// 32-bit values need the following register even at the end of a range
+		words, err := s.handler.ReadRegisters(regType, addr, uint16(config.DataTypes[a.DataType]))
+		if err == nil {
+			view.Decoded = decodeValue(a.DataType, words)
+		}
+	}
+	return view
+}
+
+func (s *Server) bitView(regType string, addr uint16, value bool) registerView {
+	view := registerView{Address: addr, Value: value}
+	if a, ok := s.annotations[annotationKey{regType, addr}]; ok {
+		view.Name = a.Name
+	}
+	return view
+}
+
+// handleHistory returns the recent writes to a watched holding register.
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	addr, err := strconv.ParseUint(r.PathValue("addr"), 10, 16)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid address '%s'", r.PathValue("addr")))
+		return
+	}
+
+	entries, ok := s.handler.History(uint16(addr))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("register %d is not watched", addr))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"address": addr,
+		"history": entries,
+	})
+}
+
+// handleIncrement adds a delta to a holding register, e.g. to count events
+// triggered by an external controller.
+func (s *Server) handleIncrement(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Address *uint16 `json:"address"`
+		Delta   int     `json:"delta"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if req.Address == nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("missing address"))
+		return
+	}
+
+	value, err := s.handler.Increment(*req.Address, req.Delta)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.logger.Info("Register incremented via admin API", map[string]interface{}{
+		"address": *req.Address,
+		"delta":   req.Delta,
+		"value":   value,
+	})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"address": *req.Address,
+		"value":   value,
+	})
+}
+
+// handleProtected lists the holding registers locked against client writes.
+func (s *Server) handleProtected(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"protected": s.handler.ProtectedRegisters(),
+	})
+}
+
+// handleProtect locks or unlocks a holding register against client writes,
+// e.g. once a setting is commissioned.
+func (s *Server) handleProtect(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Address   *uint16 `json:"address"`
+		Protected bool    `json:"protected"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if req.Address == nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("missing address"))
+		return
+	}
+
+	if err := s.handler.SetProtected(*req.Address, req.Protected); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.logger.Info("Register protection changed via admin API", map[string]interface{}{
+		"address":   *req.Address,
+		"protected": req.Protected,
+	})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"address":   *req.Address,
+		"protected": req.Protected,
+	})
+}
+
+// handleAliasRead returns the register a configured alias names.
+func (s *Server) handleAliasRead(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	ref, value, err := s.handler.ReadAlias(name)
+	if err != nil {
+		writeAliasError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"name":    name,
+		"type":    ref.Type,
+		"address": ref.Address,
+		"value":   value,
+	})
+}
+
+// handleAliasWrite sets the register a configured alias names.
+func (s *Server) handleAliasWrite(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Value *uint16 `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if req.Value == nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("missing value"))
+		return
+	}
+
+	name := r.PathValue("name")
+	ref, err := s.handler.WriteAlias(name, *req.Value)
+	if err != nil {
+		writeAliasError(w, err)
+		return
+	}
+
+	s.logger.Info("Register written via admin API", map[string]interface{}{
+		"name":    name,
+		"type":    ref.Type,
+		"address": ref.Address,
+		"value":   *req.Value,
+	})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"name":    name,
+		"type":    ref.Type,
+		"address": ref.Address,
+		"value":   *req.Value,
+	})
+}
+
+// handleTransaction reports whether a register transaction is open.
+func (s *Server) handleTransaction(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"open": s.handler.InTx(),
+	})
+}
+
+// handleTransactionAction begins, commits or rolls back a register
+// transaction, so a test can set up a register state and undo it afterwards.
+func (s *Server) handleTransactionAction(w http.ResponseWriter, r *http.Request) {
+	action := r.PathValue("action")
+
+	var changed int
+	var err error
+	switch action {
+	case "begin":
+		err = s.handler.BeginTx()
+	case "commit":
+		changed, err = s.handler.Commit()
+	case "rollback":
+		changed, err = s.handler.Rollback()
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown transaction action '%s'", action))
+		return
+	}
+	if errors.Is(err, handler.ErrTxOpen) || errors.Is(err, handler.ErrNoTx) {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"action":  action,
+		"open":    action == "begin",
+		"changed": changed,
+	})
+}
+
+// writeAliasError answers 404 for an unknown alias and 400 otherwise.
+func writeAliasError(w http.ResponseWriter, err error) {
+	if errors.Is(err, handler.ErrUnknownAlias) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeError(w, http.StatusBadRequest, err)
+}
+
+func queryUint16(r *http.Request, name string, fallback uint16) (uint16, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	n, err := strconv.ParseUint(value, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s '%s'", name, value)
+	}
+	return uint16(n), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": err.Error(),
+	})
+}
@@ -0,0 +1,593 @@
+// admin_test.go - Admin API tests
+package admin
+
+import (
+	"SPModbus/config"
+	"SPModbus/handler"
+	"SPModbus/mlog"
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/simonvetter/modbus"
+)
+
+func newTestServer(t *testing.T, cfg config.ModbusConfig) *Server {
+	t.Helper()
+
+	logger, err := mlog.NewLogger(config.LoggingConfig{
+		Level:   "ERROR",
+		Console: false,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	t.Cleanup(logger.Close)
+
+	return NewServer(&config.Config{Modbus: cfg}, handler.NewModbusHandler(cfg, logger), logger)
+}
+
+func get(t *testing.T, s *Server, target string) (int, map[string]interface{}) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Invalid JSON response %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code, body
+}
+
+func post(t *testing.T, s *Server, target, body string) (int, map[string]interface{}) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, strings.NewReader(body)))
+
+	var res map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("Invalid JSON response %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code, res
+}
+
+// TestHoldingRaw tests the raw byte view of holding registers
+func TestHoldingRaw(t *testing.T) {
+	s := newTestServer(t, config.ModbusConfig{
+		UnitID:         1,
+		MaxRegisters:   200,
+		CounterAddress: 10,
+		InitialData: []config.RegisterValue{
+			{Type: "holding", Address: 100, Value: 0x4148},
+			{Type: "holding", Address: 101, Value: 0x0001},
+		},
+	})
+
+	t.Run("BigEndianHex", func(t *testing.T) {
+		code, body := get(t, s, "/registers/holding/raw?addr=100&count=2")
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %v", code, body)
+		}
+		if body["hex"] != "41480001" {
+			t.Fatalf("Expected hex 41480001, got %v", body["hex"])
+		}
+	})
+
+	t.Run("OutOfBounds", func(t *testing.T) {
+		code, _ := get(t, s, "/registers/holding/raw?addr=199&count=5")
+		if code != http.StatusBadRequest {
+			t.Fatalf("Expected 400 for out of bounds range, got %d", code)
+		}
+	})
+}
+
+// TestAnnotatedRegisters tests that annotated registers are labeled and decoded
+func TestAnnotatedRegisters(t *testing.T) {
+	s := newTestServer(t, config.ModbusConfig{
+		UnitID:         1,
+		MaxRegisters:   200,
+		CounterAddress: 10,
+		InitialData: []config.RegisterValue{
+			{Type: "holding", Address: 20, Value: 0xffce}, // -50
+			{Type: "holding", Address: 30, Value: 0x4148}, // 12.5 high word
+			{Type: "holding", Address: 31, Value: 0x0000},
+		},
+		Annotations: []config.RegisterAnnotation{
+			{Type: "holding", Address: 20, Name: "temperature", DataType: "int16"},
+			{Type: "holding", Address: 30, Name: "setpoint", DataType: "float32"},
+		},
+	})
+
+	t.Run("Int16", func(t *testing.T) {
+		_, body := get(t, s, "/registers/holding?addr=20")
+		reg := body["registers"].([]interface{})[0].(map[string]interface{})
+		if reg["name"] != "temperature" || reg["decoded"] != float64(-50) {
+			t.Fatalf("Expected temperature -50, got %v", reg)
+		}
+	})
+
+	t.Run("Float32AtRangeEnd", func(t *testing.T) {
+		_, body := get(t, s, "/registers/holding?addr=30&count=1")
+		reg := body["registers"].([]interface{})[0].(map[string]interface{})
+		if reg["name"] != "setpoint" || reg["decoded"] != 12.5 {
+			t.Fatalf("Expected setpoint 12.5, got %v", reg)
+		}
+	})
+
+	t.Run("UnknownType", func(t *testing.T) {
+		code, _ := get(t, s, "/registers/bogus")
+		if code != http.StatusNotFound {
+			t.Fatalf("Expected 404 for unknown type, got %d", code)
+		}
+	})
+}
+
+// TestIncrement tests the register increment endpoint
+func TestIncrement(t *testing.T) {
+	s := newTestServer(t, config.ModbusConfig{
+		UnitID:         1,
+		MaxRegisters:   200,
+		CounterAddress: 10,
+		InitialData: []config.RegisterValue{
+			{Type: "holding", Address: 100, Value: 65534},
+		},
+	})
+
+	t.Run("WrapAround", func(t *testing.T) {
+		code, body := post(t, s, "/registers/increment", `{"address": 100, "delta": 3}`)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %v", code, body)
+		}
+		if body["value"] != float64(1) {
+			t.Fatalf("Expected value 1, got %v", body["value"])
+		}
+	})
+
+	t.Run("OutOfBounds", func(t *testing.T) {
+		code, _ := post(t, s, "/registers/increment", `{"address": 200, "delta": 1}`)
+		if code != http.StatusBadRequest {
+			t.Fatalf("Expected 400 for out of bounds address, got %d", code)
+		}
+	})
+
+	t.Run("MissingAddress", func(t *testing.T) {
+		code, _ := post(t, s, "/registers/increment", `{"delta": 1}`)
+		if code != http.StatusBadRequest {
+			t.Fatalf("Expected 400 for missing address, got %d", code)
+		}
+	})
+}
+
+// TestRegisterMap tests the register map export
+func TestRegisterMap(t *testing.T) {
+	s := newTestServer(t, config.ModbusConfig{
+		UnitID:         1,
+		MaxRegisters:   200,
+		CounterAddress: 10,
+		InitialData: []config.RegisterValue{
+			{Type: "holding", Address: 100, Value: 2025},
+			{Type: "coil", Address: 3, Value: 1},
+		},
+		Annotations: []config.RegisterAnnotation{
+			{Type: "holding", Address: 100, Name: "setpoint", DataType: "uint16"},
+		},
+		Simulations: []config.SimulatedRegister{{Type: "input", Address: 20, Base: 500}},
+	})
+
+	export := func(format string) (int, string) {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/registers/map?format="+format, nil))
+		return rec.Code, rec.Body.String()
+	}
+
+	t.Run("CSV", func(t *testing.T) {
+		code, body := export("csv")
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", code, body)
+		}
+		want := []string{
+			"Type,Address,Initial,Value,Name,Data Type,Notes",
+			"holding,10,,0,,,counter (read-only)",
+			"holding,100,2025,2025,setpoint,uint16,",
+			"input,20,,0,,,\"simulated, base 500\"",
+			"coil,3,true,true,,,",
+		}
+		if got := strings.Split(strings.TrimSpace(body), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Fatalf("Expected:\n%s\ngot:\n%s", strings.Join(want, "\n"), body)
+		}
+	})
+
+	t.Run("Markdown", func(t *testing.T) {
+		code, body := export("")
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", code, body)
+		}
+		if !strings.Contains(body, "| holding | 100 | 2025 | 2025 | setpoint | uint16 |  |") {
+			t.Fatalf("Expected a Markdown row for register 100, got:\n%s", body)
+		}
+	})
+
+	t.Run("UnknownFormat", func(t *testing.T) {
+		if code, _ := export("xml"); code != http.StatusBadRequest {
+			t.Fatalf("Expected 400 for unknown format, got %d", code)
+		}
+	})
+}
+
+type fakeConnections []ConnectionInfo
+
+func (f fakeConnections) Connections() []ConnectionInfo { return f }
+
+// TestConnections tests the active connection listing
+func TestConnections(t *testing.T) {
+	cfg := config.ModbusConfig{UnitID: 1, MaxRegisters: 200, CounterAddress: 10}
+
+	t.Run("NoLister", func(t *testing.T) {
+		code, body := get(t, newTestServer(t, cfg), "/connections")
+		if code != http.StatusOK || body["count"] != float64(0) {
+			t.Fatalf("Expected empty listing, got %d %v", code, body)
+		}
+	})
+
+	t.Run("Listed", func(t *testing.T) {
+		connected := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		s := newTestServer(t, cfg)
+		s.SetConnections(fakeConnections{{
+			Remote:       "10.0.0.5:40000",
+			ConnectedAt:  connected,
+			LastActivity: connected.Add(time.Minute),
+			Requests:     42,
+		}})
+
+		code, body := get(t, s, "/connections")
+		if code != http.StatusOK || body["count"] != float64(1) {
+			t.Fatalf("Expected one connection, got %d %v", code, body)
+		}
+		conn := body["connections"].([]interface{})[0].(map[string]interface{})
+		if conn["remote"] != "10.0.0.5:40000" || conn["requests"] != float64(42) ||
+			conn["connected_at"] != "2024-05-01T12:00:00Z" || conn["last_activity"] != "2024-05-01T12:01:00Z" {
+			t.Fatalf("Unexpected connection entry %v", conn)
+		}
+	})
+}
+
+type fakeCapabilities Capabilities
+
+func (f fakeCapabilities) Capabilities() Capabilities { return Capabilities(f) }
+
+// TestCapabilities tests the capabilities endpoint
+func TestCapabilities(t *testing.T) {
+	cfg := config.ModbusConfig{UnitID: 1, MaxRegisters: 200, CounterAddress: 10}
+
+	t.Run("NoReporter", func(t *testing.T) {
+		if code, _ := get(t, newTestServer(t, cfg), "/capabilities"); code != http.StatusServiceUnavailable {
+			t.Fatalf("Expected 503, got %d", code)
+		}
+	})
+
+	t.Run("Reported", func(t *testing.T) {
+		s := newTestServer(t, cfg)
+		s.SetCapabilities(fakeCapabilities{
+			FunctionCodes:  []int{3, 4},
+			Units:          []int{1},
+			Persistence:    true,
+			FaultInjection: []string{"write_delay"},
+		})
+
+		code, body := get(t, s, "/capabilities")
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", code)
+		}
+		if fcs := body["function_codes"].([]interface{}); len(fcs) != 2 || fcs[0] != float64(3) {
+			t.Fatalf("Unexpected function codes %v", fcs)
+		}
+		if body["persistence"] != true || body["tls"] != false || body["multi_unit"] != false {
+			t.Fatalf("Unexpected capabilities %v", body)
+		}
+	})
+}
+
+type fakeMetrics Metrics
+
+func (f fakeMetrics) MetricsSnapshot() Metrics { return Metrics(f) }
+
+// TestMetrics tests the metrics endpoint
+func TestMetrics(t *testing.T) {
+	cfg := config.ModbusConfig{UnitID: 1, MaxRegisters: 200, CounterAddress: 10}
+
+	t.Run("NoReporter", func(t *testing.T) {
+		if code, _ := get(t, newTestServer(t, cfg), "/metrics"); code != http.StatusServiceUnavailable {
+			t.Fatalf("Expected 503, got %d", code)
+		}
+	})
+
+	t.Run("Reported", func(t *testing.T) {
+		s := newTestServer(t, cfg)
+		s.SetMetrics(fakeMetrics{
+			Version:         MetricsVersion,
+			RequestsHandled: 7,
+			Functions:       map[string]uint64{"0x03": 7},
+			Units:           map[int]UnitMetrics{1: {RequestsHandled: 7}},
+		})
+
+		code, body := get(t, s, "/metrics")
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", code)
+		}
+		if body["version"] != float64(MetricsVersion) || body["requests_handled"] != float64(7) {
+			t.Fatalf("Unexpected metrics %v", body)
+		}
+		units := body["units"].(map[string]interface{})
+		if u := units["1"].(map[string]interface{}); u["requests_handled"] != float64(7) {
+			t.Fatalf("Expected 7 requests on unit 1, got %v", u)
+		}
+	})
+}
+
+// TestProtect tests locking and unlocking a register through the admin API
+func TestProtect(t *testing.T) {
+	s := newTestServer(t, config.ModbusConfig{UnitID: 1, MaxRegisters: 200, CounterAddress: 10})
+
+	if code, _ := post(t, s, "/registers/protect", `{"address": 50, "protected": true}`); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	_, body := get(t, s, "/registers/protected")
+	if addrs := body["protected"].([]interface{}); len(addrs) != 1 || addrs[0] != float64(50) {
+		t.Fatalf("Expected register 50 protected, got %v", addrs)
+	}
+
+	if code, _ := post(t, s, "/registers/protect", `{"address": 50, "protected": false}`); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	_, body = get(t, s, "/registers/protected")
+	if addrs := body["protected"].([]interface{}); len(addrs) != 0 {
+		t.Fatalf("Expected no protected registers, got %v", addrs)
+	}
+
+	if code, _ := post(t, s, "/registers/protect", `{"protected": true}`); code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for a missing address, got %d", code)
+	}
+	if code, _ := post(t, s, "/registers/protect", `{"address": 500, "protected": true}`); code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for an out of range address, got %d", code)
+	}
+}
+
+// TestTransaction tests beginning, committing and rolling back a register
+// transaction
+func TestTransaction(t *testing.T) {
+	s := newTestServer(t, config.ModbusConfig{UnitID: 1, MaxRegisters: 200, CounterAddress: 10})
+
+	if code, _ := post(t, s, "/transaction/commit", ""); code != http.StatusConflict {
+		t.Fatalf("Expected 409 without a transaction, got %d", code)
+	}
+	if code, _ := post(t, s, "/transaction/begin", ""); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if code, _ := post(t, s, "/transaction/begin", ""); code != http.StatusConflict {
+		t.Fatalf("Expected 409 for a second transaction, got %d", code)
+	}
+	if _, body := get(t, s, "/transaction"); body["open"] != true {
+		t.Fatalf("Expected an open transaction, got %v", body)
+	}
+
+	if code, _ := post(t, s, "/registers/increment", `{"address": 50, "delta": 3}`); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	code, body := post(t, s, "/transaction/rollback", "")
+	if code != http.StatusOK || body["changed"] != float64(1) || body["open"] != false {
+		t.Fatalf("Expected 200 with 1 change, got %d %v", code, body)
+	}
+	if regs, _ := s.handler.ReadRegisters("holding", 50, 1); regs[0] != 0 {
+		t.Fatalf("Expected register 50 restored to 0, got %d", regs[0])
+	}
+
+	if code, _ := post(t, s, "/transaction/abort", ""); code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for an unknown action, got %d", code)
+	}
+}
+
+// TestAliases tests reading and writing registers by alias name
+func TestAliases(t *testing.T) {
+	s := newTestServer(t, config.ModbusConfig{
+		UnitID:         1,
+		MaxRegisters:   200,
+		CounterAddress: 10,
+		InitialData:    []config.RegisterValue{{Type: "input", Address: 30, Value: 215}},
+		Aliases: map[string]config.RegisterRef{
+			"temperature": {Type: "input", Address: 30},
+			"setpoint":    {Type: "holding", Address: 40},
+			"pump":        {Type: "coil", Address: 3},
+		},
+	})
+
+	t.Run("Read", func(t *testing.T) {
+		code, body := get(t, s, "/registers/by-name/temperature")
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", code)
+		}
+		if body["type"] != "input" || body["address"] != float64(30) || body["value"] != float64(215) {
+			t.Fatalf("Unexpected alias read %v", body)
+		}
+	})
+
+	t.Run("Write", func(t *testing.T) {
+		if code, _ := post(t, s, "/registers/by-name/setpoint", `{"value": 180}`); code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", code)
+		}
+		if regs, _ := s.handler.ReadRegisters("holding", 40, 1); regs[0] != 180 {
+			t.Fatalf("Expected holding 40 to be 180, got %d", regs[0])
+		}
+		if _, body := get(t, s, "/registers/by-name/setpoint"); body["value"] != float64(180) {
+			t.Fatalf("Expected 180 read back by name, got %v", body["value"])
+		}
+	})
+
+	t.Run("WriteCoil", func(t *testing.T) {
+		if code, _ := post(t, s, "/registers/by-name/pump", `{"value": 1}`); code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", code)
+		}
+		if bits, _ := s.handler.ReadBits("coil", 3, 1); !bits[0] {
+			t.Fatal("Expected coil 3 to be on")
+		}
+		if code, _ := post(t, s, "/registers/by-name/pump", `{"value": 2}`); code != http.StatusBadRequest {
+			t.Fatalf("Expected 400 for a coil value of 2, got %d", code)
+		}
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		code, body := get(t, s, "/registers/by-name/pressure")
+		if code != http.StatusNotFound {
+			t.Fatalf("Expected 404, got %d", code)
+		}
+		if body["error"] != "unknown register alias 'pressure'" {
+			t.Fatalf("Unexpected error %v", body["error"])
+		}
+		if code, _ := post(t, s, "/registers/by-name/pressure", `{"value": 1}`); code != http.StatusNotFound {
+			t.Fatalf("Expected 404 for a write, got %d", code)
+		}
+	})
+}
+
+// TestEventStream tests that writes are pushed to a connected event stream
+func TestEventStream(t *testing.T) {
+	s := newTestServer(t, config.ModbusConfig{
+		UnitID:         1,
+		MaxRegisters:   200,
+		CounterAddress: 10,
+	})
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/events/stream?addr=100&count=10")
+	if err != nil {
+		t.Fatalf("Failed to open event stream: %v", err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected text/event-stream, got %q", ct)
+	}
+
+	// The stream is subscribed once the headers arrive
+	for _, addr := range []uint16{50, 105} {
+		if err := s.handler.SetRegister("holding", addr, 7); err != nil {
+			t.Fatalf("Failed to set register: %v", err)
+		}
+		if _, err := s.handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+			UnitId: 1, Addr: addr, Quantity: 1, IsWrite: true, Args: []uint16{42},
+		}); err != nil {
+			t.Fatalf("Failed to write register %d: %v", addr, err)
+		}
+	}
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	var e handler.Event
+	for e.Type == "" {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("Event stream closed before an event arrived")
+			}
+			if data, ok := strings.CutPrefix(line, "data: "); ok {
+				if err := json.Unmarshal([]byte(data), &e); err != nil {
+					t.Fatalf("Invalid event %q: %v", data, err)
+				}
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for an event")
+		}
+	}
+
+	if e.Type != handler.EventRegisterWrite || e.Address != 105 || e.Old != 7 || e.Value != 42 {
+		t.Fatalf("Expected register_write of 105 from 7 to 42, got %+v", e)
+	}
+}
+
+// TestCompression tests that large responses are gzip-encoded for clients
+// accepting it
+func TestCompression(t *testing.T) {
+	s := newTestServer(t, config.ModbusConfig{
+		UnitID:         1,
+		MaxRegisters:   1000,
+		CounterAddress: 10,
+	})
+
+	fetch := func(target, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("LargeSnapshotGzip", func(t *testing.T) {
+		rec := fetch("/registers/holding?addr=0&count=500", "gzip, deflate")
+		if enc := rec.Header().Get("Content-Encoding"); enc != "gzip" {
+			t.Fatalf("Expected gzip encoding, got %q", enc)
+		}
+
+		zr, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Fatalf("Invalid gzip response: %v", err)
+		}
+		var body map[string]interface{}
+		if err := json.NewDecoder(zr).Decode(&body); err != nil {
+			t.Fatalf("Invalid JSON in gzip response: %v", err)
+		}
+		if regs := body["registers"].([]interface{}); len(regs) != 500 {
+			t.Fatalf("Expected 500 registers, got %d", len(regs))
+		}
+	})
+
+	t.Run("Deflate", func(t *testing.T) {
+		rec := fetch("/registers/holding?addr=0&count=500", "deflate")
+		if enc := rec.Header().Get("Content-Encoding"); enc != "deflate" {
+			t.Fatalf("Expected deflate encoding, got %q", enc)
+		}
+		zr, err := zlib.NewReader(rec.Body)
+		if err != nil {
+			t.Fatalf("Expected a zlib-wrapped deflate response: %v", err)
+		}
+		var body map[string]interface{}
+		if err := json.NewDecoder(zr).Decode(&body); err != nil {
+			t.Fatalf("Invalid JSON in deflate response: %v", err)
+		}
+	})
+
+	t.Run("SmallResponseUncompressed", func(t *testing.T) {
+		rec := fetch("/info", "gzip")
+		if enc := rec.Header().Get("Content-Encoding"); enc != "" {
+			t.Fatalf("Expected no encoding for a small response, got %q", enc)
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Invalid JSON response %q: %v", rec.Body.String(), err)
+		}
+	})
+
+	t.Run("NotAccepted", func(t *testing.T) {
+		for _, accept := range []string{"", "gzip;q=0", "br"} {
+			rec := fetch("/registers/holding?addr=0&count=500", accept)
+			if enc := rec.Header().Get("Content-Encoding"); enc != "" {
+				t.Fatalf("Expected no encoding for Accept-Encoding %q, got %q", accept, enc)
+			}
+		}
+	})
+}
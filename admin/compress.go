@@ -0,0 +1,143 @@
+// compress.go - gzip/deflate compression of large admin API responses
+package admin
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// compressMinSize is the smallest response worth compressing; shorter ones
+// are sent as is.
+const compressMinSize = 1024
+
+// compress wraps next so responses of at least compressMinSize bytes are
+// gzip or deflate encoded for clients accepting it. Streamed responses are
+// left alone: a flush before the threshold sends the response uncompressed.
+func compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// acceptedEncoding picks gzip or deflate from an Accept-Encoding header,
+// preferring gzip, or returns "" when neither is accepted.
+func acceptedEncoding(header string) string {
+	var deflate bool
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "gzip":
+			return "gzip"
+		case "deflate":
+			deflate = true
+		}
+	}
+	if deflate {
+		return "deflate"
+	}
+	return ""
+}
+
+// compressWriter holds back the response until it reaches compressMinSize,
+// then decides whether to compress it.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	status   int
+	buf      bytes.Buffer
+	out      io.Writer // set once decided: the client or the compressor
+	enc      io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.out != nil {
+		return cw.out.Write(p)
+	}
+	cw.buf.Write(p)
+	if cw.buf.Len() >= compressMinSize {
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush sends what is buffered, uncompressed if undecided so far, and
+// flushes the client connection.
+func (cw *compressWriter) Flush() {
+	if cw.out == nil {
+		cw.decide(false)
+	}
+	if f, ok := cw.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close sends a response that never reached the threshold and finishes the
+// compressed stream.
+func (cw *compressWriter) Close() error {
+	if cw.out == nil {
+		return cw.decide(false)
+	}
+	if cw.enc != nil {
+		return cw.enc.Close()
+	}
+	return nil
+}
+
+// decide writes the headers and the buffered bytes, compressing from here on
+// if compressed is set.
+func (cw *compressWriter) decide(compressed bool) error {
+	header := cw.ResponseWriter.Header()
+	if header.Get("Content-Encoding") != "" || cw.status == http.StatusNoContent || cw.status == http.StatusNotModified {
+		compressed = false
+	}
+
+	cw.out = cw.ResponseWriter
+	if compressed {
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+		if cw.encoding == "gzip" {
+			cw.enc = gzip.NewWriter(cw.ResponseWriter)
+		} else {
+			// HTTP deflate is the zlib format (RFC 1950), not a raw DEFLATE stream
+			cw.enc = zlib.NewWriter(cw.ResponseWriter)
+		}
+		cw.out = cw.enc
+	}
+
+	if cw.status != 0 {
+		cw.ResponseWriter.WriteHeader(cw.status)
+	}
+	_, err := cw.out.Write(cw.buf.Bytes())
+	cw.buf.Reset()
+	return err
+}
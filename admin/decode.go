@@ -0,0 +1,59 @@
+// decode.go - Presentation of annotated register values
+package admin
+
+import (
+	"SPModbus/config"
+	"math"
+)
+
+type registerView struct {
+	Address  uint16      `json:"address"`
+	Value    interface{} `json:"value"`
+	Name     string      `json:"name,omitempty"`
+	DataType string      `json:"data_type,omitempty"`
+	Decoded  interface{} `json:"decoded,omitempty"`
+}
+
+type annotationKey struct {
+	regType string
+	address uint16
+}
+
+func indexAnnotations(annotations []config.RegisterAnnotation) map[annotationKey]config.RegisterAnnotation {
+	index := make(map[annotationKey]config.RegisterAnnotation, len(annotations))
+	for _, a := range annotations {
+		index[annotationKey{a.Type, a.Address}] = a
+	}
+	return index
+}
+
+// decodeValue interprets one or two registers (first is the annotated
+// address) according to a logical data type.
+func decodeValue(dataType string, words []uint16) interface{} {
+	if config.DataTypes[dataType] == 2 && len(words) < 2 {
+		return nil
+	}
+
+	var pair uint32
+	if len(words) >= 2 {
+		pair = uint32(words[0])<<16 | uint32(words[1])
+		switch dataType {
+		case "uint32-swapped", "int32-swapped", "float32-swapped":
+			pair = uint32(words[1])<<16 | uint32(words[0])
+		}
+	}
+
+	switch dataType {
+	case "uint16":
+		return words[0]
+	case "int16":
+		return int16(words[0])
+	case "uint32", "uint32-swapped":
+		return pair
+	case "int32", "int32-swapped":
+		return int32(pair)
+	case "float32", "float32-swapped":
+		return math.Float32frombits(pair)
+	}
+	return nil
+}
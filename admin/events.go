@@ -0,0 +1,99 @@
+// events.go - Server-sent event stream of register changes
+package admin
+
+import (
+	"SPModbus/handler"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// eventBuffer is how many events a stream client may fall behind before the
+// handler starts dropping events for it.
+const eventBuffer = 256
+
+// keepAliveInterval is how often an idle stream sends a comment, so proxies
+// keep the connection open and a gone client is noticed.
+const keepAliveInterval = 15 * time.Second
+
+// handleEventStream streams holding register, coil and counter changes as
+// server-sent events, one JSON event per message, until the client goes away
+// or the admin API stops. The optional addr and count query parameters limit
+// the stream to an address range.
+func (s *Server) handleEventStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+
+	addr, err := queryUint16(r, "addr", 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	count, err := queryUint16(r, "count", 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	end := 1 << 16
+	if r.URL.Query().Has("count") {
+		end = int(addr) + int(count)
+	}
+
+	events := make(chan handler.Event, eventBuffer)
+	s.handler.Subscribe(events)
+	defer s.handler.Unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	s.logger.Debug("Event stream opened", map[string]interface{}{
+		"client": r.RemoteAddr,
+	})
+	defer s.logger.Debug("Event stream closed", map[string]interface{}{
+		"client": r.RemoteAddr,
+	})
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.stopping:
+			return
+		case <-keepAlive.C:
+			if _, err := w.Write([]byte(": keepalive\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+		case e := <-events:
+			if !streamed(e, addr, end) {
+				continue
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			if _, err := w.Write([]byte("event: " + string(e.Type) + "\ndata: " + string(data) + "\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// streamed reports whether e is a change in the address range [addr, end).
+func streamed(e handler.Event, addr uint16, end int) bool {
+	switch e.Type {
+	case handler.EventRegisterWrite, handler.EventCoilWrite, handler.EventCounterUpdate:
+		return e.Address >= addr && int(e.Address) < end
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+// regmap.go - Export of the effective register map as CSV or Markdown
+package admin
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var registerTypeOrder = map[string]int{"holding": 0, "input": 1, "coil": 2, "discrete": 3}
+
+// mapEntry is one row of the register map.
+type mapEntry struct {
+	regType  string
+	address  uint16
+	initial  string
+	value    string
+	name     string
+	dataType string
+	notes    []string
+}
+
+// registerMap collects every address the configuration gives a meaning to,
+// sorted by type and address.
+func (s *Server) registerMap() []*mapEntry {
+	m := s.config.Modbus
+	entries := make(map[annotationKey]*mapEntry)
+	entry := func(regType string, addr uint16) *mapEntry {
+		key := annotationKey{regType, addr}
+		if e, ok := entries[key]; ok {
+			return e
+		}
+		e := &mapEntry{regType: regType, address: addr}
+		entries[key] = e
+		return e
+	}
+
+	for _, data := range m.InitialData {
+		if data.Type == "coil" || data.Type == "discrete" {
+			entry(data.Type, data.Address).initial = strconv.FormatBool(data.Value != 0)
+			continue
+		}
+		for i, word := range data.Words() {
+			entry(data.Type, data.Address+uint16(i)).initial = strconv.Itoa(int(word))
+		}
+	}
+	for _, a := range m.Annotations {
+		e := entry(a.Type, a.Address)
+		e.name = a.Name
+		e.dataType = a.DataType
+	}
+
+	counter := entry("holding", m.CounterAddress)
+	counter.notes = append(counter.notes, "counter (read-only)")
+	if m.Counter32Bit {
+		low := entry("holding", m.CounterAddress+1)
+		low.notes = append(low.notes, "counter low word (read-only)")
+	}
+	for _, addr := range s.handler.ComputedAddresses() {
+		e := entry("holding", addr)
+		e.notes = append(e.notes, "computed (read-only)")
+	}
+	for _, sim := range m.Simulations {
+		e := entry(sim.Type, sim.Address)
+		e.notes = append(e.notes, fmt.Sprintf("simulated, base %d", sim.Base))
+	}
+	for _, mirror := range m.Mirrors {
+		src := entry("holding", mirror.Source)
+		src.notes = append(src.notes, fmt.Sprintf("mirrored to input %d", mirror.Dest))
+		dst := entry("input", mirror.Dest)
+		dst.notes = append(dst.notes, fmt.Sprintf("mirror of holding %d", mirror.Source))
+	}
+	for _, addr := range m.DelayedRegisters {
+		e := entry("holding", addr)
+		e.notes = append(e.notes, "delayed write")
+	}
+	for _, addr := range m.WatchedRegisters {
+		e := entry("holding", addr)
+		e.notes = append(e.notes, "write history")
+	}
+
+	list := make([]*mapEntry, 0, len(entries))
+	for _, e := range entries {
+		e.value = s.currentValue(e.regType, e.address)
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].regType != list[j].regType {
+			return registerTypeOrder[list[i].regType] < registerTypeOrder[list[j].regType]
+		}
+		return list[i].address < list[j].address
+	})
+	return list
+}
+
+// currentValue returns the live value of an address, or "" when it is out of
+// range.
+func (s *Server) currentValue(regType string, addr uint16) string {
+	switch regType {
+	case "holding", "input":
+		if regs, err := s.handler.ReadRegisters(regType, addr, 1); err == nil {
+			return strconv.Itoa(int(regs[0]))
+		}
+	case "coil", "discrete":
+		if bits, err := s.handler.ReadBits(regType, addr, 1); err == nil {
+			return strconv.FormatBool(bits[0])
+		}
+	}
+	return ""
+}
+
+var registerMapHeader = []string{"Type", "Address", "Initial", "Value", "Name", "Data Type", "Notes"}
+
+func (e *mapEntry) row() []string {
+	return []string{e.regType, strconv.Itoa(int(e.address)), e.initial, e.value, e.name, e.dataType, strings.Join(e.notes, "; ")}
+}
+
+// handleRegisterMap exports the register map as CSV (?format=csv) or a
+// Markdown table (the default).
+func (s *Server) handleRegisterMap(w http.ResponseWriter, r *http.Request) {
+	entries := s.registerMap()
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		writeRegisterMapCSV(w, entries)
+	case "", "markdown":
+		w.Header().Set("Content-Type", "text/markdown")
+		writeRegisterMapMarkdown(w, entries)
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown format '%s'", format))
+	}
+}
+
+func writeRegisterMapCSV(w io.Writer, entries []*mapEntry) {
+	cw := csv.NewWriter(w)
+	cw.Write(registerMapHeader)
+	for _, e := range entries {
+		cw.Write(e.row())
+	}
+	cw.Flush()
+}
+
+func writeRegisterMapMarkdown(w io.Writer, entries []*mapEntry) {
+	fmt.Fprintf(w, "| %s |\n", strings.Join(registerMapHeader, " | "))
+	fmt.Fprintf(w, "|%s\n", strings.Repeat(" --- |", len(registerMapHeader)))
+	for _, e := range entries {
+		cells := e.row()
+		for i, c := range cells {
+			cells[i] = strings.ReplaceAll(c, "|", "\\|")
+		}
+		fmt.Fprintf(w, "| %s |\n", strings.Join(cells, " | "))
+	}
+}
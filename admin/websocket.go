@@ -0,0 +1,350 @@
+// websocket.go - Modbus/TCP frames tunneled over WebSocket
+package admin
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Tunnel answers Modbus/TCP frames received over WebSocket. It is
+// implemented by the Modbus server, which frames and dispatches them like
+// requests on a TCP connection.
+type Tunnel interface {
+	// OpenTunnel is called before a client is upgraded and refuses it by
+	// returning an error.
+	OpenTunnel(clientAddr string) error
+	// HandleFrame answers one MBAP frame with one. An error ends the
+	// tunnel, like a malformed frame ends a TCP connection.
+	HandleFrame(clientAddr string, frame []byte) ([]byte, error)
+	CloseTunnel(clientAddr string)
+}
+
+// websocketGUID is appended to the client's key to compute the accept key,
+// per RFC 6455.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxMessageSize is the largest message accepted: a Modbus/TCP frame is at
+// most a 7 byte MBAP header and a 253 byte PDU.
+const maxMessageSize = 260
+
+// WebSocket opcodes
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// Close status codes
+const (
+	closeNormal      = 1000
+	closeGoingAway   = 1001
+	closeProtocol    = 1002
+	closeUnsupported = 1003
+	closeInvalidData = 1007
+	closeTooBig      = 1009
+)
+
+// closeError ends a tunnel with a close status sent to the client.
+type closeError struct {
+	code   uint16
+	reason string
+}
+
+func (e *closeError) Error() string {
+	return fmt.Sprintf("%s (close %d)", e.reason, e.code)
+}
+
+// SetTunnel sets the Modbus server answering frames at the configured
+// websocket_path. Without one the endpoint is unavailable.
+func (s *Server) SetTunnel(t Tunnel) {
+	s.tunnel = t
+}
+
+// tunnelAt serves WebSocket upgrades at path ahead of next, so the path
+// takes precedence over the API routes and is never compressed.
+func (s *Server) tunnelAt(path string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			next.ServeHTTP(w, r)
+			return
+		}
+		s.handleWebSocket(w, r)
+	})
+}
+
+// handleWebSocket upgrades the request to a WebSocket and answers every
+// binary message, one Modbus/TCP frame, with one binary message until the
+// client closes the tunnel or the admin API stops.
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	if s.tunnel == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("modbus tunnel not available"))
+		return
+	}
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		writeError(w, http.StatusBadRequest, errors.New("expected a websocket upgrade"))
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		writeError(w, http.StatusUpgradeRequired, errors.New("unsupported websocket version"))
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		writeError(w, http.StatusBadRequest, errors.New("missing Sec-WebSocket-Key"))
+		return
+	}
+
+	clientAddr := r.RemoteAddr
+	if err := s.tunnel.OpenTunnel(clientAddr); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	defer s.tunnel.CloseTunnel(clientAddr)
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		s.logger.Warn("Failed to upgrade to websocket", map[string]interface{}{
+			"client": clientAddr,
+			"error":  err.Error(),
+		})
+		return
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", acceptKey(key))
+	if err := rw.Flush(); err != nil {
+		return
+	}
+
+	// Hijacked connections are not closed by the HTTP server's shutdown
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-s.stopping:
+			writeMessage(conn, opClose, closePayload(closeGoingAway, "admin API stopping"))
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	s.logger.Debug("Modbus tunnel opened", map[string]interface{}{
+		"client": clientAddr,
+	})
+	err = s.serveTunnel(conn, rw.Reader, clientAddr)
+	fields := map[string]interface{}{
+		"client": clientAddr,
+	}
+	if err != nil {
+		fields["reason"] = err.Error()
+	}
+	s.logger.Debug("Modbus tunnel closed", fields)
+}
+
+// serveTunnel answers messages until the tunnel closes. Like a Modbus
+// connection, a tunnel without a request for the server's timeout is closed.
+// It returns nil when the client closed it and the reason otherwise.
+func (s *Server) serveTunnel(conn net.Conn, r *bufio.Reader, clientAddr string) error {
+	timeout := time.Duration(s.config.Server.Timeout) * time.Second
+	for {
+		if timeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(timeout))
+		}
+		err := s.answer(conn, r, clientAddr)
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			writeMessage(conn, opClose, closePayload(closeGoingAway, "idle timeout"))
+			return err
+		}
+
+		var ce *closeError
+		if errors.As(err, &ce) {
+			writeMessage(conn, opClose, closePayload(ce.code, ce.reason))
+			if ce.code == closeNormal {
+				return nil
+			}
+		}
+		return err
+	}
+}
+
+// answer reads one message and writes the tunnel's response. Errors that
+// should end the tunnel with a status are closeErrors.
+func (s *Server) answer(conn net.Conn, r *bufio.Reader, clientAddr string) error {
+	op, payload, err := readMessage(r, conn)
+	if err != nil {
+		return err
+	}
+	if op == opText {
+		return &closeError{closeUnsupported, "text messages are not supported"}
+	}
+
+	res, err := s.tunnel.HandleFrame(clientAddr, payload)
+	if err != nil {
+		return &closeError{closeInvalidData, err.Error()}
+	}
+	return writeMessage(conn, opBinary, res)
+}
+
+// readMessage reads the next data message, joining fragments and answering
+// control frames on the way. A close frame is returned as a closeError.
+func readMessage(r *bufio.Reader, w io.Writer) (byte, []byte, error) {
+	var op byte
+	var message []byte
+	for {
+		fin, frameOp, payload, err := readWSFrame(r)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch frameOp {
+		case opPing:
+			if err := writeMessage(w, opPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return 0, nil, &closeError{closeNormal, "closed by client"}
+		case opText, opBinary:
+			if message != nil {
+				return 0, nil, &closeError{closeProtocol, "new message inside a fragmented one"}
+			}
+			op, message = frameOp, payload
+		case opContinuation:
+			if message == nil {
+				return 0, nil, &closeError{closeProtocol, "continuation without a message"}
+			}
+			if len(message)+len(payload) > maxMessageSize {
+				return 0, nil, &closeError{closeTooBig, "message too big"}
+			}
+			message = append(message, payload...)
+		default:
+			return 0, nil, &closeError{closeProtocol, fmt.Sprintf("unknown opcode %d", frameOp)}
+		}
+
+		if fin {
+			return op, message, nil
+		}
+	}
+}
+
+// readWSFrame reads one client frame and unmasks its payload. Client frames
+// must be masked and control frames must not be fragmented.
+func readWSFrame(r *bufio.Reader) (fin bool, op byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = header[0]&0x80 != 0
+	op = header[0] & 0x0f
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, &closeError{closeProtocol, "reserved bits set"}
+	}
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, &closeError{closeProtocol, "unmasked client frame"}
+	}
+	if op >= opClose && !fin {
+		return false, 0, nil, &closeError{closeProtocol, "fragmented control frame"}
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > maxMessageSize || (op >= opClose && length > 125) {
+		return false, 0, nil, &closeError{closeTooBig, "frame too big"}
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(r, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// writeMessage writes an unmasked, unfragmented server frame.
+func writeMessage(w io.Writer, op byte, payload []byte) error {
+	frame := []byte{0x80 | op}
+	if len(payload) < 126 {
+		frame = append(frame, byte(len(payload)))
+	} else {
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	}
+	frame = append(frame, payload...)
+
+	_, err := w.Write(frame)
+	return err
+}
+
+// closePayload is the body of a close frame: the status code and a reason,
+// cut to fit the 125 byte limit of control frames.
+func closePayload(code uint16, reason string) []byte {
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	return append(binary.BigEndian.AppendUint16(nil, code), reason...)
+}
+
+// acceptKey returns the Sec-WebSocket-Accept value for a client's key.
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerHasToken reports whether a comma-separated header contains token,
+// ignoring case.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
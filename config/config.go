@@ -4,50 +4,1115 @@ package config
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"math"
+	"net"
+	"net/netip"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
+	Version int           `json:"version"`
+	Name    string        `json:"name,omitempty"`
+	Seed    int64         `json:"seed,omitempty"`
 	Server  ServerConfig  `json:"server"`
 	Logging LoggingConfig `json:"logging"`
 	Modbus  ModbusConfig  `json:"modbus"`
+	Admin   AdminConfig   `json:"admin"`
+	Standby StandbyConfig `json:"standby"`
+	Replica ReplicaConfig `json:"replica"`
+
+	// notices are messages produced while loading, before a logger exists
+	notices []string
+}
+
+// Notices returns informational messages produced while loading the config,
+// so the caller can log them once the logger is configured.
+func (c *Config) Notices() []string {
+	return c.notices
 }
 
 type ServerConfig struct {
-	Address    string `json:"address"`
-	Port       int    `json:"port"`
-	MaxClients uint   `json:"max_clients"`
-	Timeout    int    `json:"timeout"`
-	MaxRetries int    `json:"max_retries"`
-	RetryDelay int    `json:"retry_delay"`
+	Address               string   `json:"address"`
+	Port                  int      `json:"port"`
+	MaxClients            uint     `json:"max_clients"`
+	Timeout               int      `json:"timeout"`
+	MaxRetries            int      `json:"max_retries"`
+	RetryDelay            int      `json:"retry_delay"`
+	RequestTimeout        Duration `json:"request_timeout,omitempty"`
+	AllowCIDRs            []string `json:"allow_cidrs,omitempty"`
+	DenyCIDRs             []string `json:"deny_cidrs,omitempty"`
+	SwapWords             bool     `json:"swap_words,omitempty"`
+	SwapBytes             bool     `json:"swap_bytes,omitempty"`
+	MaxLifetime           Duration `json:"max_lifetime,omitempty"`
+	MaxConnectionDuration Duration `json:"max_connection_duration,omitempty"`
+	MinInterval           Duration `json:"min_interval,omitempty"`
+	Mode                  string   `json:"mode,omitempty"`                  // "tcp" (default) or "udp"
+	UnsupportedFunctions  []int    `json:"unsupported_functions,omitempty"` // function codes answered with illegal function
+}
+
+// Network returns the transport the server listens on: "udp" when Mode
+// selects it, "tcp" otherwise.
+func (s ServerConfig) Network() string {
+	if s.Mode == "udp" {
+		return "udp"
+	}
+	return "tcp"
+}
+
+// ParsePrefixes parses CIDR blocks. A bare IP address is treated as a
+// single-host prefix.
+func ParsePrefixes(list []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range list {
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR '%s': %w", entry, err)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+// DualStackAddress is the server address shorthand for listening on both the
+// IPv4 and the IPv6 wildcard address.
+const DualStackAddress = "*"
+
+// DualStack reports whether Address is the dual-stack shorthand.
+func (s ServerConfig) DualStack() bool {
+	return s.Address == DualStackAddress
+}
+
+// Host returns the listen address without IPv6 brackets. It must be empty (all
+// interfaces), an IPv4 or IPv6 literal, or a hostname; a port is not allowed.
+// The dual-stack shorthand returns "".
+func (s ServerConfig) Host() (string, error) {
+	host := s.Address
+	if s.DualStack() {
+		return "", nil
+	}
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+		if net.ParseIP(host) == nil {
+			return "", fmt.Errorf("invalid server address '%s': brackets must enclose an IPv6 address", s.Address)
+		}
+		return host, nil
+	}
+	if host == "" || net.ParseIP(host) != nil {
+		return host, nil
+	}
+	if !validHostname(host) {
+		return "", fmt.Errorf("invalid server address '%s': expected an IP address or hostname without a port", s.Address)
+	}
+	return host, nil
+}
+
+// validHostname checks RFC 1123 hostname syntax.
+func validHostname(host string) bool {
+	if len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
 }
 
 type LoggingConfig struct {
-	Level   string `json:"level"`
-	File    string `json:"file"`
-	MaxSize int    `json:"max_size_mb"`
-	Console bool   `json:"console"`
+	Level      string        `json:"level"`
+	File       string        `json:"file"`
+	MaxSize    int           `json:"max_size_mb"`
+	MaxBackups int           `json:"max_backups"`
+	Console    bool          `json:"console"`
+	TimeFormat string        `json:"time_format,omitempty"`
+	UTC        bool          `json:"utc,omitempty"`
+	Syslog     *SyslogConfig `json:"syslog,omitempty"`
+	SampleRate int           `json:"sample_rate,omitempty"`
+}
+
+// SyslogConfig sends log entries to syslog as well. An empty Network and
+// Address use the local syslog daemon; otherwise Network is "udp" or "tcp".
+// Facility defaults to "user" and Tag to the program name.
+type SyslogConfig struct {
+	Network  string `json:"network,omitempty"`
+	Address  string `json:"address,omitempty"`
+	Facility string `json:"facility,omitempty"`
+	Tag      string `json:"tag,omitempty"`
+}
+
+// SyslogFacilities lists the accepted syslog facility names.
+var SyslogFacilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+type AdminConfig struct {
+	Enabled       bool   `json:"enabled"`
+	Address       string `json:"address"`
+	WebSocketPath string `json:"websocket_path,omitempty"` // Modbus over WebSocket endpoint, e.g. "/modbus"
+}
+
+// StandbyConfig makes this instance a warm standby for the simulator at Peer
+// (its Modbus host:port). It only starts serving once Peer has not answered
+// a probe for LeaseTimeout.
+type StandbyConfig struct {
+	Enabled       bool     `json:"enabled"`
+	Peer          string   `json:"peer"`
+	LeaseTimeout  Duration `json:"lease_timeout"`
+	ProbeInterval Duration `json:"probe_interval"`
+}
+
+// ReplicaConfig makes this instance a read-only replica of the simulator at
+// Primary (a Modbus URL such as tcp://10.0.0.5:1502). Its registers are
+// refreshed from the primary every RefreshInterval and client writes are
+// rejected. PreloadRanges are read from the primary at startup before the
+// server reports itself running.
+type ReplicaConfig struct {
+	Enabled         bool           `json:"enabled"`
+	Primary         string         `json:"primary"`
+	RefreshInterval Duration       `json:"refresh_interval"`
+	PreloadRanges   []PreloadRange `json:"preload_ranges,omitempty"`
+}
+
+// PreloadRange is Count values of a table from Address that a replica caches
+// before it is ready.
+type PreloadRange struct {
+	Type    string `json:"type"` // holding, input, coil or discrete
+	Address uint16 `json:"address"`
+	Count   uint16 `json:"count"`
 }
 
 type RegisterValue struct {
+	Type        string   `json:"type"`
+	Address     uint16   `json:"address"`
+	Value       uint16   `json:"value"`
+	SignedValue *int16   `json:"signed_value,omitempty"`
+	Float32     *float32 `json:"float32,omitempty"`
+}
+
+// Words returns the register values an entry seeds, starting at its address.
+// Float32 values occupy two registers, high word first.
+func (r RegisterValue) Words() []uint16 {
+	switch {
+	case r.SignedValue != nil:
+		return []uint16{uint16(*r.SignedValue)}
+	case r.Float32 != nil:
+		bits := math.Float32bits(*r.Float32)
+		return []uint16{uint16(bits >> 16), uint16(bits)}
+	}
+	return []uint16{r.Value}
+}
+
+// validate checks that at most one value field is set and that only
+// registers use the signed and float forms.
+func (r RegisterValue) validate() error {
+	set := 0
+	if r.Value != 0 {
+		set++
+	}
+	if r.SignedValue != nil {
+		set++
+	}
+	if r.Float32 != nil {
+		set++
+	}
+	if set > 1 {
+		return fmt.Errorf("only one of value, signed_value and float32 may be set")
+	}
+	if (r.SignedValue != nil || r.Float32 != nil) && (r.Type == "coil" || r.Type == "discrete") {
+		return fmt.Errorf("signed_value and float32 are not supported for %s", r.Type)
+	}
+	return nil
+}
+
+// RegisterAnnotation labels a register for the admin API. It is purely
+// presentational and does not change Modbus behavior.
+type RegisterAnnotation struct {
+	Type     string `json:"type"`
+	Address  uint16 `json:"address"`
+	Name     string `json:"name"`
+	DataType string `json:"data_type"`
+}
+
+// RegisterRef identifies a single register by type and address.
+type RegisterRef struct {
 	Type    string `json:"type"`
 	Address uint16 `json:"address"`
+}
+
+// ResetTrigger designates a holding register or coil that resets the device
+// when a client writes Value to it (1 for a coil).
+type ResetTrigger struct {
+	Type    string `json:"type"` // "holding" or "coil"
+	Address uint16 `json:"address"`
 	Value   uint16 `json:"value"`
 }
 
+// SpikeConfig makes a simulated register occasionally jump by Magnitude for
+// Duration seconds. Probability is the chance per update tick.
+type SpikeConfig struct {
+	Probability float64 `json:"probability"`
+	Magnitude   int     `json:"magnitude"`
+	Duration    int     `json:"duration"`
+}
+
+// NoiseConfig adds random noise to a simulated register on every tick.
+// Distribution is "uniform" (default) or "gaussian"; either way the noise
+// stays within ±Magnitude. Gaussian noise uses a standard deviation of a
+// third of Magnitude and is clipped to the bound.
+type NoiseConfig struct {
+	Distribution string  `json:"distribution,omitempty"`
+	Magnitude    float64 `json:"magnitude"`
+}
+
+// SimulatedRegister is a holding or input register driven by the register
+// updater rather than by clients. SafeValue, when set, is written as the
+// simulator shuts down, like a device powering off.
+type SimulatedRegister struct {
+	Type      string       `json:"type"`
+	Address   uint16       `json:"address"`
+	Base      uint16       `json:"base"`
+	Spike     *SpikeConfig `json:"spike,omitempty"`
+	Noise     *NoiseConfig `json:"noise,omitempty"`
+	SafeValue *uint16      `json:"safe_value,omitempty"`
+}
+
+// Mirror copies every client write to a holding register into an input
+// register, e.g. a command reflected in a read-only status register.
+type Mirror struct {
+	Source uint16 `json:"source"`
+	Dest   uint16 `json:"dest"`
+}
+
+// AddressAlias makes Address a second address of Target in the same table,
+// for devices exposing one value at several addresses: reads and writes of
+// either address use the same stored value.
+type AddressAlias struct {
+	Type    string `json:"type"`
+	Address uint16 `json:"address"`
+	Target  uint16 `json:"target"`
+}
+
+// RegisterConstraint restricts the values clients may write to a holding
+// register, like a device validating its settings. With Allowed set only
+// those values are accepted; Min and Max bound the value inclusively. A write
+// that violates the constraint is rejected with Illegal Data Value.
+type RegisterConstraint struct {
+	Address uint16   `json:"address"`
+	Allowed []uint16 `json:"allowed,omitempty"`
+	Min     *uint16  `json:"min,omitempty"`
+	Max     *uint16  `json:"max,omitempty"`
+}
+
+// Accepts reports whether value satisfies the constraint.
+func (c RegisterConstraint) Accepts(value uint16) bool {
+	if len(c.Allowed) > 0 && !slices.Contains(c.Allowed, value) {
+		return false
+	}
+	if c.Min != nil && value < *c.Min {
+		return false
+	}
+	if c.Max != nil && value > *c.Max {
+		return false
+	}
+	return true
+}
+
+// RegisterGroup marks Count consecutive registers starting at Address as one
+// logical value, such as a 32-bit float. Simulated registers in a group are
+// updated together, so clients never read a torn value.
+type RegisterGroup struct {
+	Type    string `json:"type"`
+	Address uint16 `json:"address"`
+	Count   int    `json:"count"`
+}
+
+// Contains reports whether a register belongs to the group.
+func (g RegisterGroup) Contains(regType string, addr uint16) bool {
+	return regType == g.Type && addr >= g.Address && int(addr) < int(g.Address)+g.Count
+}
+
+// PackedCoils exposes Count coils starting at Coil as the read-only holding
+// register Address, with coil Coil+i in bit i.
+type PackedCoils struct {
+	Address uint16 `json:"address"`
+	Coil    uint16 `json:"coil"`
+	Count   int    `json:"count"`
+}
+
+// QuantityLimits caps the quantity of each multi-value request. Zero keeps
+// the Modbus specification's limit, which is also the highest allowed.
+type QuantityLimits struct {
+	ReadCoils            uint16 `json:"read_coils,omitempty"`
+	ReadDiscreteInputs   uint16 `json:"read_discrete_inputs,omitempty"`
+	ReadHoldingRegisters uint16 `json:"read_holding_registers,omitempty"`
+	ReadInputRegisters   uint16 `json:"read_input_registers,omitempty"`
+	WriteCoils           uint16 `json:"write_coils,omitempty"`
+	WriteRegisters       uint16 `json:"write_registers,omitempty"`
+}
+
+// SpecQuantityLimits are the largest quantities the Modbus specification
+// allows, set by the 253-byte PDU.
+var SpecQuantityLimits = QuantityLimits{
+	ReadCoils:            2000,
+	ReadDiscreteInputs:   2000,
+	ReadHoldingRegisters: 125,
+	ReadInputRegisters:   125,
+	WriteCoils:           1968,
+	WriteRegisters:       123,
+}
+
+// WithDefaults returns the limits with every unset field at its
+// specification value.
+func (l QuantityLimits) WithDefaults() QuantityLimits {
+	fill := func(v *uint16, spec uint16) {
+		if *v == 0 {
+			*v = spec
+		}
+	}
+	fill(&l.ReadCoils, SpecQuantityLimits.ReadCoils)
+	fill(&l.ReadDiscreteInputs, SpecQuantityLimits.ReadDiscreteInputs)
+	fill(&l.ReadHoldingRegisters, SpecQuantityLimits.ReadHoldingRegisters)
+	fill(&l.ReadInputRegisters, SpecQuantityLimits.ReadInputRegisters)
+	fill(&l.WriteCoils, SpecQuantityLimits.WriteCoils)
+	fill(&l.WriteRegisters, SpecQuantityLimits.WriteRegisters)
+	return l
+}
+
+// UnitConfig defines an additional unit served alongside unit_id. Each unit
+// gets its own register bank, set up from the rest of the modbus section.
+type UnitConfig struct {
+	UnitID       uint8           `json:"unit_id"`
+	MaxRegisters int             `json:"max_registers,omitempty"`
+	InitialData  []RegisterValue `json:"initial_data,omitempty"` // replaces the modbus section's when set
+}
+
+// Duration is a time.Duration that unmarshals from either a duration string
+// ("500ms", "2s") or a plain number of seconds, for compatibility with older
+// config files.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(b, &seconds); err == nil {
+		*d = Duration(seconds * float64(time.Second))
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a number of seconds or a string like \"500ms\"")
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration '%s': %w", s, err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalJSON writes whole seconds as a number so generated config files keep
+// the traditional format.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	if time.Duration(d)%time.Second == 0 {
+		return json.Marshal(int64(time.Duration(d) / time.Second))
+	}
+	return json.Marshal(time.Duration(d).String())
+}
+
 type ModbusConfig struct {
-	UnitID         uint8           `json:"unit_id"`
-	MaxRegisters   int             `json:"max_registers"`
-	CounterAddress uint16          `json:"counter_address"`
-	UpdateInterval int             `json:"update_interval"`
-	InitialData    []RegisterValue `json:"initial_data"`
+	UnitID                   uint8                  `json:"unit_id"`
+	MaxRegisters             int                    `json:"max_registers"`
+	MaxCoils                 int                    `json:"max_coils,omitempty"`
+	MaxDiscreteInputs        int                    `json:"max_discrete_inputs,omitempty"`
+	MaxHoldingRegisters      int                    `json:"max_holding_registers,omitempty"`
+	MaxInputRegisters        int                    `json:"max_input_registers,omitempty"`
+	Storage                  string                 `json:"storage,omitempty"`
+	CounterAddress           uint16                 `json:"counter_address"`
+	UpdateInterval           Duration               `json:"update_interval"`
+	CounterStartDelay        Duration               `json:"counter_start_delay,omitempty"`
+	CounterInitial           uint16                 `json:"counter_initial,omitempty"`
+	OverflowBehavior         string                 `json:"overflow_behavior,omitempty"`
+	CounterMode              string                 `json:"counter_mode,omitempty"`
+	Counter32Bit             bool                   `json:"counter_32bit,omitempty"`
+	MirrorCounterToInput     *uint16                `json:"mirror_counter_to_input,omitempty"`
+	InitialData              []RegisterValue        `json:"initial_data"`
+	StateFile                string                 `json:"state_file,omitempty"`
+	InitialDataOverState     bool                   `json:"initial_data_over_state,omitempty"`
+	ResetOnConnect           bool                   `json:"reset_on_connect"`
+	ResetAddresses           []RegisterRef          `json:"reset_addresses,omitempty"`
+	Annotations              []RegisterAnnotation   `json:"annotations,omitempty"`
+	Aliases                  map[string]RegisterRef `json:"aliases,omitempty"`
+	Simulations              []SimulatedRegister    `json:"simulations,omitempty"`
+	SimulationLogWindow      Duration               `json:"simulation_log_window,omitempty"`
+	SimulationSeed           int64                  `json:"simulation_seed,omitempty"`
+	Seed                     int64                  `json:"-"` // the top-level seed, set by the server
+	RegisterGroups           []RegisterGroup        `json:"register_groups,omitempty"`
+	PackedCoils              []PackedCoils          `json:"packed_coils,omitempty"`
+	WatchedRegisters         []uint16               `json:"watched_registers,omitempty"`
+	HistoryDepth             int                    `json:"history_depth,omitempty"`
+	DelayedRegisters         []uint16               `json:"delayed_registers,omitempty"`
+	WriteDelay               Duration               `json:"write_delay,omitempty"`
+	LockWaitThreshold        Duration               `json:"lock_wait_threshold,omitempty"`
+	Mirrors                  []Mirror               `json:"mirrors,omitempty"`
+	AddressAliases           []AddressAlias         `json:"address_aliases,omitempty"`
+	Constraints              []RegisterConstraint   `json:"constraints,omitempty"`
+	LogReadValues            bool                   `json:"log_read_values,omitempty"`
+	LogReadValuesLimit       int                    `json:"log_read_values_limit,omitempty"`
+	InitPattern              string                 `json:"init_pattern,omitempty"`
+	StrictInitialData        bool                   `json:"strict_initial_data,omitempty"`
+	RejectUninitializedReads bool                   `json:"reject_uninitialized_reads,omitempty"`
+	StaleReads               bool                   `json:"stale_reads,omitempty"`
+	BoundaryMode             string                 `json:"boundary_mode,omitempty"` // "strict" (default) or "lenient"
+	Inverted                 []RegisterRef          `json:"inverted,omitempty"`
+	QuantityLimits           QuantityLimits         `json:"quantity_limits,omitempty"`
+	ResetTrigger             *ResetTrigger          `json:"reset_trigger,omitempty"`
+	RejectionLogLevel        string                 `json:"rejection_log_level,omitempty"`       // "warn" (default), "info", "debug" or "off"
+	RequestLogLimit          int                    `json:"request_log_limit,omitempty"`         // request log entries per second per connection
+	TxClientWrites           string                 `json:"transaction_client_writes,omitempty"` // "allow" (default) or "reject"
+	Units                    []UnitConfig           `json:"units,omitempty"`
+	MaxConcurrentRequests    int                    `json:"max_concurrent_requests,omitempty"`
+	GlobalRateLimit          int                    `json:"global_rate_limit,omitempty"` // requests per second across all units
+	VendorName               string                 `json:"vendor_name,omitempty"`
+	ProductCode              string                 `json:"product_code,omitempty"`
+	Revision                 string                 `json:"revision,omitempty"`
+	ExceptionMap             map[string]uint8       `json:"exception_map,omitempty"`
+}
+
+// ExceptionConditions lists the error conditions whose exception code can be
+// overridden in exception_map, with the code each returns by default.
+var ExceptionConditions = map[string]uint8{
+	"invalid_unit":     0x01,
+	"out_of_bounds":    0x02,
+	"invalid_quantity": 0x03,
+	"invalid_value":    0x03,
+	"device_busy":      0x06,
 }
 
+// ExceptionCodes lists the Modbus exception codes exception_map may return.
+var ExceptionCodes = []uint8{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x08, 0x0a, 0x0b}
+
+// MaxDeviceIDLength is the longest device identification value that fits in
+// a single read device identification response.
+const MaxDeviceIDLength = 244
+
+// Size returns the number of addresses of a data type: its own max_* setting,
+// or MaxRegisters when that is unset.
+func (m ModbusConfig) Size(regType string) int {
+	var size int
+	switch regType {
+	case "holding":
+		size = m.MaxHoldingRegisters
+	case "input":
+		size = m.MaxInputRegisters
+	case "coil":
+		size = m.MaxCoils
+	case "discrete":
+		size = m.MaxDiscreteInputs
+	}
+	if size > 0 {
+		return size
+	}
+	return m.MaxRegisters
+}
+
+// CounterWords returns how many holding registers the counter occupies: two
+// with Counter32Bit, one otherwise.
+func (m ModbusConfig) CounterWords() int {
+	if m.Counter32Bit {
+		return 2
+	}
+	return 1
+}
+
+// ForUnit returns the configuration of an additional unit. Simulations stay
+// with the primary unit. A unit's max_registers replaces every size of the
+// primary unit, including the per-type ones, and its initial_data replaces
+// the primary unit's.
+func (m ModbusConfig) ForUnit(u UnitConfig) ModbusConfig {
+	m.UnitID = u.UnitID
+	if u.MaxRegisters > 0 {
+		m.MaxRegisters = u.MaxRegisters
+		m.MaxCoils, m.MaxDiscreteInputs = 0, 0
+		m.MaxHoldingRegisters, m.MaxInputRegisters = 0, 0
+	}
+	if u.InitialData != nil {
+		m.InitialData = u.InitialData
+	}
+	m.Units = nil
+	m.Simulations = nil
+	return m
+}
+
+// DataTypes lists the logical types a register annotation may declare. 32-bit
+// types span the annotated address and the next one, high word first unless
+// the type is suffixed with -swapped.
+var DataTypes = map[string]int{
+	"uint16":          1,
+	"int16":           1,
+	"uint32":          2,
+	"int32":           2,
+	"float32":         2,
+	"uint32-swapped":  2,
+	"int32-swapped":   2,
+	"float32-swapped": 2,
+}
+
+// Overlap describes two initial data entries that write the same register.
+// First and Second are indexes into InitialData.
+type Overlap struct {
+	Type    string
+	Address uint16
+	First   int
+	Second  int
+}
+
+// InitialDataOverlaps returns every register written by more than one
+// initial data entry, including the second word of float32 entries.
+func (m ModbusConfig) InitialDataOverlaps() []Overlap {
+	type key struct {
+		regType string
+		address int
+	}
+
+	var overlaps []Overlap
+	seen := make(map[key]int)
+	for i, data := range m.InitialData {
+		for offset := range data.Words() {
+			k := key{data.Type, int(data.Address) + offset}
+			if first, ok := seen[k]; ok {
+				overlaps = append(overlaps, Overlap{Type: data.Type, Address: uint16(k.address), First: first, Second: i})
+				continue
+			}
+			seen[k] = i
+		}
+	}
+	return overlaps
+}
+
+// ParseInitPattern parses an InitPattern value: "zero" (or empty), "address",
+// "incrementing", "random" or "constant:N".
+func ParseInitPattern(pattern string) (kind string, constant uint16, err error) {
+	switch pattern {
+	case "", "zero":
+		return "zero", 0, nil
+	case "address", "incrementing", "random":
+		return pattern, 0, nil
+	}
+
+	if value, ok := strings.CutPrefix(pattern, "constant:"); ok {
+		n, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return "", 0, fmt.Errorf("invalid constant in init pattern '%s'", pattern)
+		}
+		return "constant", uint16(n), nil
+	}
+
+	return "", 0, fmt.Errorf("unknown init pattern '%s'", pattern)
+}
+
+func validRegisterType(t string) bool {
+	switch t {
+	case "holding", "input", "coil", "discrete":
+		return true
+	}
+	return false
+}
+
+// Validate checks the configuration for values that cannot be applied.
+func (c *Config) Validate() error {
+	if _, err := c.Server.Host(); err != nil {
+		return err
+	}
+	for _, fc := range c.Server.UnsupportedFunctions {
+		if fc < 1 || fc > 127 {
+			return fmt.Errorf("unsupported_functions: invalid function code %d, must be 1-127", fc)
+		}
+	}
+	if c.Logging.SampleRate < 0 {
+		return fmt.Errorf("logging sample_rate must not be negative")
+	}
+	if c.Logging.MaxSize < 0 {
+		return fmt.Errorf("logging max_size_mb must not be negative")
+	}
+	if c.Logging.MaxBackups < 0 {
+		return fmt.Errorf("logging max_backups must not be negative")
+	}
+
+	switch c.Server.Mode {
+	case "", "tcp", "udp":
+	case "rtu":
+		// There is no serial transport, and so no serial write layer for RTU
+		// features such as corrupting outgoing frames' CRC or framing to hook
+		// into; they wait for one.
+		return fmt.Errorf("server mode 'rtu' is not supported: the server only listens on tcp or udp")
+	default:
+		return fmt.Errorf("unknown server mode '%s' (valid: tcp, udp)", c.Server.Mode)
+	}
+	if _, err := ParsePrefixes(c.Server.AllowCIDRs); err != nil {
+		return fmt.Errorf("allow_cidrs: %w", err)
+	}
+	if _, err := ParsePrefixes(c.Server.DenyCIDRs); err != nil {
+		return fmt.Errorf("deny_cidrs: %w", err)
+	}
+
+	if c.Standby.Enabled {
+		if c.Server.Network() != "tcp" {
+			return fmt.Errorf("standby requires server mode tcp")
+		}
+		if _, _, err := net.SplitHostPort(c.Standby.Peer); err != nil {
+			return fmt.Errorf("standby peer must be host:port: %w", err)
+		}
+		if c.Standby.ProbeInterval <= 0 || c.Standby.LeaseTimeout < c.Standby.ProbeInterval {
+			return fmt.Errorf("standby probe_interval must be positive and no longer than lease_timeout")
+		}
+	}
+
+	if p := c.Admin.WebSocketPath; p != "" && !strings.HasPrefix(p, "/") {
+		return fmt.Errorf("admin websocket_path must start with '/', got '%s'", p)
+	}
+
+	if c.Replica.Enabled {
+		if u, err := url.Parse(c.Replica.Primary); err != nil || u.Scheme != "tcp" || u.Host == "" {
+			return fmt.Errorf("replica primary must be a tcp://host:port URL, got '%s'", c.Replica.Primary)
+		}
+		if c.Replica.RefreshInterval <= 0 {
+			return fmt.Errorf("replica refresh_interval must be positive")
+		}
+		if c.Standby.Enabled {
+			return fmt.Errorf("replica and standby can't both be enabled")
+		}
+		if len(c.Modbus.Units) > 0 {
+			return fmt.Errorf("replica mirrors a single unit; units are not supported")
+		}
+		for i, r := range c.Replica.PreloadRanges {
+			if r.Type != "holding" && r.Type != "input" && r.Type != "coil" && r.Type != "discrete" {
+				return fmt.Errorf("replica preload range %d: unknown register type '%s'", i, r.Type)
+			}
+			if r.Count == 0 {
+				return fmt.Errorf("replica preload range %d: count must be positive", i)
+			}
+			if size := c.Modbus.Size(r.Type); int(r.Address)+int(r.Count) > size {
+				return fmt.Errorf("replica preload range %d: %s %d-%d out of range for %d registers", i, r.Type, r.Address, int(r.Address)+int(r.Count)-1, size)
+			}
+		}
+	}
+
+	if c.Modbus.UpdateInterval <= 0 {
+		return fmt.Errorf("update_interval must be positive")
+	}
+	if c.Modbus.CounterStartDelay < 0 {
+		return fmt.Errorf("counter_start_delay must not be negative")
+	}
+
+	if c.Modbus.Storage != "" && c.Modbus.Storage != "dense" && c.Modbus.Storage != "sparse" {
+		return fmt.Errorf("storage must be dense or sparse, got '%s'", c.Modbus.Storage)
+	}
+
+	if sl := c.Logging.Syslog; sl != nil {
+		if sl.Facility != "" && !slices.Contains(SyslogFacilities, sl.Facility) {
+			return fmt.Errorf("unknown syslog facility '%s'", sl.Facility)
+		}
+		if (sl.Network == "") != (sl.Address == "") {
+			return fmt.Errorf("syslog network and address must be set together")
+		}
+	}
+
+	switch c.Modbus.OverflowBehavior {
+	case "", "wrap-to-one", "wrap-to-zero", "saturate", "reset-to-initial":
+	default:
+		return fmt.Errorf("unknown overflow_behavior '%s'", c.Modbus.OverflowBehavior)
+	}
+
+	switch c.Modbus.CounterMode {
+	case "", "increment":
+		if c.Modbus.Counter32Bit {
+			return fmt.Errorf("counter_32bit requires counter_mode epoch or uptime-seconds")
+		}
+	case "epoch", "uptime-seconds":
+	default:
+		return fmt.Errorf("unknown counter_mode '%s'", c.Modbus.CounterMode)
+	}
+	for name, size := range map[string]int{
+		"max_coils":             c.Modbus.MaxCoils,
+		"max_discrete_inputs":   c.Modbus.MaxDiscreteInputs,
+		"max_holding_registers": c.Modbus.MaxHoldingRegisters,
+		"max_input_registers":   c.Modbus.MaxInputRegisters,
+	} {
+		if size < 0 || size > 65536 {
+			return fmt.Errorf("%s must be between 0 and 65536, got %d", name, size)
+		}
+	}
+
+	if int(c.Modbus.CounterAddress) >= c.Modbus.Size("holding") {
+		return fmt.Errorf("counter_address %d is beyond the %d holding registers", c.Modbus.CounterAddress, c.Modbus.Size("holding"))
+	}
+	if c.Modbus.Counter32Bit && int(c.Modbus.CounterAddress)+1 >= c.Modbus.Size("holding") {
+		return fmt.Errorf("counter_32bit needs counter_address %d + 1 below %d holding registers", c.Modbus.CounterAddress, c.Modbus.Size("holding"))
+	}
+
+	if m := c.Modbus.MirrorCounterToInput; m != nil {
+		if int(*m)+c.Modbus.CounterWords() > c.Modbus.Size("input") {
+			return fmt.Errorf("mirror_counter_to_input %d is beyond the %d input registers", *m, c.Modbus.Size("input"))
+		}
+	}
+
+	if _, _, err := ParseInitPattern(c.Modbus.InitPattern); err != nil {
+		return err
+	}
+
+	for i, data := range c.Modbus.InitialData {
+		if err := data.validate(); err != nil {
+			return fmt.Errorf("initial data %d: %w", i, err)
+		}
+	}
+
+	if c.Modbus.StrictInitialData {
+		if overlaps := c.Modbus.InitialDataOverlaps(); len(overlaps) > 0 {
+			o := overlaps[0]
+			return fmt.Errorf("initial data %d and %d both set %s register %d", o.First, o.Second, o.Type, o.Address)
+		}
+	}
+
+	for i, sim := range c.Modbus.Simulations {
+		if sim.Type != "holding" && sim.Type != "input" {
+			return fmt.Errorf("simulation %d: type must be holding or input, got '%s'", i, sim.Type)
+		}
+		if sim.Spike != nil && (sim.Spike.Probability < 0 || sim.Spike.Probability > 1) {
+			return fmt.Errorf("simulation %d: spike probability must be between 0 and 1", i)
+		}
+		if n := sim.Noise; n != nil {
+			if n.Distribution != "" && n.Distribution != "uniform" && n.Distribution != "gaussian" {
+				return fmt.Errorf("simulation %d: noise distribution must be uniform or gaussian, got '%s'", i, n.Distribution)
+			}
+			if n.Magnitude < 0 {
+				return fmt.Errorf("simulation %d: noise magnitude must not be negative", i)
+			}
+		}
+	}
+
+	for i, g := range c.Modbus.RegisterGroups {
+		if g.Type != "holding" && g.Type != "input" {
+			return fmt.Errorf("register group %d: type must be holding or input, got '%s'", i, g.Type)
+		}
+		if size := c.Modbus.Size(g.Type); g.Count < 1 || int(g.Address)+g.Count > size {
+			return fmt.Errorf("register group %d: %d registers at %d do not fit in %d %s registers", i, g.Count, g.Address, size, g.Type)
+		}
+		for j, other := range c.Modbus.RegisterGroups[:i] {
+			if other.Type == g.Type && int(g.Address) < int(other.Address)+other.Count && int(other.Address) < int(g.Address)+g.Count {
+				return fmt.Errorf("register group %d overlaps group %d", i, j)
+			}
+		}
+	}
+
+	if err := c.Modbus.validateLayout(); err != nil {
+		return err
+	}
+
+	seen := map[uint8]bool{c.Modbus.UnitID: true}
+	for i, u := range c.Modbus.Units {
+		if seen[u.UnitID] {
+			return fmt.Errorf("unit %d: duplicate unit ID %d", i, u.UnitID)
+		}
+		seen[u.UnitID] = true
+
+		unit := c.Modbus.ForUnit(u)
+		if size := unit.Size("holding"); int(c.Modbus.CounterAddress)+c.Modbus.CounterWords() > size {
+			return fmt.Errorf("unit %d: max_registers %d does not cover counter registers %d-%d", i, size, c.Modbus.CounterAddress, int(c.Modbus.CounterAddress)+c.Modbus.CounterWords()-1)
+		}
+		if m := c.Modbus.MirrorCounterToInput; m != nil && int(*m)+c.Modbus.CounterWords() > unit.Size("input") {
+			return fmt.Errorf("unit %d: mirror_counter_to_input %d is beyond the %d input registers", i, *m, unit.Size("input"))
+		}
+		for j, data := range u.InitialData {
+			if err := data.validate(); err != nil {
+				return fmt.Errorf("unit %d: initial data %d: %w", i, j, err)
+			}
+			if !validRegisterType(data.Type) {
+				return fmt.Errorf("unit %d: initial data %d: unknown register type '%s'", i, j, data.Type)
+			}
+			if size := unit.Size(data.Type); int(data.Address)+len(data.Words()) > size {
+				return fmt.Errorf("unit %d: initial data %d: %s address %d out of range for %d registers", i, j, data.Type, data.Address, size)
+			}
+		}
+		if c.Modbus.StrictInitialData {
+			if overlaps := unit.InitialDataOverlaps(); len(overlaps) > 0 {
+				o := overlaps[0]
+				return fmt.Errorf("unit %d: initial data %d and %d both set %s register %d", i, o.First, o.Second, o.Type, o.Address)
+			}
+		}
+		if err := unit.validateLayout(); err != nil {
+			return fmt.Errorf("unit %d: %w", i, err)
+		}
+	}
+
+	if c.Modbus.MaxConcurrentRequests < 0 {
+		return fmt.Errorf("max_concurrent_requests must not be negative")
+	}
+
+	if c.Modbus.GlobalRateLimit < 0 {
+		return fmt.Errorf("global_rate_limit must not be negative")
+	}
+
+	if c.Modbus.RequestLogLimit < 0 {
+		return fmt.Errorf("request_log_limit must not be negative")
+	}
+
+	if c.Modbus.LockWaitThreshold < 0 {
+		return fmt.Errorf("lock_wait_threshold must not be negative")
+	}
+
+	switch c.Modbus.BoundaryMode {
+	case "", "strict", "lenient":
+	default:
+		return fmt.Errorf("unknown boundary_mode '%s'", c.Modbus.BoundaryMode)
+	}
+
+	switch c.Modbus.RejectionLogLevel {
+	case "", "warn", "info", "debug", "off":
+	default:
+		return fmt.Errorf("unknown rejection_log_level '%s'", c.Modbus.RejectionLogLevel)
+	}
+
+	switch c.Modbus.TxClientWrites {
+	case "", "allow", "reject":
+	default:
+		return fmt.Errorf("unknown transaction_client_writes '%s'", c.Modbus.TxClientWrites)
+	}
+
+	limits := c.Modbus.QuantityLimits
+	for name, v := range map[string][2]uint16{
+		"read_coils":             {limits.ReadCoils, SpecQuantityLimits.ReadCoils},
+		"read_discrete_inputs":   {limits.ReadDiscreteInputs, SpecQuantityLimits.ReadDiscreteInputs},
+		"read_holding_registers": {limits.ReadHoldingRegisters, SpecQuantityLimits.ReadHoldingRegisters},
+		"read_input_registers":   {limits.ReadInputRegisters, SpecQuantityLimits.ReadInputRegisters},
+		"write_coils":            {limits.WriteCoils, SpecQuantityLimits.WriteCoils},
+		"write_registers":        {limits.WriteRegisters, SpecQuantityLimits.WriteRegisters},
+	} {
+		if v[0] > v[1] {
+			return fmt.Errorf("quantity_limits: %s %d is above the Modbus limit %d", name, v[0], v[1])
+		}
+	}
+
+	if c.Modbus.StaleReads && c.Modbus.RejectUninitializedReads {
+		return fmt.Errorf("stale_reads cannot be combined with reject_uninitialized_reads")
+	}
+
+	for name, v := range map[string]string{
+		"vendor_name":  c.Modbus.VendorName,
+		"product_code": c.Modbus.ProductCode,
+		"revision":     c.Modbus.Revision,
+	} {
+		if len(v) > MaxDeviceIDLength {
+			return fmt.Errorf("%s must be at most %d bytes", name, MaxDeviceIDLength)
+		}
+	}
+
+	for condition, code := range c.Modbus.ExceptionMap {
+		if _, ok := ExceptionConditions[condition]; !ok {
+			return fmt.Errorf("exception_map: unknown condition '%s'", condition)
+		}
+		if !slices.Contains(ExceptionCodes, code) {
+			return fmt.Errorf("exception_map: %s: invalid exception code %d", condition, code)
+		}
+	}
+
+	for i, a := range c.Modbus.Annotations {
+		if !validRegisterType(a.Type) {
+			return fmt.Errorf("annotation %d: unknown register type '%s'", i, a.Type)
+		}
+		if a.DataType == "" {
+			continue
+		}
+		if _, ok := DataTypes[a.DataType]; !ok {
+			return fmt.Errorf("annotation %d: unknown data type '%s'", i, a.DataType)
+		}
+		if a.Type == "coil" || a.Type == "discrete" {
+			return fmt.Errorf("annotation %d: data type not supported for %s", i, a.Type)
+		}
+	}
+
+	return nil
+}
+
+// validateLayout checks the settings that name addresses against the
+// table sizes. Every unit applies them, so Validate runs it for the primary
+// unit and again for each additional unit with its own sizes.
+func (m ModbusConfig) validateLayout() error {
+	for i, p := range m.PackedCoils {
+		if p.Count < 1 || p.Count > 16 {
+			return fmt.Errorf("packed coils %d: count must be between 1 and 16, got %d", i, p.Count)
+		}
+		if int(p.Address) >= m.Size("holding") || int(p.Coil)+p.Count > m.Size("coil") {
+			return fmt.Errorf("packed coils %d: register %d or coils %d-%d out of range", i, p.Address, p.Coil, int(p.Coil)+p.Count-1)
+		}
+		if p.Address == m.CounterAddress {
+			return fmt.Errorf("packed coils %d: address %d is the counter address", i, p.Address)
+		}
+	}
+
+	for i, mirror := range m.Mirrors {
+		if int(mirror.Source) >= m.Size("holding") || int(mirror.Dest) >= m.Size("input") {
+			return fmt.Errorf("mirror %d: holding %d or input %d out of range", i, mirror.Source, mirror.Dest)
+		}
+	}
+
+	aliased := make(map[RegisterRef]bool, len(m.AddressAliases))
+	for _, a := range m.AddressAliases {
+		aliased[RegisterRef{Type: a.Type, Address: a.Address}] = true
+	}
+	for i, a := range m.AddressAliases {
+		if !validRegisterType(a.Type) {
+			return fmt.Errorf("address alias %d: unknown register type '%s'", i, a.Type)
+		}
+		if size := m.Size(a.Type); int(a.Address) >= size || int(a.Target) >= size {
+			return fmt.Errorf("address alias %d: %s %d or target %d out of range for %d registers", i, a.Type, a.Address, a.Target, size)
+		}
+		if a.Address == a.Target {
+			return fmt.Errorf("address alias %d: %s %d is its own target", i, a.Type, a.Address)
+		}
+		if aliased[RegisterRef{Type: a.Type, Address: a.Target}] {
+			return fmt.Errorf("address alias %d: target %s %d is an alias itself", i, a.Type, a.Target)
+		}
+		for _, b := range m.AddressAliases[:i] {
+			if b.Type == a.Type && b.Address == a.Address {
+				return fmt.Errorf("address alias %d: %s %d is already an alias", i, a.Type, a.Address)
+			}
+		}
+		counter := m.CounterAddress
+		if a.Type == "holding" && (a.Address == counter || m.Counter32Bit && a.Address == counter+1) {
+			return fmt.Errorf("address alias %d: address %d is the counter address", i, a.Address)
+		}
+		if a.Type == "holding" && (a.Target == counter || m.Counter32Bit && a.Target == counter+1) {
+			return fmt.Errorf("address alias %d: target %d is the counter address", i, a.Target)
+		}
+	}
+
+	constrained := make(map[uint16]bool, len(m.Constraints))
+	for i, rc := range m.Constraints {
+		if int(rc.Address) >= m.Size("holding") {
+			return fmt.Errorf("constraint %d: address %d out of range", i, rc.Address)
+		}
+		if constrained[rc.Address] {
+			return fmt.Errorf("constraint %d: address %d already has a constraint", i, rc.Address)
+		}
+		constrained[rc.Address] = true
+		if len(rc.Allowed) == 0 && rc.Min == nil && rc.Max == nil {
+			return fmt.Errorf("constraint %d: set allowed, min or max", i)
+		}
+		if rc.Min != nil && rc.Max != nil && *rc.Min > *rc.Max {
+			return fmt.Errorf("constraint %d: min %d is above max %d", i, *rc.Min, *rc.Max)
+		}
+	}
+
+	for name, ref := range m.Aliases {
+		if name == "" || strings.Contains(name, "/") {
+			return fmt.Errorf("alias '%s': names must be non-empty and contain no '/'", name)
+		}
+		if !validRegisterType(ref.Type) {
+			return fmt.Errorf("alias '%s': unknown register type '%s'", name, ref.Type)
+		}
+		if int(ref.Address) >= m.Size(ref.Type) {
+			return fmt.Errorf("alias '%s': %s address %d out of range", name, ref.Type, ref.Address)
+		}
+	}
+
+	for i, ref := range m.Inverted {
+		if !validRegisterType(ref.Type) {
+			return fmt.Errorf("inverted %d: unknown register type '%s'", i, ref.Type)
+		}
+		if int(ref.Address) >= m.Size(ref.Type) {
+			return fmt.Errorf("inverted %d: %s address %d out of range", i, ref.Type, ref.Address)
+		}
+	}
+
+	if rt := m.ResetTrigger; rt != nil {
+		if rt.Type != "holding" && rt.Type != "coil" {
+			return fmt.Errorf("reset_trigger: type must be holding or coil, got '%s'", rt.Type)
+		}
+		if int(rt.Address) >= m.Size(rt.Type) {
+			return fmt.Errorf("reset_trigger: %s address %d out of range", rt.Type, rt.Address)
+		}
+		if rt.Type == "coil" && rt.Value > 1 {
+			return fmt.Errorf("reset_trigger: coil value must be 0 or 1")
+		}
+		counter := rt.Address == m.CounterAddress || m.Counter32Bit && int(rt.Address) == int(m.CounterAddress)+1
+		if rt.Type == "holding" && counter {
+			return fmt.Errorf("reset_trigger: address %d is the counter", rt.Address)
+		}
+	}
+
+	for _, addr := range m.WatchedRegisters {
+		if int(addr) >= m.Size("holding") {
+			return fmt.Errorf("watched_registers: address %d out of range", addr)
+		}
+	}
+
+	for _, addr := range m.DelayedRegisters {
+		if int(addr) >= m.Size("holding") {
+			return fmt.Errorf("delayed_registers: address %d out of range", addr)
+		}
+	}
+
+	return nil
+}
+
+// Save writes the configuration to filename as indented JSON, creating its
+// directory if needed.
+func (c *Config) Save(filename string) error {
+	if dir := filepath.Dir(filename); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create config directory: %w", err)
+		}
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create config file '%s': %w", filename, err)
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(c); err != nil {
+		return fmt.Errorf("failed to write config file '%s': %w", filename, err)
+	}
+	return nil
+}
+
+// LoadConfig loads filename, creating it with the defaults if it does not exist.
 func LoadConfig(filename string) (*Config, error) {
-	// Default configuration
-	config := &Config{
+	return loadConfig(filename, true)
+}
+
+// LoadExistingConfig loads filename and fails if it does not exist, for
+// read-only filesystems and environments where a missing config is an error.
+func LoadExistingConfig(filename string) (*Config, error) {
+	return loadConfig(filename, false)
+}
+
+// Default returns the built-in configuration. A missing config file is
+// created with it, and a non-strict startup falls back to it when the config
+// file can't be loaded.
+func Default() *Config {
+	return &Config{
+		Version: CurrentVersion,
 		Server: ServerConfig{
 			Address:    "0.0.0.0",
 			Port:       1502,
@@ -57,16 +1122,17 @@ func LoadConfig(filename string) (*Config, error) {
 			RetryDelay: 5,
 		},
 		Logging: LoggingConfig{
-			Level:   "INFO",
-			File:    "modbus_server.jsonl",
-			MaxSize: 100,
-			Console: true,
+			Level:      "INFO",
+			File:       "modbus_server.jsonl",
+			MaxSize:    100,
+			MaxBackups: 10,
+			Console:    true,
 		},
 		Modbus: ModbusConfig{
 			UnitID:         1,
 			MaxRegisters:   1000,
 			CounterAddress: 102,
-			UpdateInterval: 1,
+			UpdateInterval: Duration(time.Second),
 			InitialData: []RegisterValue{
 				{Type: "holding", Address: 100, Value: 2025},
 				{Type: "holding", Address: 101, Value: 1234},
@@ -75,43 +1141,76 @@ func LoadConfig(filename string) (*Config, error) {
 				{Type: "input", Address: 100, Value: 5678},
 			},
 		},
+		Admin: AdminConfig{
+			Enabled: false,
+			Address: "127.0.0.1:8080",
+		},
+		Standby: StandbyConfig{
+			Enabled:       false,
+			LeaseTimeout:  Duration(5 * time.Second),
+			ProbeInterval: Duration(time.Second),
+		},
+		Replica: ReplicaConfig{
+			Enabled:         false,
+			RefreshInterval: Duration(time.Second),
+		},
 	}
+}
 
-	if _, err := os.Stat(filename); os.IsNotExist(err) {
-
-		log.Printf("Config file '%s' not found, creating with defaults", filename)
+func loadConfig(filename string, createMissing bool) (*Config, error) {
+	config := Default()
 
-		if dir := filepath.Dir(filename); dir != "." {
-			if err := os.MkdirAll(dir, 0755); err != nil {
-				return nil, fmt.Errorf("failed to create config directory: %w", err)
-			}
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		if !createMissing {
+			return nil, fmt.Errorf("config file '%s' not found", filename)
 		}
 
-		file, err := os.Create(filename)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create config file '%s': %w", filename, err)
-		}
-		defer file.Close()
+		config.notices = append(config.notices, fmt.Sprintf("Config file '%s' not found, creating with defaults", filename))
 
-		encoder := json.NewEncoder(file)
-		encoder.SetIndent("", "  ")
-		if err := encoder.Encode(config); err != nil {
-			return nil, fmt.Errorf("failed to write config file '%s': %w", filename, err)
+		if err := config.Save(filename); err != nil {
+			return nil, err
 		}
 
-		log.Printf("Created config file '%s' - edit it and restart to customize settings", filename)
+		config.notices = append(config.notices, fmt.Sprintf("Created config file '%s' - edit it and restart to customize settings", filename))
 		return config, nil
 	}
 
-	file, err := os.Open(filename)
+	var included []string
+	doc, err := readMerged(filename, nil, &included)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open config file '%s': %w", filename, err)
+		return nil, err
+	}
+	version, changes, err := migrate(doc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file '%s': %w", filename, err)
+	}
+	merged, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge config file '%s': %w", filename, err)
+	}
+	if len(included) > 0 {
+		config.notices = append(config.notices, fmt.Sprintf("Config file '%s' includes %s", filename, strings.Join(included, ", ")))
+	}
+	if len(changes) > 0 {
+		config.notices = append(config.notices, fmt.Sprintf("Config file '%s' migrated from version %d to %d: %s", filename, version, CurrentVersion, strings.Join(changes, ", ")))
 	}
-	defer file.Close()
 
-	if err := json.NewDecoder(file).Decode(config); err != nil {
+	// Decoding into the default slice would reuse its elements, leaking default
+	// fields into entries that omit them
+	defaultData := config.Modbus.InitialData
+	config.Modbus.InitialData = nil
+
+	if err := json.Unmarshal(merged, config); err != nil {
 		return nil, fmt.Errorf("failed to parse config file '%s': %w", filename, err)
 	}
 
+	if config.Modbus.InitialData == nil {
+		config.Modbus.InitialData = defaultData
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file '%s': %w", filename, err)
+	}
+
 	return config, nil
 }
@@ -0,0 +1,589 @@
+// config_test.go - Configuration loading tests
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig writes a config file into a temp directory and returns its path
+func writeConfig(t *testing.T, body string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return path
+}
+
+// TestSignedInitialValues tests signed and float initial data encoding and validation
+func TestSignedInitialValues(t *testing.T) {
+	t.Run("TwosComplement", func(t *testing.T) {
+		cfg, err := LoadConfig(writeConfig(t, `{"modbus": {"initial_data": [
+			{"type": "holding", "address": 5, "signed_value": -50},
+			{"type": "input", "address": 6, "float32": 12.5}
+		]}}`))
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+
+		if words := cfg.Modbus.InitialData[0].Words(); len(words) != 1 || words[0] != 0xffce {
+			t.Fatalf("Expected -50 to encode as 0xffce, got %#v", words)
+		}
+		if words := cfg.Modbus.InitialData[1].Words(); len(words) != 2 || words[0] != 0x4148 || words[1] != 0 {
+			t.Fatalf("Expected 12.5 to encode as 0x4148 0x0000, got %#v", words)
+		}
+	})
+
+	t.Run("MultipleValuesRejected", func(t *testing.T) {
+		_, err := LoadConfig(writeConfig(t, `{"modbus": {"initial_data": [
+			{"type": "holding", "address": 5, "value": 10, "signed_value": -50}
+		]}}`))
+		if err == nil {
+			t.Fatal("Expected error for entry with both value and signed_value")
+		}
+	})
+}
+
+// TestMissingConfig tests create-on-missing versus strict loading
+func TestMissingConfig(t *testing.T) {
+	t.Run("CreatesDefault", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.json")
+		if _, err := LoadConfig(path); err != nil {
+			t.Fatalf("Expected default config, got %v", err)
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("Expected default config file to be created: %v", err)
+		}
+	})
+
+	t.Run("CreationNotices", func(t *testing.T) {
+		cfg, err := LoadConfig(filepath.Join(t.TempDir(), "config.json"))
+		if err != nil {
+			t.Fatalf("Expected default config, got %v", err)
+		}
+		if len(cfg.Notices()) == 0 {
+			t.Fatal("Expected notices about the created config file")
+		}
+	})
+
+	t.Run("Strict", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.json")
+		if _, err := LoadExistingConfig(path); err == nil {
+			t.Fatal("Expected error for missing config")
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("Expected no file to be written, stat returned %v", err)
+		}
+	})
+}
+
+// TestDefault tests that the fallback configuration is valid and matches a
+// freshly created config file
+func TestDefault(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("Expected valid defaults, got %v", err)
+	}
+
+	created, err := LoadConfig(filepath.Join(t.TempDir(), "config.json"))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if created.Modbus.CounterAddress != Default().Modbus.CounterAddress || created.Server.Port != Default().Server.Port {
+		t.Fatalf("Expected created config to use the defaults, got %+v", created)
+	}
+
+	if _, err := LoadConfig(writeConfig(t, `{"server": `)); err == nil {
+		t.Fatal("Expected error for malformed config")
+	}
+}
+
+// TestIncludes tests merging of included config files
+func TestIncludes(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, body string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		return path
+	}
+
+	write("base.json", `{
+		"name": "base",
+		"server": {"port": 1600, "max_clients": 4},
+		"modbus": {
+			"update_interval": "500ms",
+			"exception_map": {"out_of_bounds": 4},
+			"initial_data": [
+				{"type": "holding", "address": 1, "value": 10},
+				{"type": "holding", "address": 2, "value": 20}
+			]
+		}
+	}`)
+	write("site.json", `{"include": ["base.json"], "server": {"max_clients": 6}}`)
+	path := write("prod.json", `{
+		"include": ["site.json"],
+		"name": "prod",
+		"server": {"port": 1700},
+		"modbus": {
+			"exception_map": {"device_busy": 11},
+			"initial_data": [{"type": "coil", "address": 0, "value": 1}]
+		}
+	}`)
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	t.Run("FieldOverride", func(t *testing.T) {
+		if cfg.Name != "prod" || cfg.Server.Port != 1700 {
+			t.Fatalf("Expected prod on port 1700, got %s on %d", cfg.Name, cfg.Server.Port)
+		}
+		if cfg.Server.MaxClients != 6 {
+			t.Fatalf("Expected max_clients 6 from site.json, got %d", cfg.Server.MaxClients)
+		}
+		if cfg.Modbus.UpdateInterval != Duration(500*time.Millisecond) {
+			t.Fatalf("Expected update_interval from base.json, got %v", time.Duration(cfg.Modbus.UpdateInterval))
+		}
+		if cfg.Server.Address != "0.0.0.0" {
+			t.Fatalf("Expected the default address, got %s", cfg.Server.Address)
+		}
+	})
+
+	t.Run("ObjectMerge", func(t *testing.T) {
+		if cfg.Modbus.ExceptionMap["out_of_bounds"] != 4 || cfg.Modbus.ExceptionMap["device_busy"] != 11 {
+			t.Fatalf("Expected exception maps to merge, got %v", cfg.Modbus.ExceptionMap)
+		}
+	})
+
+	t.Run("ArrayReplacement", func(t *testing.T) {
+		if len(cfg.Modbus.InitialData) != 1 || cfg.Modbus.InitialData[0].Type != "coil" {
+			t.Fatalf("Expected initial_data to be replaced, got %+v", cfg.Modbus.InitialData)
+		}
+	})
+
+	t.Run("Notice", func(t *testing.T) {
+		if len(cfg.Notices()) != 1 {
+			t.Fatalf("Expected a notice listing the includes, got %v", cfg.Notices())
+		}
+	})
+
+	t.Run("Cycle", func(t *testing.T) {
+		write("a.json", `{"include": ["b.json"]}`)
+		if _, err := LoadConfig(write("b.json", `{"include": ["a.json"]}`)); err == nil {
+			t.Fatal("Expected error for an include cycle")
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		if _, err := LoadConfig(write("broken.json", `{"include": ["nowhere.json"]}`)); err == nil {
+			t.Fatal("Expected error for a missing include")
+		}
+		if _, err := os.Stat(filepath.Join(dir, "nowhere.json")); !os.IsNotExist(err) {
+			t.Fatal("Expected a missing include not to be created")
+		}
+	})
+}
+
+// TestStrictInitialData tests that strict mode rejects overlapping entries
+func TestStrictInitialData(t *testing.T) {
+	_, err := LoadConfig(writeConfig(t, `{"modbus": {"strict_initial_data": true, "initial_data": [
+		{"type": "holding", "address": 5, "float32": 1.5},
+		{"type": "holding", "address": 6, "value": 10}
+	]}}`))
+	if err == nil {
+		t.Fatal("Expected error for float32 overlapping the next entry")
+	}
+}
+
+// TestServerMode tests transport selection through the server mode
+func TestServerMode(t *testing.T) {
+	tests := []struct {
+		mode    string
+		network string
+		valid   bool
+	}{
+		{"", "tcp", true},
+		{"tcp", "tcp", true},
+		{"udp", "udp", true},
+		{"rtu", "", false},
+		{"serial", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run("Mode_"+tt.mode, func(t *testing.T) {
+			cfg, err := LoadConfig(writeConfig(t, `{"server": {"mode": "`+tt.mode+`"}}`))
+			if !tt.valid {
+				if err == nil {
+					t.Fatalf("Expected error for mode '%s'", tt.mode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to load config: %v", err)
+			}
+			if got := cfg.Server.Network(); got != tt.network {
+				t.Fatalf("Expected network %s, got %s", tt.network, got)
+			}
+		})
+	}
+}
+
+// TestAliases tests validation of the register alias table
+func TestAliases(t *testing.T) {
+	if _, err := LoadConfig(writeConfig(t, `{"modbus": {"max_registers": 100, "counter_address": 10, "aliases": {"temperature": {"type": "input", "address": 30}}}}`)); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	for name, body := range map[string]string{
+		"UnknownType": `{"modbus": {"aliases": {"temperature": {"type": "analog", "address": 30}}}}`,
+		"OutOfRange":  `{"modbus": {"max_registers": 100, "counter_address": 10, "aliases": {"temperature": {"type": "input", "address": 100}}}}`,
+		"Slash":       `{"modbus": {"aliases": {"a/b": {"type": "holding", "address": 1}}}}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := LoadConfig(writeConfig(t, body)); err == nil {
+				t.Fatal("Expected error for invalid alias")
+			}
+		})
+	}
+}
+
+// TestConstraints tests validation of register constraints
+func TestConstraints(t *testing.T) {
+	if _, err := LoadConfig(writeConfig(t, `{"modbus": {"constraints": [{"address": 5, "allowed": [0, 1, 2, 3]}, {"address": 6, "min": 10, "max": 20}]}}`)); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	for name, body := range map[string]string{
+		"Empty":      `{"modbus": {"constraints": [{"address": 5}]}}`,
+		"MinMax":     `{"modbus": {"constraints": [{"address": 5, "min": 20, "max": 10}]}}`,
+		"OutOfRange": `{"modbus": {"constraints": [{"address": 5000, "max": 10}]}}`,
+		"Duplicate":  `{"modbus": {"constraints": [{"address": 5, "max": 10}, {"address": 5, "min": 1}]}}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := LoadConfig(writeConfig(t, body)); err == nil {
+				t.Fatal("Expected error for invalid constraint")
+			}
+		})
+	}
+}
+
+// TestExceptionMap tests validation of exception_map conditions and codes
+func TestExceptionMap(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, `{"modbus": {"exception_map": {"out_of_bounds": 4, "device_busy": 11}}}`))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if code := cfg.Modbus.ExceptionMap["out_of_bounds"]; code != 4 {
+		t.Fatalf("Expected out_of_bounds code 4, got %d", code)
+	}
+
+	for name, value := range map[string]string{
+		"UnknownCondition": `{"timeout": 2}`,
+		"InvalidCode":      `{"out_of_bounds": 7}`,
+		"ZeroCode":         `{"invalid_unit": 0}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := LoadConfig(writeConfig(t, `{"modbus": {"exception_map": `+value+`}}`)); err == nil {
+				t.Fatalf("Expected exception_map %s to be rejected", value)
+			}
+		})
+	}
+}
+
+// TestQuantityLimits tests validation and defaults of quantity_limits
+func TestQuantityLimits(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, `{"modbus": {"quantity_limits": {"read_holding_registers": 64}}}`))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	limits := cfg.Modbus.QuantityLimits.WithDefaults()
+	if limits.ReadHoldingRegisters != 64 || limits.WriteRegisters != 123 || limits.WriteCoils != 1968 {
+		t.Fatalf("Expected configured and spec limits, got %+v", limits)
+	}
+
+	if _, err := LoadConfig(writeConfig(t, `{"modbus": {"quantity_limits": {"write_registers": 124}}}`)); err == nil {
+		t.Fatal("Expected a limit above the Modbus limit to be rejected")
+	}
+}
+
+// TestResetTrigger tests validation of the reset trigger
+func TestResetTrigger(t *testing.T) {
+	if _, err := LoadConfig(writeConfig(t, `{"modbus": {"reset_trigger": {"type": "holding", "address": 99, "value": 42405}}}`)); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	for name, trigger := range map[string]string{
+		"InputType":  `{"type": "input", "address": 5, "value": 1}`,
+		"OutOfRange": `{"type": "coil", "address": 5000, "value": 1}`,
+		"CoilValue":  `{"type": "coil", "address": 5, "value": 2}`,
+		"Counter":    `{"type": "holding", "address": 0, "value": 1}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := LoadConfig(writeConfig(t, `{"modbus": {"counter_address": 0, "reset_trigger": `+trigger+`}}`)); err == nil {
+				t.Fatalf("Expected reset_trigger %s to be rejected", trigger)
+			}
+		})
+	}
+}
+
+// TestAddressAliases tests validation of address aliases
+func TestAddressAliases(t *testing.T) {
+	if _, err := LoadConfig(writeConfig(t, `{"modbus": {"address_aliases": [
+		{"type": "holding", "address": 80, "target": 20}, {"type": "coil", "address": 80, "target": 20}]}}`)); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	for name, aliases := range map[string]string{
+		"UnknownType":   `[{"type": "bogus", "address": 80, "target": 20}]`,
+		"OutOfRange":    `[{"type": "holding", "address": 5000, "target": 20}]`,
+		"SelfTarget":    `[{"type": "holding", "address": 20, "target": 20}]`,
+		"Chain":         `[{"type": "holding", "address": 80, "target": 20}, {"type": "holding", "address": 81, "target": 80}]`,
+		"Duplicate":     `[{"type": "holding", "address": 80, "target": 20}, {"type": "holding", "address": 80, "target": 21}]`,
+		"Counter":       `[{"type": "holding", "address": 0, "target": 20}]`,
+		"CounterTarget": `[{"type": "holding", "address": 80, "target": 0}]`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := LoadConfig(writeConfig(t, `{"modbus": {"counter_address": 0, "address_aliases": `+aliases+`}}`)); err == nil {
+				t.Fatalf("Expected address_aliases %s to be rejected", aliases)
+			}
+		})
+	}
+}
+
+// TestUnitInitialData tests validation of per-unit initial data against the
+// unit's own size
+func TestUnitInitialData(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, `{"modbus": {"max_registers": 1000, "counter_address": 0, "units": [
+		{"unit_id": 2, "max_registers": 100, "initial_data": [{"type": "holding", "address": 99, "value": 7}]}]}}`))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if data := cfg.Modbus.ForUnit(cfg.Modbus.Units[0]).InitialData; len(data) != 1 || data[0].Value != 7 {
+		t.Fatalf("Expected the unit's initial data, got %+v", data)
+	}
+
+	for name, unit := range map[string]string{
+		"OutOfUnitRange": `{"unit_id": 2, "max_registers": 100, "initial_data": [{"type": "holding", "address": 100, "value": 7}]}`,
+		"Float32AtEnd":   `{"unit_id": 2, "max_registers": 100, "initial_data": [{"type": "holding", "address": 99, "float32": 1.5}]}`,
+		"UnknownType":    `{"unit_id": 2, "initial_data": [{"type": "bogus", "address": 1, "value": 7}]}`,
+		"TwoValues":      `{"unit_id": 2, "initial_data": [{"type": "holding", "address": 1, "value": 7, "signed_value": -1}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			body := `{"modbus": {"max_registers": 1000, "counter_address": 0, "units": [` + unit + `]}}`
+			if _, err := LoadConfig(writeConfig(t, body)); err == nil {
+				t.Fatalf("Expected unit %s to be rejected", unit)
+			}
+		})
+	}
+}
+
+// TestUnitCounter tests that every unit must hold the whole counter and its
+// input register mirror
+func TestUnitCounter(t *testing.T) {
+	if _, err := LoadConfig(writeConfig(t, `{"modbus": {"counter_address": 98, "counter_32bit": true, "counter_mode": "epoch", "units": [
+		{"unit_id": 2, "max_registers": 100}]}}`)); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	for name, body := range map[string]string{
+		"Address":    `{"modbus": {"counter_address": 100, "units": [{"unit_id": 2, "max_registers": 100}]}}`,
+		"SecondWord": `{"modbus": {"counter_address": 99, "counter_32bit": true, "counter_mode": "epoch", "units": [{"unit_id": 2, "max_registers": 100}]}}`,
+		"Mirror":     `{"modbus": {"max_registers": 200, "counter_address": 0, "mirror_counter_to_input": 150, "units": [{"unit_id": 2, "max_registers": 100}]}}`,
+		"MirrorWord": `{"modbus": {"counter_address": 0, "counter_mode": "epoch", "counter_32bit": true, "mirror_counter_to_input": 99, "units": [{"unit_id": 2, "max_registers": 100}]}}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := LoadConfig(writeConfig(t, body)); err == nil {
+				t.Fatalf("Expected %s to be rejected", body)
+			}
+		})
+	}
+}
+
+// TestUnitLayout tests that address settings, applied by every unit, are
+// checked against each unit's own size
+func TestUnitLayout(t *testing.T) {
+	for name, setting := range map[string]string{
+		"PackedCoils":  `"packed_coils": [{"address": 150, "coil": 0, "count": 8}]`,
+		"PackedSource": `"packed_coils": [{"address": 1, "coil": 95, "count": 8}]`,
+		"Mirror":       `"mirrors": [{"source": 1, "dest": 150}]`,
+		"Constraint":   `"constraints": [{"address": 150, "min": 1}]`,
+		"ResetTrigger": `"reset_trigger": {"type": "coil", "address": 150, "value": 1}`,
+		"Inverted":     `"inverted": [{"type": "input", "address": 150}]`,
+		"Delayed":      `"delayed_registers": [150]`,
+		"Watched":      `"watched_registers": [150]`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			body := `{"modbus": {"max_registers": 200, "counter_address": 0, ` + setting + `}}`
+			if _, err := LoadConfig(writeConfig(t, body)); err != nil {
+				t.Fatalf("Failed to load config: %v", err)
+			}
+			body = `{"modbus": {"max_registers": 200, "counter_address": 0, ` + setting + `, "units": [{"unit_id": 2, "max_registers": 100}]}}`
+			_, err := LoadConfig(writeConfig(t, body))
+			if err == nil || !strings.Contains(err.Error(), "unit 0") {
+				t.Fatalf("Expected the unit to reject %s, got %v", setting, err)
+			}
+		})
+	}
+}
+
+// TestUpdateInterval tests duration strings and integer seconds for update_interval
+func TestUpdateInterval(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{"SubSecond", `"500ms"`, 500 * time.Millisecond},
+		{"MultiSecond", `"10s"`, 10 * time.Second},
+		{"IntegerSeconds", `2`, 2 * time.Second},
+		{"Mixed", `"1m30s"`, 90 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadConfig(writeConfig(t, `{"modbus": {"update_interval": `+tt.value+`}}`))
+			if err != nil {
+				t.Fatalf("Failed to load config: %v", err)
+			}
+			if got := time.Duration(cfg.Modbus.UpdateInterval); got != tt.want {
+				t.Fatalf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, value := range []string{`"fast"`, `"0s"`, `-1`} {
+			if _, err := LoadConfig(writeConfig(t, `{"modbus": {"update_interval": `+value+`}}`)); err == nil {
+				t.Fatalf("Expected update_interval %s to be rejected", value)
+			}
+		}
+	})
+
+	t.Run("Marshal", func(t *testing.T) {
+		for d, want := range map[Duration]string{
+			Duration(time.Second):            `1`,
+			Duration(250 * time.Millisecond): `"250ms"`,
+		} {
+			b, err := json.Marshal(d)
+			if err != nil {
+				t.Fatalf("Failed to marshal %v: %v", time.Duration(d), err)
+			}
+			if string(b) != want {
+				t.Fatalf("Expected %s, got %s", want, b)
+			}
+		}
+	})
+}
+
+// TestMigration tests that older config versions load in the current shape
+func TestMigration(t *testing.T) {
+	load := func(body string) (*Config, error) {
+		t.Helper()
+		return LoadConfig(writeConfig(t, body))
+	}
+
+	t.Run("Version1", func(t *testing.T) {
+		cfg, err := load(`{"modbus": {"simulation_seed": 42}}`)
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+		if cfg.Version != CurrentVersion {
+			t.Errorf("Expected version %d, got %d", CurrentVersion, cfg.Version)
+		}
+		if cfg.Seed != 42 || cfg.Modbus.SimulationSeed != 0 {
+			t.Errorf("Expected simulation_seed moved to seed 42, got seed %d, simulation_seed %d", cfg.Seed, cfg.Modbus.SimulationSeed)
+		}
+		if len(cfg.Notices()) != 1 || !strings.Contains(cfg.Notices()[0], "migrated from version 1 to 3") {
+			t.Errorf("Expected a migration notice, got %v", cfg.Notices())
+		}
+	})
+
+	t.Run("Version1WithSeed", func(t *testing.T) {
+		cfg, err := load(`{"seed": 7, "modbus": {"simulation_seed": 42}}`)
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+		if cfg.Seed != 7 || cfg.Modbus.SimulationSeed != 42 {
+			t.Errorf("Expected both seeds kept, got seed %d, simulation_seed %d", cfg.Seed, cfg.Modbus.SimulationSeed)
+		}
+		if len(cfg.Notices()) != 0 {
+			t.Errorf("Expected no notices, got %v", cfg.Notices())
+		}
+	})
+
+	t.Run("Version2", func(t *testing.T) {
+		cfg, err := load(`{"version": 2, "modbus": {"reset_addresses": [20, 30], "initial_data": [
+			{"type": "holding", "address": 20, "value": 1}, {"type": "input", "address": 20, "value": 2}]}}`)
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+		want := []RegisterRef{{Type: "holding", Address: 20}, {Type: "input", Address: 20}, {Type: "holding", Address: 30}}
+		if !slices.Equal(cfg.Modbus.ResetAddresses, want) {
+			t.Errorf("Expected reset_addresses %v, got %v", want, cfg.Modbus.ResetAddresses)
+		}
+		if len(cfg.Notices()) != 1 || !strings.Contains(cfg.Notices()[0], "reset_addresses given register types") {
+			t.Errorf("Expected a migration notice, got %v", cfg.Notices())
+		}
+	})
+
+	t.Run("CurrentVersion", func(t *testing.T) {
+		cfg, err := load(`{"version": 3, "modbus": {"simulation_seed": 42}}`)
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+		if cfg.Seed != 0 || cfg.Modbus.SimulationSeed != 42 {
+			t.Errorf("Expected simulation_seed kept, got seed %d, simulation_seed %d", cfg.Seed, cfg.Modbus.SimulationSeed)
+		}
+	})
+
+	t.Run("NewerVersion", func(t *testing.T) {
+		_, err := load(`{"version": 99}`)
+		if err == nil || !strings.Contains(err.Error(), "newer than the supported version") {
+			t.Fatalf("Expected a newer version error, got %v", err)
+		}
+	})
+
+	t.Run("SavedVersion", func(t *testing.T) {
+		if v := Default().Version; v != CurrentVersion {
+			t.Fatalf("Expected defaults at version %d, got %d", CurrentVersion, v)
+		}
+	})
+}
+
+// TestCounterStartDelay tests that counter_start_delay takes seconds or a
+// duration string and must not be negative
+func TestCounterStartDelay(t *testing.T) {
+	for name, tc := range map[string]struct {
+		value string
+		want  time.Duration
+	}{
+		"Seconds":  {`5`, 5 * time.Second},
+		"Duration": {`"1500ms"`, 1500 * time.Millisecond},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg, err := LoadConfig(writeConfig(t, `{"modbus": {"counter_start_delay": `+tc.value+`}}`))
+			if err != nil {
+				t.Fatalf("Failed to load config: %v", err)
+			}
+			if got := time.Duration(cfg.Modbus.CounterStartDelay); got != tc.want {
+				t.Fatalf("Expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+
+	t.Run("Negative", func(t *testing.T) {
+		if _, err := LoadConfig(writeConfig(t, `{"modbus": {"counter_start_delay": "-1s"}}`)); err == nil || !strings.Contains(err.Error(), "counter_start_delay") {
+			t.Fatalf("Expected a negative delay to be rejected, got %v", err)
+		}
+	})
+}
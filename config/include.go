@@ -0,0 +1,82 @@
+// include.go - Merging of config files pulled in through "include"
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// readMerged reads filename and the files listed in its top-level "include"
+// key, and returns them merged as one JSON document. Included files are
+// merged in order, then filename itself, so later files override earlier
+// ones: objects merge key by key, anything else, arrays included, is
+// replaced whole. Include paths are relative to the including file. included
+// collects every included file in load order.
+func readMerged(filename string, stack []string, included *[]string) (map[string]interface{}, error) {
+	for _, f := range stack {
+		if f == filename {
+			return nil, fmt.Errorf("config file '%s' includes itself", filename)
+		}
+	}
+	stack = append(stack, filename)
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config file '%s': %w", filename, err)
+	}
+
+	var doc map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to parse config file '%s': %w", filename, err)
+	}
+
+	var includes []string
+	if raw, ok := doc["include"]; ok {
+		list, ok := raw.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("config file '%s': include must be a list of file names", filename)
+		}
+		for _, entry := range list {
+			name, ok := entry.(string)
+			if !ok || name == "" {
+				return nil, fmt.Errorf("config file '%s': include must be a list of file names", filename)
+			}
+			includes = append(includes, name)
+		}
+		delete(doc, "include")
+	}
+
+	merged := map[string]interface{}{}
+	for _, name := range includes {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(filepath.Dir(filename), name)
+		}
+		sub, err := readMerged(name, stack, included)
+		if err != nil {
+			return nil, err
+		}
+		*included = append(*included, name)
+		mergeJSON(merged, sub)
+	}
+	mergeJSON(merged, doc)
+	return merged, nil
+}
+
+// mergeJSON merges src into dst. Nested objects are merged recursively;
+// every other value in src replaces the one in dst.
+func mergeJSON(dst, src map[string]interface{}) {
+	for key, value := range src {
+		srcObj, srcIsObj := value.(map[string]interface{})
+		dstObj, dstIsObj := dst[key].(map[string]interface{})
+		if srcIsObj && dstIsObj {
+			mergeJSON(dstObj, srcObj)
+			continue
+		}
+		dst[key] = value
+	}
+}
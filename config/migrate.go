@@ -0,0 +1,122 @@
+// migrate.go - Upgrades of older config schema versions
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// CurrentVersion is the config schema version this build writes. Files
+// without a version predate versioning and are treated as version 1.
+const CurrentVersion = 3
+
+// A migration upgrades a config document by one version in place and
+// describes each change it made.
+type migration func(doc map[string]interface{}) []string
+
+// migrations[i] upgrades version i+1 to version i+2.
+var migrations = []migration{
+	migrateSimulationSeed,
+	migrateResetAddresses,
+}
+
+// migrate upgrades doc to CurrentVersion and returns the version it was
+// written for along with a description of every change.
+func migrate(doc map[string]interface{}) (int, []string, error) {
+	version := 1
+	if raw, ok := doc["version"]; ok {
+		n, ok := raw.(json.Number)
+		v, err := n.Int64()
+		if !ok || err != nil || v < 1 {
+			return 0, nil, fmt.Errorf("version must be a positive integer")
+		}
+		if v > CurrentVersion {
+			return 0, nil, fmt.Errorf("version %d is newer than the supported version %d", v, CurrentVersion)
+		}
+		version = int(v)
+	}
+
+	var changes []string
+	for _, m := range migrations[version-1:] {
+		changes = append(changes, m(doc)...)
+	}
+	doc["version"] = CurrentVersion
+	return version, changes, nil
+}
+
+// migrateSimulationSeed moves a version 1 simulation_seed, then the only way
+// to make a run repeatable, to the top-level seed that covers all randomness
+// since version 2. The simulator follows seed when simulation_seed is unset,
+// so spikes and noise repeat as before.
+func migrateSimulationSeed(doc map[string]interface{}) []string {
+	modbus, _ := doc["modbus"].(map[string]interface{})
+	seed, ok := modbus["simulation_seed"]
+	if !ok {
+		return nil
+	}
+	if _, set := doc["seed"]; set {
+		return nil
+	}
+
+	doc["seed"] = seed
+	delete(modbus, "simulation_seed")
+	return []string{"modbus.simulation_seed moved to seed"}
+}
+
+// migrateResetAddresses turns the bare addresses of a version 2
+// reset_addresses, which matched initial data of any type, into typed
+// entries: one for every type of initial data at that address, so the same
+// entries are reset as before. An address without initial data becomes a
+// holding entry, which keeps the list from resetting everything.
+func migrateResetAddresses(doc map[string]interface{}) []string {
+	modbus, _ := doc["modbus"].(map[string]interface{})
+	list, ok := modbus["reset_addresses"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	// The types of initial data at each address, from the file or the defaults
+	types := map[string][]string{}
+	if data, ok := modbus["initial_data"].([]interface{}); ok {
+		for _, d := range data {
+			d, _ := d.(map[string]interface{})
+			regType, _ := d["type"].(string)
+			addr, _ := d["address"].(json.Number)
+			types[addr.String()] = append(types[addr.String()], regType)
+		}
+	} else {
+		for _, d := range Default().Modbus.InitialData {
+			addr := strconv.Itoa(int(d.Address))
+			types[addr] = append(types[addr], d.Type)
+		}
+	}
+
+	refs := []interface{}{}
+	converted := false
+	for _, entry := range list {
+		addr, ok := entry.(json.Number)
+		if !ok {
+			refs = append(refs, entry)
+			continue
+		}
+		converted = true
+		matched := types[addr.String()]
+		if len(matched) == 0 {
+			matched = []string{"holding"}
+		}
+		seen := map[string]bool{}
+		for _, regType := range matched {
+			if !seen[regType] {
+				seen[regType] = true
+				refs = append(refs, map[string]interface{}{"type": regType, "address": addr})
+			}
+		}
+	}
+	if !converted {
+		return nil
+	}
+
+	modbus["reset_addresses"] = refs
+	return []string{"modbus.reset_addresses given register types"}
+}
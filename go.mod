@@ -2,9 +2,9 @@ module SPModbus
 
 go 1.24.4
 
-require github.com/goburrow/modbus v0.1.0
-
 require (
-	github.com/goburrow/serial v0.1.0 // indirect
-	github.com/simonvetter/modbus v1.6.3 // indirect
+	github.com/goburrow/modbus v0.1.0
+	github.com/simonvetter/modbus v1.6.3
 )
+
+require github.com/goburrow/serial v0.1.0 // indirect
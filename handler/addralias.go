@@ -0,0 +1,71 @@
+// addralias.go - Several addresses backed by one stored value
+package handler
+
+// redirected wraps a store so reads and writes of an alias address use its
+// target's value instead.
+type redirected[T comparable] struct {
+	store[T]
+	targets map[int]int
+}
+
+func (r *redirected[T]) Get(addr int) T {
+	if target, ok := r.targets[addr]; ok {
+		addr = target
+	}
+	return r.store.Get(addr)
+}
+
+func (r *redirected[T]) Set(addr int, value T) {
+	if target, ok := r.targets[addr]; ok {
+		addr = target
+	}
+	r.store.Set(addr, value)
+}
+
+// target returns the address whose value addr shares: its alias target, or
+// addr itself. Client requests resolve every address through it before
+// applying per-address features such as constraints, protection, the
+// counter, computed registers, history and mirrors, so an alias can't be
+// used to bypass them.
+func (h *ModbusHandler) target(regType string, addr int) int {
+	if t, ok := h.aliases[regType][addr]; ok {
+		return t
+	}
+	return addr
+}
+
+// redirectAliases puts the tables with AddressAliases behind a redirection,
+// so every read and write path, from clients, the simulator or admin tools,
+// shares the target's storage. Aliases beyond a unit's smaller tables are
+// skipped with a warning. It is called once by the constructor.
+func (h *ModbusHandler) redirectAliases() {
+	targets := map[string]map[int]int{}
+	h.aliases = targets
+	for _, a := range h.config.AddressAliases {
+		if size := h.config.Size(a.Type); int(a.Address) >= size || int(a.Target) >= size {
+			h.logger.Warn("Address alias out of range, ignored", map[string]interface{}{
+				"type":    a.Type,
+				"address": a.Address,
+				"target":  a.Target,
+			})
+			continue
+		}
+		if targets[a.Type] == nil {
+			targets[a.Type] = map[int]int{}
+		}
+		targets[a.Type][int(a.Address)] = int(a.Target)
+	}
+
+	if t := targets["holding"]; t != nil {
+		h.holdingRegs = &redirected[uint16]{store: h.holdingRegs, targets: t}
+	}
+	if t := targets["input"]; t != nil {
+		h.inputRegs = &redirected[uint16]{store: h.inputRegs, targets: t}
+	}
+	if t := targets["coil"]; t != nil {
+		h.coils = &redirected[bool]{store: h.coils, targets: t}
+	}
+	if t := targets["discrete"]; t != nil {
+		h.discreteInputs = &redirected[bool]{store: h.discreteInputs, targets: t}
+	}
+}
@@ -0,0 +1,63 @@
+// aliases.go - Access to registers by their configured alias names
+package handler
+
+import (
+	"SPModbus/config"
+	"errors"
+	"fmt"
+)
+
+// ErrUnknownAlias is returned for a name that is not in the alias table.
+var ErrUnknownAlias = errors.New("unknown register alias")
+
+// ResolveAlias returns the register an alias names.
+func (h *ModbusHandler) ResolveAlias(name string) (config.RegisterRef, error) {
+	ref, ok := h.config.Aliases[name]
+	if !ok {
+		return ref, fmt.Errorf("%w '%s'", ErrUnknownAlias, name)
+	}
+	return ref, nil
+}
+
+// ReadAlias returns the current value of the register an alias names. Coils
+// and discrete inputs read as 0 or 1.
+func (h *ModbusHandler) ReadAlias(name string) (config.RegisterRef, uint16, error) {
+	ref, err := h.ResolveAlias(name)
+	if err != nil {
+		return ref, 0, err
+	}
+
+	if ref.Type == "coil" || ref.Type == "discrete" {
+		bits, err := h.ReadBits(ref.Type, ref.Address, 1)
+		if err != nil {
+			return ref, 0, err
+		}
+		if bits[0] {
+			return ref, 1, nil
+		}
+		return ref, 0, nil
+	}
+
+	regs, err := h.ReadRegisters(ref.Type, ref.Address, 1)
+	if err != nil {
+		return ref, 0, err
+	}
+	return ref, regs[0], nil
+}
+
+// WriteAlias sets the register an alias names, like SetRegister and SetBit.
+// Coils and discrete inputs accept only 0 and 1.
+func (h *ModbusHandler) WriteAlias(name string, value uint16) (config.RegisterRef, error) {
+	ref, err := h.ResolveAlias(name)
+	if err != nil {
+		return ref, err
+	}
+
+	if ref.Type == "coil" || ref.Type == "discrete" {
+		if value > 1 {
+			return ref, fmt.Errorf("%s value must be 0 or 1, got %d", ref.Type, value)
+		}
+		return ref, h.SetBit(ref.Type, ref.Address, value == 1)
+	}
+	return ref, h.SetRegister(ref.Type, ref.Address, value)
+}
@@ -0,0 +1,22 @@
+// boundary.go - Strict or lenient handling of reads crossing the table end
+package handler
+
+// available returns how many of quantity values from addr a request may
+// access in a table of size, or false when it is out of bounds. Strict mode,
+// the default, rejects any request extending past the table. In lenient mode
+// a read starting inside the table gets the values there, which the caller
+// zero-fills to quantity with zeroFill; writes are always strict.
+func (h *ModbusHandler) available(addr, quantity uint16, size int, write bool) (uint16, bool) {
+	if int(addr)+int(quantity) <= size {
+		return quantity, true
+	}
+	if write || h.config.BoundaryMode != "lenient" || int(addr) >= size {
+		return 0, false
+	}
+	return uint16(size - int(addr)), true
+}
+
+// zeroFill pads the result of a lenient read to the requested quantity.
+func zeroFill[T uint16 | bool](res []T, quantity uint16) []T {
+	return append(res, make([]T, int(quantity)-len(res))...)
+}
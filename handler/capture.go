@@ -0,0 +1,105 @@
+// capture.go - One-time capture of a real device's registers as initial data
+package handler
+
+import (
+	"SPModbus/config"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/simonvetter/modbus"
+)
+
+// CaptureRange is one block of addresses to read from a device.
+type CaptureRange struct {
+	Type    string
+	Address uint16
+	Count   int
+}
+
+// ParseCaptureRanges parses a comma-separated list of type:start-end ranges,
+// e.g. "holding:0-99,coil:0-15". A single address may omit the end.
+func ParseCaptureRanges(list string) ([]CaptureRange, error) {
+	var ranges []CaptureRange
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		regType, span, ok := strings.Cut(field, ":")
+		switch regType {
+		case "holding", "input", "coil", "discrete":
+		default:
+			return nil, fmt.Errorf("invalid capture range '%s': unknown register type '%s'", field, regType)
+		}
+		if !ok {
+			return nil, fmt.Errorf("invalid capture range '%s': expected type:start-end", field)
+		}
+
+		first, last, found := strings.Cut(span, "-")
+		if !found {
+			last = first
+		}
+		start, err := strconv.ParseUint(first, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid capture range '%s': %w", field, err)
+		}
+		end, err := strconv.ParseUint(last, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid capture range '%s': %w", field, err)
+		}
+		if end < start {
+			return nil, fmt.Errorf("invalid capture range '%s': end before start", field)
+		}
+
+		ranges = append(ranges, CaptureRange{Type: regType, Address: uint16(start), Count: int(end-start) + 1})
+	}
+
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no capture ranges given")
+	}
+	return ranges, nil
+}
+
+// Capture connects to a device as a client, reads every range once and
+// returns the values in the initial_data format. Bits are captured as 0 or 1.
+func Capture(url string, unitID uint8, ranges []CaptureRange, timeout time.Duration) ([]config.RegisterValue, error) {
+	client, err := modbus.NewClient(&modbus.ClientConfiguration{URL: url, Timeout: timeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create capture client: %w", err)
+	}
+	if err := client.Open(); err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", url, err)
+	}
+	defer client.Close()
+	client.SetUnitId(unitID)
+
+	var values []config.RegisterValue
+	for _, r := range ranges {
+		if r.Type == "holding" || r.Type == "input" {
+			words, err := readWords(client, r.Type, int(r.Address), r.Count)
+			if err != nil {
+				return nil, fmt.Errorf("failed to capture %s %d-%d: %w", r.Type, r.Address, int(r.Address)+r.Count-1, err)
+			}
+			for i, w := range words {
+				values = append(values, config.RegisterValue{Type: r.Type, Address: r.Address + uint16(i), Value: w})
+			}
+			continue
+		}
+
+		bits, err := readBits(client, r.Type, int(r.Address), r.Count)
+		if err != nil {
+			return nil, fmt.Errorf("failed to capture %s %d-%d: %w", r.Type, r.Address, int(r.Address)+r.Count-1, err)
+		}
+		for i, b := range bits {
+			var value uint16
+			if b {
+				value = 1
+			}
+			values = append(values, config.RegisterValue{Type: r.Type, Address: r.Address + uint16(i), Value: value})
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,40 @@
+// coilevents.go - Callbacks on client coil writes
+package handler
+
+import "github.com/simonvetter/modbus"
+
+// CoilWriteFunc is called after a client write changed a coil. It runs
+// outside the handler lock, so it may read or write registers itself.
+type CoilWriteFunc func(addr uint16, old, new bool)
+
+// coilChange is a changed coil whose callbacks run once the lock is released.
+type coilChange struct {
+	addr     uint16
+	old, new bool
+	fns      []CoilWriteFunc
+}
+
+// RegisterCoilOnWrite adds fn to the callbacks of a coil, e.g. to update a
+// status register when a command coil is set. Callbacks run in registration
+// order, only for client writes that change the value.
+func (h *ModbusHandler) RegisterCoilOnWrite(addr uint16, fn CoilWriteFunc) error {
+	if int(addr) >= h.coils.Len() {
+		return modbus.ErrIllegalDataAddress
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.coilCallbacks[addr] = append(h.coilCallbacks[addr], fn)
+	return nil
+}
+
+// fireCoilWrites runs the callbacks of every changed coil. It must be called
+// without the lock held.
+func (h *ModbusHandler) fireCoilWrites(changes []coilChange) {
+	for _, c := range changes {
+		for _, fn := range c.fns {
+			fn(c.addr, c.old, c.new)
+		}
+	}
+}
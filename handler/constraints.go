@@ -0,0 +1,41 @@
+// constraints.go - Validation of client writes against configured constraints
+package handler
+
+import (
+	"SPModbus/config"
+	"SPModbus/mlog"
+
+	"github.com/simonvetter/modbus"
+)
+
+func indexConstraints(list []config.RegisterConstraint) map[uint16]config.RegisterConstraint {
+	constraints := make(map[uint16]config.RegisterConstraint, len(list))
+	for _, c := range list {
+		constraints[c.Address] = c
+	}
+	return constraints
+}
+
+// checkConstraints rejects a holding register write if any of its values
+// violates a constraint, before anything is written, so a rejected
+// multi-register write leaves every register unchanged.
+func (h *ModbusHandler) checkConstraints(req *modbus.HoldingRegistersRequest, log *mlog.Logger) error {
+	if len(h.constraints) == 0 {
+		return nil
+	}
+
+	for i, value := range req.Args[:req.Quantity] {
+		addr := uint16(h.target("holding", int(req.Addr)+i))
+		c, ok := h.constraints[addr]
+		if !ok || c.Accepts(value) {
+			continue
+		}
+
+		return h.reject(log, true, req.Addr, "invalid_value", map[string]interface{}{
+			"client":  req.ClientAddr,
+			"address": addr,
+			"value":   value,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+// delayed.go - Delayed visibility of client writes to selected holding registers
+package handler
+
+import "time"
+
+// delayedWrites tracks writes that become readable only after WriteDelay.
+// Each write gets a sequence number so that a timer firing late never
+// overwrites a newer value. All fields are guarded by the handler lock.
+type delayedWrites struct {
+	delay   time.Duration
+	seq     map[uint16]uint64
+	applied map[uint16]uint64
+}
+
+func newDelayedWrites(addrs []uint16, delay time.Duration) *delayedWrites {
+	d := &delayedWrites{
+		delay:   delay,
+		seq:     make(map[uint16]uint64),
+		applied: make(map[uint16]uint64),
+	}
+	for _, addr := range addrs {
+		d.seq[addr] = 0
+	}
+	return d
+}
+
+func (d *delayedWrites) covers(addr uint16) bool {
+	if d.delay <= 0 {
+		return false
+	}
+	_, ok := d.seq[addr]
+	return ok
+}
+
+// cancel drops every staged write: their timers find a newer sequence number
+// applied and skip.
+func (d *delayedWrites) cancel() {
+	for addr, seq := range d.seq {
+		d.applied[addr] = seq
+	}
+}
+
+// stageWrite schedules value to be stored in addr after the write delay. It
+// must be called with the write lock held.
+func (h *ModbusHandler) stageWrite(addr uint16, value uint16) {
+	h.delayed.seq[addr]++
+	seq := h.delayed.seq[addr]
+
+	time.AfterFunc(h.delayed.delay, func() {
+		h.mu.Lock()
+		defer h.unlock()
+
+		if seq <= h.delayed.applied[addr] {
+			return
+		}
+		h.delayed.applied[addr] = seq
+		h.holdingRegs.Set(int(addr), value)
+		h.initialized.mark("holding", int(addr), 1)
+		h.applyMirrors(addr, value)
+
+		h.logger.Debug("Delayed write applied", map[string]interface{}{
+			"address": addr,
+			"value":   value,
+		})
+	})
+}
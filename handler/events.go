@@ -0,0 +1,94 @@
+// events.go - Fan-out of handler events to subscribers
+package handler
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventType says what an Event describes.
+type EventType string
+
+const (
+	EventRegisterWrite EventType = "register_write" // a client wrote a holding register
+	EventCoilWrite     EventType = "coil_write"     // a client wrote a coil
+	EventCounterUpdate EventType = "counter_update" // the counter advanced
+	EventConnect       EventType = "connect"        // a client connected
+	EventFault         EventType = "fault"          // a request was answered with an exception
+)
+
+// Event is one thing that happened in a handler. Coil values are 0 or 1.
+// Detail names the error condition of a fault.
+type Event struct {
+	Type    EventType `json:"type"`
+	Time    time.Time `json:"time"`
+	UnitID  uint8     `json:"unit_id"`
+	Address uint16    `json:"address"`
+	Old     uint16    `json:"old"`
+	Value   uint16    `json:"value"`
+	Client  string    `json:"client,omitempty"`
+	Detail  string    `json:"detail,omitempty"`
+}
+
+// eventBus delivers events to every subscribed channel without blocking: an
+// event a subscriber has no room for is dropped for that subscriber only.
+type eventBus struct {
+	mu      sync.RWMutex
+	subs    map[chan<- Event]struct{}
+	dropped atomic.Uint64
+}
+
+// Subscribe delivers every later event to ch. Sends never block, so give ch
+// a buffer sized for bursts; events arriving while it is full are dropped and
+// counted in DroppedEvents.
+func (h *ModbusHandler) Subscribe(ch chan<- Event) {
+	h.events.mu.Lock()
+	defer h.events.mu.Unlock()
+
+	if h.events.subs == nil {
+		h.events.subs = make(map[chan<- Event]struct{})
+	}
+	h.events.subs[ch] = struct{}{}
+}
+
+// Unsubscribe stops delivery to ch. It does not close ch.
+func (h *ModbusHandler) Unsubscribe(ch chan<- Event) {
+	h.events.mu.Lock()
+	defer h.events.mu.Unlock()
+	delete(h.events.subs, ch)
+}
+
+// DroppedEvents returns how many events were dropped because a subscriber's
+// channel was full.
+func (h *ModbusHandler) DroppedEvents() uint64 {
+	return h.events.dropped.Load()
+}
+
+// emit sends e to every subscriber. It is safe to call with the register
+// lock held.
+func (h *ModbusHandler) emit(e Event) {
+	h.events.mu.RLock()
+	defer h.events.mu.RUnlock()
+
+	if len(h.events.subs) == 0 {
+		return
+	}
+	e.Time = h.now()
+	e.UnitID = h.config.UnitID
+	for ch := range h.events.subs {
+		select {
+		case ch <- e:
+		default:
+			h.events.dropped.Add(1)
+		}
+	}
+}
+
+// bitValue returns a coil value as an event value.
+func bitValue(b bool) uint16 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,49 @@
+// exceptions.go - Configurable exception codes for handler error conditions
+package handler
+
+import (
+	"SPModbus/config"
+
+	"github.com/simonvetter/modbus"
+)
+
+// exceptionErrors maps the exception codes accepted by exception_map to the
+// errors the protocol layer encodes as those codes.
+var exceptionErrors = map[uint8]error{
+	0x01: modbus.ErrIllegalFunction,
+	0x02: modbus.ErrIllegalDataAddress,
+	0x03: modbus.ErrIllegalDataValue,
+	0x04: modbus.ErrServerDeviceFailure,
+	0x05: modbus.ErrAcknowledge,
+	0x06: modbus.ErrServerDeviceBusy,
+	0x08: modbus.ErrMemoryParityError,
+	0x0a: modbus.ErrGWPathUnavailable,
+	0x0b: modbus.ErrGWTargetFailedToRespond,
+}
+
+// exception returns the error reported for an error condition and announces
+// the fault to subscribers.
+func (h *ModbusHandler) exception(condition string) error {
+	h.emit(Event{Type: EventFault, Detail: condition})
+	return h.exceptionFor(condition)
+}
+
+// exceptionFor returns the error reported for an error condition: the
+// exception_map override if there is one, the default otherwise.
+func (h *ModbusHandler) exceptionFor(condition string) error {
+	code, ok := h.config.ExceptionMap[condition]
+	if !ok {
+		code = config.ExceptionConditions[condition]
+	}
+	return exceptionErrors[code]
+}
+
+// exceptionCode returns the exception code an error is encoded as.
+func exceptionCode(err error) uint8 {
+	for code, e := range exceptionErrors {
+		if e == err {
+			return code
+		}
+	}
+	return 0x04
+}
@@ -4,6 +4,12 @@ package handler
 import (
 	"SPModbus/config"
 	"SPModbus/mlog"
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -12,231 +18,1315 @@ import (
 )
 
 type Stats struct {
-	RequestsHandled uint64
-	Errors          uint64
-	StartTime       time.Time
+	RequestsHandled     uint64
+	Errors              uint64
+	CounterOverflows    uint64
+	StartTime           time.Time
+	FirstRequestLatency time.Duration // 0 until the first request succeeded
 }
 
+// DiagnosticsRequest carries a diagnostics (0x08) request. The modbus library
+// has no request type for this function code.
+type DiagnosticsRequest struct {
+	ClientAddr  string
+	UnitId      uint8
+	SubFunction uint16
+	Data        []byte
+}
+
+const diagReturnQueryData uint16 = 0x0000
+
+// DeviceIdentificationRequest carries a read device identification request
+// (0x2B, MEI type 0x0E).
+type DeviceIdentificationRequest struct {
+	ClientAddr   string
+	UnitId       uint8
+	ReadDeviceID uint8
+	ObjectID     uint8
+}
+
+// DeviceObject is one device identification object.
+type DeviceObject struct {
+	ID    uint8
+	Value string
+}
+
+// Read device ID codes
+const (
+	ReadDeviceIDBasic      uint8 = 0x01
+	ReadDeviceIDRegular    uint8 = 0x02
+	ReadDeviceIDExtended   uint8 = 0x03
+	ReadDeviceIDIndividual uint8 = 0x04
+)
+
+// ComputeFunc derives the value of a computed holding register. It is called
+// with the handler lock held and receives a view of the live holding
+// registers, which it must not retain.
+type ComputeFunc func(addr uint16, regs RegisterReader) uint16
+
 type ModbusHandler struct {
-	config         config.ModbusConfig
-	logger         *mlog.Logger
-	mu             sync.RWMutex
-	holdingRegs    []uint16
-	inputRegs      []uint16
-	coils          []bool
-	discreteInputs []bool
-	counter        uint16
-	stats          Stats
+	config           config.ModbusConfig
+	logger           *mlog.Logger
+	mu               sync.RWMutex
+	holdingRegs      registerStore
+	inputRegs        registerStore
+	coils            bitStore
+	discreteInputs   bitStore
+	counter          uint16
+	counterSaturated bool
+	stats            Stats
+	maintenance      atomic.Bool
+	readOnly         atomic.Bool
+	computed         map[uint16]ComputeFunc
+	coilCallbacks    map[uint16][]CoilWriteFunc
+	history          map[uint16]*historyRing
+	delayed          *delayedWrites
+	initialized      *initTracker
+	protected        bitmap                           // holding registers locked against client writes
+	aliases          map[string]map[int]int           // alias address to target, per table
+	snapshot         atomic.Pointer[registerSnapshot] // served to reads when StaleReads is set
+	constraints      map[uint16]config.RegisterConstraint
+	inverted         map[config.RegisterRef]bool
+	mirrors          map[uint16][]uint16
+	inflight         chan struct{} // nil when concurrency is unlimited
+	rate             *tokenBucket  // nil when the request rate is unlimited
+	throttles        logThrottles
+	now              func() time.Time
+	first            firstRequest
+	events           eventBus
+	journal          txJournal
+}
+
+// firstRequest measures cold-start latency: the time from the server being
+// ready to the first successfully handled request.
+type firstRequest struct {
+	once    sync.Once
+	readyAt atomic.Int64 // unix nanoseconds
+	latency atomic.Int64 // nanoseconds, 0 until recorded
 }
 
 func NewModbusHandler(config config.ModbusConfig, logger *mlog.Logger) *ModbusHandler {
 	h := &ModbusHandler{
 		config:         config,
 		logger:         logger,
-		holdingRegs:    make([]uint16, config.MaxRegisters),
-		inputRegs:      make([]uint16, config.MaxRegisters),
-		coils:          make([]bool, config.MaxRegisters),
-		discreteInputs: make([]bool, config.MaxRegisters),
+		holdingRegs:    newRegisterStore(config.Storage, config.Size("holding")),
+		inputRegs:      newRegisterStore(config.Storage, config.Size("input")),
+		coils:          newBitStore(config.Storage, config.Size("coil")),
+		discreteInputs: newBitStore(config.Storage, config.Size("discrete")),
 		stats:          Stats{StartTime: time.Now()},
+		computed:       make(map[uint16]ComputeFunc),
+		coilCallbacks:  make(map[uint16][]CoilWriteFunc),
+		history:        newHistory(config.WatchedRegisters, config.HistoryDepth),
+		delayed:        newDelayedWrites(config.DelayedRegisters, time.Duration(config.WriteDelay)),
+		mirrors:        make(map[uint16][]uint16),
+		protected:      newBitmap(config.Size("holding")),
+		now:            time.Now,
+	}
+	h.redirectAliases()
+	h.journalStores()
+
+	for _, m := range config.Mirrors {
+		h.mirrors[m.Source] = append(h.mirrors[m.Source], m.Dest)
+	}
+	h.constraints = indexConstraints(config.Constraints)
+	h.inverted = indexInverted(config.Inverted)
+
+	if config.RejectUninitializedReads {
+		h.initialized = newInitTracker(map[string]int{
+			"holding":  config.Size("holding"),
+			"input":    config.Size("input"),
+			"coil":     config.Size("coil"),
+			"discrete": config.Size("discrete"),
+		}, h.aliases)
+	}
+
+	for _, p := range config.PackedCoils {
+		h.computed[p.Address] = h.packCoils(p)
+	}
+
+	if config.MaxConcurrentRequests > 0 {
+		h.inflight = make(chan struct{}, config.MaxConcurrentRequests)
+	}
+	if config.GlobalRateLimit > 0 {
+		h.rate = newTokenBucket(config.GlobalRateLimit, h.now())
+	}
+
+	for _, o := range config.InitialDataOverlaps() {
+		logger.Warn("Duplicate initial data entry, last one wins", map[string]interface{}{
+			"type":    o.Type,
+			"address": o.Address,
+			"first":   o.First,
+			"second":  o.Second,
+		})
+	}
+
+	h.applyInitPattern()
+	h.applyInitialData(config.InitialData)
+
+	h.first.readyAt.Store(h.stats.StartTime.UnixNano())
+
+	h.counter = config.CounterInitial
+	h.storeCounter(h.counter)
+	if h.clockCounter() {
+		h.setClockCounter()
+	}
+	h.publish()
+
+	logger.Info("Handler initialized", map[string]interface{}{
+		"max_registers": config.MaxRegisters,
+		"unit_id":       config.UnitID,
+	})
+
+	return h
+}
+
+// applyInitPattern pre-fills the holding and input registers according to
+// InitPattern. "address" stores each register's own address, "incrementing"
+// stores address+1 so no register reads as zero, "random" stores values drawn
+// from Seed (offset by the unit ID so units differ) and "constant:N" stores N.
+func (h *ModbusHandler) applyInitPattern() {
+	kind, constant, err := config.ParseInitPattern(h.config.InitPattern)
+	if err != nil {
+		h.logger.Warn("Invalid init pattern, using zero", map[string]interface{}{
+			"pattern": h.config.InitPattern,
+			"error":   err.Error(),
+		})
+		return
+	}
+	if kind == "zero" {
+		return
 	}
+	h.initialized.mark("holding", 0, h.holdingRegs.Len())
+	h.initialized.mark("input", 0, h.inputRegs.Len())
 
-	for _, data := range config.InitialData {
-		if data.Address >= uint16(config.MaxRegisters) {
-			logger.Warn("Initial data address out of bounds, skipping", map[string]interface{}{
+	if kind == "random" {
+		seed := h.config.Seed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		rng := rand.New(rand.NewSource(seed + int64(h.config.UnitID)))
+		for i := 0; i < h.holdingRegs.Len(); i++ {
+			h.holdingRegs.Set(i, uint16(rng.Intn(math.MaxUint16+1)))
+		}
+		for i := 0; i < h.inputRegs.Len(); i++ {
+			h.inputRegs.Set(i, uint16(rng.Intn(math.MaxUint16+1)))
+		}
+		return
+	}
+
+	// The tables may differ in size, so each is filled up to its own length
+	for _, table := range []registerStore{h.holdingRegs, h.inputRegs} {
+		for i := 0; i < table.Len(); i++ {
+			value := constant
+			switch kind {
+			case "address":
+				value = uint16(i)
+			case "incrementing":
+				value = uint16(i + 1)
+			}
+			table.Set(i, value)
+		}
+	}
+}
+
+// applyInitialData writes the given entries into the register arrays. Callers
+// other than the constructor must hold the write lock.
+func (h *ModbusHandler) applyInitialData(entries []config.RegisterValue) {
+	for _, data := range entries {
+		words := data.Words()
+		if size := h.config.Size(data.Type); int(data.Address)+len(words) > size {
+			h.logger.Warn("Initial data address out of bounds, skipping", map[string]interface{}{
+				"type":    data.Type,
 				"address": data.Address,
-				"max":     config.MaxRegisters,
+				"max":     size,
 			})
 			continue
 		}
 
+		h.initialized.mark(data.Type, int(data.Address), len(words))
+
 		switch data.Type {
 		case "holding":
-			h.holdingRegs[data.Address] = data.Value
+			setWords(h.holdingRegs, int(data.Address), words)
 		case "input":
-			h.inputRegs[data.Address] = data.Value
+			setWords(h.inputRegs, int(data.Address), words)
 		case "coil":
-			h.coils[data.Address] = (data.Value != 0)
+			h.coils.Set(int(data.Address), data.Value != 0)
 		case "discrete":
-			h.discreteInputs[data.Address] = (data.Value != 0)
+			h.discreteInputs.Set(int(data.Address), data.Value != 0)
 		default:
-			logger.Warn("Unknown initial data type in config, skipping", map[string]interface{}{
+			h.logger.Warn("Unknown initial data type in config, skipping", map[string]interface{}{
 				"type": data.Type,
 			})
 		}
 	}
+}
 
-	h.holdingRegs[config.CounterAddress] = 0
+// applyMirrors copies a written holding register value to its mirrored input
+// registers. Callers must hold the write lock.
+func (h *ModbusHandler) applyMirrors(addr uint16, value uint16) {
+	for _, dest := range h.mirrors[addr] {
+		if int(dest) >= h.inputRegs.Len() {
+			continue
+		}
+		h.inputRegs.Set(int(dest), value)
+		h.initialized.mark("input", int(dest), 1)
+	}
+}
 
-	logger.Info("Handler initialized", map[string]interface{}{
-		"max_registers": config.MaxRegisters,
-		"unit_id":       config.UnitID,
+// Function codes of the requests the register handlers serve
+const (
+	fcReadCoils              uint8 = 0x01
+	fcReadDiscreteInputs     uint8 = 0x02
+	fcReadHoldingRegisters   uint8 = 0x03
+	fcReadInputRegisters     uint8 = 0x04
+	fcWriteSingleCoil        uint8 = 0x05
+	fcWriteSingleRegister    uint8 = 0x06
+	fcWriteMultipleCoils     uint8 = 0x0f
+	fcWriteMultipleRegisters uint8 = 0x10
+)
+
+// writeFunction returns the function code of a write. The library requests
+// don't carry it, so a multiple write of one value reports as a single write.
+func writeFunction(quantity uint16, single, multiple uint8) uint8 {
+	if quantity == 1 {
+		return single
+	}
+	return multiple
+}
+
+// requestLogger returns a logger that adds the request's function code, unit
+// ID, start address and quantity to every entry logged while handling it.
+// With RequestLogLimit set its DEBUG and INFO entries are throttled per
+// client connection.
+func (h *ModbusHandler) requestLogger(clientAddr string, function, unitID uint8, addr, quantity uint16) *mlog.Logger {
+	log := h.logger
+	if t := h.logThrottle(clientAddr); t != nil {
+		log = log.Throttled(t)
+	}
+	return log.WithFields(map[string]interface{}{
+		"function": function,
+		"unit_id":  unitID,
+		"start":    addr,
+		"quantity": quantity,
 	})
+}
 
-	return h
+const defaultLogReadValuesLimit = 16
+
+// addReadValues adds the values returned by a read to a log entry when
+// LogReadValues is enabled, capped at LogReadValuesLimit entries.
+func addReadValues[T uint16 | bool](h *ModbusHandler, data map[string]interface{}, values []T) {
+	if !h.config.LogReadValues {
+		return
+	}
+	limit := h.config.LogReadValuesLimit
+	if limit <= 0 {
+		limit = defaultLogReadValuesLimit
+	}
+
+	if len(values) > limit {
+		data["values_truncated"] = len(values) - limit
+		values = values[:limit]
+	}
+	data["values"] = values
+}
+
+func setWords(regs registerStore, addr int, words []uint16) {
+	for i, w := range words {
+		regs.Set(addr+i, w)
+	}
+}
+
+// OnConnect is called by the server for every new client connection. When
+// ResetOnConnect is enabled the initial data (or the ResetAddresses subset of
+// it) is re-applied, simulating a freshly powered device for each session.
+func (h *ModbusHandler) OnConnect(clientAddr string) {
+	h.emit(Event{Type: EventConnect, Client: clientAddr})
+	if !h.config.ResetOnConnect {
+		return
+	}
+
+	var entries []config.RegisterValue
+	for _, data := range h.config.InitialData {
+		// The counter keeps running across sessions
+		if data.Type == "holding" && h.isCounter(int(data.Address)) {
+			continue
+		}
+		if len(h.config.ResetAddresses) > 0 && !slices.Contains(h.config.ResetAddresses, config.RegisterRef{Type: data.Type, Address: data.Address}) {
+			continue
+		}
+		entries = append(entries, data)
+	}
+
+	h.mu.Lock()
+	h.applyInitialData(entries)
+	h.unlock()
+
+	h.logger.Info("Registers reset on new connection", map[string]interface{}{
+		"client":  clientAddr,
+		"entries": len(entries),
+	})
+}
+
+// counterOverflow returns the counter value following 65535 according to
+// OverflowBehavior. Callers must hold the write lock.
+func (h *ModbusHandler) counterOverflow() uint16 {
+	behavior := h.config.OverflowBehavior
+	if behavior == "" {
+		behavior = "wrap-to-one"
+	}
+
+	// A saturated counter stays at the maximum; only report reaching it
+	if behavior == "saturate" && h.counterSaturated {
+		return math.MaxUint16
+	}
+	atomic.AddUint64(&h.stats.CounterOverflows, 1)
+
+	var next uint16
+	switch behavior {
+	case "wrap-to-zero":
+		next = 0
+	case "saturate":
+		next = math.MaxUint16
+		h.counterSaturated = true
+	case "reset-to-initial":
+		next = h.config.CounterInitial
+	default:
+		next = 1
+	}
+
+	h.logger.Warn("Counter overflow", map[string]interface{}{
+		"behavior": behavior,
+		"next":     next,
+	})
+	return next
 }
 
 func (h *ModbusHandler) UpdateCounter() {
 	h.mu.Lock()
-	defer h.mu.Unlock()
+	defer h.unlock()
 
-	oldValue := h.counter
-	h.counter++
-	h.holdingRegs[h.config.CounterAddress] = h.counter
+	if h.clockCounter() {
+		h.setClockCounter()
+		return
+	}
 
-	if h.counter == 0 { // Overflow
-		h.logger.Warn("Counter overflow, resetting", nil)
-		h.counter = 1
-		h.holdingRegs[h.config.CounterAddress] = 1
+	oldValue := h.counter
+	if h.counter == math.MaxUint16 {
+		h.counter = h.counterOverflow()
+	} else {
+		h.counter++
 	}
+	h.storeCounter(h.counter)
 
 	h.logger.Debug("Counter updated", map[string]interface{}{
 		"address": h.config.CounterAddress,
 		"old":     oldValue,
 		"new":     h.counter,
 	})
+	h.emit(Event{Type: EventCounterUpdate, Address: h.config.CounterAddress, Old: oldValue, Value: h.counter})
+}
+
+// clockCounter reports whether the counter follows the clock instead of
+// incrementing.
+func (h *ModbusHandler) clockCounter() bool {
+	return h.config.CounterMode == "epoch" || h.config.CounterMode == "uptime-seconds"
+}
+
+// setClockCounter writes the current Unix time or uptime in seconds to the
+// counter: the low 16 bits, or with Counter32Bit the full value with the
+// high word first. Callers must hold the write lock or own the handler.
+func (h *ModbusHandler) setClockCounter() {
+	now := h.now()
+	var seconds uint32
+	if h.config.CounterMode == "epoch" {
+		seconds = uint32(now.Unix())
+	} else {
+		seconds = uint32(now.Sub(h.stats.StartTime) / time.Second)
+	}
+
+	if h.config.Counter32Bit {
+		h.storeCounter(uint16(seconds>>16), uint16(seconds))
+	} else {
+		h.storeCounter(uint16(seconds))
+	}
+	old := h.counter
+	h.counter = uint16(seconds)
+
+	h.logger.Debug("Counter updated", map[string]interface{}{
+		"address": h.config.CounterAddress,
+		"mode":    h.config.CounterMode,
+		"seconds": seconds,
+	})
+	h.emit(Event{Type: EventCounterUpdate, Address: h.config.CounterAddress, Old: old, Value: h.counter})
+}
+
+// storeCounter writes the counter words, high word first, to the counter
+// address and, with MirrorCounterToInput, to its input register copy in the
+// same critical section. Callers must hold the write lock or own the handler.
+func (h *ModbusHandler) storeCounter(words ...uint16) {
+	addr := int(h.config.CounterAddress)
+	for i, w := range words {
+		h.holdingRegs.Set(addr+i, w)
+	}
+	h.initialized.mark("holding", addr, len(words))
+
+	if m := h.config.MirrorCounterToInput; m != nil {
+		for i, w := range words {
+			h.inputRegs.Set(int(*m)+i, w)
+		}
+		h.initialized.mark("input", int(*m), len(words))
+	}
+}
+
+// isCounter reports whether a holding register is part of the counter.
+func (h *ModbusHandler) isCounter(addr int) bool {
+	counter := int(h.config.CounterAddress)
+	return addr == counter || h.config.Counter32Bit && addr == counter+1
+}
+
+// MarkReady records when the server started accepting requests, the start of
+// the first-request latency. Until called, the handler's creation time is
+// used.
+func (h *ModbusHandler) MarkReady(t time.Time) {
+	h.first.readyAt.Store(t.UnixNano())
+}
+
+// recordFirstRequest measures and logs the first-request latency. Called
+// after every successfully handled request; only the first one counts.
+func (h *ModbusHandler) recordFirstRequest() {
+	h.first.once.Do(func() {
+		latency := h.now().Sub(time.Unix(0, h.first.readyAt.Load()))
+		h.first.latency.Store(int64(latency))
+		h.logger.Info("First request handled", map[string]interface{}{
+			"unit_id": h.config.UnitID,
+			"latency": latency.String(),
+		})
+	})
+}
+
+// SetMaintenance switches maintenance mode on or off. While enabled every
+// request is answered with ErrServerDeviceBusy but connections stay open.
+func (h *ModbusHandler) SetMaintenance(enabled bool) {
+	if h.maintenance.Swap(enabled) == enabled {
+		return
+	}
+
+	if enabled {
+		h.logger.Warn("Entering maintenance mode", map[string]interface{}{"unit_id": h.config.UnitID})
+	} else {
+		h.logger.Info("Leaving maintenance mode", map[string]interface{}{"unit_id": h.config.UnitID})
+	}
+}
+
+func (h *ModbusHandler) InMaintenance() bool {
+	return h.maintenance.Load()
+}
+
+// acquire reserves an in-flight request slot, reporting false when the unit
+// already has MaxConcurrentRequests in progress.
+func (h *ModbusHandler) acquire() bool {
+	if h.inflight == nil {
+		return true
+	}
+	select {
+	case h.inflight <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (h *ModbusHandler) release() {
+	if h.inflight != nil {
+		<-h.inflight
+	}
+}
+
+// RecordError counts an error that happened outside the handler, such as a
+// recovered panic.
+func (h *ModbusHandler) RecordError() {
+	atomic.AddUint64(&h.stats.Errors, 1)
+}
+
+// LockContended reports whether the register lock is held right now, e.g.
+// by a slow request. It never blocks.
+func (h *ModbusHandler) LockContended() bool {
+	if h.mu.TryLock() {
+		h.mu.Unlock()
+		return false
+	}
+	return true
 }
 
 func (h *ModbusHandler) GetStats() Stats {
 	return Stats{
-		RequestsHandled: atomic.LoadUint64(&h.stats.RequestsHandled),
-		Errors:          atomic.LoadUint64(&h.stats.Errors),
-		StartTime:       h.stats.StartTime,
+		RequestsHandled:     atomic.LoadUint64(&h.stats.RequestsHandled),
+		Errors:              atomic.LoadUint64(&h.stats.Errors),
+		CounterOverflows:    atomic.LoadUint64(&h.stats.CounterOverflows),
+		StartTime:           h.stats.StartTime,
+		FirstRequestLatency: time.Duration(h.first.latency.Load()),
+	}
+}
+
+// SetRegister writes a holding or input register on behalf of the simulator
+// or admin tools. Unlike client writes it may target input registers.
+func (h *ModbusHandler) SetRegister(regType string, addr uint16, value uint16) error {
+	return h.SetRegisters(regType, map[uint16]uint16{addr: value})
+}
+
+// SetRegisters writes several registers of one type under a single lock
+// acquisition, so no client read sees some of the new values without the
+// others. Nothing is written if any address is out of range.
+func (h *ModbusHandler) SetRegisters(regType string, values map[uint16]uint16) error {
+	h.mu.Lock()
+	defer h.unlock()
+
+	regs, err := h.wordStore(regType)
+	if err != nil {
+		return err
+	}
+
+	for addr := range values {
+		if int(addr) >= regs.Len() {
+			return modbus.ErrIllegalDataAddress
+		}
+	}
+	for addr, value := range values {
+		regs.Set(int(addr), value)
+		h.initialized.mark(regType, int(addr), 1)
+	}
+	return nil
+}
+
+// SetBit writes a coil or discrete input on behalf of admin tools. Unlike
+// client writes it may target discrete inputs.
+func (h *ModbusHandler) SetBit(regType string, addr uint16, value bool) error {
+	h.mu.Lock()
+	defer h.unlock()
+
+	bits, err := h.bitStore(regType)
+	if err != nil {
+		return err
+	}
+	if int(addr) >= bits.Len() {
+		return modbus.ErrIllegalDataAddress
+	}
+	bits.Set(int(addr), value)
+	h.initialized.mark(regType, int(addr), 1)
+	return nil
+}
+
+// bitStore returns the coil or discrete input store.
+func (h *ModbusHandler) bitStore(regType string) (bitStore, error) {
+	switch regType {
+	case "coil":
+		return h.coils, nil
+	case "discrete":
+		return h.discreteInputs, nil
+	default:
+		return nil, fmt.Errorf("unknown register type '%s'", regType)
+	}
+}
+
+// wordStore returns the holding or input register store.
+func (h *ModbusHandler) wordStore(regType string) (registerStore, error) {
+	switch regType {
+	case "holding":
+		return h.holdingRegs, nil
+	case "input":
+		return h.inputRegs, nil
+	default:
+		return nil, fmt.Errorf("unknown register type '%s'", regType)
+	}
+}
+
+// Increment atomically adds delta to a holding register and returns the new
+// value. The result wraps around at the 16-bit boundaries.
+func (h *ModbusHandler) Increment(addr uint16, delta int) (uint16, error) {
+	h.mu.Lock()
+	defer h.unlock()
+
+	if int(addr) >= h.holdingRegs.Len() {
+		return 0, modbus.ErrIllegalDataAddress
+	}
+	value := uint16(int(h.holdingRegs.Get(int(addr))) + delta)
+	h.holdingRegs.Set(int(addr), value)
+	h.initialized.mark("holding", int(addr), 1)
+	return value, nil
+}
+
+// RegisterComputed makes a holding register computed: reads return the result
+// of fn instead of the stored value, and client writes to it are ignored.
+func (h *ModbusHandler) RegisterComputed(addr uint16, fn ComputeFunc) error {
+	if int(addr) >= h.holdingRegs.Len() {
+		return modbus.ErrIllegalDataAddress
+	}
+
+	h.mu.Lock()
+	defer h.unlock()
+
+	if fn == nil {
+		delete(h.computed, addr)
+		return nil
+	}
+	h.computed[addr] = fn
+	h.initialized.mark("holding", int(addr), 1)
+	return nil
+}
+
+// packCoils returns a compute function assembling a holding register from a
+// range of coils; like every compute function it runs with the lock held.
+// Coils beyond the store, possible on a smaller unit, read as off.
+func (h *ModbusHandler) packCoils(p config.PackedCoils) ComputeFunc {
+	h.initialized.mark("holding", int(p.Address), 1)
+	return func(addr uint16, regs RegisterReader) uint16 {
+		var v uint16
+		for i := 0; i < p.Count; i++ {
+			if coil := int(p.Coil) + i; coil < h.coils.Len() && h.coils.Get(coil) {
+				v |= 1 << i
+			}
+		}
+		return v
+	}
+}
+
+// ComputedAddresses returns the computed holding registers in ascending order.
+func (h *ModbusHandler) ComputedAddresses() []uint16 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	addrs := make([]uint16, 0, len(h.computed))
+	for addr := range h.computed {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
+
+// holdingValue returns the value of a holding register, consulting computed
+// registers. Callers must hold the lock.
+func (h *ModbusHandler) holdingValue(addr int) uint16 {
+	addr = h.target("holding", addr)
+	if fn, ok := h.computed[uint16(addr)]; ok {
+		return fn(uint16(addr), h.holdingRegs)
+	}
+	return h.holdingRegs.Get(addr)
+}
+
+// ReadRegisters returns a copy of a holding or input register range for
+// inspection. It bypasses unit ID checks and request statistics, and returns
+// the stored values: inverted registers are not inverted, unlike client
+// reads and WireHoldingRegisters.
+func (h *ModbusHandler) ReadRegisters(regType string, addr, count uint16) ([]uint16, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	regs, err := h.wordStore(regType)
+	if err != nil {
+		return nil, err
+	}
+
+	if int(addr)+int(count) > regs.Len() {
+		return nil, modbus.ErrIllegalDataAddress
+	}
+
+	res := make([]uint16, count)
+	for i := range res {
+		if regType == "holding" {
+			res[i] = h.holdingValue(int(addr) + i)
+		} else {
+			res[i] = regs.Get(int(addr) + i)
+		}
+	}
+	return res, nil
+}
+
+// ReadBits returns a copy of a coil or discrete input range for inspection,
+// with the stored bits of inverted coils and discrete inputs.
+func (h *ModbusHandler) ReadBits(regType string, addr, count uint16) ([]bool, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	bits, err := h.bitStore(regType)
+	if err != nil {
+		return nil, err
+	}
+
+	if int(addr)+int(count) > bits.Len() {
+		return nil, modbus.ErrIllegalDataAddress
+	}
+
+	res := make([]bool, count)
+	for i := range res {
+		res[i] = bits.Get(int(addr) + i)
 	}
+	return res, nil
+}
+
+// WireHoldingRegisters returns the big-endian byte serialization of a holding
+// register range, exactly as it would be put on the wire: inverted registers
+// are inverted, unlike in ReadRegisters.
+func (h *ModbusHandler) WireHoldingRegisters(addr, count uint16) ([]byte, error) {
+	if int(addr)+int(count) > h.holdingRegs.Len() {
+		return nil, modbus.ErrIllegalDataAddress
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	raw := make([]byte, 0, 2*int(count))
+	for i := int(addr); i < int(addr)+int(count); i++ {
+		value := h.holdingValue(i)
+		if h.inverted[config.RegisterRef{Type: "holding", Address: uint16(i)}] {
+			value = ^value
+		}
+		raw = append(raw, byte(value>>8), byte(value))
+	}
+	return raw, nil
 }
 
 func (h *ModbusHandler) HandleHoldingRegisters(req *modbus.HoldingRegistersRequest) ([]uint16, error) {
+	return h.HandleHoldingRegistersContext(context.Background(), req)
+}
+
+// HandleHoldingRegistersContext is HandleHoldingRegisters for a request with
+// a deadline: a write whose context is done by the time it gets the register
+// lock is dropped with server device busy instead of being applied.
+func (h *ModbusHandler) HandleHoldingRegistersContext(ctx context.Context, req *modbus.HoldingRegistersRequest) ([]uint16, error) {
 	atomic.AddUint64(&h.stats.RequestsHandled, 1)
 
+	function := fcReadHoldingRegisters
+	if req.IsWrite {
+		function = writeFunction(req.Quantity, fcWriteSingleRegister, fcWriteMultipleRegisters)
+	}
+	log := h.requestLogger(req.ClientAddr, function, req.UnitId, req.Addr, req.Quantity)
+
+	if !h.allowRate() {
+		return nil, h.reject(log, req.IsWrite, req.Addr, "device_busy", map[string]interface{}{
+			"rate_limit": h.config.GlobalRateLimit,
+		})
+	}
+
+	if !h.acquire() {
+		return nil, h.reject(log, req.IsWrite, req.Addr, "device_busy", nil)
+	}
+	defer h.release()
+
+	if h.maintenance.Load() {
+		return nil, h.exception("device_busy")
+	}
+
 	if req.UnitId != h.config.UnitID {
-		atomic.AddUint64(&h.stats.Errors, 1)
-		h.logger.Warn("Invalid unit ID", map[string]interface{}{
-			"requested": req.UnitId,
-			"expected":  h.config.UnitID,
+		return nil, h.reject(log, req.IsWrite, req.Addr, "invalid_unit", map[string]interface{}{
+			"expected": h.config.UnitID,
 		})
-		return nil, modbus.ErrIllegalFunction
 	}
 
-	if int(req.Addr)+int(req.Quantity) > len(h.holdingRegs) {
-		atomic.AddUint64(&h.stats.Errors, 1)
-		h.logger.Warn("Address out of bounds", map[string]interface{}{
-			"start":    req.Addr,
-			"quantity": req.Quantity,
-			"max":      len(h.holdingRegs),
+	if req.IsWrite && h.readOnly.Load() {
+		return nil, h.rejectWith(log, true, req.Addr, "replica_write", modbus.ErrIllegalFunction, map[string]interface{}{
+			"client": req.ClientAddr,
 		})
-		return nil, modbus.ErrIllegalDataAddress
 	}
 
-	h.mu.Lock()
-	defer h.mu.Unlock()
+	if req.Quantity == 0 {
+		return nil, h.reject(log, req.IsWrite, req.Addr, "invalid_quantity", nil)
+	}
+
+	if limit := h.maxQuantity(function); req.Quantity > limit {
+		return nil, h.reject(log, req.IsWrite, req.Addr, "invalid_quantity", map[string]interface{}{
+			"max": limit,
+		})
+	}
+
+	n, ok := h.available(req.Addr, req.Quantity, h.holdingRegs.Len(), req.IsWrite)
+	if !ok {
+		return nil, h.reject(log, req.IsWrite, req.Addr, "out_of_bounds", map[string]interface{}{
+			"max": h.holdingRegs.Len(),
+		})
+	}
+
+	if req.IsWrite {
+		if err := h.checkConstraints(req, log); err != nil {
+			return nil, err
+		}
+	}
+
+	if !req.IsWrite && h.config.StaleReads {
+		res := snapshotRange(h.snapshot.Load().holding, req.Addr, req.Quantity)
+		h.invertWords("holding", req.Addr, res)
+		h.holdingHandled(req, res, log)
+		return res, nil
+	}
+
+	if !req.IsWrite {
+		return h.readHolding(req, n, log)
+	}
+
+	h.lock(log)
+	defer h.unlock()
+
+	if err := h.expired(ctx, req.Addr, log); err != nil {
+		return nil, err
+	}
+	if err := h.rejectTxWrite(req.Addr, req.ClientAddr, log); err != nil {
+		return nil, err
+	}
 
 	var res []uint16
-	for i := 0; i < int(req.Quantity); i++ {
-		addr := int(req.Addr) + i
+	for i := 0; i < int(n); i++ {
+		addr := h.target("holding", int(req.Addr)+i)
 
-		if req.IsWrite {
-			// Protect counter register
-			if uint16(addr) != h.config.CounterAddress {
-				old := h.holdingRegs[addr]
-				h.holdingRegs[addr] = req.Args[i]
-				h.logger.Debug("Register written", map[string]interface{}{
-					"address": addr,
-					"old":     old,
-					"new":     req.Args[i],
-				})
+		// Protect counter, computed and locked registers
+		_, computed := h.computed[uint16(addr)]
+		if h.protected.has(addr) {
+			log.Debug("Write to protected register ignored", map[string]interface{}{
+				"client":  req.ClientAddr,
+				"address": addr,
+			})
+		} else if !h.isCounter(addr) && !computed {
+			old := h.holdingRegs.Get(addr)
+			if h.delayed.covers(uint16(addr)) {
+				h.stageWrite(uint16(addr), req.Args[i])
+			} else {
+				h.holdingRegs.Set(addr, req.Args[i])
+				h.initialized.mark("holding", addr, 1)
+				h.applyMirrors(uint16(addr), req.Args[i])
+			}
+			if ring, ok := h.history[uint16(addr)]; ok {
+				ring.add(HistoryEntry{Time: h.now(), Old: old, Value: req.Args[i], Client: req.ClientAddr})
 			}
+			log.Debug("Register written", map[string]interface{}{
+				"address": addr,
+				"old":     old,
+				"new":     req.Args[i],
+			})
+			h.emit(Event{Type: EventRegisterWrite, Address: uint16(addr), Old: old, Value: req.Args[i], Client: req.ClientAddr})
 		}
 
-		res = append(res, h.holdingRegs[addr])
+		res = append(res, h.holdingValue(addr))
+	}
+
+	if i, ok := h.resetIndex("holding", req.Addr, len(req.Args)); ok && req.Args[i] == h.config.ResetTrigger.Value {
+		h.resetDevice(req.ClientAddr, log)
+	}
+	h.holdingHandled(req, res, log)
+	return res, nil
+}
+
+// readHolding serves a validated holding register read under the read lock,
+// so reads run concurrently and only wait for writes.
+func (h *ModbusHandler) readHolding(req *modbus.HoldingRegistersRequest, n uint16, log *mlog.Logger) ([]uint16, error) {
+	h.rlock(log)
+	defer h.mu.RUnlock()
+
+	if err := h.rejectUninitialized("holding", req.Addr, n, log); err != nil {
+		return nil, err
 	}
 
+	res := make([]uint16, 0, req.Quantity)
+	for i := 0; i < int(n); i++ {
+		res = append(res, h.holdingValue(int(req.Addr)+i))
+	}
+	res = zeroFill(res, req.Quantity)
+	h.invertWords("holding", req.Addr, res)
+	h.holdingHandled(req, res, log)
+	return res, nil
+}
+
+// holdingHandled logs a completed holding register request and records it as
+// handled.
+func (h *ModbusHandler) holdingHandled(req *modbus.HoldingRegistersRequest, res []uint16, log *mlog.Logger) {
 	operation := "read"
 	if req.IsWrite {
 		operation = "write"
 	}
 
-	h.logger.Debug("Holding registers handled", map[string]interface{}{
-		"operation": operation,
-		"start":     req.Addr,
-		"quantity":  req.Quantity,
-	})
+	data := map[string]interface{}{"operation": operation}
+	if !req.IsWrite {
+		addReadValues(h, data, res)
+	}
+	log.Debug("Holding registers handled", data)
 
-	return res, nil
+	h.recordFirstRequest()
 }
 
 func (h *ModbusHandler) HandleInputRegisters(req *modbus.InputRegistersRequest) ([]uint16, error) {
 	atomic.AddUint64(&h.stats.RequestsHandled, 1)
+	log := h.requestLogger(req.ClientAddr, fcReadInputRegisters, req.UnitId, req.Addr, req.Quantity)
+
+	if !h.allowRate() {
+		return nil, h.reject(log, false, req.Addr, "device_busy", map[string]interface{}{
+			"rate_limit": h.config.GlobalRateLimit,
+		})
+	}
+
+	if !h.acquire() {
+		return nil, h.reject(log, false, req.Addr, "device_busy", nil)
+	}
+	defer h.release()
+
+	if h.maintenance.Load() {
+		return nil, h.exception("device_busy")
+	}
 
 	if req.UnitId != h.config.UnitID {
-		atomic.AddUint64(&h.stats.Errors, 1)
-		return nil, modbus.ErrIllegalFunction
+		return nil, h.reject(log, false, req.Addr, "invalid_unit", map[string]interface{}{
+			"expected": h.config.UnitID,
+		})
 	}
 
-	if int(req.Addr)+int(req.Quantity) > len(h.inputRegs) {
-		atomic.AddUint64(&h.stats.Errors, 1)
-		return nil, modbus.ErrIllegalDataAddress
+	if req.Quantity == 0 {
+		return nil, h.reject(log, false, req.Addr, "invalid_quantity", nil)
 	}
 
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+	if limit := h.maxQuantity(fcReadInputRegisters); req.Quantity > limit {
+		return nil, h.reject(log, false, req.Addr, "invalid_quantity", map[string]interface{}{
+			"max": limit,
+		})
+	}
+
+	n, ok := h.available(req.Addr, req.Quantity, h.inputRegs.Len(), false)
+	if !ok {
+		return nil, h.reject(log, false, req.Addr, "out_of_bounds", map[string]interface{}{
+			"max": h.inputRegs.Len(),
+		})
+	}
 
 	var res []uint16
-	for i := 0; i < int(req.Quantity); i++ {
-		res = append(res, h.inputRegs[int(req.Addr)+i])
+	if h.config.StaleReads {
+		res = snapshotRange(h.snapshot.Load().input, req.Addr, req.Quantity)
+	} else {
+		h.rlock(log)
+		defer h.mu.RUnlock()
+
+		if err := h.rejectUninitialized("input", req.Addr, n, log); err != nil {
+			return nil, err
+		}
+
+		for i := 0; i < int(n); i++ {
+			res = append(res, h.inputRegs.Get(int(req.Addr)+i))
+		}
+		res = zeroFill(res, req.Quantity)
+	}
+	h.invertWords("input", req.Addr, res)
+
+	if h.config.LogReadValues {
+		data := map[string]interface{}{}
+		addReadValues(h, data, res)
+		log.Debug("Input registers read", data)
 	}
 
+	h.recordFirstRequest()
 	return res, nil
 }
 
 func (h *ModbusHandler) HandleCoils(req *modbus.CoilsRequest) ([]bool, error) {
+	return h.HandleCoilsContext(context.Background(), req)
+}
+
+// HandleCoilsContext is HandleCoils for a request with a deadline, dropping
+// expired writes like HandleHoldingRegistersContext.
+func (h *ModbusHandler) HandleCoilsContext(ctx context.Context, req *modbus.CoilsRequest) ([]bool, error) {
 	atomic.AddUint64(&h.stats.RequestsHandled, 1)
 
+	function := fcReadCoils
+	if req.IsWrite {
+		function = writeFunction(req.Quantity, fcWriteSingleCoil, fcWriteMultipleCoils)
+	}
+	log := h.requestLogger(req.ClientAddr, function, req.UnitId, req.Addr, req.Quantity)
+
+	if !h.allowRate() {
+		return nil, h.reject(log, req.IsWrite, req.Addr, "device_busy", map[string]interface{}{
+			"rate_limit": h.config.GlobalRateLimit,
+		})
+	}
+
+	if !h.acquire() {
+		return nil, h.reject(log, req.IsWrite, req.Addr, "device_busy", nil)
+	}
+	defer h.release()
+
+	if h.maintenance.Load() {
+		return nil, h.exception("device_busy")
+	}
+
 	if req.UnitId != h.config.UnitID {
-		atomic.AddUint64(&h.stats.Errors, 1)
-		return nil, modbus.ErrIllegalFunction
+		return nil, h.reject(log, req.IsWrite, req.Addr, "invalid_unit", map[string]interface{}{
+			"expected": h.config.UnitID,
+		})
 	}
 
-	if int(req.Addr)+int(req.Quantity) > len(h.coils) {
-		atomic.AddUint64(&h.stats.Errors, 1)
-		return nil, modbus.ErrIllegalDataAddress
+	if req.IsWrite && h.readOnly.Load() {
+		return nil, h.rejectWith(log, true, req.Addr, "replica_write", modbus.ErrIllegalFunction, map[string]interface{}{
+			"client": req.ClientAddr,
+		})
 	}
 
-	h.mu.Lock()
-	defer h.mu.Unlock()
+	if req.Quantity == 0 {
+		return nil, h.reject(log, req.IsWrite, req.Addr, "invalid_quantity", nil)
+	}
+
+	if limit := h.maxQuantity(function); req.Quantity > limit {
+		return nil, h.reject(log, req.IsWrite, req.Addr, "invalid_quantity", map[string]interface{}{
+			"max": limit,
+		})
+	}
+
+	n, ok := h.available(req.Addr, req.Quantity, h.coils.Len(), req.IsWrite)
+	if !ok {
+		return nil, h.reject(log, req.IsWrite, req.Addr, "out_of_bounds", map[string]interface{}{
+			"max": h.coils.Len(),
+		})
+	}
+
+	if !req.IsWrite && h.config.StaleReads {
+		res := snapshotRange(h.snapshot.Load().coils, req.Addr, req.Quantity)
+		h.invertBits("coil", req.Addr, res)
+		h.coilsRead(res, log)
+		return res, nil
+	}
+
+	// Deferred before locking, so the callbacks run after the lock is released
+	var changes []coilChange
+	if req.IsWrite {
+		defer func() { h.fireCoilWrites(changes) }()
+	}
+
+	h.lock(log)
+	defer h.unlock()
+
+	if !req.IsWrite {
+		if err := h.rejectUninitialized("coil", req.Addr, n, log); err != nil {
+			return nil, err
+		}
+	} else if err := h.expired(ctx, req.Addr, log); err != nil {
+		return nil, err
+	} else if err := h.rejectTxWrite(req.Addr, req.ClientAddr, log); err != nil {
+		return nil, err
+	}
 
 	var res []bool
-	for i := 0; i < int(req.Quantity); i++ {
-		addr := int(req.Addr) + i
+	for i := 0; i < int(n); i++ {
+		addr := h.target("coil", int(req.Addr)+i)
 
 		if req.IsWrite {
-			h.coils[addr] = req.Args[i]
+			old := h.coils.Get(addr)
+			h.coils.Set(addr, req.Args[i])
+			h.initialized.mark("coil", addr, 1)
+			if fns := h.coilCallbacks[uint16(addr)]; len(fns) > 0 && old != req.Args[i] {
+				changes = append(changes, coilChange{addr: uint16(addr), old: old, new: req.Args[i], fns: fns})
+			}
+			log.Debug("Coil written", map[string]interface{}{
+				"address": addr,
+				"value":   req.Args[i],
+			})
+			h.emit(Event{Type: EventCoilWrite, Address: uint16(addr), Old: bitValue(old), Value: bitValue(req.Args[i]), Client: req.ClientAddr})
 		}
 
-		res = append(res, h.coils[addr])
+		res = append(res, h.coils.Get(addr))
 	}
 
+	if !req.IsWrite {
+		res = zeroFill(res, req.Quantity)
+		h.invertBits("coil", req.Addr, res)
+		h.coilsRead(res, log)
+	} else {
+		if i, ok := h.resetIndex("coil", req.Addr, len(req.Args)); ok && req.Args[i] == (h.config.ResetTrigger.Value != 0) {
+			h.resetDevice(req.ClientAddr, log)
+		}
+		h.recordFirstRequest()
+	}
 	return res, nil
 }
 
+// coilsRead logs a completed coil read and records it as handled.
+func (h *ModbusHandler) coilsRead(res []bool, log *mlog.Logger) {
+	if h.config.LogReadValues {
+		data := map[string]interface{}{}
+		addReadValues(h, data, res)
+		log.Debug("Coils read", data)
+	}
+
+	h.recordFirstRequest()
+}
+
 func (h *ModbusHandler) HandleDiscreteInputs(req *modbus.DiscreteInputsRequest) ([]bool, error) {
 	atomic.AddUint64(&h.stats.RequestsHandled, 1)
+	log := h.requestLogger(req.ClientAddr, fcReadDiscreteInputs, req.UnitId, req.Addr, req.Quantity)
+
+	if !h.allowRate() {
+		return nil, h.reject(log, false, req.Addr, "device_busy", map[string]interface{}{
+			"rate_limit": h.config.GlobalRateLimit,
+		})
+	}
+
+	if !h.acquire() {
+		return nil, h.reject(log, false, req.Addr, "device_busy", nil)
+	}
+	defer h.release()
+
+	if h.maintenance.Load() {
+		return nil, h.exception("device_busy")
+	}
+
+	if req.UnitId != h.config.UnitID {
+		return nil, h.reject(log, false, req.Addr, "invalid_unit", map[string]interface{}{
+			"expected": h.config.UnitID,
+		})
+	}
+
+	if req.Quantity == 0 {
+		return nil, h.reject(log, false, req.Addr, "invalid_quantity", nil)
+	}
+
+	if limit := h.maxQuantity(fcReadDiscreteInputs); req.Quantity > limit {
+		return nil, h.reject(log, false, req.Addr, "invalid_quantity", map[string]interface{}{
+			"max": limit,
+		})
+	}
+
+	n, ok := h.available(req.Addr, req.Quantity, h.discreteInputs.Len(), false)
+	if !ok {
+		return nil, h.reject(log, false, req.Addr, "out_of_bounds", map[string]interface{}{
+			"max": h.discreteInputs.Len(),
+		})
+	}
+
+	var res []bool
+	if h.config.StaleReads {
+		res = snapshotRange(h.snapshot.Load().discrete, req.Addr, req.Quantity)
+	} else {
+		h.rlock(log)
+		defer h.mu.RUnlock()
+
+		if err := h.rejectUninitialized("discrete", req.Addr, n, log); err != nil {
+			return nil, err
+		}
+
+		for i := 0; i < int(n); i++ {
+			res = append(res, h.discreteInputs.Get(int(req.Addr)+i))
+		}
+		res = zeroFill(res, req.Quantity)
+	}
+	h.invertBits("discrete", req.Addr, res)
+
+	if h.config.LogReadValues {
+		data := map[string]interface{}{}
+		addReadValues(h, data, res)
+		log.Debug("Discrete inputs read", data)
+	}
+
+	h.recordFirstRequest()
+	return res, nil
+}
+
+// HandleDiagnostics handles the diagnostics (0x08) function code. Only the
+// return query data (loopback) subfunction is supported; it echoes the request
+// data without touching any registers.
+func (h *ModbusHandler) HandleDiagnostics(req *DiagnosticsRequest) ([]byte, error) {
+	atomic.AddUint64(&h.stats.RequestsHandled, 1)
+
+	if !h.allowRate() {
+		atomic.AddUint64(&h.stats.Errors, 1)
+		return nil, h.exception("device_busy")
+	}
+
+	if !h.acquire() {
+		atomic.AddUint64(&h.stats.Errors, 1)
+		return nil, h.exception("device_busy")
+	}
+	defer h.release()
+
+	if h.maintenance.Load() {
+		return nil, h.exception("device_busy")
+	}
 
 	if req.UnitId != h.config.UnitID {
 		atomic.AddUint64(&h.stats.Errors, 1)
+		return nil, h.exception("invalid_unit")
+	}
+
+	if req.SubFunction != diagReturnQueryData {
+		atomic.AddUint64(&h.stats.Errors, 1)
+		h.logger.Warn("Unsupported diagnostics subfunction", map[string]interface{}{
+			"subfunction": req.SubFunction,
+		})
 		return nil, modbus.ErrIllegalFunction
 	}
 
-	if int(req.Addr)+int(req.Quantity) > len(h.discreteInputs) {
+	h.logger.Debug("Diagnostics loopback handled", map[string]interface{}{
+		"bytes": len(req.Data),
+	})
+
+	h.recordFirstRequest()
+	return append([]byte(nil), req.Data...), nil
+}
+
+// HandleDeviceIdentification handles read device identification requests.
+// Only the basic objects (vendor name, product code, revision) exist, so
+// every stream access returns them starting at the requested object; an
+// unknown start object restarts at the first one, as the spec requires.
+// Devices without any identification configured don't support the request.
+func (h *ModbusHandler) HandleDeviceIdentification(req *DeviceIdentificationRequest) ([]DeviceObject, error) {
+	atomic.AddUint64(&h.stats.RequestsHandled, 1)
+
+	if !h.allowRate() {
 		atomic.AddUint64(&h.stats.Errors, 1)
-		return nil, modbus.ErrIllegalDataAddress
+		return nil, h.exception("device_busy")
 	}
 
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+	if !h.acquire() {
+		atomic.AddUint64(&h.stats.Errors, 1)
+		return nil, h.exception("device_busy")
+	}
+	defer h.release()
 
-	var res []bool
-	for i := 0; i < int(req.Quantity); i++ {
-		res = append(res, h.discreteInputs[int(req.Addr)+i])
+	if h.maintenance.Load() {
+		return nil, h.exception("device_busy")
 	}
 
-	return res, nil
+	if req.UnitId != h.config.UnitID {
+		atomic.AddUint64(&h.stats.Errors, 1)
+		return nil, h.exception("invalid_unit")
+	}
+
+	if h.config.VendorName == "" && h.config.ProductCode == "" && h.config.Revision == "" {
+		atomic.AddUint64(&h.stats.Errors, 1)
+		return nil, modbus.ErrIllegalFunction
+	}
+
+	objects := []DeviceObject{
+		{ID: 0x00, Value: h.config.VendorName},
+		{ID: 0x01, Value: h.config.ProductCode},
+		{ID: 0x02, Value: h.config.Revision},
+	}
+
+	start := int(req.ObjectID)
+	switch req.ReadDeviceID {
+	case ReadDeviceIDBasic, ReadDeviceIDRegular, ReadDeviceIDExtended:
+		if start >= len(objects) {
+			start = 0
+		}
+		objects = objects[start:]
+	case ReadDeviceIDIndividual:
+		if start >= len(objects) {
+			atomic.AddUint64(&h.stats.Errors, 1)
+			return nil, modbus.ErrIllegalDataAddress
+		}
+		objects = objects[start : start+1]
+	default:
+		atomic.AddUint64(&h.stats.Errors, 1)
+		return nil, modbus.ErrIllegalDataValue
+	}
+
+	h.logger.Debug("Device identification read", map[string]interface{}{
+		"client":    req.ClientAddr,
+		"code":      req.ReadDeviceID,
+		"object_id": req.ObjectID,
+	})
+
+	h.recordFirstRequest()
+	return objects, nil
 }
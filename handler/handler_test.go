@@ -4,7 +4,14 @@ package handler
 import (
 	"SPModbus/config"
 	"SPModbus/mlog"
+	"SPModbus/testhelpers"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/simonvetter/modbus"
 )
@@ -300,3 +307,2762 @@ func BenchmarkHoldingRegisterRead(b *testing.B) {
 		}
 	}
 }
+
+// TestResetOnConnect tests that initial data is re-applied for each new connection
+func TestResetOnConnect(t *testing.T) {
+	cfg := config.ModbusConfig{
+		UnitID:         1,
+		MaxRegisters:   200,
+		CounterAddress: 10,
+		UpdateInterval: 1,
+		ResetOnConnect: true,
+		ResetAddresses: []config.RegisterRef{{Type: "holding", Address: 20}},
+		InitialData: []config.RegisterValue{
+			{Type: "holding", Address: 20, Value: 500},
+			{Type: "holding", Address: 21, Value: 600},
+			{Type: "coil", Address: 20, Value: 1},
+		},
+	}
+
+	logger, err := mlog.NewLogger(config.LoggingConfig{
+		Level:   "ERROR",
+		Console: false,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	defer logger.Close()
+
+	handler := NewModbusHandler(cfg, logger)
+
+	// Overwrite both registers and the coil during the first session
+	_, err = handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+		UnitId:   1,
+		Addr:     20,
+		Quantity: 2,
+		IsWrite:  true,
+		Args:     []uint16{1, 2},
+	})
+	if err != nil {
+		t.Fatalf("Failed to write registers: %v", err)
+	}
+	_, err = handler.HandleCoils(&modbus.CoilsRequest{
+		UnitId:   1,
+		Addr:     20,
+		Quantity: 1,
+		IsWrite:  true,
+		Args:     []bool{false},
+	})
+	if err != nil {
+		t.Fatalf("Failed to write coil: %v", err)
+	}
+
+	// A new connection only resets the designated subset
+	handler.OnConnect("127.0.0.1:50000")
+
+	res, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+		UnitId:   1,
+		Addr:     20,
+		Quantity: 2,
+	})
+	if err != nil {
+		t.Fatalf("Failed to read registers: %v", err)
+	}
+	if res[0] != 500 {
+		t.Fatalf("Expected register 20 reset to 500, got %d", res[0])
+	}
+	if res[1] != 2 {
+		t.Fatalf("Expected register 21 to keep written value 2, got %d", res[1])
+	}
+
+	// Only holding 20 is listed, so coil 20 keeps its written value
+	coils, err := handler.HandleCoils(&modbus.CoilsRequest{
+		UnitId:   1,
+		Addr:     20,
+		Quantity: 1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to read coil: %v", err)
+	}
+	if coils[0] {
+		t.Fatal("Expected coil 20 to keep written value false")
+	}
+}
+
+// TestMaintenanceMode tests that all requests report busy while in maintenance
+func TestMaintenanceMode(t *testing.T) {
+	cfg := config.ModbusConfig{
+		UnitID:         1,
+		MaxRegisters:   200,
+		CounterAddress: 10,
+		UpdateInterval: 1,
+	}
+
+	logger, err := mlog.NewLogger(config.LoggingConfig{
+		Level:   "ERROR",
+		Console: false,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	defer logger.Close()
+
+	handler := NewModbusHandler(cfg, logger)
+	handler.SetMaintenance(true)
+
+	t.Run("AllHandlersBusy", func(t *testing.T) {
+		if _, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{UnitId: 1, Quantity: 1}); err != modbus.ErrServerDeviceBusy {
+			t.Fatalf("Holding registers: expected ErrServerDeviceBusy, got %v", err)
+		}
+		if _, err := handler.HandleInputRegisters(&modbus.InputRegistersRequest{UnitId: 1, Quantity: 1}); err != modbus.ErrServerDeviceBusy {
+			t.Fatalf("Input registers: expected ErrServerDeviceBusy, got %v", err)
+		}
+		if _, err := handler.HandleCoils(&modbus.CoilsRequest{UnitId: 1, Quantity: 1}); err != modbus.ErrServerDeviceBusy {
+			t.Fatalf("Coils: expected ErrServerDeviceBusy, got %v", err)
+		}
+		if _, err := handler.HandleDiscreteInputs(&modbus.DiscreteInputsRequest{UnitId: 1, Quantity: 1}); err != modbus.ErrServerDeviceBusy {
+			t.Fatalf("Discrete inputs: expected ErrServerDeviceBusy, got %v", err)
+		}
+	})
+
+	t.Run("Resume", func(t *testing.T) {
+		handler.SetMaintenance(false)
+		if _, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{UnitId: 1, Quantity: 1}); err != nil {
+			t.Fatalf("Expected no error after leaving maintenance, got %v", err)
+		}
+	})
+}
+
+// TestDiagnosticsLoopback tests the FC8 return query data echo
+func TestDiagnosticsLoopback(t *testing.T) {
+	cfg := config.ModbusConfig{
+		UnitID:         1,
+		MaxRegisters:   200,
+		CounterAddress: 10,
+		UpdateInterval: 1,
+	}
+
+	logger, err := mlog.NewLogger(config.LoggingConfig{
+		Level:   "ERROR",
+		Console: false,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	defer logger.Close()
+
+	handler := NewModbusHandler(cfg, logger)
+
+	t.Run("Echo", func(t *testing.T) {
+		data := []byte{0xa5, 0x37}
+		res, err := handler.HandleDiagnostics(&DiagnosticsRequest{
+			UnitId:      1,
+			SubFunction: 0x0000,
+			Data:        data,
+		})
+		if err != nil {
+			t.Fatalf("Expected no error for loopback, got %v", err)
+		}
+		if string(res) != string(data) {
+			t.Fatalf("Expected echo %x, got %x", data, res)
+		}
+	})
+
+	t.Run("UnsupportedSubfunction", func(t *testing.T) {
+		_, err := handler.HandleDiagnostics(&DiagnosticsRequest{
+			UnitId:      1,
+			SubFunction: 0x000a,
+		})
+		if err != modbus.ErrIllegalFunction {
+			t.Fatalf("Expected ErrIllegalFunction, got %v", err)
+		}
+	})
+}
+
+// TestZeroQuantity tests that zero-quantity requests are rejected on every data type
+func TestZeroQuantity(t *testing.T) {
+	cfg := config.ModbusConfig{
+		UnitID:         1,
+		MaxRegisters:   200,
+		CounterAddress: 10,
+		UpdateInterval: 1,
+	}
+
+	logger, err := mlog.NewLogger(config.LoggingConfig{
+		Level:   "ERROR",
+		Console: false,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	defer logger.Close()
+
+	handler := NewModbusHandler(cfg, logger)
+
+	t.Run("HoldingRead", func(t *testing.T) {
+		_, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{UnitId: 1, Addr: 0, Quantity: 0})
+		if err != modbus.ErrIllegalDataValue {
+			t.Fatalf("Expected ErrIllegalDataValue, got %v", err)
+		}
+	})
+
+	t.Run("HoldingWrite", func(t *testing.T) {
+		_, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{UnitId: 1, Addr: 0, Quantity: 0, IsWrite: true})
+		if err != modbus.ErrIllegalDataValue {
+			t.Fatalf("Expected ErrIllegalDataValue, got %v", err)
+		}
+	})
+
+	t.Run("InputRead", func(t *testing.T) {
+		_, err := handler.HandleInputRegisters(&modbus.InputRegistersRequest{UnitId: 1, Addr: 0, Quantity: 0})
+		if err != modbus.ErrIllegalDataValue {
+			t.Fatalf("Expected ErrIllegalDataValue, got %v", err)
+		}
+	})
+
+	t.Run("CoilRead", func(t *testing.T) {
+		_, err := handler.HandleCoils(&modbus.CoilsRequest{UnitId: 1, Addr: 0, Quantity: 0})
+		if err != modbus.ErrIllegalDataValue {
+			t.Fatalf("Expected ErrIllegalDataValue, got %v", err)
+		}
+	})
+
+	t.Run("CoilWrite", func(t *testing.T) {
+		_, err := handler.HandleCoils(&modbus.CoilsRequest{UnitId: 1, Addr: 0, Quantity: 0, IsWrite: true})
+		if err != modbus.ErrIllegalDataValue {
+			t.Fatalf("Expected ErrIllegalDataValue, got %v", err)
+		}
+	})
+
+	t.Run("DiscreteRead", func(t *testing.T) {
+		_, err := handler.HandleDiscreteInputs(&modbus.DiscreteInputsRequest{UnitId: 1, Addr: 0, Quantity: 0})
+		if err != modbus.ErrIllegalDataValue {
+			t.Fatalf("Expected ErrIllegalDataValue, got %v", err)
+		}
+	})
+}
+
+// TestComputedRegister tests a checksum register derived from other registers
+func TestComputedRegister(t *testing.T) {
+	cfg := config.ModbusConfig{
+		UnitID:         1,
+		MaxRegisters:   200,
+		CounterAddress: 10,
+		UpdateInterval: 1,
+		InitialData: []config.RegisterValue{
+			{Type: "holding", Address: 50, Value: 100},
+			{Type: "holding", Address: 51, Value: 200},
+		},
+	}
+
+	logger, err := mlog.NewLogger(config.LoggingConfig{
+		Level:   "ERROR",
+		Console: false,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	defer logger.Close()
+
+	handler := NewModbusHandler(cfg, logger)
+
+	// Register 52 is the sum of registers 50 and 51
+	err = handler.RegisterComputed(52, func(addr uint16, regs RegisterReader) uint16 {
+		return regs.Get(50) + regs.Get(51)
+	})
+	if err != nil {
+		t.Fatalf("Failed to register computed register: %v", err)
+	}
+
+	read := func() uint16 {
+		res, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{UnitId: 1, Addr: 52, Quantity: 1})
+		if err != nil {
+			t.Fatalf("Failed to read computed register: %v", err)
+		}
+		return res[0]
+	}
+
+	if v := read(); v != 300 {
+		t.Fatalf("Expected sum 300, got %d", v)
+	}
+
+	// The computed value follows writes to its inputs
+	_, err = handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+		UnitId:   1,
+		Addr:     50,
+		Quantity: 1,
+		IsWrite:  true,
+		Args:     []uint16{1000},
+	})
+	if err != nil {
+		t.Fatalf("Failed to write register: %v", err)
+	}
+	if v := read(); v != 1200 {
+		t.Fatalf("Expected sum 1200 after write, got %d", v)
+	}
+
+	// Direct writes to the computed register are ignored
+	_, err = handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+		UnitId:   1,
+		Addr:     52,
+		Quantity: 1,
+		IsWrite:  true,
+		Args:     []uint16{7},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error writing computed register, got %v", err)
+	}
+	if v := read(); v != 1200 {
+		t.Fatalf("Expected computed register to ignore writes, got %d", v)
+	}
+}
+
+// TestWriteHistory tests the ring buffer of writes to a watched register
+func TestWriteHistory(t *testing.T) {
+	cfg := config.ModbusConfig{
+		UnitID:           1,
+		MaxRegisters:     200,
+		CounterAddress:   10,
+		UpdateInterval:   1,
+		WatchedRegisters: []uint16{30},
+		HistoryDepth:     3,
+	}
+
+	logger, err := mlog.NewLogger(config.LoggingConfig{
+		Level:   "ERROR",
+		Console: false,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	defer logger.Close()
+
+	handler := NewModbusHandler(cfg, logger)
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	handler.now = func() time.Time { return now }
+
+	// Write five values; only the last three should be kept
+	for v := uint16(1); v <= 5; v++ {
+		_, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+			ClientAddr: "127.0.0.1:50000",
+			UnitId:     1,
+			Addr:       30,
+			Quantity:   1,
+			IsWrite:    true,
+			Args:       []uint16{v},
+		})
+		if err != nil {
+			t.Fatalf("Failed to write register: %v", err)
+		}
+	}
+
+	entries, ok := handler.History(30)
+	if !ok {
+		t.Fatal("Expected register 30 to be watched")
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 history entries, got %d", len(entries))
+	}
+	for i, want := range []uint16{3, 4, 5} {
+		if entries[i].Value != want || entries[i].Old != want-1 {
+			t.Fatalf("Entry %d: expected %d->%d, got %d->%d", i, want-1, want, entries[i].Old, entries[i].Value)
+		}
+		if !entries[i].Time.Equal(now) {
+			t.Fatalf("Entry %d: expected time %v from the handler clock, got %v", i, now, entries[i].Time)
+		}
+	}
+
+	if _, ok := handler.History(31); ok {
+		t.Fatal("Expected register 31 not to be watched")
+	}
+}
+
+// TestInitPattern tests each register initialization pattern
+func TestInitPattern(t *testing.T) {
+	logger, err := mlog.NewLogger(config.LoggingConfig{
+		Level:   "ERROR",
+		Console: false,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	defer logger.Close()
+
+	tests := []struct {
+		pattern string
+		want    func(addr uint16) uint16
+	}{
+		{"zero", func(addr uint16) uint16 { return 0 }},
+		{"address", func(addr uint16) uint16 { return addr }},
+		{"incrementing", func(addr uint16) uint16 { return addr + 1 }},
+		{"constant:4660", func(addr uint16) uint16 { return 4660 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			handler := NewModbusHandler(config.ModbusConfig{
+				UnitID:         1,
+				MaxRegisters:   200,
+				CounterAddress: 10,
+				InitPattern:    tt.pattern,
+				InitialData: []config.RegisterValue{
+					{Type: "holding", Address: 50, Value: 9999},
+				},
+			}, logger)
+
+			holding, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{UnitId: 1, Addr: 40, Quantity: 20})
+			if err != nil {
+				t.Fatalf("Failed to read holding registers: %v", err)
+			}
+			input, err := handler.HandleInputRegisters(&modbus.InputRegistersRequest{UnitId: 1, Addr: 40, Quantity: 20})
+			if err != nil {
+				t.Fatalf("Failed to read input registers: %v", err)
+			}
+
+			for i := range holding {
+				addr := uint16(40 + i)
+				want := tt.want(addr)
+				if addr == 50 {
+					want = 9999 // InitialData overrides the pattern
+				}
+				if holding[i] != want {
+					t.Fatalf("Holding %d: expected %d, got %d", addr, want, holding[i])
+				}
+				if addr != 50 && input[i] != tt.want(addr) {
+					t.Fatalf("Input %d: expected %d, got %d", addr, tt.want(addr), input[i])
+				}
+			}
+		})
+	}
+
+	// Each table is filled up to its own size
+	for _, sizes := range [][2]int{{300, 100}, {100, 300}} {
+		t.Run(fmt.Sprintf("Holding%dInput%d", sizes[0], sizes[1]), func(t *testing.T) {
+			handler := NewModbusHandler(config.ModbusConfig{
+				UnitID:              1,
+				MaxRegisters:        200,
+				MaxHoldingRegisters: sizes[0],
+				MaxInputRegisters:   sizes[1],
+				CounterAddress:      10,
+				InitPattern:         "incrementing",
+			}, logger)
+
+			for _, table := range []struct {
+				regType string
+				size    int
+			}{{"holding", sizes[0]}, {"input", sizes[1]}} {
+				last := uint16(table.size - 1)
+				regs, err := handler.ReadRegisters(table.regType, last, 1)
+				if err != nil {
+					t.Fatalf("Failed to read %s %d: %v", table.regType, last, err)
+				}
+				if regs[0] != last+1 {
+					t.Fatalf("%s %d: expected %d, got %d", table.regType, last, last+1, regs[0])
+				}
+			}
+		})
+	}
+}
+
+// TestDuplicateInitialData tests that conflicting initial data entries are reported
+func TestDuplicateInitialData(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "test.jsonl")
+	logger, err := mlog.NewLogger(config.LoggingConfig{
+		Level:   "WARN",
+		File:    logFile,
+		Console: false,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	defer logger.Close()
+
+	NewModbusHandler(config.ModbusConfig{
+		UnitID:         1,
+		MaxRegisters:   200,
+		CounterAddress: 10,
+		InitialData: []config.RegisterValue{
+			{Type: "input", Address: 30, Value: 21072},
+			{Type: "input", Address: 30, Value: 8077},
+			{Type: "holding", Address: 30, Value: 1}, // same address, different type
+		},
+	}, logger)
+
+	logs, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	if n := strings.Count(string(logs), "Duplicate initial data entry"); n != 1 {
+		t.Fatalf("Expected exactly 1 duplicate warning, got %d in %s", n, logs)
+	}
+	if !strings.Contains(string(logs), `"address":30`) {
+		t.Fatalf("Expected warning to identify address 30, got %s", logs)
+	}
+}
+
+// TestIncrement tests atomic increments of arbitrary holding registers
+func TestIncrement(t *testing.T) {
+	handler, _ := newTestHandler(t, config.ModbusConfig{
+		UnitID:         1,
+		MaxRegisters:   200,
+		CounterAddress: 10,
+		InitialData: []config.RegisterValue{
+			{Type: "holding", Address: 20, Value: 5},
+			{Type: "holding", Address: 21, Value: 65535},
+			{Type: "holding", Address: 22, Value: 1},
+		},
+	})
+
+	tests := []struct {
+		name  string
+		addr  uint16
+		delta int
+		want  uint16
+	}{
+		{"Add", 20, 3, 8},
+		{"WrapUp", 21, 2, 1},
+		{"WrapDown", 22, -3, 65534},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := handler.Increment(tt.addr, tt.delta)
+			if err != nil {
+				t.Fatalf("Increment failed: %v", err)
+			}
+			if value != tt.want {
+				t.Fatalf("Expected %d, got %d", tt.want, value)
+			}
+
+			regs, _ := handler.ReadRegisters("holding", tt.addr, 1)
+			if regs[0] != tt.want {
+				t.Fatalf("Expected stored value %d, got %d", tt.want, regs[0])
+			}
+		})
+	}
+
+	t.Run("OutOfBounds", func(t *testing.T) {
+		if _, err := handler.Increment(200, 1); err != modbus.ErrIllegalDataAddress {
+			t.Fatalf("Expected ErrIllegalDataAddress, got %v", err)
+		}
+	})
+}
+
+// TestDelayedWrite tests that writes to delayed registers become readable only
+// after the write delay
+func TestDelayedWrite(t *testing.T) {
+	handler, _ := newTestHandler(t, config.ModbusConfig{
+		UnitID:           1,
+		MaxRegisters:     200,
+		CounterAddress:   10,
+		DelayedRegisters: []uint16{20},
+		WriteDelay:       config.Duration(200 * time.Millisecond),
+		InitialData: []config.RegisterValue{
+			{Type: "holding", Address: 20, Value: 1},
+			{Type: "holding", Address: 21, Value: 1},
+		},
+	})
+
+	write := func(addr, value uint16) {
+		_, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+			UnitId: 1, Addr: addr, Quantity: 1, IsWrite: true, Args: []uint16{value},
+		})
+		if err != nil {
+			t.Fatalf("Failed to write register %d: %v", addr, err)
+		}
+	}
+	read := func(addr uint16) uint16 {
+		res, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+			UnitId: 1, Addr: addr, Quantity: 1,
+		})
+		if err != nil {
+			t.Fatalf("Failed to read register %d: %v", addr, err)
+		}
+		return res[0]
+	}
+
+	write(20, 42)
+	write(21, 42)
+
+	t.Run("OldValueBeforeDelay", func(t *testing.T) {
+		if v := read(20); v != 1 {
+			t.Fatalf("Expected old value 1 before the delay, got %d", v)
+		}
+		if v := read(21); v != 42 {
+			t.Fatalf("Expected undelayed register to update immediately, got %d", v)
+		}
+	})
+
+	t.Run("NewValueAfterDelay", func(t *testing.T) {
+		time.Sleep(300 * time.Millisecond)
+		if v := read(20); v != 42 {
+			t.Fatalf("Expected new value 42 after the delay, got %d", v)
+		}
+	})
+
+	t.Run("LastWriteWins", func(t *testing.T) {
+		write(20, 7)
+		write(20, 8)
+		time.Sleep(300 * time.Millisecond)
+		if v := read(20); v != 8 {
+			t.Fatalf("Expected last write 8, got %d", v)
+		}
+	})
+}
+
+// TestFloatInitialData tests that float32 initial data reads back through the handler
+func TestFloatInitialData(t *testing.T) {
+	f := float32(21.7)
+	handler, _ := newTestHandler(t, config.ModbusConfig{
+		UnitID:         1,
+		MaxRegisters:   200,
+		CounterAddress: 10,
+		InitialData: []config.RegisterValue{
+			{Type: "holding", Address: 30, Float32: &f},
+			{Type: "holding", Address: 40, Value: 0xffff},
+			{Type: "holding", Address: 41, Value: 0xfff6},
+		},
+	})
+
+	read := func(addr uint16) []uint16 {
+		res, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{UnitId: 1, Addr: addr, Quantity: 2})
+		if err != nil {
+			t.Fatalf("Failed to read registers %d-%d: %v", addr, addr+1, err)
+		}
+		return res
+	}
+
+	testhelpers.AssertFloat32(t, read(30), 21.7, 1e-5)
+	testhelpers.AssertInt32(t, read(40), -10)
+}
+
+// TestUninitializedReads tests rejection of reads from never-initialized addresses
+func TestUninitializedReads(t *testing.T) {
+	cfg := config.ModbusConfig{
+		UnitID:         1,
+		MaxRegisters:   200,
+		CounterAddress: 10,
+		InitialData: []config.RegisterValue{
+			{Type: "holding", Address: 20, Value: 0},
+			{Type: "input", Address: 20, Value: 5},
+			{Type: "coil", Address: 20, Value: 1},
+		},
+	}
+
+	readHolding := func(h *ModbusHandler, addr, quantity uint16) error {
+		_, err := h.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{UnitId: 1, Addr: addr, Quantity: quantity})
+		return err
+	}
+
+	t.Run("DefaultReturnsZero", func(t *testing.T) {
+		handler, _ := newTestHandler(t, cfg)
+		if err := readHolding(handler, 50, 1); err != nil {
+			t.Fatalf("Expected uninitialized read to succeed by default, got %v", err)
+		}
+	})
+
+	cfg.RejectUninitializedReads = true
+	handler, _ := newTestHandler(t, cfg)
+
+	t.Run("InitializedZero", func(t *testing.T) {
+		if err := readHolding(handler, 20, 1); err != nil {
+			t.Fatalf("Expected initialized zero to be readable, got %v", err)
+		}
+		if err := readHolding(handler, 10, 1); err != nil {
+			t.Fatalf("Expected counter register to be readable, got %v", err)
+		}
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		if err := readHolding(handler, 50, 1); err != modbus.ErrIllegalDataAddress {
+			t.Fatalf("Expected ErrIllegalDataAddress for uninitialized holding register, got %v", err)
+		}
+		if err := readHolding(handler, 20, 2); err != modbus.ErrIllegalDataAddress {
+			t.Fatalf("Expected ErrIllegalDataAddress for partially initialized range, got %v", err)
+		}
+		if _, err := handler.HandleInputRegisters(&modbus.InputRegistersRequest{UnitId: 1, Addr: 21, Quantity: 1}); err != modbus.ErrIllegalDataAddress {
+			t.Fatalf("Expected ErrIllegalDataAddress for uninitialized input register, got %v", err)
+		}
+		if _, err := handler.HandleDiscreteInputs(&modbus.DiscreteInputsRequest{UnitId: 1, Addr: 20, Quantity: 1}); err != modbus.ErrIllegalDataAddress {
+			t.Fatalf("Expected ErrIllegalDataAddress for uninitialized discrete input, got %v", err)
+		}
+	})
+
+	t.Run("WriteInitializes", func(t *testing.T) {
+		_, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+			UnitId: 1, Addr: 50, Quantity: 1, IsWrite: true, Args: []uint16{0},
+		})
+		if err != nil {
+			t.Fatalf("Expected write to uninitialized register to succeed, got %v", err)
+		}
+		if err := readHolding(handler, 50, 1); err != nil {
+			t.Fatalf("Expected written register to be readable, got %v", err)
+		}
+	})
+
+	t.Run("InitPatternInitializesAll", func(t *testing.T) {
+		cfg.InitPattern = "address"
+		handler, _ := newTestHandler(t, cfg)
+		if err := readHolding(handler, 150, 5); err != nil {
+			t.Fatalf("Expected init pattern to initialize every register, got %v", err)
+		}
+	})
+}
+
+// TestMirrors tests that holding register writes are reflected in mirrored input registers
+func TestMirrors(t *testing.T) {
+	handler, _ := newTestHandler(t, config.ModbusConfig{
+		UnitID:         1,
+		MaxRegisters:   200,
+		CounterAddress: 10,
+		Mirrors: []config.Mirror{
+			{Source: 20, Dest: 120},
+			{Source: 20, Dest: 121},
+			{Source: 21, Dest: 122},
+		},
+	})
+
+	_, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+		UnitId: 1, Addr: 20, Quantity: 3, IsWrite: true, Args: []uint16{11, 22, 33},
+	})
+	if err != nil {
+		t.Fatalf("Failed to write holding registers: %v", err)
+	}
+
+	input, err := handler.HandleInputRegisters(&modbus.InputRegistersRequest{UnitId: 1, Addr: 120, Quantity: 4})
+	if err != nil {
+		t.Fatalf("Failed to read input registers: %v", err)
+	}
+	want := []uint16{11, 11, 22, 0}
+	for i := range want {
+		if input[i] != want[i] {
+			t.Fatalf("Input %d: expected %d, got %d", 120+i, want[i], input[i])
+		}
+	}
+
+	t.Run("ProtectedSourceNotMirrored", func(t *testing.T) {
+		handler, _ := newTestHandler(t, config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   200,
+			CounterAddress: 10,
+			Mirrors:        []config.Mirror{{Source: 10, Dest: 110}},
+		})
+		handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+			UnitId: 1, Addr: 10, Quantity: 1, IsWrite: true, Args: []uint16{9999},
+		})
+		if v := readInput(t, handler, 110); v != 0 {
+			t.Fatalf("Expected ignored counter write not to be mirrored, got %d", v)
+		}
+	})
+}
+
+// TestLogReadValues tests that read values are logged, capped, only when enabled
+func TestLogReadValues(t *testing.T) {
+	readLog := func(t *testing.T, enabled bool) string {
+		logFile := filepath.Join(t.TempDir(), "test.jsonl")
+		logger, err := mlog.NewLogger(config.LoggingConfig{
+			Level:   "DEBUG",
+			File:    logFile,
+			Console: false,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create logger: %v", err)
+		}
+		defer logger.Close()
+
+		handler := NewModbusHandler(config.ModbusConfig{
+			UnitID:             1,
+			MaxRegisters:       200,
+			CounterAddress:     10,
+			InitPattern:        "address",
+			LogReadValues:      enabled,
+			LogReadValuesLimit: 3,
+		}, logger)
+		if _, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{UnitId: 1, Addr: 20, Quantity: 5}); err != nil {
+			t.Fatalf("Failed to read holding registers: %v", err)
+		}
+		if _, err := handler.HandleInputRegisters(&modbus.InputRegistersRequest{UnitId: 1, Addr: 30, Quantity: 2}); err != nil {
+			t.Fatalf("Failed to read input registers: %v", err)
+		}
+
+		logs, err := os.ReadFile(logFile)
+		if err != nil {
+			t.Fatalf("Failed to read log file: %v", err)
+		}
+		return string(logs)
+	}
+
+	t.Run("Enabled", func(t *testing.T) {
+		logs := readLog(t, true)
+		if !strings.Contains(logs, `"values":[20,21,22],"values_truncated":2`) {
+			t.Fatalf("Expected capped holding values in log, got %s", logs)
+		}
+		if !strings.Contains(logs, `"values":[30,31]`) {
+			t.Fatalf("Expected input values in log, got %s", logs)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		if logs := readLog(t, false); strings.Contains(logs, `"values"`) {
+			t.Fatalf("Expected no values in log when disabled, got %s", logs)
+		}
+	})
+}
+
+// TestCounterOverflow tests each overflow behavior at the 65535 boundary
+func TestCounterOverflow(t *testing.T) {
+	tests := []struct {
+		behavior string
+		want     []uint16 // values after each update starting from 65534
+	}{
+		{"", []uint16{65535, 1, 2}},
+		{"wrap-to-one", []uint16{65535, 1, 2}},
+		{"wrap-to-zero", []uint16{65535, 0, 1}},
+		{"saturate", []uint16{65535, 65535, 65535}},
+		{"reset-to-initial", []uint16{65535, 65534, 65535}},
+	}
+
+	for _, tt := range tests {
+		name := tt.behavior
+		if name == "" {
+			name = "default"
+		}
+		t.Run(name, func(t *testing.T) {
+			handler, _ := newTestHandler(t, config.ModbusConfig{
+				UnitID:           1,
+				MaxRegisters:     200,
+				CounterAddress:   10,
+				CounterInitial:   65534,
+				OverflowBehavior: tt.behavior,
+			})
+
+			regs, err := handler.ReadRegisters("holding", 10, 1)
+			if err != nil {
+				t.Fatalf("Failed to read counter: %v", err)
+			}
+			if regs[0] != 65534 {
+				t.Fatalf("Expected initial counter 65534, got %d", regs[0])
+			}
+
+			for i, want := range tt.want {
+				handler.UpdateCounter()
+				regs, err := handler.ReadRegisters("holding", 10, 1)
+				if err != nil {
+					t.Fatalf("Failed to read counter: %v", err)
+				}
+				if regs[0] != want {
+					t.Fatalf("Update %d: expected counter %d, got %d", i+1, want, regs[0])
+				}
+			}
+
+			if got := handler.GetStats().CounterOverflows; got != 1 {
+				t.Fatalf("Expected 1 counter overflow, got %d", got)
+			}
+		})
+	}
+}
+
+// TestPackedCoils tests that a packed holding register mirrors coil states bit by bit
+func TestPackedCoils(t *testing.T) {
+	handler, _ := newTestHandler(t, config.ModbusConfig{
+		UnitID:         1,
+		MaxRegisters:   200,
+		CounterAddress: 10,
+		PackedCoils:    []config.PackedCoils{{Address: 50, Coil: 30, Count: 12}},
+	})
+
+	coils := []bool{true, false, true, true, false, false, false, false, true, false, false, true}
+	_, err := handler.HandleCoils(&modbus.CoilsRequest{
+		UnitId: 1, Addr: 30, Quantity: uint16(len(coils)), IsWrite: true, Args: coils,
+	})
+	if err != nil {
+		t.Fatalf("Failed to write coils: %v", err)
+	}
+	// Coils past the packed range must not leak in
+	if _, err := handler.HandleCoils(&modbus.CoilsRequest{
+		UnitId: 1, Addr: 42, Quantity: 1, IsWrite: true, Args: []bool{true},
+	}); err != nil {
+		t.Fatalf("Failed to write coil: %v", err)
+	}
+
+	read := func() uint16 {
+		t.Helper()
+		res, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{UnitId: 1, Addr: 50, Quantity: 1})
+		if err != nil {
+			t.Fatalf("Failed to read packed register: %v", err)
+		}
+		return res[0]
+	}
+
+	if v := read(); v != 0b1001_0000_1101 {
+		t.Fatalf("Expected packed value %#b, got %#b", 0b1001_0000_1101, v)
+	}
+
+	t.Run("FollowsCoilChanges", func(t *testing.T) {
+		handler.HandleCoils(&modbus.CoilsRequest{UnitId: 1, Addr: 30, Quantity: 1, IsWrite: true, Args: []bool{false}})
+		if v := read(); v != 0b1001_0000_1100 {
+			t.Fatalf("Expected packed value %#b, got %#b", 0b1001_0000_1100, v)
+		}
+	})
+
+	t.Run("ReadOnly", func(t *testing.T) {
+		handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+			UnitId: 1, Addr: 50, Quantity: 1, IsWrite: true, Args: []uint16{0xffff},
+		})
+		if v := read(); v != 0b1001_0000_1100 {
+			t.Fatalf("Expected write to be ignored, got %#b", v)
+		}
+	})
+}
+
+// TestClockCounter tests that time-based counter modes track the clock
+func TestClockCounter(t *testing.T) {
+	readCounter := func(t *testing.T, h *ModbusHandler, count uint16) []uint16 {
+		t.Helper()
+		regs, err := h.ReadRegisters("holding", 10, count)
+		if err != nil {
+			t.Fatalf("Failed to read counter: %v", err)
+		}
+		return regs
+	}
+
+	t.Run("UptimeSeconds", func(t *testing.T) {
+		handler, _ := newTestHandler(t, config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   200,
+			CounterAddress: 10,
+			CounterMode:    "uptime-seconds",
+		})
+		start := handler.stats.StartTime
+
+		for _, elapsed := range []time.Duration{0, 1500 * time.Millisecond, 90 * time.Second, 70000 * time.Second} {
+			handler.now = func() time.Time { return start.Add(elapsed) }
+			handler.UpdateCounter()
+			want := uint16(uint32(elapsed / time.Second))
+			if got := readCounter(t, handler, 1)[0]; got != want {
+				t.Fatalf("After %v: expected counter %d, got %d", elapsed, want, got)
+			}
+		}
+	})
+
+	t.Run("Epoch32Bit", func(t *testing.T) {
+		handler, _ := newTestHandler(t, config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   200,
+			CounterAddress: 10,
+			CounterMode:    "epoch",
+			Counter32Bit:   true,
+		})
+		now := time.Unix(1_700_000_000, 0)
+		handler.now = func() time.Time { return now }
+		handler.UpdateCounter()
+
+		regs := readCounter(t, handler, 2)
+		if got := uint32(regs[0])<<16 | uint32(regs[1]); got != 1_700_000_000 {
+			t.Fatalf("Expected epoch 1700000000, got %d (%v)", got, regs)
+		}
+
+		// Both words are protected from client writes
+		handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+			UnitId: 1, Addr: 10, Quantity: 2, IsWrite: true, Args: []uint16{1, 2},
+		})
+		if got := readCounter(t, handler, 2); got[0] != regs[0] || got[1] != regs[1] {
+			t.Fatalf("Expected counter writes to be ignored, got %v", got)
+		}
+	})
+}
+
+// TestLoadStatePrecedence tests how a snapshot and initial data are merged
+func TestLoadStatePrecedence(t *testing.T) {
+	cfg := config.ModbusConfig{
+		UnitID:         1,
+		MaxRegisters:   200,
+		CounterAddress: 10,
+		InitialData: []config.RegisterValue{
+			{Type: "holding", Address: 20, Value: 100},
+			{Type: "holding", Address: 21, Value: 200},
+			{Type: "coil", Address: 5, Value: 1},
+		},
+	}
+
+	// The saved device had register 20 changed, 21 cleared and coil 5 off
+	source, _ := newTestHandler(t, cfg)
+	source.SetRegister("holding", 20, 111)
+	source.SetRegister("holding", 21, 0)
+	source.HandleCoils(&modbus.CoilsRequest{UnitId: 1, Addr: 5, Quantity: 1, IsWrite: true, Args: []bool{false}})
+	source.SetRegister("holding", 30, 333)
+	for i := 0; i < 7; i++ {
+		source.UpdateCounter()
+	}
+	snap := source.Snapshot()
+
+	tests := []struct {
+		name        string
+		initialWins bool
+		wantHolding []uint16 // addresses 20, 21, 30
+		wantCoilOn  bool
+	}{
+		{"SnapshotWins", false, []uint16{111, 0, 333}, false},
+		{"InitialDataWins", true, []uint16{100, 200, 333}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := cfg
+			cfg.InitialDataOverState = tt.initialWins
+			handler, _ := newTestHandler(t, cfg)
+			handler.LoadState(snap)
+
+			for i, addr := range []uint16{20, 21, 30} {
+				regs, _ := handler.ReadRegisters("holding", addr, 1)
+				if regs[0] != tt.wantHolding[i] {
+					t.Fatalf("Register %d: expected %d, got %d", addr, tt.wantHolding[i], regs[0])
+				}
+			}
+			coils, _ := handler.ReadBits("coil", 5, 1)
+			if coils[0] != tt.wantCoilOn {
+				t.Fatalf("Coil 5: expected %v, got %v", tt.wantCoilOn, coils[0])
+			}
+
+			// The counter continues from its saved value
+			handler.UpdateCounter()
+			if regs, _ := handler.ReadRegisters("holding", 10, 1); regs[0] != 8 {
+				t.Fatalf("Expected counter to continue at 8, got %d", regs[0])
+			}
+		})
+	}
+}
+
+// TestPerTypeSizes tests that each data type is bounded by its own size
+func TestPerTypeSizes(t *testing.T) {
+	handler, _ := newTestHandler(t, config.ModbusConfig{
+		UnitID:              1,
+		MaxRegisters:        100,
+		CounterAddress:      10,
+		MaxCoils:            2000,
+		MaxHoldingRegisters: 50,
+		MaxInputRegisters:   300,
+	})
+
+	tests := []struct {
+		name string
+		read func(addr uint16) error
+		size int
+	}{
+		{"Coils", func(addr uint16) error {
+			_, err := handler.HandleCoils(&modbus.CoilsRequest{UnitId: 1, Addr: addr, Quantity: 1})
+			return err
+		}, 2000},
+		{"DiscreteInputsFallBack", func(addr uint16) error {
+			_, err := handler.HandleDiscreteInputs(&modbus.DiscreteInputsRequest{UnitId: 1, Addr: addr, Quantity: 1})
+			return err
+		}, 100},
+		{"Holding", func(addr uint16) error {
+			_, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{UnitId: 1, Addr: addr, Quantity: 1})
+			return err
+		}, 50},
+		{"Input", func(addr uint16) error {
+			_, err := handler.HandleInputRegisters(&modbus.InputRegistersRequest{UnitId: 1, Addr: addr, Quantity: 1})
+			return err
+		}, 300},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.read(uint16(tt.size - 1)); err != nil {
+				t.Fatalf("Expected last address %d to be readable, got %v", tt.size-1, err)
+			}
+			if err := tt.read(uint16(tt.size)); err != modbus.ErrIllegalDataAddress {
+				t.Fatalf("Expected ErrIllegalDataAddress at %d, got %v", tt.size, err)
+			}
+		})
+	}
+}
+
+// TestFirstRequestLatency tests that the first successful request after
+// startup is measured and logged exactly once
+func TestFirstRequestLatency(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "test.jsonl")
+	logger, err := mlog.NewLogger(config.LoggingConfig{
+		Level:   "INFO",
+		File:    logFile,
+		Console: false,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	defer logger.Close()
+
+	handler := NewModbusHandler(config.ModbusConfig{
+		UnitID:         1,
+		MaxRegisters:   200,
+		CounterAddress: 10,
+	}, logger)
+
+	ready := time.Now()
+	handler.MarkReady(ready)
+	now := ready.Add(40 * time.Millisecond)
+	handler.now = func() time.Time { return now }
+
+	// A failed request doesn't count
+	if _, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{UnitId: 1, Addr: 500, Quantity: 1}); err == nil {
+		t.Fatalf("Expected out-of-range read to fail")
+	}
+	if got := handler.GetStats().FirstRequestLatency; got != 0 {
+		t.Fatalf("Expected no latency before a successful request, got %v", got)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{UnitId: 1, Addr: 0, Quantity: 1}); err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		now = now.Add(time.Second)
+	}
+
+	if got := handler.GetStats().FirstRequestLatency; got != 40*time.Millisecond {
+		t.Fatalf("Expected first request latency 40ms, got %v", got)
+	}
+
+	logs, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	if n := strings.Count(string(logs), "First request handled"); n != 1 {
+		t.Fatalf("Expected 1 first-request entry, got %d in %s", n, logs)
+	}
+}
+
+// TestExceptionMap tests that exception_map overrides the exception returned
+// for each error condition and leaves the others at their defaults
+func TestExceptionMap(t *testing.T) {
+	handler, _ := newTestHandler(t, config.ModbusConfig{
+		UnitID:         1,
+		MaxRegisters:   100,
+		CounterAddress: 10,
+		ExceptionMap: map[string]uint8{
+			"out_of_bounds": 0x04,
+			"invalid_unit":  0x0b,
+		},
+	})
+
+	tests := []struct {
+		name string
+		req  modbus.HoldingRegistersRequest
+		want error
+	}{
+		{"OutOfBounds", modbus.HoldingRegistersRequest{UnitId: 1, Addr: 99, Quantity: 2}, modbus.ErrServerDeviceFailure},
+		{"InvalidUnit", modbus.HoldingRegistersRequest{UnitId: 7, Addr: 0, Quantity: 1}, modbus.ErrGWTargetFailedToRespond},
+		{"DefaultQuantity", modbus.HoldingRegistersRequest{UnitId: 1, Addr: 0, Quantity: 0}, modbus.ErrIllegalDataValue},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := handler.HandleHoldingRegisters(&tt.req); err != tt.want {
+				t.Fatalf("Expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+
+	t.Run("Coils", func(t *testing.T) {
+		_, err := handler.HandleCoils(&modbus.CoilsRequest{UnitId: 1, Addr: 100, Quantity: 1})
+		if err != modbus.ErrServerDeviceFailure {
+			t.Fatalf("Expected ErrServerDeviceFailure, got %v", err)
+		}
+	})
+}
+
+// TestProtectedRegisters tests locking and unlocking a holding register
+// against client writes
+func TestProtectedRegisters(t *testing.T) {
+	handler, _ := newTestHandler(t, config.ModbusConfig{
+		UnitID:         1,
+		MaxRegisters:   100,
+		CounterAddress: 10,
+	})
+
+	write := func(t *testing.T, addr, value uint16) {
+		t.Helper()
+		if _, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+			UnitId: 1, Addr: addr, Quantity: 1, IsWrite: true, Args: []uint16{value},
+		}); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	read := func(t *testing.T, addr uint16) uint16 {
+		t.Helper()
+		regs, err := handler.ReadRegisters("holding", addr, 1)
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		return regs[0]
+	}
+
+	write(t, 20, 1)
+	if err := handler.SetProtected(20, true); err != nil {
+		t.Fatalf("SetProtected failed: %v", err)
+	}
+
+	t.Run("Locked", func(t *testing.T) {
+		write(t, 20, 2)
+		if v := read(t, 20); v != 1 {
+			t.Fatalf("Expected protected register to keep 1, got %d", v)
+		}
+		if !handler.Protected(20) || handler.Protected(21) {
+			t.Fatal("Expected only register 20 to be protected")
+		}
+	})
+
+	t.Run("MultipleWrite", func(t *testing.T) {
+		if _, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+			UnitId: 1, Addr: 19, Quantity: 3, IsWrite: true, Args: []uint16{7, 7, 7},
+		}); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		if a, b, c := read(t, 19), read(t, 20), read(t, 21); a != 7 || b != 1 || c != 7 {
+			t.Fatalf("Expected 7, 1, 7, got %d, %d, %d", a, b, c)
+		}
+	})
+
+	t.Run("AdminWrite", func(t *testing.T) {
+		if err := handler.SetRegister("holding", 20, 3); err != nil {
+			t.Fatalf("SetRegister failed: %v", err)
+		}
+		if v := read(t, 20); v != 3 {
+			t.Fatalf("Expected admin write to apply, got %d", v)
+		}
+	})
+
+	t.Run("Unlocked", func(t *testing.T) {
+		if err := handler.SetProtected(20, false); err != nil {
+			t.Fatalf("SetProtected failed: %v", err)
+		}
+		write(t, 20, 4)
+		if v := read(t, 20); v != 4 {
+			t.Fatalf("Expected unlocked register to be written, got %d", v)
+		}
+		if got := handler.ProtectedRegisters(); len(got) != 0 {
+			t.Fatalf("Expected no protected registers, got %v", got)
+		}
+	})
+
+	t.Run("OutOfBounds", func(t *testing.T) {
+		if err := handler.SetProtected(100, true); err != modbus.ErrIllegalDataAddress {
+			t.Fatalf("Expected ErrIllegalDataAddress, got %v", err)
+		}
+	})
+}
+
+// TestConstraints tests that writes violating a register constraint are
+// rejected and leave the registers unchanged
+func TestConstraints(t *testing.T) {
+	low, high := uint16(10), uint16(20)
+	handler, _ := newTestHandler(t, config.ModbusConfig{
+		UnitID:         1,
+		MaxRegisters:   100,
+		CounterAddress: 99,
+		Constraints: []config.RegisterConstraint{
+			{Address: 5, Allowed: []uint16{0, 1, 2, 3}},
+			{Address: 6, Min: &low, Max: &high},
+		},
+	})
+
+	write := func(addr uint16, values ...uint16) error {
+		_, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+			UnitId: 1, Addr: addr, Quantity: uint16(len(values)), IsWrite: true, Args: values,
+		})
+		return err
+	}
+	read := func(t *testing.T, addr uint16) uint16 {
+		t.Helper()
+		regs, err := handler.ReadRegisters("holding", addr, 1)
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		return regs[0]
+	}
+
+	tests := []struct {
+		name  string
+		addr  uint16
+		value uint16
+		want  error
+	}{
+		{"EnumValid", 5, 3, nil},
+		{"EnumInvalid", 5, 4, modbus.ErrIllegalDataValue},
+		{"RangeLow", 6, 10, nil},
+		{"RangeHigh", 6, 20, nil},
+		{"RangeBelow", 6, 9, modbus.ErrIllegalDataValue},
+		{"RangeAbove", 6, 21, modbus.ErrIllegalDataValue},
+		{"Unconstrained", 7, 500, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := read(t, tt.addr)
+			if err := write(tt.addr, tt.value); err != tt.want {
+				t.Fatalf("Expected %v, got %v", tt.want, err)
+			}
+			want := tt.value
+			if tt.want != nil {
+				want = before
+			}
+			if v := read(t, tt.addr); v != want {
+				t.Fatalf("Expected register %d to be %d, got %d", tt.addr, want, v)
+			}
+		})
+	}
+
+	t.Run("MultipleWrite", func(t *testing.T) {
+		if err := write(4, 1, 9, 15); err != modbus.ErrIllegalDataValue {
+			t.Fatalf("Expected ErrIllegalDataValue, got %v", err)
+		}
+		if v := read(t, 4); v != 0 {
+			t.Fatalf("Expected register 4 to stay 0 after a rejected write, got %d", v)
+		}
+		if err := write(4, 1, 2, 15); err != nil {
+			t.Fatalf("Expected valid write to succeed, got %v", err)
+		}
+	})
+
+	if stats := handler.GetStats(); stats.Errors != 4 {
+		t.Fatalf("Expected 4 errors, got %d", stats.Errors)
+	}
+}
+
+// TestMirrorCounterToInput tests that the counter reads the same through the
+// holding and input register function codes
+func TestMirrorCounterToInput(t *testing.T) {
+	mirror := uint16(50)
+	readBoth := func(t *testing.T, h *ModbusHandler, count uint16) ([]uint16, []uint16) {
+		t.Helper()
+		holding, err := h.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{UnitId: 1, Addr: 10, Quantity: count})
+		if err != nil {
+			t.Fatalf("Failed to read holding counter: %v", err)
+		}
+		input, err := h.HandleInputRegisters(&modbus.InputRegistersRequest{UnitId: 1, Addr: mirror, Quantity: count})
+		if err != nil {
+			t.Fatalf("Failed to read input counter: %v", err)
+		}
+		return holding, input
+	}
+
+	t.Run("Increment", func(t *testing.T) {
+		handler, _ := newTestHandler(t, config.ModbusConfig{
+			UnitID:               1,
+			MaxRegisters:         100,
+			CounterAddress:       10,
+			CounterInitial:       65534,
+			MirrorCounterToInput: &mirror,
+		})
+
+		for i := 0; i < 4; i++ {
+			holding, input := readBoth(t, handler, 1)
+			if holding[0] != input[0] {
+				t.Fatalf("Step %d: holding counter %d, input counter %d", i, holding[0], input[0])
+			}
+			handler.UpdateCounter()
+		}
+	})
+
+	t.Run("Epoch32Bit", func(t *testing.T) {
+		handler, _ := newTestHandler(t, config.ModbusConfig{
+			UnitID:               1,
+			MaxRegisters:         100,
+			CounterAddress:       10,
+			CounterMode:          "epoch",
+			Counter32Bit:         true,
+			MirrorCounterToInput: &mirror,
+		})
+		handler.now = func() time.Time { return time.Unix(0x12345678, 0) }
+		handler.UpdateCounter()
+
+		holding, input := readBoth(t, handler, 2)
+		if holding[0] != 0x1234 || holding[1] != 0x5678 {
+			t.Fatalf("Expected holding counter 0x1234 0x5678, got %#x %#x", holding[0], holding[1])
+		}
+		if input[0] != holding[0] || input[1] != holding[1] {
+			t.Fatalf("Expected input counter %v, got %v", holding, input)
+		}
+	})
+}
+
+// TestParseCaptureRanges tests parsing of -capture-ranges
+func TestParseCaptureRanges(t *testing.T) {
+	ranges, err := ParseCaptureRanges("holding:0-99, coil:5,input:100-101")
+	if err != nil {
+		t.Fatalf("Failed to parse ranges: %v", err)
+	}
+	want := []CaptureRange{{"holding", 0, 100}, {"coil", 5, 1}, {"input", 100, 2}}
+	if len(ranges) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, ranges)
+	}
+	for i := range want {
+		if ranges[i] != want[i] {
+			t.Fatalf("Range %d: expected %v, got %v", i, want[i], ranges[i])
+		}
+	}
+
+	for _, list := range []string{"", "holding", "flags:0-1", "holding:10-5", "input:0-70000"} {
+		if _, err := ParseCaptureRanges(list); err == nil {
+			t.Fatalf("Expected %q to be rejected", list)
+		}
+	}
+}
+
+// TestStaleReads tests that reads are served from the snapshot without
+// waiting for the lock, and that the snapshot follows completed writes.
+func TestStaleReads(t *testing.T) {
+	handler, _ := newTestHandler(t, config.ModbusConfig{
+		UnitID:         1,
+		MaxRegisters:   100,
+		CounterAddress: 10,
+		StaleReads:     true,
+		InitialData: []config.RegisterValue{
+			{Type: "holding", Address: 20, Value: 7},
+		},
+	})
+
+	readHolding := func() uint16 {
+		res, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{UnitId: 1, Addr: 20, Quantity: 1})
+		if err != nil {
+			t.Fatalf("Failed to read holding register: %v", err)
+		}
+		return res[0]
+	}
+
+	t.Run("InitialData", func(t *testing.T) {
+		if v := readHolding(); v != 7 {
+			t.Fatalf("Expected 7, got %d", v)
+		}
+	})
+
+	t.Run("FollowsWrites", func(t *testing.T) {
+		_, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+			UnitId: 1, Addr: 20, Quantity: 1, IsWrite: true, Args: []uint16{42},
+		})
+		if err != nil {
+			t.Fatalf("Failed to write holding register: %v", err)
+		}
+		if v := readHolding(); v != 42 {
+			t.Fatalf("Expected 42 after write, got %d", v)
+		}
+
+		if _, err := handler.HandleCoils(&modbus.CoilsRequest{
+			UnitId: 1, Addr: 3, Quantity: 1, IsWrite: true, Args: []bool{true},
+		}); err != nil {
+			t.Fatalf("Failed to write coil: %v", err)
+		}
+		coils, err := handler.HandleCoils(&modbus.CoilsRequest{UnitId: 1, Addr: 3, Quantity: 1})
+		if err != nil {
+			t.Fatalf("Failed to read coil: %v", err)
+		}
+		if !coils[0] {
+			t.Fatalf("Expected coil 3 to be set after write")
+		}
+
+		if err := handler.SetRegister("input", 5, 99); err != nil {
+			t.Fatalf("Failed to set input register: %v", err)
+		}
+		if v := readInput(t, handler, 5); v != 99 {
+			t.Fatalf("Expected input 99, got %d", v)
+		}
+	})
+
+	t.Run("DoesNotBlock", func(t *testing.T) {
+		handler.mu.Lock()
+		defer handler.mu.Unlock()
+
+		done := make(chan uint16, 1)
+		go func() {
+			res, _ := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{UnitId: 1, Addr: 20, Quantity: 1})
+			if len(res) == 1 {
+				done <- res[0]
+			}
+			close(done)
+		}()
+
+		select {
+		case v := <-done:
+			if v != 42 {
+				t.Fatalf("Expected 42 while locked, got %d", v)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected read to complete while the lock is held")
+		}
+	})
+}
+
+// TestStaleReadsComputePanic tests that a compute function panicking while
+// the snapshot is published doesn't leave the write lock held
+func TestStaleReadsComputePanic(t *testing.T) {
+	handler, _ := newTestHandler(t, config.ModbusConfig{
+		UnitID:         1,
+		MaxRegisters:   100,
+		CounterAddress: 10,
+		StaleReads:     true,
+	})
+
+	var explode bool
+	if err := handler.RegisterComputed(50, func(addr uint16, regs RegisterReader) uint16 {
+		if explode {
+			panic("compute failed")
+		}
+		return 1
+	}); err != nil {
+		t.Fatalf("Failed to register computed register: %v", err)
+	}
+
+	explode = true
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatal("Expected the write to panic")
+			}
+		}()
+		handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+			UnitId: 1, Addr: 20, Quantity: 1, IsWrite: true, Args: []uint16{42},
+		})
+	}()
+	explode = false
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+			UnitId: 1, Addr: 21, Quantity: 1, IsWrite: true, Args: []uint16{43},
+		})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Expected the second write to succeed, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the second write to complete, the lock is still held")
+	}
+}
+
+// TestInvertedValues tests that inverted coils and registers read
+// complemented while the stored values stay as written
+func TestInvertedValues(t *testing.T) {
+	handler, _ := newTestHandler(t, config.ModbusConfig{
+		UnitID:         1,
+		MaxRegisters:   100,
+		CounterAddress: 10,
+		Inverted: []config.RegisterRef{
+			{Type: "holding", Address: 20},
+			{Type: "input", Address: 5},
+			{Type: "coil", Address: 3},
+			{Type: "discrete", Address: 4},
+		},
+		InitialData: []config.RegisterValue{
+			{Type: "holding", Address: 20, Value: 0x00FF},
+			{Type: "holding", Address: 21, Value: 0x00FF},
+			{Type: "input", Address: 5, Value: 1},
+			{Type: "coil", Address: 3, Value: 1},
+		},
+	})
+
+	t.Run("Holding", func(t *testing.T) {
+		res, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{UnitId: 1, Addr: 20, Quantity: 2})
+		if err != nil {
+			t.Fatalf("Failed to read holding registers: %v", err)
+		}
+		if res[0] != 0xFF00 || res[1] != 0x00FF {
+			t.Fatalf("Expected [0xFF00 0x00FF], got [%#04x %#04x]", res[0], res[1])
+		}
+
+		stored, err := handler.ReadRegisters("holding", 20, 1)
+		if err != nil {
+			t.Fatalf("Failed to inspect holding register: %v", err)
+		}
+		if stored[0] != 0x00FF {
+			t.Fatalf("Expected storage to keep 0x00FF, got %#04x", stored[0])
+		}
+
+		wire, err := handler.WireHoldingRegisters(20, 1)
+		if err != nil {
+			t.Fatalf("Failed to read wire bytes of holding register: %v", err)
+		}
+		if wire[0] != 0xFF || wire[1] != 0x00 {
+			t.Fatalf("Expected wire bytes FF 00, got % X", wire)
+		}
+	})
+
+	t.Run("Input", func(t *testing.T) {
+		res, err := handler.HandleInputRegisters(&modbus.InputRegistersRequest{UnitId: 1, Addr: 5, Quantity: 1})
+		if err != nil {
+			t.Fatalf("Failed to read input register: %v", err)
+		}
+		if res[0] != 0xFFFE {
+			t.Fatalf("Expected 0xFFFE, got %#04x", res[0])
+		}
+		if v := readInput(t, handler, 5); v != 1 {
+			t.Fatalf("Expected storage to keep 1, got %d", v)
+		}
+	})
+
+	t.Run("Bits", func(t *testing.T) {
+		coils, err := handler.HandleCoils(&modbus.CoilsRequest{UnitId: 1, Addr: 2, Quantity: 2})
+		if err != nil {
+			t.Fatalf("Failed to read coils: %v", err)
+		}
+		if coils[0] || coils[1] {
+			t.Fatalf("Expected coils [false false], got %v", coils)
+		}
+
+		discrete, err := handler.HandleDiscreteInputs(&modbus.DiscreteInputsRequest{UnitId: 1, Addr: 4, Quantity: 1})
+		if err != nil {
+			t.Fatalf("Failed to read discrete inputs: %v", err)
+		}
+		if !discrete[0] {
+			t.Fatalf("Expected inverted discrete input 4 to read true")
+		}
+
+		stored, err := handler.ReadBits("coil", 3, 1)
+		if err != nil {
+			t.Fatalf("Failed to inspect coil: %v", err)
+		}
+		if !stored[0] {
+			t.Fatalf("Expected coil 3 storage to stay set")
+		}
+	})
+}
+
+// TestRequestLogContext tests that entries logged while handling a request
+// carry the request's function code, unit ID, start address and quantity
+func TestRequestLogContext(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "test.jsonl")
+	logger, err := mlog.NewLogger(config.LoggingConfig{
+		Level:   "DEBUG",
+		File:    logFile,
+		Console: false,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	defer logger.Close()
+
+	handler := NewModbusHandler(config.ModbusConfig{
+		UnitID:         1,
+		MaxRegisters:   200,
+		CounterAddress: 10,
+	}, logger)
+	if _, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+		UnitId: 1, Addr: 20, Quantity: 2, IsWrite: true, Args: []uint16{5, 6},
+	}); err != nil {
+		t.Fatalf("Failed to write holding registers: %v", err)
+	}
+	if _, err := handler.HandleCoils(&modbus.CoilsRequest{
+		UnitId: 1, Addr: 3, Quantity: 1, IsWrite: true, Args: []bool{true},
+	}); err != nil {
+		t.Fatalf("Failed to write coil: %v", err)
+	}
+
+	logs, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+
+	var written, coil int
+	for _, line := range strings.Split(strings.TrimSpace(string(logs)), "\n") {
+		var entry mlog.LogEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Invalid log line %q: %v", line, err)
+		}
+		switch entry.Message {
+		case "Register written":
+			written++
+			if entry.Data["function"] != float64(0x10) || entry.Data["unit_id"] != float64(1) ||
+				entry.Data["start"] != float64(20) || entry.Data["quantity"] != float64(2) {
+				t.Fatalf("Expected write multiple context on %v", entry.Data)
+			}
+		case "Coil written":
+			coil++
+			if entry.Data["function"] != float64(0x05) || entry.Data["address"] != float64(3) {
+				t.Fatalf("Expected write single coil context on %v", entry.Data)
+			}
+		}
+	}
+	if written != 2 || coil != 1 {
+		t.Fatalf("Expected 2 register and 1 coil write entries, got %d and %d", written, coil)
+	}
+}
+
+// TestCoilOnWrite tests that coil callbacks fire on changing client writes,
+// outside the lock
+func TestCoilOnWrite(t *testing.T) {
+	handler, _ := newTestHandler(t, config.ModbusConfig{
+		UnitID:         1,
+		MaxRegisters:   100,
+		CounterAddress: 10,
+	})
+
+	type event struct {
+		addr     uint16
+		old, new bool
+	}
+	var events []event
+	err := handler.RegisterCoilOnWrite(3, func(addr uint16, old, new bool) {
+		events = append(events, event{addr, old, new})
+		// Calling back into the handler would deadlock under the lock
+		status := uint16(0)
+		if new {
+			status = 1
+		}
+		if err := handler.SetRegister("holding", 30, status); err != nil {
+			t.Errorf("Failed to set status register: %v", err)
+		}
+	})
+	if err != nil {
+		t.Fatalf("Failed to register coil callback: %v", err)
+	}
+
+	if err := handler.RegisterCoilOnWrite(200, func(uint16, bool, bool) {}); err != modbus.ErrIllegalDataAddress {
+		t.Fatalf("Expected ErrIllegalDataAddress for an out of range coil, got %v", err)
+	}
+
+	write := func(values ...bool) {
+		t.Helper()
+		if _, err := handler.HandleCoils(&modbus.CoilsRequest{
+			UnitId: 1, Addr: 2, Quantity: uint16(len(values)), IsWrite: true, Args: values,
+		}); err != nil {
+			t.Fatalf("Failed to write coils: %v", err)
+		}
+	}
+
+	write(true, true)  // coil 3 off -> on
+	write(false, true) // coil 3 unchanged
+	write(true, false) // coil 3 on -> off
+
+	want := []event{{3, false, true}, {3, true, false}}
+	if len(events) != len(want) {
+		t.Fatalf("Expected %d events, got %v", len(want), events)
+	}
+	for i, e := range want {
+		if events[i] != e {
+			t.Fatalf("Event %d: expected %+v, got %+v", i, e, events[i])
+		}
+	}
+
+	regs, err := handler.ReadRegisters("holding", 30, 1)
+	if err != nil {
+		t.Fatalf("Failed to read status register: %v", err)
+	}
+	if regs[0] != 0 {
+		t.Fatalf("Expected status register 0 after the coil was cleared, got %d", regs[0])
+	}
+}
+
+// TestBoundaryMode tests strict and lenient reads crossing the end of a table
+func TestBoundaryMode(t *testing.T) {
+	newHandler := func(t *testing.T, mode string, stale bool) *ModbusHandler {
+		handler, _ := newTestHandler(t, config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   100,
+			CounterAddress: 10,
+			InitPattern:    "incrementing",
+			BoundaryMode:   mode,
+			StaleReads:     stale,
+			InitialData: []config.RegisterValue{
+				{Type: "coil", Address: 99, Value: 1},
+			},
+		})
+		return handler
+	}
+	crossing := &modbus.HoldingRegistersRequest{UnitId: 1, Addr: 98, Quantity: 4}
+
+	t.Run("Strict", func(t *testing.T) {
+		handler := newHandler(t, "", false)
+		if _, err := handler.HandleHoldingRegisters(crossing); err != modbus.ErrIllegalDataAddress {
+			t.Fatalf("Expected ErrIllegalDataAddress, got %v", err)
+		}
+	})
+
+	for _, stale := range []bool{false, true} {
+		name := "Lenient"
+		if stale {
+			name = "LenientStale"
+		}
+		t.Run(name, func(t *testing.T) {
+			handler := newHandler(t, "lenient", stale)
+
+			res, err := handler.HandleHoldingRegisters(crossing)
+			if err != nil {
+				t.Fatalf("Expected lenient read to succeed, got %v", err)
+			}
+			if want := []uint16{99, 100, 0, 0}; fmt.Sprint(res) != fmt.Sprint(want) {
+				t.Fatalf("Expected %v, got %v", want, res)
+			}
+
+			input, err := handler.HandleInputRegisters(&modbus.InputRegistersRequest{UnitId: 1, Addr: 99, Quantity: 2})
+			if err != nil || len(input) != 2 || input[0] != 100 || input[1] != 0 {
+				t.Fatalf("Expected input [100 0], got %v (%v)", input, err)
+			}
+
+			coils, err := handler.HandleCoils(&modbus.CoilsRequest{UnitId: 1, Addr: 99, Quantity: 3})
+			if err != nil || len(coils) != 3 || !coils[0] || coils[1] || coils[2] {
+				t.Fatalf("Expected coils [true false false], got %v (%v)", coils, err)
+			}
+
+			if _, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{UnitId: 1, Addr: 100, Quantity: 1}); err != modbus.ErrIllegalDataAddress {
+				t.Fatalf("Expected ErrIllegalDataAddress for a start past the end, got %v", err)
+			}
+
+			_, err = handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+				UnitId: 1, Addr: 99, Quantity: 2, IsWrite: true, Args: []uint16{1, 2},
+			})
+			if err != modbus.ErrIllegalDataAddress {
+				t.Fatalf("Expected crossing write to stay strict, got %v", err)
+			}
+		})
+	}
+}
+
+func TestEventSubscription(t *testing.T) {
+	newHandler := func(t *testing.T) *ModbusHandler {
+		handler, _ := newTestHandler(t, config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   100,
+			CounterAddress: 10,
+		})
+		return handler
+	}
+	writeRegister := func(t *testing.T, handler *ModbusHandler, addr, value uint16) {
+		t.Helper()
+		if _, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+			UnitId: 1, Addr: addr, Quantity: 1, IsWrite: true, Args: []uint16{value}, ClientAddr: "10.0.0.1:5000",
+		}); err != nil {
+			t.Fatalf("Failed to write register: %v", err)
+		}
+	}
+
+	t.Run("MultipleSubscribers", func(t *testing.T) {
+		handler := newHandler(t)
+		first := make(chan Event, 16)
+		second := make(chan Event, 16)
+		handler.Subscribe(first)
+		handler.Subscribe(second)
+
+		handler.OnConnect("10.0.0.1:5000")
+		writeRegister(t, handler, 5, 42)
+		if _, err := handler.HandleCoils(&modbus.CoilsRequest{
+			UnitId: 1, Addr: 2, Quantity: 1, IsWrite: true, Args: []bool{true},
+		}); err != nil {
+			t.Fatalf("Failed to write coil: %v", err)
+		}
+		handler.UpdateCounter()
+		handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{UnitId: 1, Addr: 90, Quantity: 20})
+
+		want := []Event{
+			{Type: EventConnect, UnitID: 1, Client: "10.0.0.1:5000"},
+			{Type: EventRegisterWrite, UnitID: 1, Address: 5, Value: 42, Client: "10.0.0.1:5000"},
+			{Type: EventCoilWrite, UnitID: 1, Address: 2, Value: 1},
+			{Type: EventCounterUpdate, UnitID: 1, Address: 10, Value: 1},
+			{Type: EventFault, UnitID: 1, Address: 90, Detail: "out_of_bounds"},
+		}
+		for name, ch := range map[string]chan Event{"first": first, "second": second} {
+			if len(ch) != len(want) {
+				t.Fatalf("Expected %d events for the %s subscriber, got %d", len(want), name, len(ch))
+			}
+			for i, w := range want {
+				e := <-ch
+				if e.Time.IsZero() {
+					t.Errorf("Event %d for the %s subscriber has no time", i, name)
+				}
+				e.Time = time.Time{}
+				if e != w {
+					t.Errorf("Event %d for the %s subscriber: expected %+v, got %+v", i, name, w, e)
+				}
+			}
+		}
+	})
+
+	t.Run("SlowSubscriberDropped", func(t *testing.T) {
+		handler := newHandler(t)
+		slow := make(chan Event, 1)
+		fast := make(chan Event, 16)
+		handler.Subscribe(slow)
+		handler.Subscribe(fast)
+
+		// Nobody reads slow, so a blocking send would hang here
+		for i := uint16(0); i < 5; i++ {
+			writeRegister(t, handler, 5, i+1)
+		}
+
+		if len(fast) != 5 {
+			t.Errorf("Expected 5 events for the fast subscriber, got %d", len(fast))
+		}
+		if e := <-slow; e.Value != 1 {
+			t.Errorf("Expected the slow subscriber to keep the first event, got value %d", e.Value)
+		}
+		if got := handler.DroppedEvents(); got != 4 {
+			t.Errorf("Expected 4 dropped events, got %d", got)
+		}
+	})
+
+	t.Run("Unsubscribe", func(t *testing.T) {
+		handler := newHandler(t)
+		ch := make(chan Event, 16)
+		handler.Subscribe(ch)
+		writeRegister(t, handler, 5, 1)
+		handler.Unsubscribe(ch)
+		writeRegister(t, handler, 5, 2)
+
+		if len(ch) != 1 {
+			t.Errorf("Expected 1 event before unsubscribing, got %d", len(ch))
+		}
+	})
+}
+
+func TestQuantityLimits(t *testing.T) {
+	type request func(h *ModbusHandler, quantity uint16) error
+	readCoils := func(h *ModbusHandler, quantity uint16) error {
+		_, err := h.HandleCoils(&modbus.CoilsRequest{UnitId: 1, Quantity: quantity})
+		return err
+	}
+	writeCoils := func(h *ModbusHandler, quantity uint16) error {
+		_, err := h.HandleCoils(&modbus.CoilsRequest{UnitId: 1, Quantity: quantity, IsWrite: true, Args: make([]bool, quantity)})
+		return err
+	}
+	readDiscrete := func(h *ModbusHandler, quantity uint16) error {
+		_, err := h.HandleDiscreteInputs(&modbus.DiscreteInputsRequest{UnitId: 1, Quantity: quantity})
+		return err
+	}
+	readHolding := func(h *ModbusHandler, quantity uint16) error {
+		_, err := h.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{UnitId: 1, Addr: 100, Quantity: quantity})
+		return err
+	}
+	writeHolding := func(h *ModbusHandler, quantity uint16) error {
+		_, err := h.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+			UnitId: 1, Addr: 100, Quantity: quantity, IsWrite: true, Args: make([]uint16, quantity),
+		})
+		return err
+	}
+	readInput := func(h *ModbusHandler, quantity uint16) error {
+		_, err := h.HandleInputRegisters(&modbus.InputRegistersRequest{UnitId: 1, Quantity: quantity})
+		return err
+	}
+
+	tests := []struct {
+		name    string
+		limits  config.QuantityLimits
+		request request
+		max     uint16
+	}{
+		{"ReadCoilsSpec", config.QuantityLimits{}, readCoils, 2000},
+		{"ReadDiscreteInputsSpec", config.QuantityLimits{}, readDiscrete, 2000},
+		{"ReadHoldingRegistersSpec", config.QuantityLimits{}, readHolding, 125},
+		{"ReadInputRegistersSpec", config.QuantityLimits{}, readInput, 125},
+		{"WriteCoilsSpec", config.QuantityLimits{}, writeCoils, 1968},
+		{"WriteRegistersSpec", config.QuantityLimits{}, writeHolding, 123},
+		{"ReadCoilsConfigured", config.QuantityLimits{ReadCoils: 64}, readCoils, 64},
+		{"ReadDiscreteInputsConfigured", config.QuantityLimits{ReadDiscreteInputs: 16}, readDiscrete, 16},
+		{"ReadHoldingRegistersConfigured", config.QuantityLimits{ReadHoldingRegisters: 32}, readHolding, 32},
+		{"ReadInputRegistersConfigured", config.QuantityLimits{ReadInputRegisters: 20}, readInput, 20},
+		{"WriteCoilsConfigured", config.QuantityLimits{WriteCoils: 8}, writeCoils, 8},
+		{"WriteRegistersConfigured", config.QuantityLimits{WriteRegisters: 10}, writeHolding, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, _ := newTestHandler(t, config.ModbusConfig{
+				UnitID:         1,
+				MaxRegisters:   4000,
+				CounterAddress: 10,
+				QuantityLimits: tt.limits,
+			})
+
+			if err := tt.request(handler, tt.max); err != nil {
+				t.Fatalf("Expected quantity %d to be accepted, got %v", tt.max, err)
+			}
+			if err := tt.request(handler, tt.max+1); err != modbus.ErrIllegalDataValue {
+				t.Fatalf("Expected ErrIllegalDataValue for quantity %d, got %v", tt.max+1, err)
+			}
+		})
+	}
+}
+
+func TestResetTrigger(t *testing.T) {
+	newHandler := func(t *testing.T, trigger config.ResetTrigger) *ModbusHandler {
+		handler, _ := newTestHandler(t, config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   100,
+			CounterAddress: 10,
+			CounterInitial: 5,
+			InitialData: []config.RegisterValue{
+				{Type: "holding", Address: 20, Value: 500},
+				{Type: "coil", Address: 4, Value: 1},
+			},
+			ResetTrigger: &trigger,
+		})
+
+		// Move everything away from its defaults
+		if _, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+			UnitId: 1, Addr: 20, Quantity: 11, IsWrite: true, Args: []uint16{7, 0, 0, 0, 0, 0, 0, 0, 0, 0, 9},
+		}); err != nil {
+			t.Fatalf("Failed to write registers: %v", err)
+		}
+		if _, err := handler.HandleCoils(&modbus.CoilsRequest{
+			UnitId: 1, Addr: 4, Quantity: 1, IsWrite: true, Args: []bool{false},
+		}); err != nil {
+			t.Fatalf("Failed to write coil: %v", err)
+		}
+		handler.UpdateCounter()
+		return handler
+	}
+
+	expectValues := func(t *testing.T, handler *ModbusHandler, holding20, holding30, counter uint16, coil4 bool) {
+		t.Helper()
+		regs, _ := handler.ReadRegisters("holding", 0, 31)
+		bits, _ := handler.ReadBits("coil", 4, 1)
+		if regs[20] != holding20 || regs[30] != holding30 || regs[10] != counter || bits[0] != coil4 {
+			t.Fatalf("Expected holding 20=%d 30=%d counter=%d coil 4=%v, got %d %d %d %v",
+				holding20, holding30, counter, coil4, regs[20], regs[30], regs[10], bits[0])
+		}
+	}
+
+	t.Run("HoldingMagicValue", func(t *testing.T) {
+		handler := newHandler(t, config.ResetTrigger{Type: "holding", Address: 99, Value: 0xa5a5})
+
+		if _, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+			UnitId: 1, Addr: 99, Quantity: 1, IsWrite: true, Args: []uint16{0x1234},
+		}); err != nil {
+			t.Fatalf("Failed to write trigger: %v", err)
+		}
+		expectValues(t, handler, 7, 9, 6, false)
+
+		if _, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+			UnitId: 1, Addr: 98, Quantity: 2, IsWrite: true, Args: []uint16{1, 0xa5a5},
+		}); err != nil {
+			t.Fatalf("Failed to write trigger: %v", err)
+		}
+		expectValues(t, handler, 500, 0, 5, true)
+
+		if regs, _ := handler.ReadRegisters("holding", 98, 2); regs[0] != 0 || regs[1] != 0 {
+			t.Fatalf("Expected the reset write itself to be cleared, got %v", regs)
+		}
+	})
+
+	t.Run("Coil", func(t *testing.T) {
+		handler := newHandler(t, config.ResetTrigger{Type: "coil", Address: 50, Value: 1})
+
+		if _, err := handler.HandleCoils(&modbus.CoilsRequest{
+			UnitId: 1, Addr: 50, Quantity: 1, IsWrite: true, Args: []bool{true},
+		}); err != nil {
+			t.Fatalf("Failed to write trigger: %v", err)
+		}
+		expectValues(t, handler, 500, 0, 5, true)
+	})
+
+	t.Run("DelayedWrite", func(t *testing.T) {
+		handler, _ := newTestHandler(t, config.ModbusConfig{
+			UnitID:           1,
+			MaxRegisters:     100,
+			CounterAddress:   10,
+			DelayedRegisters: []uint16{20},
+			WriteDelay:       config.Duration(50 * time.Millisecond),
+			InitialData:      []config.RegisterValue{{Type: "holding", Address: 20, Value: 500}},
+			ResetTrigger:     &config.ResetTrigger{Type: "holding", Address: 99, Value: 0xa5a5},
+		})
+
+		for _, req := range []*modbus.HoldingRegistersRequest{
+			{UnitId: 1, Addr: 20, Quantity: 1, IsWrite: true, Args: []uint16{7}},
+			{UnitId: 1, Addr: 99, Quantity: 1, IsWrite: true, Args: []uint16{0xa5a5}},
+		} {
+			if _, err := handler.HandleHoldingRegisters(req); err != nil {
+				t.Fatalf("Failed to write register %d: %v", req.Addr, err)
+			}
+		}
+
+		time.Sleep(150 * time.Millisecond)
+		if regs, _ := handler.ReadRegisters("holding", 20, 1); regs[0] != 500 {
+			t.Fatalf("Expected the staged write cancelled by the reset, got %d", regs[0])
+		}
+	})
+
+	t.Run("Uninitialized", func(t *testing.T) {
+		handler, _ := newTestHandler(t, config.ModbusConfig{
+			UnitID:                   1,
+			MaxRegisters:             100,
+			CounterAddress:           10,
+			RejectUninitializedReads: true,
+			ResetTrigger:             &config.ResetTrigger{Type: "coil", Address: 50, Value: 1},
+		})
+
+		for _, req := range []*modbus.CoilsRequest{
+			{UnitId: 1, Addr: 20, Quantity: 1, IsWrite: true, Args: []bool{true}},
+			{UnitId: 1, Addr: 50, Quantity: 1, IsWrite: true, Args: []bool{true}},
+		} {
+			if _, err := handler.HandleCoils(req); err != nil {
+				t.Fatalf("Failed to write coil %d: %v", req.Addr, err)
+			}
+		}
+
+		_, err := handler.HandleCoils(&modbus.CoilsRequest{UnitId: 1, Addr: 20, Quantity: 1})
+		if err != modbus.ErrIllegalDataAddress {
+			t.Fatalf("Expected coil 20 uninitialized after the reset, got %v", err)
+		}
+	})
+}
+
+// TestRejectionLogging tests that rejections in every data handler are
+// logged with the same fields
+func TestRejectionLogging(t *testing.T) {
+	newLoggedHandler := func(t *testing.T, level string) (*ModbusHandler, string) {
+		logFile := filepath.Join(t.TempDir(), "test.jsonl")
+		logger, err := mlog.NewLogger(config.LoggingConfig{
+			Level:   "DEBUG",
+			File:    logFile,
+			Console: false,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create logger: %v", err)
+		}
+		t.Cleanup(logger.Close)
+
+		return NewModbusHandler(config.ModbusConfig{
+			UnitID:            1,
+			MaxRegisters:      100,
+			CounterAddress:    10,
+			RejectionLogLevel: level,
+		}, logger), logFile
+	}
+	rejectAll := func(handler *ModbusHandler) {
+		handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{UnitId: 1, Addr: 99, Quantity: 2, IsWrite: true, Args: []uint16{1, 2}})
+		handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{UnitId: 1, Addr: 95, Quantity: 10})
+		handler.HandleInputRegisters(&modbus.InputRegistersRequest{UnitId: 2, Addr: 5, Quantity: 1})
+		handler.HandleCoils(&modbus.CoilsRequest{UnitId: 1, Addr: 0, Quantity: 0, IsWrite: true})
+		handler.HandleDiscreteInputs(&modbus.DiscreteInputsRequest{UnitId: 1, Addr: 0, Quantity: 2001})
+	}
+	rejections := func(t *testing.T, logFile string) []mlog.LogEntry {
+		logs, err := os.ReadFile(logFile)
+		if err != nil {
+			t.Fatalf("Failed to read log file: %v", err)
+		}
+		var entries []mlog.LogEntry
+		for _, line := range strings.Split(strings.TrimSpace(string(logs)), "\n") {
+			var entry mlog.LogEntry
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatalf("Invalid log line %q: %v", line, err)
+			}
+			if entry.Message == "Request rejected" {
+				entries = append(entries, entry)
+			}
+		}
+		return entries
+	}
+
+	t.Run("UniformFields", func(t *testing.T) {
+		handler, logFile := newLoggedHandler(t, "")
+		rejectAll(handler)
+
+		want := []struct {
+			function  float64
+			unitID    float64
+			start     float64
+			quantity  float64
+			write     bool
+			reason    string
+			exception float64
+		}{
+			{0x10, 1, 99, 2, true, "out_of_bounds", 0x02},
+			{0x03, 1, 95, 10, false, "out_of_bounds", 0x02},
+			{0x04, 2, 5, 1, false, "invalid_unit", 0x01},
+			{0x0f, 1, 0, 0, true, "invalid_quantity", 0x03},
+			{0x02, 1, 0, 2001, false, "invalid_quantity", 0x03},
+		}
+		entries := rejections(t, logFile)
+		if len(entries) != len(want) {
+			t.Fatalf("Expected %d rejection entries, got %d", len(want), len(entries))
+		}
+		for i, w := range want {
+			e := entries[i]
+			if e.Level != "WARN" {
+				t.Errorf("Entry %d: expected WARN, got %s", i, e.Level)
+			}
+			got := e.Data
+			if got["function"] != w.function || got["unit_id"] != w.unitID || got["start"] != w.start ||
+				got["quantity"] != w.quantity || got["write"] != w.write || got["reason"] != w.reason ||
+				got["exception"] != w.exception {
+				t.Errorf("Entry %d: expected %+v, got %v", i, w, got)
+			}
+		}
+	})
+
+	t.Run("Off", func(t *testing.T) {
+		handler, logFile := newLoggedHandler(t, "off")
+		rejectAll(handler)
+
+		if entries := rejections(t, logFile); len(entries) != 0 {
+			t.Fatalf("Expected no rejection entries, got %d", len(entries))
+		}
+		if errs := handler.GetStats().Errors; errs != 5 {
+			t.Fatalf("Expected 5 errors counted, got %d", errs)
+		}
+	})
+}
+
+func TestTransactions(t *testing.T) {
+	newHandler := func(t *testing.T, policy string) *ModbusHandler {
+		handler, _ := newTestHandler(t, config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   100,
+			CounterAddress: 10,
+			CounterInitial: 5,
+			InitialData: []config.RegisterValue{
+				{Type: "holding", Address: 20, Value: 500},
+				{Type: "coil", Address: 4, Value: 1},
+			},
+			TxClientWrites: policy,
+		})
+		return handler
+	}
+
+	// change writes a holding register and a coil as a client and an input
+	// register and advances the counter as the simulator would
+	change := func(t *testing.T, handler *ModbusHandler) {
+		t.Helper()
+		if _, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+			UnitId: 1, Addr: 20, Quantity: 2, IsWrite: true, Args: []uint16{7, 8},
+		}); err != nil {
+			t.Fatalf("Failed to write registers: %v", err)
+		}
+		if _, err := handler.HandleCoils(&modbus.CoilsRequest{
+			UnitId: 1, Addr: 4, Quantity: 1, IsWrite: true, Args: []bool{false},
+		}); err != nil {
+			t.Fatalf("Failed to write coil: %v", err)
+		}
+		if err := handler.SetRegister("input", 3, 33); err != nil {
+			t.Fatalf("Failed to set input register: %v", err)
+		}
+		handler.UpdateCounter()
+	}
+
+	expectValues := func(t *testing.T, handler *ModbusHandler, h20, h21, counter, input3 uint16, coil4 bool) {
+		t.Helper()
+		regs, _ := handler.ReadRegisters("holding", 0, 22)
+		input, _ := handler.ReadRegisters("input", 3, 1)
+		bits, _ := handler.ReadBits("coil", 4, 1)
+		if regs[20] != h20 || regs[21] != h21 || regs[10] != counter || input[0] != input3 || bits[0] != coil4 {
+			t.Fatalf("Expected holding 20=%d 21=%d counter=%d input 3=%d coil 4=%v, got %d %d %d %d %v",
+				h20, h21, counter, input3, coil4, regs[20], regs[21], regs[10], input[0], bits[0])
+		}
+	}
+
+	t.Run("Rollback", func(t *testing.T) {
+		handler := newHandler(t, "")
+
+		if err := handler.BeginTx(); err != nil {
+			t.Fatalf("Failed to begin transaction: %v", err)
+		}
+		change(t, handler)
+		expectValues(t, handler, 7, 8, 6, 33, false)
+
+		n, err := handler.Rollback()
+		if err != nil {
+			t.Fatalf("Failed to roll back: %v", err)
+		}
+		if n != 5 {
+			t.Errorf("Expected 5 changed addresses, got %d", n)
+		}
+		expectValues(t, handler, 500, 0, 5, 0, true)
+
+		// The counter continues from its restored value
+		handler.UpdateCounter()
+		expectValues(t, handler, 500, 0, 6, 0, true)
+	})
+
+	t.Run("Commit", func(t *testing.T) {
+		handler := newHandler(t, "")
+
+		if err := handler.BeginTx(); err != nil {
+			t.Fatalf("Failed to begin transaction: %v", err)
+		}
+		change(t, handler)
+		if _, err := handler.Commit(); err != nil {
+			t.Fatalf("Failed to commit: %v", err)
+		}
+		expectValues(t, handler, 7, 8, 6, 33, false)
+
+		// Writes after the commit are no longer journaled
+		if _, err := handler.Rollback(); err != ErrNoTx {
+			t.Fatalf("Expected ErrNoTx, got %v", err)
+		}
+		expectValues(t, handler, 7, 8, 6, 33, false)
+	})
+
+	t.Run("OneAtATime", func(t *testing.T) {
+		handler := newHandler(t, "")
+
+		if _, err := handler.Commit(); err != ErrNoTx {
+			t.Fatalf("Expected ErrNoTx, got %v", err)
+		}
+		if err := handler.BeginTx(); err != nil {
+			t.Fatalf("Failed to begin transaction: %v", err)
+		}
+		if err := handler.BeginTx(); err != ErrTxOpen {
+			t.Fatalf("Expected ErrTxOpen, got %v", err)
+		}
+		if !handler.InTx() {
+			t.Fatal("Expected a transaction to be open")
+		}
+	})
+
+	t.Run("RejectClientWrites", func(t *testing.T) {
+		handler := newHandler(t, "reject")
+
+		if err := handler.BeginTx(); err != nil {
+			t.Fatalf("Failed to begin transaction: %v", err)
+		}
+		if _, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+			UnitId: 1, Addr: 20, Quantity: 1, IsWrite: true, Args: []uint16{7},
+		}); err != modbus.ErrServerDeviceBusy {
+			t.Fatalf("Expected device busy for a register write, got %v", err)
+		}
+		if _, err := handler.HandleCoils(&modbus.CoilsRequest{
+			UnitId: 1, Addr: 4, Quantity: 1, IsWrite: true, Args: []bool{false},
+		}); err != modbus.ErrServerDeviceBusy {
+			t.Fatalf("Expected device busy for a coil write, got %v", err)
+		}
+		if _, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+			UnitId: 1, Addr: 20, Quantity: 1,
+		}); err != nil {
+			t.Fatalf("Expected reads to be served, got %v", err)
+		}
+
+		// Admin writes are still accepted
+		if err := handler.SetRegister("holding", 20, 9); err != nil {
+			t.Fatalf("Failed to set register: %v", err)
+		}
+		if _, err := handler.Rollback(); err != nil {
+			t.Fatalf("Failed to roll back: %v", err)
+		}
+		expectValues(t, handler, 500, 0, 5, 0, true)
+
+		if _, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+			UnitId: 1, Addr: 20, Quantity: 1, IsWrite: true, Args: []uint16{7},
+		}); err != nil {
+			t.Fatalf("Expected client writes after the transaction, got %v", err)
+		}
+	})
+}
+
+// TestLockWaitThreshold tests that a request held up by the register lock
+// for longer than the threshold is logged, and an uncontended one is not
+func TestLockWaitThreshold(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "test.jsonl")
+	logger, err := mlog.NewLogger(config.LoggingConfig{
+		Level:   "WARN",
+		File:    logFile,
+		Console: false,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	t.Cleanup(logger.Close)
+
+	handler := NewModbusHandler(config.ModbusConfig{
+		UnitID:            1,
+		MaxRegisters:      100,
+		CounterAddress:    10,
+		LockWaitThreshold: config.Duration(5 * time.Millisecond),
+	}, logger)
+
+	slowLocks := func(t *testing.T) []mlog.LogEntry {
+		logs, err := os.ReadFile(logFile)
+		if err != nil {
+			t.Fatalf("Failed to read log file: %v", err)
+		}
+		var entries []mlog.LogEntry
+		for _, line := range strings.Split(strings.TrimSpace(string(logs)), "\n") {
+			var entry mlog.LogEntry
+			if err := json.Unmarshal([]byte(line), &entry); err == nil && entry.Message == "Slow lock acquisition" {
+				entries = append(entries, entry)
+			}
+		}
+		return entries
+	}
+
+	if _, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{UnitId: 1, Addr: 20, Quantity: 2}); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if entries := slowLocks(t); len(entries) != 0 {
+		t.Fatalf("Expected no warning without contention, got %v", entries)
+	}
+
+	// Hold the write lock while a read waits for it
+	handler.mu.Lock()
+	done := make(chan error)
+	go func() {
+		_, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{UnitId: 1, Addr: 20, Quantity: 2})
+		done <- err
+	}()
+	time.Sleep(30 * time.Millisecond)
+	handler.mu.Unlock()
+	if err := <-done; err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+
+	entries := slowLocks(t)
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 slow lock warning, got %d", len(entries))
+	}
+	if entries[0].Level != "WARN" || entries[0].Data["lock"] != "read" || entries[0].Data["threshold"] != "5ms" {
+		t.Errorf("Expected a WARN for the read lock with threshold 5ms, got %+v", entries[0])
+	}
+	wait, err := time.ParseDuration(entries[0].Data["wait"].(string))
+	if err != nil || wait < 5*time.Millisecond {
+		t.Errorf("Expected a wait above the threshold, got %v (%v)", entries[0].Data["wait"], err)
+	}
+}
+
+// TestGlobalRateLimit tests that requests above the rate limit get the busy
+// exception, and that units sharing the limit draw from the same budget
+func TestGlobalRateLimit(t *testing.T) {
+	cfg := config.ModbusConfig{
+		UnitID:          1,
+		MaxRegisters:    100,
+		CounterAddress:  10,
+		GlobalRateLimit: 10,
+	}
+	now := time.Now().Add(time.Hour)
+	clock := func() time.Time { return now }
+
+	read := func(handler *ModbusHandler) error {
+		_, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{UnitId: handler.config.UnitID, Addr: 20, Quantity: 1})
+		return err
+	}
+	drive := func(t *testing.T, handler *ModbusHandler, n int) (ok, busy int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			switch err := read(handler); err {
+			case nil:
+				ok++
+			case modbus.ErrServerDeviceBusy:
+				busy++
+			default:
+				t.Fatalf("Expected success or device busy, got %v", err)
+			}
+		}
+		return ok, busy
+	}
+
+	t.Run("AboveCap", func(t *testing.T) {
+		handler, _ := newTestHandler(t, cfg)
+		handler.now = clock
+
+		if ok, busy := drive(t, handler, 25); ok != 10 || busy != 15 {
+			t.Fatalf("Expected 10 served and 15 busy, got %d and %d", ok, busy)
+		}
+		if stats := handler.GetStats(); stats.Errors != 15 {
+			t.Fatalf("Expected 15 errors, got %d", stats.Errors)
+		}
+
+		now = now.Add(500 * time.Millisecond)
+		if ok, busy := drive(t, handler, 10); ok != 5 || busy != 5 {
+			t.Fatalf("Expected 5 served and 5 busy after half a second, got %d and %d", ok, busy)
+		}
+	})
+
+	t.Run("SharedAcrossUnits", func(t *testing.T) {
+		unit1, _ := newTestHandler(t, cfg)
+		unit1.now = clock
+		unitCfg := cfg
+		unitCfg.UnitID = 2
+		unit2, _ := newTestHandler(t, unitCfg)
+		unit2.now = clock
+		unit2.ShareRateLimit(unit1)
+
+		ok1, _ := drive(t, unit1, 6)
+		ok2, busy2 := drive(t, unit2, 6)
+		if ok1 != 6 || ok2 != 4 || busy2 != 2 {
+			t.Fatalf("Expected 6 and 4 served out of a shared 10, got %d and %d", ok1, ok2)
+		}
+	})
+
+	t.Run("CheckedBeforeMaintenance", func(t *testing.T) {
+		handler, _ := newTestHandler(t, cfg)
+		handler.now = clock
+		handler.SetMaintenance(true)
+
+		// Every function code draws from the budget before maintenance refuses it
+		read(handler)
+		for i := 0; i < 4; i++ {
+			handler.HandleDiagnostics(&DiagnosticsRequest{UnitId: 1, SubFunction: diagReturnQueryData})
+		}
+		for i := 0; i < 5; i++ {
+			handler.HandleDeviceIdentification(&DeviceIdentificationRequest{UnitId: 1, ReadDeviceID: ReadDeviceIDBasic})
+		}
+		handler.SetMaintenance(false)
+
+		if err := read(handler); err != modbus.ErrServerDeviceBusy {
+			t.Fatalf("Expected the budget spent during maintenance, got %v", err)
+		}
+	})
+
+	t.Run("Unlimited", func(t *testing.T) {
+		unlimited := cfg
+		unlimited.GlobalRateLimit = 0
+		handler, _ := newTestHandler(t, unlimited)
+		handler.now = clock
+
+		if ok, _ := drive(t, handler, 100); ok != 100 {
+			t.Fatalf("Expected every request served, got %d", ok)
+		}
+	})
+}
+
+// TestAddressAliases tests that an alias address and its target share one
+// stored value in every table
+func TestAddressAliases(t *testing.T) {
+	maxValue := uint16(5)
+	handler, _ := newTestHandler(t, config.ModbusConfig{
+		UnitID:         1,
+		MaxRegisters:   100,
+		CounterAddress: 10,
+		InitialData:    []config.RegisterValue{{Type: "input", Address: 30, Value: 215}},
+		AddressAliases: []config.AddressAlias{
+			{Type: "holding", Address: 80, Target: 20},
+			{Type: "input", Address: 81, Target: 30},
+			{Type: "coil", Address: 82, Target: 2},
+			{Type: "discrete", Address: 83, Target: 3},
+			{Type: "holding", Address: 60, Target: 40},
+			{Type: "holding", Address: 61, Target: 10},
+		},
+		Constraints: []config.RegisterConstraint{{Address: 40, Max: &maxValue}},
+	})
+
+	readHolding := func(t *testing.T, addr uint16) uint16 {
+		t.Helper()
+		res, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{UnitId: 1, Addr: addr, Quantity: 1})
+		if err != nil {
+			t.Fatalf("Failed to read holding register %d: %v", addr, err)
+		}
+		return res[0]
+	}
+	writeHolding := func(t *testing.T, addr, value uint16) {
+		t.Helper()
+		if _, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+			UnitId: 1, Addr: addr, Quantity: 1, IsWrite: true, Args: []uint16{value},
+		}); err != nil {
+			t.Fatalf("Failed to write holding register %d: %v", addr, err)
+		}
+	}
+	readCoil := func(t *testing.T, addr uint16) bool {
+		t.Helper()
+		res, err := handler.HandleCoils(&modbus.CoilsRequest{UnitId: 1, Addr: addr, Quantity: 1})
+		if err != nil {
+			t.Fatalf("Failed to read coil %d: %v", addr, err)
+		}
+		return res[0]
+	}
+	writeCoil := func(t *testing.T, addr uint16, value bool) {
+		t.Helper()
+		if _, err := handler.HandleCoils(&modbus.CoilsRequest{
+			UnitId: 1, Addr: addr, Quantity: 1, IsWrite: true, Args: []bool{value},
+		}); err != nil {
+			t.Fatalf("Failed to write coil %d: %v", addr, err)
+		}
+	}
+
+	t.Run("Holding", func(t *testing.T) {
+		writeHolding(t, 80, 1234)
+		if v := readHolding(t, 20); v != 1234 {
+			t.Fatalf("Expected 1234 at the target after writing the alias, got %d", v)
+		}
+		writeHolding(t, 20, 4321)
+		if v := readHolding(t, 80); v != 4321 {
+			t.Fatalf("Expected 4321 at the alias after writing the target, got %d", v)
+		}
+
+		// A range covering both sees the same value twice
+		res, _ := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{UnitId: 1, Addr: 79, Quantity: 2})
+		if res[1] != 4321 {
+			t.Fatalf("Expected 4321 at 80 in a range read, got %v", res)
+		}
+	})
+
+	t.Run("Input", func(t *testing.T) {
+		res, err := handler.HandleInputRegisters(&modbus.InputRegistersRequest{UnitId: 1, Addr: 81, Quantity: 1})
+		if err != nil || res[0] != 215 {
+			t.Fatalf("Expected the target's initial 215 at the alias, got %v (%v)", res, err)
+		}
+		if err := handler.SetRegister("input", 81, 216); err != nil {
+			t.Fatalf("Failed to set input register: %v", err)
+		}
+		if regs, _ := handler.ReadRegisters("input", 30, 1); regs[0] != 216 {
+			t.Fatalf("Expected 216 at the target, got %d", regs[0])
+		}
+	})
+
+	t.Run("Coil", func(t *testing.T) {
+		writeCoil(t, 82, true)
+		if !readCoil(t, 2) {
+			t.Fatal("Expected the target coil on after writing the alias")
+		}
+		writeCoil(t, 2, false)
+		if readCoil(t, 82) {
+			t.Fatal("Expected the alias coil off after writing the target")
+		}
+	})
+
+	t.Run("Discrete", func(t *testing.T) {
+		if err := handler.SetBit("discrete", 3, true); err != nil {
+			t.Fatalf("Failed to set discrete input: %v", err)
+		}
+		res, err := handler.HandleDiscreteInputs(&modbus.DiscreteInputsRequest{UnitId: 1, Addr: 83, Quantity: 1})
+		if err != nil || !res[0] {
+			t.Fatalf("Expected the alias discrete input on, got %v (%v)", res, err)
+		}
+	})
+
+	// Per-address features apply to the target, whichever address is written
+	t.Run("TargetConstraint", func(t *testing.T) {
+		_, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+			UnitId: 1, Addr: 60, Quantity: 1, IsWrite: true, Args: []uint16{999},
+		})
+		if err != modbus.ErrIllegalDataValue {
+			t.Fatalf("Expected ErrIllegalDataValue writing 999 to the alias, got %v", err)
+		}
+		if v := readHolding(t, 40); v != 0 {
+			t.Fatalf("Expected the constrained target unchanged, got %d", v)
+		}
+	})
+
+	t.Run("TargetCounter", func(t *testing.T) {
+		before := readHolding(t, 10)
+		writeHolding(t, 61, 4242)
+		if v := readHolding(t, 10); v != before {
+			t.Fatalf("Expected the counter to ignore a write to its alias, got %d", v)
+		}
+	})
+
+	t.Run("TargetProtected", func(t *testing.T) {
+		writeHolding(t, 20, 7)
+		if err := handler.SetProtected(20, true); err != nil {
+			t.Fatalf("Failed to protect register: %v", err)
+		}
+		writeHolding(t, 80, 8)
+		if v := readHolding(t, 20); v != 7 {
+			t.Fatalf("Expected the protected target to keep 7, got %d", v)
+		}
+		if !handler.Protected(80) {
+			t.Fatal("Expected the alias to report its target's protection")
+		}
+	})
+
+	t.Run("Initialized", func(t *testing.T) {
+		strict, _ := newTestHandler(t, config.ModbusConfig{
+			UnitID:                   1,
+			MaxRegisters:             100,
+			CounterAddress:           10,
+			RejectUninitializedReads: true,
+			InitialData:              []config.RegisterValue{{Type: "holding", Address: 20, Value: 1}, {Type: "holding", Address: 81, Value: 2}},
+			AddressAliases: []config.AddressAlias{
+				{Type: "holding", Address: 80, Target: 20},
+				{Type: "holding", Address: 81, Target: 21},
+			},
+		})
+		for _, addr := range []uint16{20, 80, 21, 81} {
+			if _, err := strict.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{UnitId: 1, Addr: addr, Quantity: 1}); err != nil {
+				t.Fatalf("Expected holding %d initialized through its alias or target, got %v", addr, err)
+			}
+		}
+	})
+}
+
+// TestRequestLogLimit tests that request entries are throttled per client
+// connection and the suppressed count is reported when it disconnects
+func TestRequestLogLimit(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "test.jsonl")
+	logger, err := mlog.NewLogger(config.LoggingConfig{
+		Level:   "DEBUG",
+		File:    logFile,
+		Console: false,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	t.Cleanup(logger.Close)
+
+	handler := NewModbusHandler(config.ModbusConfig{
+		UnitID:          1,
+		MaxRegisters:    100,
+		CounterAddress:  10,
+		RequestLogLimit: 5,
+	}, logger)
+
+	const chatty, quiet = "192.0.2.1:5020", "192.0.2.2:5020"
+	for i := 0; i < 50; i++ {
+		handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{ClientAddr: chatty, UnitId: 1, Addr: 20, Quantity: 1})
+	}
+	handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{ClientAddr: quiet, UnitId: 1, Addr: 30, Quantity: 1})
+	handler.OnDisconnect(chatty)
+
+	logs, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	perStart := make(map[float64]int)
+	var report *mlog.LogEntry
+	for _, line := range strings.Split(strings.TrimSpace(string(logs)), "\n") {
+		var entry mlog.LogEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Invalid log line %q: %v", line, err)
+		}
+		if start, ok := entry.Data["start"].(float64); ok {
+			perStart[start]++
+		}
+		if entry.Message == "Log entries suppressed by throttle" {
+			report = &entry
+		}
+	}
+
+	if perStart[20] != 5 {
+		t.Fatalf("Expected 5 request entries of the chatty client, got %d", perStart[20])
+	}
+	if perStart[30] == 0 {
+		t.Fatal("Expected the other client's requests to be logged")
+	}
+	if report == nil || report.Data["client"] != chatty || report.Data["suppressed"].(float64) < 45 {
+		t.Fatalf("Expected the chatty client's suppressed entries reported, got %+v", report)
+	}
+}
+
+// TestRequestLogThrottleExpiry tests that the throttles of clients that never
+// disconnect, like UDP clients, are dropped once idle
+func TestRequestLogThrottleExpiry(t *testing.T) {
+	handler, _ := newTestHandler(t, config.ModbusConfig{
+		UnitID:          1,
+		MaxRegisters:    100,
+		CounterAddress:  10,
+		RequestLogLimit: 5,
+	})
+
+	for i := 0; i < 100; i++ {
+		clientAddr := fmt.Sprintf("192.0.2.%d:5020", i)
+		handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{ClientAddr: clientAddr, UnitId: 1, Addr: 20, Quantity: 1})
+	}
+	if n := len(handler.throttles.conns); n != 100 {
+		t.Fatalf("Expected 100 throttles, got %d", n)
+	}
+
+	// Sweeps are spaced by throttleIdle, so clear the last one before each
+	handler.throttles.mu.Lock()
+	handler.throttles.swept = time.Time{}
+	idle := handler.throttles.expire(time.Now().Add(throttleIdle / 2))
+	handler.throttles.mu.Unlock()
+	if len(idle) != 0 {
+		t.Fatalf("Expected no throttles expired before %v, got %d", throttleIdle, len(idle))
+	}
+
+	handler.throttles.mu.Lock()
+	handler.throttles.swept = time.Time{}
+	idle = handler.throttles.expire(time.Now().Add(throttleIdle))
+	remaining := len(handler.throttles.conns)
+	handler.throttles.mu.Unlock()
+	if len(idle) != 100 || remaining != 0 {
+		t.Fatalf("Expected all 100 idle throttles expired, got %d with %d remaining", len(idle), remaining)
+	}
+}
@@ -0,0 +1,65 @@
+// history.go - Write history for watched holding registers
+package handler
+
+import "time"
+
+const defaultHistoryDepth = 16
+
+type HistoryEntry struct {
+	Time   time.Time `json:"time"`
+	Old    uint16    `json:"old"`
+	Value  uint16    `json:"value"`
+	Client string    `json:"client,omitempty"`
+}
+
+// historyRing keeps the most recent writes to one register.
+type historyRing struct {
+	entries []HistoryEntry
+	next    int
+	full    bool
+}
+
+func newHistoryRing(depth int) *historyRing {
+	return &historyRing{entries: make([]HistoryEntry, depth)}
+}
+
+func (r *historyRing) add(e HistoryEntry) {
+	r.entries[r.next] = e
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// list returns the entries oldest first.
+func (r *historyRing) list() []HistoryEntry {
+	if !r.full {
+		return append([]HistoryEntry(nil), r.entries[:r.next]...)
+	}
+	return append(append([]HistoryEntry(nil), r.entries[r.next:]...), r.entries[:r.next]...)
+}
+
+func newHistory(watched []uint16, depth int) map[uint16]*historyRing {
+	if depth <= 0 {
+		depth = defaultHistoryDepth
+	}
+
+	history := make(map[uint16]*historyRing, len(watched))
+	for _, addr := range watched {
+		history[addr] = newHistoryRing(depth)
+	}
+	return history
+}
+
+// History returns the recorded writes to a watched holding register, oldest
+// first. ok is false if the register is not watched.
+func (h *ModbusHandler) History(addr uint16) (entries []HistoryEntry, ok bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	ring, ok := h.history[addr]
+	if !ok {
+		return nil, false
+	}
+	return ring.list(), true
+}
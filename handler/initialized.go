@@ -0,0 +1,107 @@
+// initialized.go - Tracking of initialized addresses for uninitialized-read rejection
+package handler
+
+import (
+	"SPModbus/mlog"
+
+	"github.com/simonvetter/modbus"
+)
+
+// bitmap is a fixed-size set of addresses.
+type bitmap []uint64
+
+func newBitmap(size int) bitmap {
+	return make(bitmap, (size+63)/64)
+}
+
+func (b bitmap) set(i int) {
+	b[i/64] |= 1 << (uint(i) % 64)
+}
+
+func (b bitmap) clear(i int) {
+	b[i/64] &^= 1 << (uint(i) % 64)
+}
+
+func (b bitmap) has(i int) bool {
+	return b[i/64]&(1<<(uint(i)%64)) != 0
+}
+
+// initTracker records which addresses have been initialized or written. A nil
+// tracker, used when RejectUninitializedReads is off, reports every address as
+// initialized. It is guarded by the handler lock.
+type initTracker struct {
+	banks   map[string]bitmap
+	targets map[string]map[int]int   // alias address to its target
+	aliases map[string]map[int][]int // target address to the aliases sharing it
+}
+
+// newInitTracker creates a tracker with a bank per data type, sized in
+// addresses. An alias and its target share a value, so marking either one
+// marks both.
+func newInitTracker(sizes map[string]int, aliases map[string]map[int]int) *initTracker {
+	t := &initTracker{banks: make(map[string]bitmap, len(sizes)), targets: aliases, aliases: map[string]map[int][]int{}}
+	for regType, size := range sizes {
+		t.banks[regType] = newBitmap(size)
+	}
+	for regType, targets := range aliases {
+		t.aliases[regType] = map[int][]int{}
+		for alias, target := range targets {
+			t.aliases[regType][target] = append(t.aliases[regType][target], alias)
+		}
+	}
+	return t
+}
+
+func (t *initTracker) mark(regType string, addr, count int) {
+	if t == nil {
+		return
+	}
+	bank, ok := t.banks[regType]
+	if !ok {
+		return
+	}
+	for i := addr; i < addr+count; i++ {
+		target, ok := t.targets[regType][i]
+		if !ok {
+			target = i
+		}
+		bank.set(target)
+		for _, alias := range t.aliases[regType][target] {
+			bank.set(alias)
+		}
+	}
+}
+
+// reset forgets every initialized address.
+func (t *initTracker) reset() {
+	if t == nil {
+		return
+	}
+	for _, bank := range t.banks {
+		clear(bank)
+	}
+}
+
+func (t *initTracker) covers(regType string, addr, count int) bool {
+	if t == nil {
+		return true
+	}
+	bank := t.banks[regType]
+	for i := addr; i < addr+count; i++ {
+		if !bank.has(i) {
+			return false
+		}
+	}
+	return true
+}
+
+// rejectUninitialized rejects a read that touches a never-initialized
+// address with Illegal Data Address. It must be called with the lock held.
+func (h *ModbusHandler) rejectUninitialized(regType string, addr, quantity uint16, log *mlog.Logger) error {
+	if h.initialized.covers(regType, int(addr), int(quantity)) {
+		return nil
+	}
+	return h.rejectWith(log, false, addr, "uninitialized", modbus.ErrIllegalDataAddress, map[string]interface{}{
+		"type": regType,
+	})
+}
@@ -0,0 +1,39 @@
+// inversion.go - Inverted logic for selected coils and registers
+package handler
+
+import "SPModbus/config"
+
+func indexInverted(list []config.RegisterRef) map[config.RegisterRef]bool {
+	inverted := make(map[config.RegisterRef]bool, len(list))
+	for _, ref := range list {
+		inverted[ref] = true
+	}
+	return inverted
+}
+
+// invertWords bit-inverts the inverted registers in values, a copy of the
+// registers starting at addr. An alias is inverted like its target. Storage
+// is left untouched.
+func (h *ModbusHandler) invertWords(regType string, addr uint16, values []uint16) {
+	if len(h.inverted) == 0 {
+		return
+	}
+	for i := range values {
+		if h.inverted[config.RegisterRef{Type: regType, Address: uint16(h.target(regType, int(addr)+i))}] {
+			values[i] = ^values[i]
+		}
+	}
+}
+
+// invertBits complements the inverted coils or discrete inputs in values, a
+// copy of the bits starting at addr. Storage is left untouched.
+func (h *ModbusHandler) invertBits(regType string, addr uint16, values []bool) {
+	if len(h.inverted) == 0 {
+		return
+	}
+	for i := range values {
+		if h.inverted[config.RegisterRef{Type: regType, Address: uint16(h.target(regType, int(addr)+i))}] {
+			values[i] = !values[i]
+		}
+	}
+}
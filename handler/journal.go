@@ -0,0 +1,164 @@
+// journal.go - Write journal for transactions that can be rolled back
+package handler
+
+import (
+	"SPModbus/mlog"
+	"errors"
+)
+
+var (
+	// ErrTxOpen is returned by BeginTx while a transaction is already open.
+	ErrTxOpen = errors.New("a transaction is already open")
+	// ErrNoTx is returned by Commit and Rollback without an open transaction.
+	ErrNoTx = errors.New("no transaction is open")
+)
+
+// store is what registerStore and bitStore have in common.
+type store[T comparable] interface {
+	Len() int
+	Get(addr int) T
+	Set(addr int, value T)
+}
+
+// journaled wraps a store and, while a transaction is open, remembers the
+// value each address had before it was first written.
+type journaled[T comparable] struct {
+	store[T]
+	saved map[int]T // nil outside a transaction
+}
+
+func (j *journaled[T]) Set(addr int, value T) {
+	if j.saved != nil {
+		if _, ok := j.saved[addr]; !ok {
+			j.saved[addr] = j.store.Get(addr)
+		}
+	}
+	j.store.Set(addr, value)
+}
+
+func (j *journaled[T]) begin() {
+	j.saved = make(map[int]T)
+}
+
+// end closes the journal, restoring the saved values if rollback is set, and
+// returns how many addresses were written during the transaction.
+func (j *journaled[T]) end(rollback bool) int {
+	n := len(j.saved)
+	if rollback {
+		for addr, value := range j.saved {
+			j.store.Set(addr, value)
+		}
+	}
+	j.saved = nil
+	return n
+}
+
+// txJournal holds the journaled tables and the counter state at BeginTx.
+type txJournal struct {
+	open      bool
+	holding   *journaled[uint16]
+	input     *journaled[uint16]
+	coils     *journaled[bool]
+	discrete  *journaled[bool]
+	counter   uint16
+	saturated bool
+}
+
+// journalStores puts every table behind the write journal. It is called once
+// by the constructor, before anything is written.
+func (h *ModbusHandler) journalStores() {
+	h.journal.holding = &journaled[uint16]{store: h.holdingRegs}
+	h.journal.input = &journaled[uint16]{store: h.inputRegs}
+	h.journal.coils = &journaled[bool]{store: h.coils}
+	h.journal.discrete = &journaled[bool]{store: h.discreteInputs}
+	h.holdingRegs = h.journal.holding
+	h.inputRegs = h.journal.input
+	h.coils = h.journal.coils
+	h.discreteInputs = h.journal.discrete
+}
+
+// BeginTx opens a transaction: from now on every change to any table, by
+// clients, the simulator or admin tools, is journaled until Commit keeps it
+// or Rollback undoes it. Only one transaction can be open at a time. Whether
+// client writes are accepted meanwhile is set by TxClientWrites.
+func (h *ModbusHandler) BeginTx() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.journal.open {
+		return ErrTxOpen
+	}
+	h.journal.open = true
+	h.journal.counter = h.counter
+	h.journal.saturated = h.counterSaturated
+	h.journal.holding.begin()
+	h.journal.input.begin()
+	h.journal.coils.begin()
+	h.journal.discrete.begin()
+
+	h.logger.Info("Transaction started", nil)
+	return nil
+}
+
+// Commit closes the open transaction, keeping its changes, and returns the
+// number of addresses it changed.
+func (h *ModbusHandler) Commit() (int, error) {
+	h.mu.Lock()
+	defer h.unlock()
+
+	return h.endTx(false)
+}
+
+// Rollback closes the open transaction and restores every address it changed,
+// and the counter, to the value it had at BeginTx. It returns the number of
+// addresses restored. Writes still pending under WriteDelay are not
+// cancelled and may land after the rollback.
+func (h *ModbusHandler) Rollback() (int, error) {
+	h.mu.Lock()
+	defer h.unlock()
+
+	return h.endTx(true)
+}
+
+// InTx reports whether a transaction is open.
+func (h *ModbusHandler) InTx() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.journal.open
+}
+
+// endTx closes the journal. It must be called with the write lock held.
+func (h *ModbusHandler) endTx(rollback bool) (int, error) {
+	if !h.journal.open {
+		return 0, ErrNoTx
+	}
+	h.journal.open = false
+	n := h.journal.holding.end(rollback) +
+		h.journal.input.end(rollback) +
+		h.journal.coils.end(rollback) +
+		h.journal.discrete.end(rollback)
+
+	msg := "Transaction committed"
+	if rollback {
+		h.counter = h.journal.counter
+		h.counterSaturated = h.journal.saturated
+		msg = "Transaction rolled back"
+	}
+	h.logger.Info(msg, map[string]interface{}{
+		"changed": n,
+	})
+	return n, nil
+}
+
+// rejectTxWrite refuses a client write while a transaction is open, if
+// TxClientWrites is "reject". It must be called with the lock held.
+func (h *ModbusHandler) rejectTxWrite(addr uint16, clientAddr string, log *mlog.Logger) error {
+	if !h.journal.open || h.config.TxClientWrites != "reject" {
+		return nil
+	}
+	return h.reject(log, true, addr, "device_busy", map[string]interface{}{
+		"client":      clientAddr,
+		"transaction": true,
+	})
+}
@@ -0,0 +1,60 @@
+// lockwait.go - Timing how long requests wait for the register lock
+package handler
+
+import (
+	"SPModbus/mlog"
+	"context"
+	"time"
+
+	"github.com/simonvetter/modbus"
+)
+
+// lock takes the write lock for a client request. With LockWaitThreshold
+// set, a wait longer than the threshold is logged, so contention can be told
+// apart from slow handling.
+func (h *ModbusHandler) lock(log *mlog.Logger) {
+	if h.config.LockWaitThreshold <= 0 {
+		h.mu.Lock()
+		return
+	}
+	start := time.Now()
+	h.mu.Lock()
+	h.lockWaited(log, "write", time.Since(start))
+}
+
+// rlock is lock for the read lock.
+func (h *ModbusHandler) rlock(log *mlog.Logger) {
+	if h.config.LockWaitThreshold <= 0 {
+		h.mu.RLock()
+		return
+	}
+	start := time.Now()
+	h.mu.RLock()
+	h.lockWaited(log, "read", time.Since(start))
+}
+
+func (h *ModbusHandler) lockWaited(log *mlog.Logger, mode string, wait time.Duration) {
+	threshold := time.Duration(h.config.LockWaitThreshold)
+	if wait <= threshold {
+		return
+	}
+	log.Warn("Slow lock acquisition", map[string]interface{}{
+		"lock":      mode,
+		"wait":      wait.String(),
+		"threshold": threshold.String(),
+	})
+}
+
+// expired refuses a write whose request timed out while it waited for the
+// write lock. The server has already answered it with server device busy, so
+// applying it would change registers behind the client's back. A write that
+// got the lock before the deadline is applied in full.
+func (h *ModbusHandler) expired(ctx context.Context, addr uint16, log *mlog.Logger) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+	log.Debug("Timed out write dropped", map[string]interface{}{
+		"address": addr,
+	})
+	return modbus.ErrServerDeviceBusy
+}
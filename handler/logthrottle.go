@@ -0,0 +1,81 @@
+// logthrottle.go - Per-connection throttling of request log entries
+package handler
+
+import (
+	"SPModbus/mlog"
+	"sync"
+	"time"
+)
+
+// throttleIdle is how long a client's throttle is kept without requests. UDP
+// clients never disconnect, so their throttles are only dropped once idle.
+const throttleIdle = time.Minute
+
+// logThrottles holds the request log throttle of each client connection.
+type logThrottles struct {
+	mu    sync.Mutex
+	conns map[string]*mlog.Throttle
+	swept time.Time
+}
+
+// logThrottle returns the throttle for a client's request entries, creating
+// it on the client's first request, or nil without RequestLogLimit.
+func (h *ModbusHandler) logThrottle(clientAddr string) *mlog.Throttle {
+	if h.config.RequestLogLimit <= 0 {
+		return nil
+	}
+
+	h.throttles.mu.Lock()
+	t, ok := h.throttles.conns[clientAddr]
+	var idle []*mlog.Throttle
+	if !ok {
+		if h.throttles.conns == nil {
+			h.throttles.conns = make(map[string]*mlog.Throttle)
+		}
+		idle = h.throttles.expire(time.Now())
+		t = mlog.NewThrottle(h.config.RequestLogLimit, map[string]interface{}{
+			"client":  clientAddr,
+			"unit_id": h.config.UnitID,
+		})
+		h.throttles.conns[clientAddr] = t
+	}
+	h.throttles.mu.Unlock()
+
+	for _, t := range idle {
+		h.logger.EndThrottle(t)
+	}
+	return t
+}
+
+// expire removes and returns the throttles without requests for throttleIdle.
+// The map is swept at most once per throttleIdle, when a client is added.
+// The caller must hold mu.
+func (l *logThrottles) expire(now time.Time) []*mlog.Throttle {
+	if now.Sub(l.swept) < throttleIdle {
+		return nil
+	}
+	l.swept = now
+
+	var idle []*mlog.Throttle
+	for clientAddr, t := range l.conns {
+		if now.Sub(t.Last()) >= throttleIdle {
+			idle = append(idle, t)
+			delete(l.conns, clientAddr)
+		}
+	}
+	return idle
+}
+
+// OnDisconnect is called by the server when a client connection closes. It
+// reports the client's request entries still held back by the throttle and
+// forgets it.
+func (h *ModbusHandler) OnDisconnect(clientAddr string) {
+	h.throttles.mu.Lock()
+	t, ok := h.throttles.conns[clientAddr]
+	delete(h.throttles.conns, clientAddr)
+	h.throttles.mu.Unlock()
+
+	if ok {
+		h.logger.EndThrottle(t)
+	}
+}
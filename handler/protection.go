@@ -0,0 +1,45 @@
+// protection.go - Runtime write protection of individual holding registers
+package handler
+
+import "github.com/simonvetter/modbus"
+
+// SetProtected locks or unlocks a holding register against client writes.
+// Like the counter, a protected register ignores writes and keeps its value;
+// the simulator and admin tools can still change it. Protecting an alias
+// protects its target.
+func (h *ModbusHandler) SetProtected(addr uint16, protected bool) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if int(addr) >= h.holdingRegs.Len() {
+		return modbus.ErrIllegalDataAddress
+	}
+	if protected {
+		h.protected.set(h.target("holding", int(addr)))
+	} else {
+		h.protected.clear(h.target("holding", int(addr)))
+	}
+	return nil
+}
+
+// Protected reports whether a holding register is locked against client writes.
+func (h *ModbusHandler) Protected(addr uint16) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return int(addr) < h.holdingRegs.Len() && h.protected.has(h.target("holding", int(addr)))
+}
+
+// ProtectedRegisters lists the protected holding registers in address order.
+func (h *ModbusHandler) ProtectedRegisters() []uint16 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	addrs := []uint16{}
+	for addr := 0; addr < h.holdingRegs.Len(); addr++ {
+		if h.protected.has(addr) {
+			addrs = append(addrs, uint16(addr))
+		}
+	}
+	return addrs
+}
@@ -0,0 +1,23 @@
+// quantity.go - Per-function request quantity limits
+package handler
+
+// maxQuantity returns the largest quantity accepted for a function code,
+// from quantity_limits or the Modbus specification.
+func (h *ModbusHandler) maxQuantity(function uint8) uint16 {
+	limits := h.config.QuantityLimits.WithDefaults()
+	switch function {
+	case fcReadCoils:
+		return limits.ReadCoils
+	case fcReadDiscreteInputs:
+		return limits.ReadDiscreteInputs
+	case fcReadHoldingRegisters:
+		return limits.ReadHoldingRegisters
+	case fcReadInputRegisters:
+		return limits.ReadInputRegisters
+	case fcWriteMultipleCoils:
+		return limits.WriteCoils
+	case fcWriteMultipleRegisters:
+		return limits.WriteRegisters
+	}
+	return 1
+}
@@ -0,0 +1,48 @@
+// ratelimit.go - Server-wide request rate limit
+package handler
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenBucket allows rate requests per second on average, with bursts of up
+// to one second's worth.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int, now time.Time) *tokenBucket {
+	return &tokenBucket{rate: float64(rate), tokens: float64(rate), last: now}
+}
+
+// allow takes a token if one is available at now.
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(b.rate, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// allowRate reports whether a request fits in GlobalRateLimit. Without a
+// limit every request does.
+func (h *ModbusHandler) allowRate() bool {
+	return h.rate == nil || h.rate.allow(h.now())
+}
+
+// ShareRateLimit makes h draw from the rate limit of other, so the limit
+// applies to all units together rather than to each one.
+func (h *ModbusHandler) ShareRateLimit(other *ModbusHandler) {
+	h.rate = other.rate
+}
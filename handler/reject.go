@@ -0,0 +1,41 @@
+// reject.go - Uniform counting and logging of rejected data requests
+package handler
+
+import (
+	"SPModbus/mlog"
+	"sync/atomic"
+)
+
+// reject counts a refused read or write and logs it to the request logger,
+// then returns the exception for condition. The entry carries the request's
+// function, unit ID, start and quantity along with the reason, whether it
+// was a write and the exception code; data adds reason-specific details.
+func (h *ModbusHandler) reject(log *mlog.Logger, write bool, addr uint16, condition string, data map[string]interface{}) error {
+	return h.rejectWith(log, write, addr, condition, h.exceptionFor(condition), data)
+}
+
+// rejectWith is reject for refusals answered with a fixed exception instead
+// of an exception_map condition.
+func (h *ModbusHandler) rejectWith(log *mlog.Logger, write bool, addr uint16, reason string, err error, data map[string]interface{}) error {
+	atomic.AddUint64(&h.stats.Errors, 1)
+	h.emit(Event{Type: EventFault, Address: addr, Detail: reason})
+
+	entry := map[string]interface{}{}
+	for k, v := range data {
+		entry[k] = v
+	}
+	entry["reason"] = reason
+	entry["write"] = write
+	entry["exception"] = exceptionCode(err)
+
+	switch h.config.RejectionLogLevel {
+	case "off":
+	case "debug":
+		log.Debug("Request rejected", entry)
+	case "info":
+		log.Info("Request rejected", entry)
+	default:
+		log.Warn("Request rejected", entry)
+	}
+	return err
+}
@@ -0,0 +1,311 @@
+// replica.go - Read-only replica of another simulator's registers
+package handler
+
+import (
+	"SPModbus/config"
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/simonvetter/modbus"
+)
+
+// Largest quantities a single read may request, per the Modbus spec
+const (
+	maxReadRegisters = 125
+	maxReadBits      = 2000
+)
+
+// ReplicaHandler mirrors the register state of a primary simulator into a
+// ModbusHandler by polling the primary over Modbus/TCP. The wrapped handler
+// serves reads from the cached values and rejects every client write with
+// ErrIllegalFunction.
+type ReplicaHandler struct {
+	*ModbusHandler
+	cfg    config.ReplicaConfig
+	client *modbus.ModbusClient // nil until connected or after a failure
+	synced atomic.Bool          // the last refresh succeeded
+
+	preloaded chan struct{} // closed once the PreloadRanges are cached
+}
+
+// NewReplicaHandler makes h read-only and returns a replica that fills it
+// from cfg.Primary once Run is called.
+func NewReplicaHandler(cfg config.ReplicaConfig, h *ModbusHandler) *ReplicaHandler {
+	h.readOnly.Store(true)
+	r := &ReplicaHandler{ModbusHandler: h, cfg: cfg, preloaded: make(chan struct{})}
+	if len(cfg.PreloadRanges) == 0 {
+		close(r.preloaded)
+	}
+	return r
+}
+
+// Preloaded returns a channel closed once the PreloadRanges have been read
+// from the primary, right away without any.
+func (r *ReplicaHandler) Preloaded() <-chan struct{} {
+	return r.preloaded
+}
+
+// Ready reports whether the preload is complete.
+func (r *ReplicaHandler) Ready() bool {
+	select {
+	case <-r.preloaded:
+		return true
+	default:
+		return false
+	}
+}
+
+// Run preloads the PreloadRanges, then refreshes the replica every
+// RefreshInterval until ctx is cancelled. Failures are logged once until the
+// primary answers again; meanwhile the last values fetched keep being served.
+func (r *ReplicaHandler) Run(ctx context.Context) {
+	interval := time.Duration(r.cfg.RefreshInterval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	defer r.disconnect()
+
+	r.logger.Debug("Replica refresher started", map[string]interface{}{
+		"primary":  r.cfg.Primary,
+		"interval": interval.String(),
+	})
+
+	if !r.preload(ctx, ticker) {
+		r.logger.Debug("Replica refresher stopping", nil)
+		return
+	}
+
+	logged := false // a failure was logged since the last successful refresh
+	for {
+		if err := r.Refresh(); err != nil {
+			r.synced.Store(false)
+			if !logged {
+				r.logger.Warn("Replica refresh failed", map[string]interface{}{
+					"primary": r.cfg.Primary,
+					"error":   err.Error(),
+				})
+				logged = true
+			}
+		} else {
+			if !r.synced.Swap(true) {
+				r.logger.Info("Replica in sync with primary", map[string]interface{}{
+					"primary": r.cfg.Primary,
+				})
+			}
+			logged = false
+		}
+
+		select {
+		case <-ctx.Done():
+			r.logger.Debug("Replica refresher stopping", nil)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Synced reports whether the last refresh from the primary succeeded.
+func (r *ReplicaHandler) Synced() bool {
+	return r.synced.Load()
+}
+
+// preload retries Preload every tick until it succeeds, and reports false
+// if ctx is cancelled first.
+func (r *ReplicaHandler) preload(ctx context.Context, ticker *time.Ticker) bool {
+	if r.Ready() {
+		return true
+	}
+
+	start := time.Now()
+	logged := false
+	for {
+		err := r.Preload()
+		if err == nil {
+			close(r.preloaded)
+			r.logger.Info("Replica preload complete", map[string]interface{}{
+				"primary":  r.cfg.Primary,
+				"ranges":   len(r.cfg.PreloadRanges),
+				"duration": time.Since(start).String(),
+			})
+			return true
+		}
+		if !logged {
+			r.logger.Warn("Replica preload failed, retrying", map[string]interface{}{
+				"primary": r.cfg.Primary,
+				"error":   err.Error(),
+			})
+			logged = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// Preload reads the PreloadRanges from the primary and caches them under a
+// single lock acquisition. Nothing is cached if any read fails.
+func (r *ReplicaHandler) Preload() error {
+	if err := r.connect(); err != nil {
+		return err
+	}
+
+	words := make([][]uint16, len(r.cfg.PreloadRanges))
+	bits := make([][]bool, len(r.cfg.PreloadRanges))
+	for i, rng := range r.cfg.PreloadRanges {
+		var err error
+		switch rng.Type {
+		case "holding", "input":
+			words[i], err = readWords(r.client, rng.Type, int(rng.Address), int(rng.Count))
+		default:
+			bits[i], err = readBits(r.client, rng.Type, int(rng.Address), int(rng.Count))
+		}
+		if err != nil {
+			return r.fail(rng.Type, err)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.unlock()
+
+	for i, rng := range r.cfg.PreloadRanges {
+		if words[i] != nil {
+			table, _ := r.wordStore(rng.Type)
+			for j, v := range words[i] {
+				table.Set(int(rng.Address)+j, v)
+			}
+		} else {
+			table, _ := r.bitStore(rng.Type)
+			for j, v := range bits[i] {
+				table.Set(int(rng.Address)+j, v)
+			}
+		}
+		r.initialized.mark(rng.Type, int(rng.Address), int(rng.Count))
+	}
+	r.counter = r.holdingRegs.Get(int(r.config.CounterAddress))
+	return nil
+}
+
+// Refresh reads every table from the primary and replaces the cached values
+// under a single lock acquisition, so clients never see a mix of two
+// refreshes. Nothing is replaced if any read fails.
+func (r *ReplicaHandler) Refresh() error {
+	if err := r.connect(); err != nil {
+		return err
+	}
+
+	holding, err := readWords(r.client, "holding", 0, r.holdingRegs.Len())
+	if err != nil {
+		return r.fail("holding", err)
+	}
+	input, err := readWords(r.client, "input", 0, r.inputRegs.Len())
+	if err != nil {
+		return r.fail("input", err)
+	}
+	coils, err := readBits(r.client, "coil", 0, r.coils.Len())
+	if err != nil {
+		return r.fail("coil", err)
+	}
+	discrete, err := readBits(r.client, "discrete", 0, r.discreteInputs.Len())
+	if err != nil {
+		return r.fail("discrete", err)
+	}
+
+	r.mu.Lock()
+	defer r.unlock()
+
+	for addr, v := range holding {
+		r.holdingRegs.Set(addr, v)
+	}
+	for addr, v := range input {
+		r.inputRegs.Set(addr, v)
+	}
+	for addr, v := range coils {
+		r.coils.Set(addr, v)
+	}
+	for addr, v := range discrete {
+		r.discreteInputs.Set(addr, v)
+	}
+	r.initialized.mark("holding", 0, len(holding))
+	r.initialized.mark("input", 0, len(input))
+	r.initialized.mark("coil", 0, len(coils))
+	r.initialized.mark("discrete", 0, len(discrete))
+	r.counter = r.holdingRegs.Get(int(r.config.CounterAddress))
+	return nil
+}
+
+func (r *ReplicaHandler) connect() error {
+	if r.client != nil {
+		return nil
+	}
+
+	client, err := modbus.NewClient(&modbus.ClientConfiguration{
+		URL:     r.cfg.Primary,
+		Timeout: time.Duration(r.cfg.RefreshInterval),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create replica client: %w", err)
+	}
+	if err := client.Open(); err != nil {
+		return fmt.Errorf("failed to connect to primary: %w", err)
+	}
+	client.SetUnitId(r.config.UnitID)
+	r.client = client
+	return nil
+}
+
+// fail drops the connection so the next refresh reconnects.
+func (r *ReplicaHandler) fail(regType string, err error) error {
+	r.disconnect()
+	return fmt.Errorf("failed to read %s from primary: %w", regType, err)
+}
+
+func (r *ReplicaHandler) disconnect() {
+	if r.client != nil {
+		r.client.Close()
+		r.client = nil
+	}
+}
+
+// readWords reads count holding or input registers from start, split into
+// requests the protocol allows.
+func readWords(client *modbus.ModbusClient, regType string, start, count int) ([]uint16, error) {
+	kind := modbus.HOLDING_REGISTER
+	if regType == "input" {
+		kind = modbus.INPUT_REGISTER
+	}
+
+	words := make([]uint16, 0, count)
+	for addr := start; addr < start+count; addr += maxReadRegisters {
+		quantity := min(maxReadRegisters, start+count-addr)
+		chunk, err := client.ReadRegisters(uint16(addr), uint16(quantity), kind)
+		if err != nil {
+			return nil, err
+		}
+		words = append(words, chunk...)
+	}
+	return words, nil
+}
+
+// readBits reads count coils or discrete inputs from start, split into
+// requests the protocol allows.
+func readBits(client *modbus.ModbusClient, regType string, start, count int) ([]bool, error) {
+	read := client.ReadCoils
+	if regType == "discrete" {
+		read = client.ReadDiscreteInputs
+	}
+
+	bits := make([]bool, 0, count)
+	for addr := start; addr < start+count; addr += maxReadBits {
+		quantity := min(maxReadBits, start+count-addr)
+		chunk, err := read(uint16(addr), uint16(quantity))
+		if err != nil {
+			return nil, err
+		}
+		bits = append(bits, chunk...)
+	}
+	return bits, nil
+}
@@ -0,0 +1,62 @@
+// reset.go - Device reset on a client write of the reset trigger
+package handler
+
+import (
+	"SPModbus/mlog"
+)
+
+// resetIndex returns the position of the reset trigger within a write of
+// count values of regType starting at addr, if the write covers it or one of
+// its aliases.
+func (h *ModbusHandler) resetIndex(regType string, addr uint16, count int) (int, bool) {
+	rt := h.config.ResetTrigger
+	if rt == nil || rt.Type != regType {
+		return 0, false
+	}
+	for i := 0; i < count; i++ {
+		if h.target(regType, int(addr)+i) == int(rt.Address) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// resetDevice simulates a reboot: every table is cleared, the init pattern
+// and initial data are re-applied and the counter restarts from its initial
+// value. Writes still pending under WriteDelay are cancelled and only the
+// re-applied addresses count as initialized. Write history is kept, and a
+// reset inside an open transaction is journaled like any other change, so
+// Rollback undoes it. It must be called with the write lock held.
+func (h *ModbusHandler) resetDevice(clientAddr string, log *mlog.Logger) {
+	h.delayed.cancel()
+	h.initialized.reset()
+
+	for i := 0; i < h.holdingRegs.Len(); i++ {
+		h.holdingRegs.Set(i, 0)
+	}
+	for i := 0; i < h.inputRegs.Len(); i++ {
+		h.inputRegs.Set(i, 0)
+	}
+	for i := 0; i < h.coils.Len(); i++ {
+		h.coils.Set(i, false)
+	}
+	for i := 0; i < h.discreteInputs.Len(); i++ {
+		h.discreteInputs.Set(i, false)
+	}
+
+	h.applyInitPattern()
+	h.applyInitialData(h.config.InitialData)
+
+	h.counter = h.config.CounterInitial
+	h.counterSaturated = false
+	h.storeCounter(h.counter)
+	if h.clockCounter() {
+		h.setClockCounter()
+	}
+
+	log.Info("Device reset by client", map[string]interface{}{
+		"client":  clientAddr,
+		"type":    h.config.ResetTrigger.Type,
+		"address": h.config.ResetTrigger.Address,
+	})
+}
@@ -0,0 +1,213 @@
+// simulator.go - Simulated register values driven by the register updater
+package handler
+
+import (
+	"SPModbus/config"
+	"SPModbus/mlog"
+	"math"
+	"math/rand"
+	"time"
+)
+
+type simState struct {
+	config     config.SimulatedRegister
+	group      int // index into register groups, -1 when ungrouped
+	spikeUntil time.Time
+	lastLogged uint16
+	loggedAt   time.Time
+}
+
+// Simulator drives the configured simulated registers. Step is called from
+// the register updater goroutine only, so its state needs no locking.
+type Simulator struct {
+	handler *ModbusHandler
+	logger  *mlog.Logger
+	rng     *rand.Rand
+	sims    []*simState
+	groups  []config.RegisterGroup
+	window  time.Duration
+}
+
+// NewSimulator creates a simulator for the configured registers. A non-zero
+// SimulationSeed, or failing that Seed, makes spikes and noise reproducible
+// across runs.
+func NewSimulator(config config.ModbusConfig, handler *ModbusHandler, logger *mlog.Logger) *Simulator {
+	seed := config.SimulationSeed
+	if seed == 0 {
+		seed = config.Seed
+	}
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	s := &Simulator{
+		handler: handler,
+		logger:  logger,
+		rng:     rand.New(rand.NewSource(seed)),
+		groups:  config.RegisterGroups,
+		window:  time.Duration(config.SimulationLogWindow),
+	}
+
+	for _, sim := range config.Simulations {
+		state := &simState{config: sim, group: -1, lastLogged: sim.Base}
+		for i, g := range config.RegisterGroups {
+			if g.Contains(sim.Type, sim.Address) {
+				state.group = i
+			}
+		}
+		s.sims = append(s.sims, state)
+	}
+
+	return s
+}
+
+// Step advances every simulated register to its value at now. Registers in a
+// group are written together once all their values are known.
+func (s *Simulator) Step(now time.Time) {
+	pending := make(map[int][]*simState)
+	values := make(map[*simState]uint16, len(s.sims))
+
+	for _, sim := range s.sims {
+		value := sim.config.Base
+
+		if spike := sim.config.Spike; spike != nil {
+			switch {
+			case now.Before(sim.spikeUntil):
+				value = offsetValue(sim.config.Base, spike.Magnitude)
+
+			case !sim.spikeUntil.IsZero():
+				// Spike just ended; return to normal for at least one tick
+				sim.spikeUntil = time.Time{}
+				s.logger.Info("Simulated spike ended", map[string]interface{}{
+					"type":    sim.config.Type,
+					"address": sim.config.Address,
+				})
+
+			case s.rng.Float64() < spike.Probability:
+				sim.spikeUntil = now.Add(time.Duration(spike.Duration) * time.Second)
+				value = offsetValue(sim.config.Base, spike.Magnitude)
+				s.logger.Info("Simulated spike started", map[string]interface{}{
+					"type":     sim.config.Type,
+					"address":  sim.config.Address,
+					"value":    value,
+					"duration": spike.Duration,
+				})
+			}
+		}
+
+		if noise := sim.config.Noise; noise != nil && noise.Magnitude > 0 {
+			value = offsetValue(value, s.noise(noise))
+		}
+
+		if sim.group >= 0 {
+			pending[sim.group] = append(pending[sim.group], sim)
+			values[sim] = value
+			continue
+		}
+
+		if err := s.handler.SetRegister(sim.config.Type, sim.config.Address, value); err != nil {
+			s.logger.Warn("Simulated register update failed", map[string]interface{}{
+				"type":    sim.config.Type,
+				"address": sim.config.Address,
+				"error":   err.Error(),
+			})
+			continue
+		}
+		s.logChange(sim, value, now)
+	}
+
+	for i, sims := range pending {
+		g := s.groups[i]
+		update := make(map[uint16]uint16, len(sims))
+		for _, sim := range sims {
+			update[sim.config.Address] = values[sim]
+		}
+
+		if err := s.handler.SetRegisters(g.Type, update); err != nil {
+			s.logger.Warn("Simulated register group update failed", map[string]interface{}{
+				"type":    g.Type,
+				"address": g.Address,
+				"count":   g.Count,
+				"error":   err.Error(),
+			})
+			continue
+		}
+		for _, sim := range sims {
+			s.logChange(sim, values[sim], now)
+		}
+	}
+}
+
+// Shutdown writes the safe value of every simulated register that has one,
+// leaving a known end-of-run state. Registers of one type are written under a
+// single lock acquisition, so no reader sees a half powered-down device.
+func (s *Simulator) Shutdown() {
+	safe := make(map[string]map[uint16]uint16)
+	for _, sim := range s.sims {
+		if sim.config.SafeValue == nil {
+			continue
+		}
+		if safe[sim.config.Type] == nil {
+			safe[sim.config.Type] = make(map[uint16]uint16)
+		}
+		safe[sim.config.Type][sim.config.Address] = *sim.config.SafeValue
+	}
+
+	for regType, values := range safe {
+		if err := s.handler.SetRegisters(regType, values); err != nil {
+			s.logger.Warn("Failed to set simulated registers to safe values", map[string]interface{}{
+				"type":  regType,
+				"error": err.Error(),
+			})
+			continue
+		}
+		s.logger.Info("Simulated registers set to safe values", map[string]interface{}{
+			"type":  regType,
+			"count": len(values),
+		})
+	}
+}
+
+// logChange logs a simulated value change. With a log window configured,
+// changes are coalesced: at most one entry per register per window, showing
+// the net change since the last logged value.
+func (s *Simulator) logChange(sim *simState, value uint16, now time.Time) {
+	if value == sim.lastLogged {
+		return
+	}
+	if s.window > 0 && !sim.loggedAt.IsZero() && now.Sub(sim.loggedAt) < s.window {
+		return
+	}
+
+	s.logger.Debug("Simulated register changed", map[string]interface{}{
+		"type":    sim.config.Type,
+		"address": sim.config.Address,
+		"old":     sim.lastLogged,
+		"new":     value,
+	})
+	sim.lastLogged = value
+	sim.loggedAt = now
+}
+
+// noise draws one noise sample within ±Magnitude, rounded to a whole number.
+func (s *Simulator) noise(n *config.NoiseConfig) int {
+	var v float64
+	if n.Distribution == "gaussian" {
+		v = math.Max(-n.Magnitude, math.Min(n.Magnitude, s.rng.NormFloat64()*n.Magnitude/3))
+	} else {
+		v = (s.rng.Float64()*2 - 1) * n.Magnitude
+	}
+	return int(math.Round(v))
+}
+
+// offsetValue adds delta to base, clamped to the uint16 range.
+func offsetValue(base uint16, delta int) uint16 {
+	v := int(base) + delta
+	if v < 0 {
+		return 0
+	}
+	if v > 0xffff {
+		return 0xffff
+	}
+	return uint16(v)
+}
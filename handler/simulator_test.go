@@ -0,0 +1,246 @@
+// simulator_test.go - Simulated register tests
+package handler
+
+import (
+	"SPModbus/config"
+	"SPModbus/mlog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/simonvetter/modbus"
+)
+
+func newTestHandler(t testing.TB, cfg config.ModbusConfig) (*ModbusHandler, *mlog.Logger) {
+	t.Helper()
+
+	logger, err := mlog.NewLogger(config.LoggingConfig{
+		Level:   "ERROR",
+		Console: false,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	t.Cleanup(logger.Close)
+
+	return NewModbusHandler(cfg, logger), logger
+}
+
+func readInput(t *testing.T, h *ModbusHandler, addr uint16) uint16 {
+	t.Helper()
+
+	regs, err := h.ReadRegisters("input", addr, 1)
+	if err != nil {
+		t.Fatalf("Failed to read input register %d: %v", addr, err)
+	}
+	return regs[0]
+}
+
+// TestSimulatorSpike tests that a spike holds for its duration then returns to base
+func TestSimulatorSpike(t *testing.T) {
+	sims := []config.SimulatedRegister{
+		{Type: "input", Address: 20, Base: 500, Spike: &config.SpikeConfig{Probability: 1, Magnitude: 10000, Duration: 5}},
+		{Type: "input", Address: 21, Base: 700, Spike: &config.SpikeConfig{Probability: 0, Magnitude: 10000, Duration: 5}},
+		{Type: "input", Address: 22, Base: 100, Spike: &config.SpikeConfig{Probability: 1, Magnitude: -1000, Duration: 5}},
+	}
+	cfg := config.ModbusConfig{
+		UnitID:         1,
+		MaxRegisters:   200,
+		CounterAddress: 10,
+		Simulations:    sims,
+	}
+	h, logger := newTestHandler(t, cfg)
+	sim := NewSimulator(cfg, h, logger)
+
+	start := time.Now()
+
+	sim.Step(start)
+	if v := readInput(t, h, 20); v != 10500 {
+		t.Fatalf("Expected spiked value 10500, got %d", v)
+	}
+	if v := readInput(t, h, 21); v != 700 {
+		t.Fatalf("Expected base value 700 with zero probability, got %d", v)
+	}
+	if v := readInput(t, h, 22); v != 0 {
+		t.Fatalf("Expected negative spike clamped to 0, got %d", v)
+	}
+
+	sim.Step(start.Add(4 * time.Second))
+	if v := readInput(t, h, 20); v != 10500 {
+		t.Fatalf("Expected spike to hold within duration, got %d", v)
+	}
+
+	sim.Step(start.Add(6 * time.Second))
+	if v := readInput(t, h, 20); v != 500 {
+		t.Fatalf("Expected return to base 500 after duration, got %d", v)
+	}
+}
+
+// TestSimulatorLogWindow tests that rapid simulated changes are coalesced in the log
+func TestSimulatorLogWindow(t *testing.T) {
+	tests := []struct {
+		name   string
+		window time.Duration
+		want   int
+	}{
+		{"EveryChange", 0, 9},
+		{"Coalesced", 5 * time.Second, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logFile := filepath.Join(t.TempDir(), "test.jsonl")
+			logger, err := mlog.NewLogger(config.LoggingConfig{
+				Level:   "DEBUG",
+				File:    logFile,
+				Console: false,
+			})
+			if err != nil {
+				t.Fatalf("Failed to create logger: %v", err)
+			}
+			defer logger.Close()
+
+			cfg := config.ModbusConfig{
+				UnitID:         1,
+				MaxRegisters:   200,
+				CounterAddress: 10,
+				Simulations: []config.SimulatedRegister{
+					{Type: "input", Address: 20, Base: 500, Spike: &config.SpikeConfig{Probability: 1, Magnitude: 100, Duration: 1}},
+				},
+				SimulationLogWindow: config.Duration(tt.window),
+			}
+			sim := NewSimulator(cfg, NewModbusHandler(cfg, logger), logger)
+
+			// Alternates between spike and base on every 1.5s tick
+			start := time.Now()
+			for i := 0; i < 9; i++ {
+				sim.Step(start.Add(time.Duration(i) * 1500 * time.Millisecond))
+			}
+
+			logs, err := os.ReadFile(logFile)
+			if err != nil {
+				t.Fatalf("Failed to read log file: %v", err)
+			}
+			if n := strings.Count(string(logs), "Simulated register changed"); n != tt.want {
+				t.Fatalf("Expected %d change entries, got %d in %s", tt.want, n, logs)
+			}
+		})
+	}
+}
+
+// TestSimulatorNoise tests that noise stays within its magnitude and that a
+// seed makes it reproducible
+func TestSimulatorNoise(t *testing.T) {
+	for _, dist := range []string{"uniform", "gaussian"} {
+		t.Run(dist, func(t *testing.T) {
+			cfg := config.ModbusConfig{
+				UnitID:         1,
+				MaxRegisters:   200,
+				CounterAddress: 10,
+				Simulations: []config.SimulatedRegister{
+					{Type: "input", Address: 20, Base: 1000, Noise: &config.NoiseConfig{Distribution: dist, Magnitude: 25}},
+				},
+				SimulationSeed: 42,
+			}
+
+			run := func() []uint16 {
+				h, logger := newTestHandler(t, cfg)
+				sim := NewSimulator(cfg, h, logger)
+
+				var values []uint16
+				start := time.Now()
+				for i := 0; i < 500; i++ {
+					sim.Step(start.Add(time.Duration(i) * time.Second))
+					values = append(values, readInput(t, h, 20))
+				}
+				return values
+			}
+
+			first := run()
+			varied := false
+			for i, v := range first {
+				if v < 975 || v > 1025 {
+					t.Fatalf("Tick %d: value %d outside 1000±25", i, v)
+				}
+				if v != 1000 {
+					varied = true
+				}
+			}
+			if !varied {
+				t.Fatalf("Expected noise to change the value at least once")
+			}
+
+			for i, v := range run() {
+				if v != first[i] {
+					t.Fatalf("Tick %d: same seed gave %d then %d", i, first[i], v)
+				}
+			}
+		})
+	}
+}
+
+// TestSimulatorGroupConsistency tests that concurrent reads never see a
+// register group half updated
+func TestSimulatorGroupConsistency(t *testing.T) {
+	spike := &config.SpikeConfig{Probability: 1, Magnitude: 0xffff, Duration: 1}
+	cfg := config.ModbusConfig{
+		UnitID:         1,
+		MaxRegisters:   200,
+		CounterAddress: 10,
+		Simulations: []config.SimulatedRegister{
+			{Type: "input", Address: 20, Base: 0, Spike: spike},
+			{Type: "input", Address: 21, Base: 0, Spike: spike},
+		},
+		RegisterGroups: []config.RegisterGroup{{Type: "input", Address: 20, Count: 2}},
+	}
+	h, logger := newTestHandler(t, cfg)
+	sim := NewSimulator(cfg, h, logger)
+
+	// Both registers flip between 0 and 0xffff on every tick
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		start := time.Now()
+		for i := 0; i < 20000; i++ {
+			sim.Step(start.Add(time.Duration(i) * 1500 * time.Millisecond))
+		}
+	}()
+
+	var wg sync.WaitGroup
+	torn := make(chan []uint16, 1)
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				regs, err := h.HandleInputRegisters(&modbus.InputRegistersRequest{UnitId: 1, Addr: 20, Quantity: 2})
+				if err != nil {
+					t.Errorf("Read failed: %v", err)
+					return
+				}
+				if regs[0] != regs[1] {
+					select {
+					case torn <- regs:
+					default:
+					}
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	select {
+	case regs := <-torn:
+		t.Fatalf("Read a torn group value %v", regs)
+	default:
+	}
+}
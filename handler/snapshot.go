@@ -0,0 +1,57 @@
+// snapshot.go - Copy-on-write register snapshot for lock-free stale reads
+package handler
+
+// registerSnapshot is an immutable copy of every table. Computed holding
+// registers are stored with the value they had when the snapshot was taken.
+type registerSnapshot struct {
+	holding  []uint16
+	input    []uint16
+	coils    []bool
+	discrete []bool
+}
+
+// publish replaces the snapshot with a copy of the current tables when
+// StaleReads is enabled. It must be called with the write lock held. Every
+// call copies all four tables, so writes cost O(size) in this mode.
+func (h *ModbusHandler) publish() {
+	if !h.config.StaleReads {
+		return
+	}
+
+	snap := &registerSnapshot{
+		holding:  make([]uint16, h.holdingRegs.Len()),
+		input:    make([]uint16, h.inputRegs.Len()),
+		coils:    make([]bool, h.coils.Len()),
+		discrete: make([]bool, h.discreteInputs.Len()),
+	}
+	for i := range snap.holding {
+		snap.holding[i] = h.holdingValue(i)
+	}
+	for i := range snap.input {
+		snap.input[i] = h.inputRegs.Get(i)
+	}
+	for i := range snap.coils {
+		snap.coils[i] = h.coils.Get(i)
+	}
+	for i := range snap.discrete {
+		snap.discrete[i] = h.discreteInputs.Get(i)
+	}
+	h.snapshot.Store(snap)
+}
+
+// unlock publishes a new snapshot and releases the write lock. Every write
+// path uses it in place of mu.Unlock so stale reads see each completed write.
+// The lock is released even if a compute function panics while publishing.
+func (h *ModbusHandler) unlock() {
+	defer h.mu.Unlock()
+	h.publish()
+}
+
+// snapshotRange copies count values from table starting at addr; values past
+// the end of the table, possible in lenient boundary mode, read as zero. The
+// snapshot slices are shared, so callers must never return them directly.
+func snapshotRange[T uint16 | bool](table []T, addr, count uint16) []T {
+	res := make([]T, count)
+	copy(res, table[addr:])
+	return res
+}
@@ -0,0 +1,82 @@
+// state.go - Register state snapshots carried across restarts
+package handler
+
+import (
+	"SPModbus/config"
+	"time"
+)
+
+// Snapshot is the persisted register state of one unit. Registers uses the
+// initial_data entry format, so a snapshot can be loaded like initial data.
+type Snapshot struct {
+	SavedAt   time.Time              `json:"saved_at"`
+	Registers []config.RegisterValue `json:"registers"`
+}
+
+// Snapshot captures every register and bit that is set, plus every address
+// seeded by InitialData even when it has since been cleared, so reloading it
+// over InitialData restores the cleared value.
+func (h *ModbusHandler) Snapshot() Snapshot {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	seeded := make(map[string]map[int]bool)
+	for _, data := range h.config.InitialData {
+		if seeded[data.Type] == nil {
+			seeded[data.Type] = make(map[int]bool)
+		}
+		for i := range data.Words() {
+			seeded[data.Type][int(data.Address)+i] = true
+		}
+	}
+
+	snap := Snapshot{SavedAt: time.Now()}
+	for _, regType := range []string{"holding", "input"} {
+		regs, _ := h.wordStore(regType)
+		for addr := 0; addr < regs.Len(); addr++ {
+			if v := regs.Get(addr); v != 0 || seeded[regType][addr] {
+				snap.Registers = append(snap.Registers, config.RegisterValue{Type: regType, Address: uint16(addr), Value: v})
+			}
+		}
+	}
+	for _, area := range []struct {
+		regType string
+		bits    bitStore
+	}{{"coil", h.coils}, {"discrete", h.discreteInputs}} {
+		regType, bits := area.regType, area.bits
+		for addr := 0; addr < bits.Len(); addr++ {
+			if v := bits.Get(addr); v || seeded[regType][addr] {
+				var value uint16
+				if v {
+					value = 1
+				}
+				snap.Registers = append(snap.Registers, config.RegisterValue{Type: regType, Address: uint16(addr), Value: value})
+			}
+		}
+	}
+	return snap
+}
+
+// LoadState merges a snapshot into the registers. By default the snapshot
+// wins for every address it contains and InitialData, already applied by
+// NewModbusHandler, keeps the rest. With InitialDataOverState, InitialData
+// is applied again afterwards and wins instead. The counter continues from
+// its saved value.
+func (h *ModbusHandler) LoadState(snap Snapshot) {
+	h.mu.Lock()
+	defer h.unlock()
+
+	h.applyInitialData(snap.Registers)
+	if h.config.InitialDataOverState {
+		h.applyInitialData(h.config.InitialData)
+	}
+	h.counter = h.holdingRegs.Get(int(h.config.CounterAddress))
+	h.storeCounter(h.counter)
+
+	h.logger.Info("Register state loaded", map[string]interface{}{
+		"unit_id":           h.config.UnitID,
+		"entries":           len(snap.Registers),
+		"saved_at":          snap.SavedAt.Format(time.RFC3339),
+		"initial_data_wins": h.config.InitialDataOverState,
+	})
+}
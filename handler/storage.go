@@ -0,0 +1,89 @@
+// storage.go - Dense and sparse backing stores for register banks
+package handler
+
+// RegisterReader is a read-only view of a register bank.
+type RegisterReader interface {
+	Len() int
+	Get(addr int) uint16
+}
+
+// registerStore holds one bank of 16-bit registers. Addresses are checked by
+// the caller.
+type registerStore interface {
+	RegisterReader
+	Set(addr int, value uint16)
+}
+
+// bitStore holds one bank of coils or discrete inputs.
+type bitStore interface {
+	Len() int
+	Get(addr int) bool
+	Set(addr int, value bool)
+}
+
+// newRegisterStore returns a sparse map-backed store for kind "sparse" and a
+// dense array otherwise.
+func newRegisterStore(kind string, size int) registerStore {
+	if kind == "sparse" {
+		return &sparseRegisters{size: size, values: make(map[int]uint16)}
+	}
+	return denseRegisters(make([]uint16, size))
+}
+
+func newBitStore(kind string, size int) bitStore {
+	if kind == "sparse" {
+		return &sparseBits{size: size, values: make(map[int]struct{})}
+	}
+	return denseBits(make([]bool, size))
+}
+
+type denseRegisters []uint16
+
+func (d denseRegisters) Len() int                   { return len(d) }
+func (d denseRegisters) Get(addr int) uint16        { return d[addr] }
+func (d denseRegisters) Set(addr int, value uint16) { d[addr] = value }
+
+// sparseRegisters only stores non-zero registers, so memory follows the
+// number of registers in use rather than the allocated address space.
+type sparseRegisters struct {
+	size   int
+	values map[int]uint16
+}
+
+func (s *sparseRegisters) Len() int            { return s.size }
+func (s *sparseRegisters) Get(addr int) uint16 { return s.values[addr] }
+
+func (s *sparseRegisters) Set(addr int, value uint16) {
+	if value == 0 {
+		delete(s.values, addr)
+		return
+	}
+	s.values[addr] = value
+}
+
+type denseBits []bool
+
+func (d denseBits) Len() int                 { return len(d) }
+func (d denseBits) Get(addr int) bool        { return d[addr] }
+func (d denseBits) Set(addr int, value bool) { d[addr] = value }
+
+// sparseBits stores the set of addresses that are on.
+type sparseBits struct {
+	size   int
+	values map[int]struct{}
+}
+
+func (s *sparseBits) Len() int { return s.size }
+
+func (s *sparseBits) Get(addr int) bool {
+	_, ok := s.values[addr]
+	return ok
+}
+
+func (s *sparseBits) Set(addr int, value bool) {
+	if value {
+		s.values[addr] = struct{}{}
+	} else {
+		delete(s.values, addr)
+	}
+}
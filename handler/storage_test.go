@@ -0,0 +1,191 @@
+// storage_test.go - Register storage tests and benchmarks
+package handler
+
+import (
+	"SPModbus/config"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/simonvetter/modbus"
+)
+
+// TestSparseStorage tests that sparse storage behaves like dense storage
+func TestSparseStorage(t *testing.T) {
+	for _, kind := range []string{"dense", "sparse"} {
+		t.Run(kind, func(t *testing.T) {
+			handler, _ := newTestHandler(t, config.ModbusConfig{
+				UnitID:         1,
+				MaxRegisters:   65536,
+				CounterAddress: 10,
+				Storage:        kind,
+				InitialData: []config.RegisterValue{
+					{Type: "holding", Address: 65535, Value: 7},
+					{Type: "coil", Address: 40000, Value: 1},
+				},
+			})
+
+			_, err := handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+				UnitId: 1, Addr: 30000, Quantity: 2, IsWrite: true, Args: []uint16{5, 0},
+			})
+			if err != nil {
+				t.Fatalf("Failed to write registers: %v", err)
+			}
+
+			regs, err := handler.ReadRegisters("holding", 29999, 3)
+			if err != nil {
+				t.Fatalf("Failed to read registers: %v", err)
+			}
+			if regs[0] != 0 || regs[1] != 5 || regs[2] != 0 {
+				t.Fatalf("Expected [0 5 0], got %v", regs)
+			}
+			if regs, _ := handler.ReadRegisters("holding", 65535, 1); regs[0] != 7 {
+				t.Fatalf("Expected last register 7, got %d", regs[0])
+			}
+			if bits, _ := handler.ReadBits("coil", 39999, 2); bits[0] || !bits[1] {
+				t.Fatalf("Expected coils [false true], got %v", bits)
+			}
+		})
+	}
+
+	t.Run("ZeroDropsEntry", func(t *testing.T) {
+		s := newRegisterStore("sparse", 100).(*sparseRegisters)
+		s.Set(5, 1)
+		s.Set(5, 0)
+		if len(s.values) != 0 {
+			t.Fatalf("Expected zero write to free the entry, have %d entries", len(s.values))
+		}
+	})
+}
+
+func BenchmarkRegisterStore(b *testing.B) {
+	for _, kind := range []string{"dense", "sparse"} {
+		for _, used := range []int{100, 65536} {
+			b.Run(fmt.Sprintf("%s/used=%d", kind, used), func(b *testing.B) {
+				store := newRegisterStore(kind, 65536)
+				for i := 0; i < used; i++ {
+					store.Set(i, uint16(i+1))
+				}
+				b.ResetTimer()
+
+				var sum uint16
+				for i := 0; i < b.N; i++ {
+					addr := i % used
+					store.Set(addr, store.Get(addr)+1)
+					sum += store.Get(addr)
+				}
+				_ = sum
+			})
+		}
+	}
+}
+
+func BenchmarkHoldingRead(b *testing.B) {
+	for _, kind := range []string{"dense", "sparse"} {
+		b.Run(kind, func(b *testing.B) {
+			handler, _ := newTestHandler(b, config.ModbusConfig{
+				UnitID:         1,
+				MaxRegisters:   65536,
+				CounterAddress: 10,
+				Storage:        kind,
+			})
+			req := &modbus.HoldingRegistersRequest{UnitId: 1, Addr: 1000, Quantity: 125}
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := handler.HandleHoldingRegisters(req); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkHoldingReadUnderWrites compares locked and snapshot reads while
+// another goroutine keeps writing.
+func BenchmarkHoldingReadUnderWrites(b *testing.B) {
+	for _, stale := range []bool{false, true} {
+		name := "locked"
+		if stale {
+			name = "snapshot"
+		}
+		b.Run(name, func(b *testing.B) {
+			handler, _ := newTestHandler(b, config.ModbusConfig{
+				UnitID:         1,
+				MaxRegisters:   1000,
+				CounterAddress: 10,
+				StaleReads:     stale,
+			})
+
+			stop := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				write := &modbus.HoldingRegistersRequest{UnitId: 1, Addr: 500, Quantity: 10, IsWrite: true, Args: make([]uint16, 10)}
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					if _, err := handler.HandleHoldingRegisters(write); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			}()
+
+			req := &modbus.HoldingRegistersRequest{UnitId: 1, Addr: 0, Quantity: 10}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := handler.HandleHoldingRegisters(req); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+
+			close(stop)
+			<-done
+		})
+	}
+}
+
+// BenchmarkHoldingReadParallel measures holding register read throughput
+// with concurrent readers, which share the read lock. The slow case reads a
+// computed register that takes 20µs, so readers holding the lock longer than
+// the request overhead show whether they overlap.
+func BenchmarkHoldingReadParallel(b *testing.B) {
+	for _, slow := range []bool{false, true} {
+		name := "plain"
+		if slow {
+			name = "slow_computed"
+		}
+		b.Run(name, func(b *testing.B) {
+			handler, _ := newTestHandler(b, config.ModbusConfig{
+				UnitID:         1,
+				MaxRegisters:   1000,
+				CounterAddress: 10,
+			})
+			if slow {
+				if err := handler.RegisterComputed(50, func(addr uint16, regs RegisterReader) uint16 {
+					time.Sleep(20 * time.Microsecond)
+					return regs.Get(51)
+				}); err != nil {
+					b.Fatal(err)
+				}
+			}
+			req := &modbus.HoldingRegistersRequest{UnitId: 1, Addr: 0, Quantity: 125}
+			b.SetParallelism(8)
+			b.ResetTimer()
+
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := handler.HandleHoldingRegisters(req); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
@@ -3,10 +3,12 @@ package main
 
 import (
 	"SPModbus/config"
+	"SPModbus/handler"
 	"SPModbus/mlog"
 	"SPModbus/server"
 	"context"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
@@ -16,21 +18,56 @@ import (
 
 func main() {
 	var configFile = flag.String("config", "config.json", "Path to configuration file")
+	var requireConfig = flag.Bool("require-config", false, "Fail instead of creating a default config file when it is missing")
+	var strictConfig = flag.Bool("strict-config", true, "Fail when the config file can't be loaded; with -strict-config=false, log the error and start with defaults")
+	var captureURL = flag.String("capture", "", "Capture the registers of the device at this Modbus URL into a config file, then exit")
+	var captureRanges = flag.String("capture-ranges", "holding:0-99", "Ranges to capture, e.g. holding:0-99,input:0-9,coil:0-15")
+	var captureUnit = flag.Uint("capture-unit", 0, "Unit ID of the captured device (defaults to modbus.unit_id)")
+	var captureOut = flag.String("capture-out", "captured_config.json", "Config file to write the captured initial data to")
 	flag.Parse()
 
 	// Load configuration
-	config, err := config.LoadConfig(*configFile)
-	if err != nil {
-		log.Fatalf("Failed to load config: %v\n", err)
+	load := config.LoadConfig
+	if *requireConfig {
+		load = config.LoadExistingConfig
+	}
+	cfg, loadErr := load(*configFile)
+	if loadErr != nil {
+		if *strictConfig {
+			log.Fatalf("Failed to load config: %v\n", loadErr)
+		}
+		cfg = config.Default()
 	}
 
 	// Create logger
-	logger, err := mlog.NewLogger(config.Logging)
+	logger, err := mlog.NewLogger(cfg.Logging)
 	if err != nil {
-		log.Println(config.Logging)
+		log.Println(cfg.Logging)
 		log.Fatalf("Failed to create logger: %v\n", err)
 	}
 	defer logger.Close()
+	logger.SetName(cfg.Name)
+
+	if loadErr != nil {
+		logger.Error("Failed to load config, starting with defaults", map[string]interface{}{
+			"config": *configFile,
+			"error":  loadErr.Error(),
+		})
+	}
+
+	for _, notice := range cfg.Notices() {
+		logger.Info(notice, nil)
+	}
+
+	if *captureURL != "" {
+		if err := capture(cfg, logger, *captureURL, *captureRanges, *captureUnit, *captureOut); err != nil {
+			logger.Error("Capture failed", map[string]interface{}{
+				"error": err.Error(),
+			})
+			os.Exit(1)
+		}
+		return
+	}
 
 	logger.Info("Starting Modbus server", map[string]interface{}{
 		"version": "1.0.0",
@@ -38,38 +75,156 @@ func main() {
 	})
 
 	// Create and start srvr
-	srvr := server.NewModbusServer(config, logger)
+	srvr := server.NewModbusServer(cfg, logger)
 
 	// Setup graceful shutdown
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
 
-	// Handle shutdown signals
+	// Shutdown signals cancel ctx, so one arriving during the start retries
+	// interrupts them as well. A second signal exits right away, for a
+	// graceful shutdown that hangs.
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		received := 0
+		for sig := range sigChan {
+			received++
+			if received == 1 {
+				logger.Info("Shutdown signal received", map[string]interface{}{"shutdown": "Shutting down"})
+				cancel(server.SignalError{Signal: sig})
+				continue
+			}
+			logger.Warn("Second shutdown signal received, exiting immediately", map[string]interface{}{
+				"signal": sig.String(),
+			})
+			logger.Close()
+			os.Exit(1)
+		}
+	}()
+
+	// SIGUSR2 toggles maintenance mode
+	maintChan := make(chan os.Signal, 1)
+	signal.Notify(maintChan, syscall.SIGUSR2)
+	go func() {
+		for range maintChan {
+			srvr.ToggleMaintenance()
+		}
+	}()
+
+	// SIGUSR1 logs the full stats right away
+	statsChan := make(chan os.Signal, 1)
+	signal.Notify(statsChan, syscall.SIGUSR1)
+	go func() {
+		for range statsChan {
+			srvr.DumpStats()
+		}
+	}()
 
 	// Start server
 	if err := srvr.Start(ctx); err != nil {
+		shutdown := server.ShutdownFor(err)
+		if shutdown.Reason == server.ReasonSignal {
+			if !stop(srvr, logger, shutdown) {
+				os.Exit(1)
+			}
+			return
+		}
 		logger.Error("Failed to start server", map[string]interface{}{
 			"error": err.Error(),
 		})
+		stop(srvr, logger, shutdown)
 		os.Exit(1)
 	}
 
 	// Wait for shutdown signal
-	<-sigChan
-	logger.Info("Shutdown signal received", map[string]interface{}{"shutdown": "Shutting down"})
+	var shutdown server.Shutdown
+	select {
+	case err := <-srvr.Errors():
+		shutdown = server.ShutdownFor(err)
+		if shutdown.Reason != server.ReasonMaxLifetime {
+			logger.Error("Server failed", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+	case <-ctx.Done():
+		shutdown = server.ShutdownFor(context.Cause(ctx))
+	}
+
+	if !stop(srvr, logger, shutdown) {
+		os.Exit(1)
+	}
+}
 
-	// Graceful shutdown with timeout
+// stop shuts the server down with a timeout and reports whether it stopped cleanly.
+func stop(srvr *server.ModbusServer, logger *mlog.Logger, shutdown server.Shutdown) bool {
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer shutdownCancel()
 
-	if err := srvr.Stop(shutdownCtx); err != nil {
+	if err := srvr.Stop(shutdownCtx, shutdown); err != nil {
 		logger.Error("Error during shutdown", map[string]interface{}{
-			"error": err.Error(),
+			"error":  err.Error(),
+			"reason": shutdown.Reason,
 		})
-		os.Exit(1)
+		return false
+	}
+	return true
+}
+
+// capture reads the given ranges from a real device and saves a copy of cfg
+// whose initial data is the captured values, growing the register tables
+// where the captured addresses need it.
+func capture(cfg *config.Config, logger *mlog.Logger, url, rangeList string, unitID uint, out string) error {
+	ranges, err := handler.ParseCaptureRanges(rangeList)
+	if err != nil {
+		return err
 	}
+	if unitID == 0 {
+		unitID = uint(cfg.Modbus.UnitID)
+	}
+	if unitID > 255 {
+		return fmt.Errorf("invalid capture unit ID %d", unitID)
+	}
+
+	logger.Info("Capturing device registers", map[string]interface{}{
+		"target":  url,
+		"unit_id": unitID,
+		"ranges":  rangeList,
+	})
 
-	logger.Info("Server stopped successfully", map[string]interface{}{"shutdown": "End"})
+	values, err := handler.Capture(url, uint8(unitID), ranges, 5*time.Second)
+	if err != nil {
+		return err
+	}
+
+	captured := *cfg
+	captured.Modbus.InitialData = values
+	for _, r := range ranges {
+		end := int(r.Address) + r.Count
+		if end <= captured.Modbus.Size(r.Type) {
+			continue
+		}
+		switch r.Type {
+		case "holding":
+			captured.Modbus.MaxHoldingRegisters = end
+		case "input":
+			captured.Modbus.MaxInputRegisters = end
+		case "coil":
+			captured.Modbus.MaxCoils = end
+		case "discrete":
+			captured.Modbus.MaxDiscreteInputs = end
+		}
+	}
+	if err := captured.Validate(); err != nil {
+		return fmt.Errorf("captured config is invalid: %w", err)
+	}
+	if err := captured.Save(out); err != nil {
+		return err
+	}
+
+	logger.Info("Capture saved", map[string]interface{}{
+		"file":   out,
+		"values": len(values),
+	})
+	return nil
 }
@@ -0,0 +1,34 @@
+// fields.go - Derived loggers with preset entry fields
+package mlog
+
+import "maps"
+
+// WithFields returns a logger that adds fields to the data of every entry,
+// e.g. the request being handled. An entry's own data wins over a field with
+// the same key. The derived logger writes through l, keeps its throttle and
+// must not be closed.
+func (l *Logger) WithFields(fields map[string]interface{}) *Logger {
+	merged := make(map[string]interface{}, len(l.fields)+len(fields))
+	maps.Copy(merged, l.fields)
+	maps.Copy(merged, fields)
+	return &Logger{root: l.base(), fields: merged, throttle: l.throttle}
+}
+
+// base returns the logger that owns the outputs.
+func (l *Logger) base() *Logger {
+	if l.root != nil {
+		return l.root
+	}
+	return l
+}
+
+// withFields returns data with the preset fields added.
+func (l *Logger) withFields(data map[string]interface{}) map[string]interface{} {
+	if len(l.fields) == 0 {
+		return data
+	}
+	merged := make(map[string]interface{}, len(l.fields)+len(data))
+	maps.Copy(merged, l.fields)
+	maps.Copy(merged, data)
+	return merged
+}
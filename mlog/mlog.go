@@ -5,6 +5,7 @@ import (
 	"SPModbus/config"
 	"encoding/json"
 	"fmt"
+	"log/syslog"
 	"os"
 	"path/filepath"
 	"sync"
@@ -20,22 +21,39 @@ const (
 	ERROR
 )
 
+// LogEntry is one line of the JSONL log file.
 type LogEntry struct {
-	Timestamp time.Time              `json:"timestamp"`
+	// Timestamp is the time as written, in the configured time_format or
+	// RFC3339 with nanoseconds. It was a time.Time, which can't hold a
+	// custom layout; parse it with the same layout.
+	Timestamp string                 `json:"timestamp"`
+	Name      string                 `json:"name,omitempty"`
 	Level     string                 `json:"level"`
 	Message   string                 `json:"message"`
 	Data      map[string]interface{} `json:"data,omitempty"`
 }
 
 type Logger struct {
-	config config.LoggingConfig
-	file   *os.File
-	mu     sync.Mutex
-	level  LogLevel
+	config   config.LoggingConfig
+	file     *os.File
+	size     int64 // bytes in file
+	maxBytes int64 // MaxSize in bytes, 0 never rotates
+	syslog   *syslog.Writer
+	mu       sync.Mutex
+	level    LogLevel
+	name     string
+	sampler  *sampler // nil unless SampleRate is above 1
+
+	// Set on loggers made by WithFields and Throttled, which write through
+	// root
+	root     *Logger
+	fields   map[string]interface{}
+	throttle *Throttle // nil unless throttled
 }
 
 func NewLogger(config config.LoggingConfig) (*Logger, error) {
 	var file *os.File
+	var size int64
 	var err error
 
 	if config.File != "" {
@@ -45,7 +63,7 @@ func NewLogger(config config.LoggingConfig) (*Logger, error) {
 			}
 		}
 
-		file, err = os.OpenFile(config.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		file, size, err = openLogFile(config.File)
 		if err != nil {
 			return nil, fmt.Errorf("failed to open log file: %w", err)
 		}
@@ -61,40 +79,131 @@ func NewLogger(config config.LoggingConfig) (*Logger, error) {
 		level = ERROR
 	}
 
-	return &Logger{
-		config: config,
-		file:   file,
-		level:  level,
-	}, nil
+	l := &Logger{
+		config:   config,
+		file:     file,
+		size:     size,
+		level:    level,
+		maxBytes: int64(config.MaxSize) << 20,
+	}
+	if config.SampleRate > 1 {
+		l.sampler = newSampler(config.SampleRate)
+	}
+
+	// An unreachable syslog must not keep the server from starting
+	if config.Syslog != nil {
+		if l.syslog, err = dialSyslog(*config.Syslog); err != nil {
+			l.Warn("Syslog unavailable, continuing without it", map[string]interface{}{
+				"network": config.Syslog.Network,
+				"address": config.Syslog.Address,
+				"error":   err.Error(),
+			})
+		}
+	}
+
+	return l, nil
+}
+
+var syslogFacilities = map[string]syslog.Priority{
+	"kern": syslog.LOG_KERN, "user": syslog.LOG_USER, "mail": syslog.LOG_MAIL,
+	"daemon": syslog.LOG_DAEMON, "auth": syslog.LOG_AUTH, "syslog": syslog.LOG_SYSLOG,
+	"lpr": syslog.LOG_LPR, "news": syslog.LOG_NEWS, "uucp": syslog.LOG_UUCP,
+	"cron": syslog.LOG_CRON, "authpriv": syslog.LOG_AUTHPRIV, "ftp": syslog.LOG_FTP,
+	"local0": syslog.LOG_LOCAL0, "local1": syslog.LOG_LOCAL1, "local2": syslog.LOG_LOCAL2,
+	"local3": syslog.LOG_LOCAL3, "local4": syslog.LOG_LOCAL4, "local5": syslog.LOG_LOCAL5,
+	"local6": syslog.LOG_LOCAL6, "local7": syslog.LOG_LOCAL7,
+}
+
+func dialSyslog(cfg config.SyslogConfig) (*syslog.Writer, error) {
+	facility := syslog.LOG_USER
+	if cfg.Facility != "" {
+		f, ok := syslogFacilities[cfg.Facility]
+		if !ok {
+			return nil, fmt.Errorf("unknown syslog facility '%s'", cfg.Facility)
+		}
+		facility = f
+	}
+
+	w, err := syslog.Dial(cfg.Network, cfg.Address, facility|syslog.LOG_INFO, cfg.Tag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+	}
+	return w, nil
+}
+
+// SetName sets an instance name included in every entry, so logs from many
+// simulators can be told apart once aggregated.
+func (l *Logger) SetName(name string) {
+	l = l.base()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.name = name
 }
 
 func (l *Logger) Close() {
+	if l.root != nil {
+		return
+	}
+	l.reportSuppressed(true)
+
+	l.mu.Lock()
 	if l.file != nil {
 		l.file.Close()
+		l.file = nil
+	}
+	l.mu.Unlock()
+	if l.syslog != nil {
+		l.syslog.Close()
 	}
 }
 
 func (l *Logger) log(level LogLevel, levelStr, message string, data map[string]interface{}) {
+	if l.root != nil {
+		if level >= l.root.level && l.admit(level) {
+			l.root.log(level, levelStr, message, l.withFields(data))
+		}
+		return
+	}
 	if level < l.level {
 		return
 	}
+	if level == DEBUG && l.sampler != nil {
+		keep := l.sampler.keep(message)
+		l.reportSuppressed(false)
+		if !keep {
+			return
+		}
+	}
+	l.write(levelStr, message, data)
+}
+
+func (l *Logger) write(levelStr, message string, data map[string]interface{}) {
+	now := time.Now()
+	if l.config.UTC {
+		now = now.UTC()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
 	entry := LogEntry{
-		Timestamp: time.Now(),
+		Timestamp: l.formatTime(now, time.RFC3339Nano),
+		Name:      l.name,
 		Level:     levelStr,
 		Message:   message,
 		Data:      data,
 	}
 
-	l.mu.Lock()
-	defer l.mu.Unlock()
-
 	// Write to file
 	if l.file != nil {
 		if jsonData, err := json.Marshal(entry); err == nil {
-			l.file.Write(jsonData)
-			l.file.Write([]byte("\n"))
-			l.file.Sync()
+			line := append(jsonData, '\n')
+			l.rotateIfFull(len(line))
+			if l.file != nil {
+				n, _ := l.file.Write(line)
+				l.size += int64(n)
+				l.file.Sync()
+			}
 		}
 	}
 
@@ -106,10 +215,53 @@ func (l *Logger) log(level LogLevel, levelStr, message string, data map[string]i
 				dataStr = fmt.Sprintf(" %s", string(jsonData))
 			}
 		}
-		fmt.Printf("[%s] %s: %s%s\n", levelStr, entry.Timestamp.Format("15:04:05"), message, dataStr)
+		name := ""
+		if l.name != "" {
+			name = l.name + " "
+		}
+		fmt.Printf("[%s] %s%s: %s%s\n", levelStr, name, l.formatTime(now, "15:04:05"), message, dataStr)
+	}
+
+	// Write to syslog, which adds its own timestamp
+	if l.syslog != nil {
+		l.writeSyslog(levelStr, message, data)
+	}
+}
+
+// writeSyslog sends an entry at the matching syslog severity, with the data
+// as JSON after the message. Errors are dropped like file write errors.
+func (l *Logger) writeSyslog(levelStr, message string, data map[string]interface{}) {
+	line := message
+	if l.name != "" {
+		line = l.name + " " + line
+	}
+	if len(data) > 0 {
+		if jsonData, err := json.Marshal(data); err == nil {
+			line += " " + string(jsonData)
+		}
+	}
+
+	switch levelStr {
+	case "DEBUG":
+		l.syslog.Debug(line)
+	case "WARN":
+		l.syslog.Warning(line)
+	case "ERROR":
+		l.syslog.Err(line)
+	default:
+		l.syslog.Info(line)
 	}
 }
 
+// formatTime applies the configured TimeFormat, falling back to the given
+// layout so the file and console keep their historical formats by default.
+func (l *Logger) formatTime(t time.Time, fallback string) string {
+	if l.config.TimeFormat != "" {
+		return t.Format(l.config.TimeFormat)
+	}
+	return t.Format(fallback)
+}
+
 func (l *Logger) Debug(message string, data map[string]interface{}) {
 	l.log(DEBUG, "DEBUG", message, data)
 }
@@ -118,6 +270,12 @@ func (l *Logger) Info(message string, data map[string]interface{}) {
 	l.log(INFO, "INFO", message, data)
 }
 
+// Always logs at INFO even when the configured level would filter it out,
+// for output an operator asked for explicitly.
+func (l *Logger) Always(message string, data map[string]interface{}) {
+	l.base().write("INFO", message, l.withFields(data))
+}
+
 func (l *Logger) Warn(message string, data map[string]interface{}) {
 	l.log(WARN, "WARN", message, data)
 }
@@ -0,0 +1,496 @@
+// mlog_test.go - Logger tests
+package mlog
+
+import (
+	"SPModbus/config"
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// readEntries returns every JSON entry written to a log file
+func readEntries(t *testing.T, path string) []LogEntry {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open log file: %v", err)
+	}
+	defer file.Close()
+
+	var entries []LogEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry LogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Invalid log line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// TestTimestampFormat tests that the configured format and timezone are applied
+func TestTimestampFormat(t *testing.T) {
+	const layout = "2006-01-02T15:04:05.000Z07:00"
+
+	t.Run("UTCMilliseconds", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.jsonl")
+		logger, err := NewLogger(config.LoggingConfig{
+			Level:      "INFO",
+			File:       path,
+			TimeFormat: layout,
+			UTC:        true,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create logger: %v", err)
+		}
+		logger.Info("formatted", nil)
+		logger.Close()
+
+		entries := readEntries(t, path)
+		if len(entries) != 1 {
+			t.Fatalf("Expected 1 entry, got %d", len(entries))
+		}
+
+		ts, err := time.Parse(layout, entries[0].Timestamp)
+		if err != nil {
+			t.Fatalf("Timestamp %q does not match layout: %v", entries[0].Timestamp, err)
+		}
+		if ts.Format(layout) != entries[0].Timestamp {
+			t.Fatalf("Timestamp %q not formatted with layout", entries[0].Timestamp)
+		}
+		if _, offset := ts.Zone(); offset != 0 {
+			t.Fatalf("Expected UTC timestamp, got %q", entries[0].Timestamp)
+		}
+	})
+
+	t.Run("DefaultFormat", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.jsonl")
+		logger, err := NewLogger(config.LoggingConfig{
+			Level: "INFO",
+			File:  path,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create logger: %v", err)
+		}
+		logger.Info("default", nil)
+		logger.Close()
+
+		entries := readEntries(t, path)
+		if _, err := time.Parse(time.RFC3339Nano, entries[0].Timestamp); err != nil {
+			t.Fatalf("Default timestamp %q is not RFC3339: %v", entries[0].Timestamp, err)
+		}
+	})
+}
+
+// TestNoConsoleOutput tests that nothing reaches stdout when Console is false
+func TestNoConsoleOutput(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Failed to create pipe: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+
+	logger, err := NewLogger(config.LoggingConfig{
+		Level:   "DEBUG",
+		File:    filepath.Join(t.TempDir(), "test.jsonl"),
+		Console: false,
+	})
+	if err == nil {
+		logger.Debug("debug", nil)
+		logger.Info("info", map[string]interface{}{"key": "value"})
+		logger.Warn("warn", nil)
+		logger.Error("error", nil)
+		logger.Close()
+	}
+
+	os.Stdout = stdout
+	w.Close()
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+
+	out, _ := io.ReadAll(r)
+	if len(out) != 0 {
+		t.Fatalf("Expected no stdout output, got %q", out)
+	}
+}
+
+// TestLoggerName tests that the instance name is added to every entry
+func TestLoggerName(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.jsonl")
+	logger, err := NewLogger(config.LoggingConfig{
+		Level: "INFO",
+		File:  path,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	logger.SetName("hvac-sim-3")
+	logger.Info("one", nil)
+	logger.Warn("two", map[string]interface{}{"key": "value"})
+	logger.Close()
+
+	for _, entry := range readEntries(t, path) {
+		if entry.Name != "hvac-sim-3" {
+			t.Fatalf("Expected name hvac-sim-3 on %q, got %q", entry.Message, entry.Name)
+		}
+	}
+}
+
+// TestSyslog tests that entries reach a remote syslog with their severity
+func TestSyslog(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+
+	logger, err := NewLogger(config.LoggingConfig{
+		Level: "INFO",
+		Syslog: &config.SyslogConfig{
+			Network:  "udp",
+			Address:  conn.LocalAddr().String(),
+			Facility: "local3",
+			Tag:      "ezmodbus",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	defer logger.Close()
+	logger.SetName("sim-a")
+
+	logger.Warn("Register write rejected", map[string]interface{}{"address": 42})
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1024)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("No syslog message received: %v", err)
+	}
+	msg := string(buf[:n])
+
+	// local3 (19) * 8 + warning (4)
+	if !strings.HasPrefix(msg, "<156>") {
+		t.Fatalf("Expected priority <156>, got %q", msg)
+	}
+	for _, want := range []string{"ezmodbus", `sim-a Register write rejected {"address":42}`} {
+		if !strings.Contains(msg, want) {
+			t.Fatalf("Expected %q in syslog message %q", want, msg)
+		}
+	}
+}
+
+// TestSyslogUnreachable tests that an unreachable syslog doesn't prevent logging
+func TestSyslogUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	logFile := filepath.Join(t.TempDir(), "test.jsonl")
+	logger, err := NewLogger(config.LoggingConfig{
+		Level:  "INFO",
+		File:   logFile,
+		Syslog: &config.SyslogConfig{Network: "tcp", Address: addr},
+	})
+	if err != nil {
+		t.Fatalf("Expected logger despite unreachable syslog, got %v", err)
+	}
+	defer logger.Close()
+
+	logger.Info("Still logging", nil)
+
+	entries := readEntries(t, logFile)
+	if len(entries) != 2 || entries[0].Message != "Syslog unavailable, continuing without it" || entries[1].Message != "Still logging" {
+		t.Fatalf("Unexpected entries %+v", entries)
+	}
+}
+
+// TestSampleRate tests that only 1 in N DEBUG entries per message is written
+// and that the suppressed count is reported
+func TestSampleRate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.jsonl")
+	logger, err := NewLogger(config.LoggingConfig{
+		Level:      "DEBUG",
+		File:       path,
+		SampleRate: 10,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		logger.Debug("Holding registers handled", map[string]interface{}{"i": i})
+	}
+	logger.Debug("Client connected", nil)
+	logger.Info("Not sampled", nil)
+	logger.Info("Not sampled", nil)
+	logger.Close()
+
+	counts := make(map[string]int)
+	var report *LogEntry
+	for _, entry := range readEntries(t, path) {
+		counts[entry.Message]++
+		if entry.Message == "Debug entries suppressed by sampling" {
+			report = &entry
+		}
+	}
+
+	if n := counts["Holding registers handled"]; n < 90 || n > 110 {
+		t.Fatalf("Expected roughly 100 of 1000 entries written, got %d", n)
+	}
+	if counts["Client connected"] != 1 {
+		t.Fatalf("Expected the first entry of a message to be written, got %d", counts["Client connected"])
+	}
+	if counts["Not sampled"] != 2 {
+		t.Fatalf("Expected INFO entries not to be sampled, got %d", counts["Not sampled"])
+	}
+	if report == nil {
+		t.Fatal("Expected the suppressed entries to be reported on close")
+	}
+	if total := report.Data["total"]; total != float64(1000-counts["Holding registers handled"]) {
+		t.Fatalf("Expected the report to count the dropped entries, got %v", total)
+	}
+}
+
+// TestWithFields tests that a derived logger adds its fields to every entry
+func TestWithFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.jsonl")
+	logger, err := NewLogger(config.LoggingConfig{
+		Level: "INFO",
+		File:  path,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+
+	request := logger.WithFields(map[string]interface{}{"function": 3, "start": 100})
+	nested := request.WithFields(map[string]interface{}{"unit_id": 1})
+	nested.Info("nested", nil)
+	request.Warn("override", map[string]interface{}{"start": 101})
+	request.Debug("filtered", nil)
+	request.Close() // derived loggers don't own the file
+	logger.Info("plain", nil)
+	logger.Close()
+
+	entries := readEntries(t, path)
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(entries))
+	}
+
+	t.Run("Inherited", func(t *testing.T) {
+		data := entries[0].Data
+		if data["function"] != float64(3) || data["start"] != float64(100) || data["unit_id"] != float64(1) {
+			t.Fatalf("Expected function, start and unit_id on nested entry, got %v", data)
+		}
+	})
+
+	t.Run("EntryWins", func(t *testing.T) {
+		if v := entries[1].Data["start"]; v != float64(101) {
+			t.Fatalf("Expected entry data to override start, got %v", v)
+		}
+	})
+
+	t.Run("ParentUnchanged", func(t *testing.T) {
+		if len(entries[2].Data) != 0 {
+			t.Fatalf("Expected no fields on the parent logger, got %v", entries[2].Data)
+		}
+	})
+}
+
+// TestRotationUnderLoad tests that no entry is lost or split while the file
+// is rotated, by size and on request, under concurrent logging
+func TestRotationUnderLoad(t *testing.T) {
+	const goroutines, perGoroutine = 16, 500
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.jsonl")
+	logger, err := NewLogger(config.LoggingConfig{Level: "INFO", File: path})
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	logger.maxBytes = 8 << 10 // far below the 1 MB max_size_mb allows
+	request := logger.WithFields(map[string]interface{}{"unit_id": 1})
+
+	stop := make(chan struct{})
+	rotated := make(chan int)
+	go func() {
+		n := 0
+		for {
+			select {
+			case <-stop:
+				rotated <- n
+				return
+			default:
+			}
+			if err := logger.Rotate(); err != nil {
+				t.Errorf("Rotate failed: %v", err)
+			}
+			n++
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < perGoroutine; i++ {
+				request.Info("stress", map[string]interface{}{"goroutine": g, "i": i})
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(stop)
+	if n := <-rotated; n == 0 {
+		t.Fatal("Expected forced rotations to have run")
+	}
+	logger.Close()
+
+	files, err := filepath.Glob(path + "*")
+	if err != nil {
+		t.Fatalf("Failed to list log files: %v", err)
+	}
+	if len(files) < 3 {
+		t.Fatalf("Expected several rotated files, got %v", files)
+	}
+
+	seen := make(map[[2]int]bool)
+	for _, file := range files {
+		for _, entry := range readEntries(t, file) {
+			key := [2]int{int(entry.Data["goroutine"].(float64)), int(entry.Data["i"].(float64))}
+			if seen[key] {
+				t.Fatalf("Entry %v written twice", key)
+			}
+			seen[key] = true
+		}
+	}
+	if len(seen) != goroutines*perGoroutine {
+		t.Fatalf("Expected %d entries across %d files, got %d", goroutines*perGoroutine, len(files), len(seen))
+	}
+}
+
+// TestRotationBySize tests that the file is rotated before an entry would
+// take it past the size limit, keeping the entry whole in the new file
+func TestRotationBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.jsonl")
+	logger, err := NewLogger(config.LoggingConfig{Level: "INFO", File: path})
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	defer logger.Close()
+	logger.maxBytes = 300
+
+	for i := 0; i < 10; i++ {
+		logger.Info("entry", map[string]interface{}{"i": i, "padding": strings.Repeat("x", 50)})
+	}
+
+	files, _ := filepath.Glob(path + "*")
+	total := 0
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", file, err)
+		}
+		if info.Size() > 300 {
+			t.Errorf("Expected %s to stay within 300 bytes, got %d", file, info.Size())
+		}
+		total += len(readEntries(t, file))
+	}
+	if len(files) < 2 || total != 10 {
+		t.Fatalf("Expected 10 entries over several files, got %d over %d", total, len(files))
+	}
+}
+
+// TestRotationMaxBackups tests that rotation keeps only the newest
+// max_backups rotated files and leaves other files alone
+func TestRotationMaxBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.jsonl")
+	other := path + ".notes"
+	if err := os.WriteFile(other, nil, 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	logger, err := NewLogger(config.LoggingConfig{Level: "INFO", File: path, MaxBackups: 2})
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	defer logger.Close()
+
+	for i := 0; i < 5; i++ {
+		logger.Info("entry", map[string]interface{}{"i": i})
+		if err := logger.Rotate(); err != nil {
+			t.Fatalf("Rotate failed: %v", err)
+		}
+	}
+
+	rotated, _ := filepath.Glob(path + ".2*")
+	if len(rotated) != 2 {
+		t.Fatalf("Expected 2 rotated files, got %v", rotated)
+	}
+	for i, file := range rotated {
+		entries := readEntries(t, file)
+		if len(entries) != 1 || entries[0].Data["i"] != float64(3+i) {
+			t.Fatalf("Expected %s to hold entry %d, got %+v", file, 3+i, entries)
+		}
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Fatalf("Expected unrelated file to be kept, got %v", err)
+	}
+}
+
+// TestThrottle tests that a throttled logger writes at most the limit of
+// DEBUG and INFO entries per second, never holds back warnings and reports
+// what it suppressed
+func TestThrottle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.jsonl")
+	logger, err := NewLogger(config.LoggingConfig{Level: "DEBUG", File: path})
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+
+	throttle := NewThrottle(3, map[string]interface{}{"client": "192.0.2.1:5020"})
+	conn := logger.Throttled(throttle).WithFields(map[string]interface{}{"unit_id": 1})
+	for i := 0; i < 10; i++ {
+		conn.Debug("Holding registers handled", map[string]interface{}{"i": i})
+	}
+	conn.Warn("Request rejected", nil)
+	logger.Debug("Unthrottled", nil)
+	logger.EndThrottle(throttle)
+	logger.Close()
+
+	counts := make(map[string]int)
+	var report *LogEntry
+	for _, entry := range readEntries(t, path) {
+		counts[entry.Message]++
+		if entry.Message == "Log entries suppressed by throttle" {
+			report = &entry
+		}
+	}
+
+	if n := counts["Holding registers handled"]; n != 3 {
+		t.Fatalf("Expected 3 of 10 entries written, got %d", n)
+	}
+	if counts["Request rejected"] != 1 || counts["Unthrottled"] != 1 {
+		t.Fatalf("Expected warnings and other loggers unaffected, got %v", counts)
+	}
+	if report == nil {
+		t.Fatal("Expected the suppressed entries to be reported")
+	}
+	if report.Data["suppressed"] != float64(7) || report.Data["client"] != "192.0.2.1:5020" {
+		t.Fatalf("Expected 7 suppressed entries of the client, got %v", report.Data)
+	}
+}
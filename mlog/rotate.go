@@ -0,0 +1,125 @@
+// rotate.go - Size-based rotation of the log file
+package mlog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// openLogFile opens the log file for appending and returns its current size.
+func openLogFile(path string) (*os.File, int64, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, info.Size(), nil
+}
+
+// rotateIfFull rotates the file before an entry of n bytes would take it past
+// MaxSize. It must be called with l.mu held. A file holding a single entry
+// larger than MaxSize is not rotated again before the next one.
+func (l *Logger) rotateIfFull(n int) {
+	if l.maxBytes <= 0 || l.size == 0 || l.size+int64(n) <= l.maxBytes {
+		return
+	}
+	if err := l.rotate(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to rotate log file: %v\n", err)
+	}
+}
+
+// Rotate moves the current log file aside and starts a new one, e.g. on
+// request from an external log shipper. It does nothing without a log file.
+func (l *Logger) Rotate() error {
+	l = l.base()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	return l.rotate()
+}
+
+// rotate syncs and closes the log file, renames it to a timestamped name and
+// opens a new one, then removes rotated files beyond MaxBackups. It must be
+// called with l.mu held: holding the lock for the whole handover means no
+// entry is written to the closing file or lost between the two. If the
+// rename fails, logging continues in the old file.
+func (l *Logger) rotate() error {
+	l.file.Sync()
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %w", err)
+	}
+
+	renameErr := os.Rename(l.config.File, rotatedName(l.config.File, time.Now()))
+
+	file, size, err := openLogFile(l.config.File)
+	if err != nil {
+		l.file = nil
+		return fmt.Errorf("failed to reopen log file: %w", err)
+	}
+	l.file, l.size = file, size
+	if renameErr != nil {
+		return fmt.Errorf("failed to rename log file: %w", renameErr)
+	}
+	return pruneRotated(l.config.File, l.config.MaxBackups)
+}
+
+// pruneRotated removes the oldest rotated files of path until at most keep
+// are left. keep 0 keeps them all.
+func pruneRotated(path string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return fmt.Errorf("failed to list rotated log files: %w", err)
+	}
+
+	// Rotated names sort by rotation time
+	var rotated []string
+	for _, name := range matches {
+		stamp := strings.TrimPrefix(name, path+".")
+		if len(stamp) < len(rotatedLayout) {
+			continue
+		}
+		if _, err := time.Parse(rotatedLayout, stamp[:len(rotatedLayout)]); err == nil {
+			rotated = append(rotated, name)
+		}
+	}
+	sort.Strings(rotated)
+
+	for len(rotated) > keep {
+		if err := os.Remove(rotated[0]); err != nil {
+			return fmt.Errorf("failed to remove rotated log file: %w", err)
+		}
+		rotated = rotated[1:]
+	}
+	return nil
+}
+
+// rotatedLayout is the time format appended to rotated file names.
+const rotatedLayout = "20060102-150405.000000000"
+
+// rotatedName returns an unused name for a rotated log file: the path with
+// the rotation time appended, and a counter if that exists already.
+func rotatedName(path string, t time.Time) string {
+	name := path + "." + t.Format(rotatedLayout)
+	candidate := name
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+		candidate = name + "-" + strconv.Itoa(i)
+	}
+}
@@ -0,0 +1,83 @@
+// sample.go - Sampling of high-volume DEBUG entries
+package mlog
+
+import (
+	"sync"
+	"time"
+)
+
+// sampleReportInterval is how often the number of entries dropped by
+// sampling is logged.
+const sampleReportInterval = 30 * time.Second
+
+// sampler keeps 1 in rate DEBUG entries per message, always including the
+// first, and counts the ones it drops.
+type sampler struct {
+	rate       uint64
+	mu         sync.Mutex
+	seen       map[string]uint64
+	suppressed map[string]uint64
+	reported   time.Time
+}
+
+func newSampler(rate int) *sampler {
+	return &sampler{
+		rate:       uint64(rate),
+		seen:       make(map[string]uint64),
+		suppressed: make(map[string]uint64),
+		reported:   time.Now(),
+	}
+}
+
+// keep reports whether an entry with this message should be written.
+func (s *sampler) keep(message string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := s.seen[message]
+	s.seen[message] = n + 1
+	if n%s.rate == 0 {
+		return true
+	}
+	s.suppressed[message]++
+	return false
+}
+
+// take returns and resets the suppressed counts once sampleReportInterval has
+// passed since the last report, or right away with force.
+func (s *sampler) take(force bool) map[string]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.suppressed) == 0 || (!force && time.Since(s.reported) < sampleReportInterval) {
+		return nil
+	}
+	counts := s.suppressed
+	s.suppressed = make(map[string]uint64)
+	s.reported = time.Now()
+	return counts
+}
+
+// reportSuppressed logs how many DEBUG entries sampling dropped per message
+// since the last report.
+func (l *Logger) reportSuppressed(force bool) {
+	if l.sampler == nil {
+		return
+	}
+	counts := l.sampler.take(force)
+	if counts == nil {
+		return
+	}
+
+	suppressed := make(map[string]interface{}, len(counts))
+	var total uint64
+	for message, n := range counts {
+		suppressed[message] = n
+		total += n
+	}
+	l.write("INFO", "Debug entries suppressed by sampling", map[string]interface{}{
+		"sample_rate": l.sampler.rate,
+		"total":       total,
+		"suppressed":  suppressed,
+	})
+}
@@ -0,0 +1,103 @@
+// throttle.go - Rate limit on the entries of one source, e.g. a connection
+package mlog
+
+import (
+	"sync"
+	"time"
+)
+
+// throttleWindow is the period a Throttle's limit applies to.
+const throttleWindow = time.Second
+
+// Throttle lets through at most limit DEBUG and INFO entries per second from
+// the loggers it is attached to and counts the rest. Warnings and errors are
+// never throttled.
+type Throttle struct {
+	limit      int
+	fields     map[string]interface{} // identify the source in the summary
+	mu         sync.Mutex
+	window     time.Time
+	count      int
+	suppressed uint64
+	last       time.Time // of the last entry counted
+}
+
+// NewThrottle returns a throttle allowing limit entries per second. The
+// fields are added to the entry summarizing suppressed entries.
+func NewThrottle(limit int, fields map[string]interface{}) *Throttle {
+	return &Throttle{limit: limit, fields: fields, last: time.Now()}
+}
+
+// Last returns the time of the last entry counted against t, or of its
+// creation before the first.
+func (t *Throttle) Last() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.last
+}
+
+// allow reports whether an entry at now fits in the current window. When a
+// new window starts it also returns the number suppressed in the last one.
+func (t *Throttle) allow(now time.Time) (bool, uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.last = now
+	var suppressed uint64
+	if now.Sub(t.window) >= throttleWindow {
+		suppressed, t.suppressed = t.suppressed, 0
+		t.window, t.count = now, 0
+	}
+	if t.count >= t.limit {
+		t.suppressed++
+		return false, suppressed
+	}
+	t.count++
+	return true, suppressed
+}
+
+// take returns and resets the suppressed count.
+func (t *Throttle) take() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := t.suppressed
+	t.suppressed = 0
+	return n
+}
+
+// Throttled returns a logger like l whose DEBUG and INFO entries count
+// against t. It writes through l's root and must not be closed.
+func (l *Logger) Throttled(t *Throttle) *Logger {
+	return &Logger{root: l.base(), fields: l.fields, throttle: t}
+}
+
+// admit applies the throttle to an entry, first summarizing the entries
+// suppressed in the previous window.
+func (l *Logger) admit(level LogLevel) bool {
+	if l.throttle == nil || level >= WARN {
+		return true
+	}
+	ok, suppressed := l.throttle.allow(time.Now())
+	l.reportThrottled(suppressed)
+	return ok
+}
+
+// EndThrottle logs the entries t suppressed that have not been reported yet,
+// e.g. when the connection it limits closes.
+func (l *Logger) EndThrottle(t *Throttle) {
+	l.Throttled(t).reportThrottled(t.take())
+}
+
+func (l *Logger) reportThrottled(suppressed uint64) {
+	if suppressed == 0 {
+		return
+	}
+	data := make(map[string]interface{}, len(l.throttle.fields)+2)
+	for k, v := range l.throttle.fields {
+		data[k] = v
+	}
+	data["suppressed"] = suppressed
+	data["limit"] = l.throttle.limit
+	l.base().write("INFO", "Log entries suppressed by throttle", data)
+}
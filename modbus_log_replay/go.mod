@@ -0,0 +1,7 @@
+module modbus-log-replay
+
+go 1.24.4
+
+require github.com/simonvetter/modbus v1.6.3
+
+require github.com/goburrow/serial v0.1.0 // indirect
@@ -0,0 +1,168 @@
+// main.go - Replays register writes from a server log against a live server
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/simonvetter/modbus"
+)
+
+// logEntry is the subset of a server JSONL log line the replay needs.
+type logEntry struct {
+	Timestamp string                 `json:"timestamp"`
+	Message   string                 `json:"message"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// write is one replayable write event.
+type write struct {
+	at      time.Time
+	unitID  uint8
+	coil    bool
+	address uint16
+	value   uint16
+}
+
+// number reads a numeric field from decoded log data.
+func number(data map[string]interface{}, key string) (float64, bool) {
+	v, ok := data[key].(float64)
+	return v, ok
+}
+
+// parseWrite turns a log line into a write event. Lines that are not write
+// events are skipped with ok == false.
+func parseWrite(line []byte, defaultUnit uint8) (w write, ok bool, err error) {
+	var entry logEntry
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return w, false, fmt.Errorf("failed to parse log entry: %w", err)
+	}
+
+	switch entry.Message {
+	case "Register written":
+		v, found := number(entry.Data, "new")
+		if !found {
+			return w, false, fmt.Errorf("register write without a new value")
+		}
+		w.value = uint16(v)
+	case "Coil written":
+		v, found := entry.Data["value"].(bool)
+		if !found {
+			return w, false, fmt.Errorf("coil write without a value")
+		}
+		w.coil = true
+		if v {
+			w.value = 1
+		}
+	default:
+		return w, false, nil
+	}
+
+	addr, found := number(entry.Data, "address")
+	if !found {
+		return w, false, fmt.Errorf("write without an address")
+	}
+	w.address = uint16(addr)
+
+	w.unitID = defaultUnit
+	if unit, found := number(entry.Data, "unit_id"); found {
+		w.unitID = uint8(unit)
+	}
+
+	if w.at, err = time.Parse(time.RFC3339Nano, entry.Timestamp); err != nil {
+		return w, false, fmt.Errorf("failed to parse timestamp %q: %w", entry.Timestamp, err)
+	}
+	return w, true, nil
+}
+
+// readWrites loads every write event from the log file, in file order.
+func readWrites(path string, defaultUnit uint8) ([]write, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+	defer file.Close()
+
+	var writes []write
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		w, ok, err := parseWrite(scanner.Bytes(), defaultUnit)
+		if err != nil {
+			log.Printf("Skipping line %d: %v", lineNo, err)
+			continue
+		}
+		if ok {
+			writes = append(writes, w)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read log file: %w", err)
+	}
+	return writes, nil
+}
+
+func main() {
+	logFile := flag.String("log", "modbus_server.jsonl", "Server JSONL log to replay (must be logged at DEBUG)")
+	serverURL := flag.String("url", "tcp://localhost:1502", "Modbus server URL (e.g., tcp://127.0.0.1:1502)")
+	timing := flag.String("timing", "original", "Replay timing: \"original\" keeps the logged gaps, \"fast\" sends as fast as possible")
+	unitID := flag.Uint("unitID", 1, "Unit ID for log entries that don't record one")
+	flag.Parse()
+
+	if *timing != "original" && *timing != "fast" {
+		log.Fatalf("Invalid -timing %q (valid: original, fast)", *timing)
+	}
+
+	writes, err := readWrites(*logFile, uint8(*unitID))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if len(writes) == 0 {
+		log.Fatalf("No write events found in %s, was the server logging at DEBUG?", *logFile)
+	}
+	log.Printf("Replaying %d writes from %s to %s (%s timing)", len(writes), *logFile, *serverURL, *timing)
+
+	client, err := modbus.NewClient(&modbus.ClientConfiguration{
+		URL:     *serverURL,
+		Timeout: 2 * time.Second,
+	})
+	if err != nil {
+		log.Fatalf("Failed to create client: %v", err)
+	}
+	if err := client.Open(); err != nil {
+		log.Fatalf("Failed to connect to %s: %v", *serverURL, err)
+	}
+	defer client.Close()
+
+	var failures int
+	start := time.Now()
+	for i, w := range writes {
+		if *timing == "original" && i > 0 {
+			time.Sleep(w.at.Sub(writes[i-1].at))
+		}
+
+		client.SetUnitId(w.unitID)
+		if w.coil {
+			err = client.WriteCoil(w.address, w.value == 1)
+		} else {
+			err = client.WriteRegister(w.address, w.value)
+		}
+		if err != nil {
+			failures++
+			log.Printf("Write %d (unit %d, address %d) failed: %v", i+1, w.unitID, w.address, err)
+		}
+	}
+
+	log.Printf("Replay finished in %s: %d writes, %d failed", time.Since(start).Round(time.Millisecond), len(writes), failures)
+	if failures > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,88 @@
+// byteorder.go - Detection of the word and byte order a server reads back in
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/simonvetter/modbus"
+)
+
+// probeFloat is written for detection. Its four bytes all differ, so each
+// ordering decodes it to a different value.
+const probeFloat float32 = 123.456
+
+// byteOrder maps the two registers read back to the four bytes of a float32,
+// most significant first.
+type byteOrder struct {
+	name   string
+	detail string
+	decode func(hi, lo uint16) uint32
+}
+
+var byteOrders = []byteOrder{
+	{"ABCD", "big-endian, high word first", func(hi, lo uint16) uint32 {
+		return uint32(hi)<<16 | uint32(lo)
+	}},
+	{"CDAB", "word swap, low word first", func(hi, lo uint16) uint32 {
+		return uint32(lo)<<16 | uint32(hi)
+	}},
+	{"BADC", "byte swap within each register", func(hi, lo uint16) uint32 {
+		return uint32(swap16(hi))<<16 | uint32(swap16(lo))
+	}},
+	{"DCBA", "little-endian, word and byte swap", func(hi, lo uint16) uint32 {
+		return uint32(swap16(lo))<<16 | uint32(swap16(hi))
+	}},
+}
+
+func swap16(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+// detectByteOrder writes probeFloat high word first to addr and addr+1,
+// reads the pair back and prints which ordering decodes it.
+func detectByteOrder(url string, unitID uint8, addr uint16) error {
+	client, err := modbus.NewClient(&modbus.ClientConfiguration{
+		URL:     url,
+		Timeout: 5 * time.Second,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create client: %w", err)
+	}
+	if err := client.Open(); err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", url, err)
+	}
+	defer client.Close()
+	client.SetUnitId(unitID)
+
+	bits := math.Float32bits(probeFloat)
+	if err := client.WriteRegisters(addr, []uint16{uint16(bits >> 16), uint16(bits)}); err != nil {
+		return fmt.Errorf("failed to write registers %d-%d: %w", addr, addr+1, err)
+	}
+	regs, err := client.ReadRegisters(addr, 2, modbus.HOLDING_REGISTER)
+	if err != nil {
+		return fmt.Errorf("failed to read registers %d-%d: %w", addr, addr+1, err)
+	}
+
+	log.Printf("Wrote %g as %04X %04X to registers %d-%d, read back %04X %04X", probeFloat, uint16(bits>>16), uint16(bits), addr, addr+1, regs[0], regs[1])
+	var detected *byteOrder
+	for i, order := range byteOrders {
+		value := math.Float32frombits(order.decode(regs[0], regs[1]))
+		if value != probeFloat {
+			log.Printf("  %s (%s): %g", order.name, order.detail, value)
+			continue
+		}
+		log.Printf("  %s (%s): %g <- round-trips", order.name, order.detail, value)
+		if detected == nil {
+			detected = &byteOrders[i]
+		}
+	}
+
+	if detected == nil {
+		return fmt.Errorf("no ordering round-trips, is register %d writable and not computed?", addr)
+	}
+	log.Printf("Detected order: %s (%s)", detected.name, detected.detail)
+	return nil
+}
@@ -6,9 +6,15 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"math/rand/v2"
 	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/simonvetter/modbus"
@@ -19,6 +25,113 @@ var stats struct {
 	failures  atomic.Uint64
 }
 
+// unitResult tracks probe outcomes for a single unit ID. The map is built
+// before any client starts and is only read afterwards.
+type unitResult struct {
+	expectValid bool
+	passes      atomic.Uint64
+	fails       atomic.Uint64
+}
+
+var unitResults = map[uint8]*unitResult{}
+
+// sharedPool directs every client at the same small set of registers to
+// create lock contention. Each slot is a pair of registers written in one
+// request as a value and its complement, so a read that observes a torn
+// write fails the check. The pool is built before any client starts and
+// only its counters change afterwards; nil means the pool is disabled.
+type sharedPool struct {
+	base      uint16
+	readRatio float64
+	ops       int
+	slots     []*slotResult
+}
+
+type slotResult struct {
+	reads  atomic.Uint64
+	writes atomic.Uint64
+	fails  atomic.Uint64
+}
+
+var pool *sharedPool
+
+func newSharedPool(base uint16, size int, readRatio float64, ops int) *sharedPool {
+	p := &sharedPool{base: base, readRatio: readRatio, ops: ops}
+	for i := 0; i < size; i++ {
+		p.slots = append(p.slots, &slotResult{})
+	}
+	return p
+}
+
+func (p *sharedPool) addr(slot int) uint16 {
+	return p.base + uint16(2*slot)
+}
+
+// run performs ops random reads and writes against the pool.
+func (p *sharedPool) run(l *log.Logger, client *modbus.ModbusClient) {
+	for i := 0; i < p.ops; i++ {
+		slot := rand.IntN(len(p.slots))
+		addr := p.addr(slot)
+		result := p.slots[slot]
+
+		if rand.Float64() >= p.readRatio {
+			value := uint16(rand.Uint32())
+			if err := client.WriteRegisters(addr, []uint16{value, ^value}); err != nil {
+				l.Printf("FAIL: Could not write shared register %d: %v", addr, err)
+				stats.failures.Add(1)
+				result.fails.Add(1)
+				continue
+			}
+			stats.successes.Add(1)
+			result.writes.Add(1)
+			continue
+		}
+
+		regs, err := client.ReadRegisters(addr, 2, modbus.HOLDING_REGISTER)
+		switch {
+		case err != nil:
+			l.Printf("FAIL: Could not read shared register %d: %v", addr, err)
+		case regs[0] == 0 && regs[1] == 0, regs[1] == ^regs[0]:
+			// Never written yet, or a complete write
+			stats.successes.Add(1)
+			result.reads.Add(1)
+			continue
+		default:
+			l.Printf("FAIL: Torn read at shared register %d: %d and %d are not complements", addr, regs[0], regs[1])
+		}
+		stats.failures.Add(1)
+		result.fails.Add(1)
+	}
+}
+
+func (p *sharedPool) printSummary() {
+	log.Println("Shared pool results:")
+	for i, r := range p.slots {
+		status := "PASS"
+		if r.fails.Load() > 0 {
+			status = "FAIL"
+		}
+		log.Printf("  Registers %5d-%-5d: %s - %d reads, %d writes, %d failed", p.addr(i), p.addr(i)+1, status, r.reads.Load(), r.writes.Load(), r.fails.Load())
+	}
+}
+
+// parseUnitIDs parses a comma-separated list of unit IDs.
+func parseUnitIDs(list string) ([]uint8, error) {
+	var ids []uint8
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(field, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid unit ID %q: %w", field, err)
+		}
+		ids = append(ids, uint8(id))
+	}
+	return ids, nil
+}
+
 func main() {
 	serverURL := flag.String("url", "tcp://localhost:1502", "Modbus server URL (e.g., tcp://127.0.0.1:1502)")
 	unitID := flag.Uint("unitID", 1, "The correct Modbus Unit ID of the server")
@@ -26,11 +139,84 @@ func main() {
 	runDuration := flag.Duration("duration", 30*time.Second, "How long to run the test for")
 	requestsPerSec := flag.Int("rate", 10, "Requests per second for each client")
 	counterAddr := flag.Uint("counterAddr", 102, "Address of the server's auto-incrementing counter")
+	validUnits := flag.String("validUnits", "", "Comma-separated unit IDs expected to respond (defaults to -unitID)")
+	invalidUnits := flag.String("invalidUnits", "99", "Comma-separated unit IDs expected to be rejected")
+	poolSize := flag.Int("poolSize", 0, "Direct all clients at this many shared register pairs instead of one address each (0 disables)")
+	poolBase := flag.Uint("poolBase", 200, "First holding register of the shared pool")
+	readRatio := flag.Float64("readRatio", 0.8, "Fraction of shared pool operations that are reads (0-1)")
+	poolOps := flag.Int("poolOps", 10, "Shared pool operations per test sequence")
+	captureFile := flag.String("capture", "", "Record the requests of a real master into this JSONL file, proxying -listen to -device, instead of testing")
+	listenAddr := flag.String("listen", ":1503", "Address masters connect to in capture mode")
+	device := flag.String("device", "", "Device the capture proxy forwards to (e.g., tcp://192.168.1.50:502)")
+	replayFile := flag.String("replay", "", "Replay a recorded JSONL profile against -url instead of testing")
+	speed := flag.Float64("speed", 1, "Replay speed multiple (2 replays twice as fast)")
+	detectOrder := flag.Bool("detectOrder", false, "Detect the server's float32 word and byte order instead of testing")
+	orderAddr := flag.Uint("orderAddr", 400, "First of the two holding registers -detectOrder writes")
 	flag.Parse()
 
+	if *detectOrder {
+		if err := detectByteOrder(*serverURL, uint8(*unitID), uint16(*orderAddr)); err != nil {
+			log.Fatalf("Byte order detection failed: %v", err)
+		}
+		return
+	}
+
+	if *captureFile != "" {
+		if *device == "" {
+			log.Fatalf("-capture needs -device")
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := runCapture(ctx, *listenAddr, strings.TrimPrefix(*device, "tcp://"), *captureFile); err != nil {
+			log.Fatalf("Capture failed: %v", err)
+		}
+		return
+	}
+	if *replayFile != "" {
+		if *speed <= 0 {
+			log.Fatalf("-speed must be above 0")
+		}
+		if err := runReplay(*replayFile, strings.TrimPrefix(*serverURL, "tcp://"), *speed); err != nil {
+			log.Fatalf("Replay failed: %v", err)
+		}
+		return
+	}
+
+	if *poolSize < 0 || *readRatio < 0 || *readRatio > 1 || *poolOps < 1 {
+		log.Fatalf("Bad shared pool settings: -poolSize must not be negative, -readRatio must be 0-1 and -poolOps at least 1")
+	}
+	if *poolSize > 0 {
+		pool = newSharedPool(uint16(*poolBase), *poolSize, *readRatio, *poolOps)
+	}
+
+	valid, err := parseUnitIDs(*validUnits)
+	if err != nil {
+		log.Fatalf("Bad -validUnits: %v", err)
+	}
+	if len(valid) == 0 {
+		valid = []uint8{uint8(*unitID)}
+	}
+	invalid, err := parseUnitIDs(*invalidUnits)
+	if err != nil {
+		log.Fatalf("Bad -invalidUnits: %v", err)
+	}
+	for _, id := range valid {
+		unitResults[id] = &unitResult{expectValid: true}
+	}
+	for _, id := range invalid {
+		if _, dup := unitResults[id]; dup {
+			log.Fatalf("Unit ID %d listed as both valid and invalid", id)
+		}
+		unitResults[id] = &unitResult{expectValid: false}
+	}
+
 	log.Printf("Starting Modbus stress test...")
 	log.Printf("Target: %s, UnitID: %d, Concurrent Clients: %d", *serverURL, *unitID, *numClients)
 	log.Printf("Test Duration: %v, Request Rate: %d/sec per client", *runDuration, *requestsPerSec)
+	log.Printf("Valid Unit IDs: %v, Invalid Unit IDs: %v", valid, invalid)
+	if pool != nil {
+		log.Printf("Shared pool: %d register pairs from %d, read ratio %.2f, %d ops per sequence", *poolSize, *poolBase, *readRatio, *poolOps)
+	}
 	log.Println("--------------------------------------------------")
 
 	var wg sync.WaitGroup
@@ -46,6 +232,32 @@ func main() {
 
 	log.Println("--------------------------------------------------")
 	log.Printf("Test finished. Total Successes: %d, Total Failures: %d\n", stats.successes.Load(), stats.failures.Load())
+	printUnitSummary()
+	if pool != nil {
+		pool.printSummary()
+	}
+}
+
+func printUnitSummary() {
+	ids := make([]int, 0, len(unitResults))
+	for id := range unitResults {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+
+	log.Println("Per-unit results:")
+	for _, id := range ids {
+		r := unitResults[uint8(id)]
+		expect := "invalid"
+		if r.expectValid {
+			expect = "valid"
+		}
+		status := "PASS"
+		if r.fails.Load() > 0 {
+			status = "FAIL"
+		}
+		log.Printf("  Unit %3d (%s): %s - %d passed, %d failed", id, expect, status, r.passes.Load(), r.fails.Load())
+	}
 }
 
 func runTestClient(ctx context.Context, wg *sync.WaitGroup, clientID int, url string, unitID uint8, rate int, counterAddr uint16) {
@@ -87,26 +299,15 @@ func runTestClient(ctx context.Context, wg *sync.WaitGroup, clientID int, url st
 func runTestSequence(l *log.Logger, client *modbus.ModbusClient, unitID uint8, clientID int, counterAddr uint16) {
 	client.SetUnitId(unitID)
 
-	// Test 1: Data Integrity (Write then Read)
-	testAddr := uint16(200 + clientID)
-	testValue := uint16(1000 + clientID)
-	err := client.WriteRegister(testAddr, testValue)
-	if err == nil {
-		stats.successes.Add(1)
-		readVal, err_read := client.ReadRegister(testAddr, modbus.HOLDING_REGISTER)
-		if err_read == nil && readVal == testValue {
-			stats.successes.Add(1)
-		} else {
-			l.Printf("FAIL: Data integrity check failed. Wrote %d, but read %d. Error: %v", testValue, readVal, err_read)
-			stats.failures.Add(1)
-		}
+	// Test 1: Data Integrity (Write then Read), on the shared pool if enabled
+	if pool != nil {
+		pool.run(l, client)
 	} else {
-		l.Printf("FAIL: Could not write to register %d: %v", testAddr, err)
-		stats.failures.Add(1)
+		runOwnAddressCheck(l, client, clientID)
 	}
 
 	// Test 2: Protected Register
-	err = client.WriteRegister(counterAddr, 9999)
+	err := client.WriteRegister(counterAddr, 9999)
 	if err == nil {
 		stats.successes.Add(1)
 		val, err_read := client.ReadRegister(counterAddr, modbus.HOLDING_REGISTER)
@@ -132,14 +333,22 @@ func runTestSequence(l *log.Logger, client *modbus.ModbusClient, unitID uint8, c
 		stats.failures.Add(1)
 	}
 
-	// Test 4: Invalid Unit ID
-	client.SetUnitId(99)
-	_, err = client.ReadRegister(100, modbus.HOLDING_REGISTER)
-	if err != nil {
-		stats.successes.Add(1)
-	} else {
-		l.Printf("FAIL: Invalid Unit ID test failed. Server did not return an error.")
-		stats.failures.Add(1)
+	// Test 4: Unit ID probing
+	for id, result := range unitResults {
+		client.SetUnitId(id)
+		_, err = client.ReadRegister(100, modbus.HOLDING_REGISTER)
+		if (err == nil) == result.expectValid {
+			stats.successes.Add(1)
+			result.passes.Add(1)
+		} else if result.expectValid {
+			l.Printf("FAIL: Valid Unit ID %d was rejected: %v", id, err)
+			stats.failures.Add(1)
+			result.fails.Add(1)
+		} else {
+			l.Printf("FAIL: Invalid Unit ID %d test failed. Server did not return an error.", id)
+			stats.failures.Add(1)
+			result.fails.Add(1)
+		}
 	}
 	client.SetUnitId(unitID)
 
@@ -152,3 +361,24 @@ func runTestSequence(l *log.Logger, client *modbus.ModbusClient, unitID uint8, c
 		stats.failures.Add(1)
 	}
 }
+
+// runOwnAddressCheck writes a value to the client's own register and reads
+// it back.
+func runOwnAddressCheck(l *log.Logger, client *modbus.ModbusClient, clientID int) {
+	testAddr := uint16(200 + clientID)
+	testValue := uint16(1000 + clientID)
+	err := client.WriteRegister(testAddr, testValue)
+	if err == nil {
+		stats.successes.Add(1)
+		readVal, err_read := client.ReadRegister(testAddr, modbus.HOLDING_REGISTER)
+		if err_read == nil && readVal == testValue {
+			stats.successes.Add(1)
+		} else {
+			l.Printf("FAIL: Data integrity check failed. Wrote %d, but read %d. Error: %v", testValue, readVal, err_read)
+			stats.failures.Add(1)
+		}
+	} else {
+		l.Printf("FAIL: Could not write to register %d: %v", testAddr, err)
+		stats.failures.Add(1)
+	}
+}
@@ -0,0 +1,314 @@
+// profile.go - Recording a master's traffic and replaying it as a load profile
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// profileRequest is one request of a recorded profile, stored as a JSONL
+// line. Address and quantity are decoded for the read and write function
+// codes and left 0 for any other; PDU holds the whole request for replay.
+type profileRequest struct {
+	Offset   time.Duration `json:"offset_ns"` // since the capture started
+	Conn     int           `json:"conn"`      // master connection, numbered from 1
+	UnitID   uint8         `json:"unit_id"`
+	Function uint8         `json:"function"`
+	Address  uint16        `json:"address"`
+	Quantity uint16        `json:"quantity"`
+	PDU      string        `json:"pdu"` // hex
+}
+
+// maxFrameLength is the largest MBAP length field a valid frame can carry:
+// the unit ID plus a 253-byte PDU.
+const maxFrameLength = 254
+
+// readFrame reads one Modbus/TCP frame and returns the MBAP header and the
+// PDU separately.
+func readFrame(r io.Reader) (header [7]byte, pdu []byte, err error) {
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		return header, nil, err
+	}
+	length := binary.BigEndian.Uint16(header[4:6])
+	if length < 2 || length > maxFrameLength {
+		return header, nil, fmt.Errorf("invalid frame length %d", length)
+	}
+	pdu = make([]byte, length-1)
+	if _, err = io.ReadFull(r, pdu); err != nil {
+		return header, nil, err
+	}
+	return header, pdu, nil
+}
+
+// decodeRequest fills in the function code, address and quantity of a
+// request PDU.
+func decodeRequest(req *profileRequest, pdu []byte) {
+	req.Function = pdu[0]
+	if len(pdu) < 5 {
+		return
+	}
+	req.Address = binary.BigEndian.Uint16(pdu[1:3])
+	switch req.Function {
+	case 0x01, 0x02, 0x03, 0x04, 0x0f, 0x10:
+		req.Quantity = binary.BigEndian.Uint16(pdu[3:5])
+	case 0x05, 0x06:
+		req.Quantity = 1
+	default:
+		req.Address = 0
+	}
+}
+
+// profileWriter appends requests to a capture file from every proxied
+// connection.
+type profileWriter struct {
+	mu    sync.Mutex
+	out   *bufio.Writer
+	start time.Time
+	count int
+}
+
+func (w *profileWriter) write(req profileRequest) error {
+	line, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.count++
+	if _, err := w.out.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return w.out.Flush()
+}
+
+// runCapture proxies masters connecting to listenAddr through to the device
+// and records every request to path until ctx is cancelled.
+func runCapture(ctx context.Context, listenAddr, device, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create capture file: %w", err)
+	}
+	defer file.Close()
+
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", listenAddr, err)
+	}
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	w := &profileWriter{out: bufio.NewWriter(file), start: time.Now()}
+	log.Printf("Capturing from %s to %s into %s, stop with Ctrl+C", listenAddr, device, path)
+
+	var wg sync.WaitGroup
+	for conn := 1; ; conn++ {
+		master, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			proxyConn(ctx, master, device, conn, w)
+		}()
+	}
+	wg.Wait()
+
+	log.Printf("Capture finished: %d requests recorded in %s", w.count, path)
+	return nil
+}
+
+// proxyConn forwards one master connection to the device, recording each
+// request on the way. Responses are passed back unchanged.
+func proxyConn(ctx context.Context, master net.Conn, device string, conn int, w *profileWriter) {
+	defer master.Close()
+
+	target, err := net.DialTimeout("tcp", device, 5*time.Second)
+	if err != nil {
+		log.Printf("Connection %d: failed to connect to device: %v", conn, err)
+		return
+	}
+	defer target.Close()
+	log.Printf("Connection %d: %s connected", conn, master.RemoteAddr())
+
+	go func() {
+		<-ctx.Done()
+		master.Close()
+		target.Close()
+	}()
+	go func() {
+		io.Copy(master, target)
+		master.Close()
+	}()
+
+	for {
+		header, pdu, err := readFrame(master)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && ctx.Err() == nil {
+				log.Printf("Connection %d: %v", conn, err)
+			}
+			return
+		}
+
+		req := profileRequest{
+			Offset: time.Since(w.start),
+			Conn:   conn,
+			UnitID: header[6],
+			PDU:    hex.EncodeToString(pdu),
+		}
+		decodeRequest(&req, pdu)
+		if err := w.write(req); err != nil {
+			log.Printf("Connection %d: failed to record request: %v", conn, err)
+		}
+
+		if _, err := target.Write(append(header[:], pdu...)); err != nil {
+			log.Printf("Connection %d: failed to forward request: %v", conn, err)
+			return
+		}
+	}
+}
+
+// readProfile loads a capture file, grouped by master connection in the
+// recorded order.
+func readProfile(path string) (map[int][]profileRequest, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open capture file: %w", err)
+	}
+	defer file.Close()
+
+	conns := map[int][]profileRequest{}
+	total := 0
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var req profileRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			return nil, 0, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if _, err := hex.DecodeString(req.PDU); err != nil || req.PDU == "" {
+			return nil, 0, fmt.Errorf("line %d: invalid pdu %q", lineNo, req.PDU)
+		}
+		conns[req.Conn] = append(conns[req.Conn], req)
+		total++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to read capture file: %w", err)
+	}
+	return conns, total, nil
+}
+
+// replayStats counts the outcome of replayed requests.
+type replayStats struct {
+	sent       atomic.Uint64
+	exceptions atomic.Uint64
+	failures   atomic.Uint64
+	latency    atomic.Int64 // total, nanoseconds
+}
+
+// runReplay sends a recorded profile to addr, one connection per recorded
+// master connection, keeping the recorded gaps divided by speed.
+func runReplay(path, addr string, speed float64) error {
+	conns, total, err := readProfile(path)
+	if err != nil {
+		return err
+	}
+	if total == 0 {
+		return fmt.Errorf("no requests found in %s", path)
+	}
+	log.Printf("Replaying %d requests on %d connections from %s to %s at %gx speed", total, len(conns), path, addr, speed)
+
+	ids := make([]int, 0, len(conns))
+	for id := range conns {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	var stats replayStats
+	var wg sync.WaitGroup
+	start := time.Now()
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id int, reqs []profileRequest) {
+			defer wg.Done()
+			replayConn(addr, id, reqs, start, speed, &stats)
+		}(id, conns[id])
+	}
+	wg.Wait()
+
+	sent := stats.sent.Load()
+	var avg time.Duration
+	if sent > 0 {
+		avg = time.Duration(stats.latency.Load() / int64(sent))
+	}
+	log.Printf("Replay finished in %s: %d sent, %d exception responses, %d failed, average latency %s",
+		time.Since(start).Round(time.Millisecond), sent, stats.exceptions.Load(), stats.failures.Load(), avg)
+	if stats.failures.Load() > 0 {
+		return fmt.Errorf("%d requests failed", stats.failures.Load())
+	}
+	return nil
+}
+
+// replayConn replays the requests of one recorded connection.
+func replayConn(addr string, id int, reqs []profileRequest, start time.Time, speed float64, stats *replayStats) {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		log.Printf("Connection %d: failed to connect: %v", id, err)
+		stats.failures.Add(uint64(len(reqs)))
+		return
+	}
+	defer conn.Close()
+
+	for i, req := range reqs {
+		if wait := time.Until(start.Add(time.Duration(float64(req.Offset) / speed))); wait > 0 {
+			time.Sleep(wait)
+		}
+
+		pdu, _ := hex.DecodeString(req.PDU)
+		frame := make([]byte, 7, 7+len(pdu))
+		binary.BigEndian.PutUint16(frame[0:2], uint16(i+1))
+		binary.BigEndian.PutUint16(frame[4:6], uint16(len(pdu)+1))
+		frame[6] = req.UnitID
+		frame = append(frame, pdu...)
+
+		sent := time.Now()
+		conn.SetDeadline(sent.Add(5 * time.Second))
+		if _, err := conn.Write(frame); err != nil {
+			log.Printf("Connection %d: failed to send request %d: %v", id, i+1, err)
+			stats.failures.Add(uint64(len(reqs) - i))
+			return
+		}
+		_, res, err := readFrame(conn)
+		if err != nil {
+			log.Printf("Connection %d: no response to request %d: %v", id, i+1, err)
+			stats.failures.Add(uint64(len(reqs) - i))
+			return
+		}
+		stats.latency.Add(int64(time.Since(sent)))
+		stats.sent.Add(1)
+		if res[0]&0x80 != 0 {
+			stats.exceptions.Add(1)
+		}
+	}
+}
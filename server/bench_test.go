@@ -0,0 +1,123 @@
+// bench_test.go - End-to-end throughput benchmarks over real connections
+package server
+
+import (
+	"SPModbus/config"
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/simonvetter/modbus"
+)
+
+// Run with e.g. go test ./server -run '^$' -bench Network -bench.clients 32 -benchtime 5s
+var benchClients = flag.Int("bench.clients", 8, "concurrent clients for network benchmarks")
+
+// startBenchServer starts a server on a free loopback port and returns its URL.
+func startBenchServer(b *testing.B, clients int) string {
+	b.Helper()
+
+	s := newTestServer(b, &config.Config{
+		Server: config.ServerConfig{
+			Address:    "127.0.0.1",
+			Port:       0,
+			MaxClients: uint(clients),
+			MaxRetries: 1,
+		},
+		Modbus: config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   1000,
+			CounterAddress: 10,
+			UpdateInterval: config.Duration(time.Second),
+		},
+	})
+	if err := s.Start(context.Background()); err != nil {
+		b.Fatalf("Failed to start server: %v", err)
+	}
+	b.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.Stop(ctx, Shutdown{Reason: ReasonContextCancelled, Detail: "benchmark done"})
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return "tcp://" + s.listeners[0].Addr().String()
+}
+
+// BenchmarkNetworkHoldingRead measures end-to-end holding register reads from
+// concurrent clients, each on its own connection. b.N requests are split
+// across the clients; -benchtime sets how long it runs. Reports throughput
+// and per-request latency.
+func BenchmarkNetworkHoldingRead(b *testing.B) {
+	clients := *benchClients
+	if clients < 1 {
+		b.Fatalf("bench.clients must be at least 1, got %d", clients)
+	}
+	url := startBenchServer(b, clients)
+
+	conns := make([]*modbus.ModbusClient, clients)
+	for i := range conns {
+		client, err := modbus.NewClient(&modbus.ClientConfiguration{URL: url, Timeout: 5 * time.Second})
+		if err != nil {
+			b.Fatalf("Failed to create client: %v", err)
+		}
+		if err := client.Open(); err != nil {
+			b.Fatalf("Failed to connect client %d: %v", i, err)
+		}
+		defer client.Close()
+		conns[i] = client
+	}
+
+	latencies := make([][]time.Duration, clients)
+	errs := make([]error, clients)
+	var wg sync.WaitGroup
+
+	b.ResetTimer()
+	start := time.Now()
+	for i, client := range conns {
+		quota := b.N / clients
+		if i < b.N%clients {
+			quota++
+		}
+
+		wg.Add(1)
+		go func(i int, client *modbus.ModbusClient, quota int) {
+			defer wg.Done()
+			for n := 0; n < quota; n++ {
+				t := time.Now()
+				if _, err := client.ReadRegisters(100, 10, modbus.HOLDING_REGISTER); err != nil {
+					errs[i] = fmt.Errorf("client %d: %w", i, err)
+					return
+				}
+				latencies[i] = append(latencies[i], time.Since(t))
+			}
+		}(i, client, quota)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	b.StopTimer()
+
+	for _, err := range errs {
+		if err != nil {
+			b.Fatalf("Read failed: %v", err)
+		}
+	}
+
+	var all []time.Duration
+	for _, l := range latencies {
+		all = append(all, l...)
+	}
+	if len(all) == 0 {
+		return
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+
+	b.ReportMetric(float64(len(all))/elapsed.Seconds(), "req/s")
+	b.ReportMetric(float64(all[len(all)/2].Microseconds()), "p50-µs")
+	b.ReportMetric(float64(all[len(all)*99/100].Microseconds()), "p99-µs")
+}
@@ -0,0 +1,70 @@
+// capabilities.go - Self-description of the running simulator
+package server
+
+import (
+	"SPModbus/admin"
+	"slices"
+	"sort"
+)
+
+// Capabilities reports the features the active configuration enables, for
+// tooling that discovers what the simulator supports.
+func (s *ModbusServer) Capabilities() admin.Capabilities {
+	cfg := s.config
+	replica := s.replica != nil
+
+	fcs := []uint8{fcReadCoils, fcReadDiscreteInputs, fcReadHoldingRegisters, fcReadInputRegisters, fcDiagnostics}
+	if !replica {
+		fcs = append(fcs, fcWriteSingleCoil, fcWriteSingleRegister, fcWriteMultipleCoils, fcWriteMultipleRegisters)
+	}
+	deviceID := cfg.Modbus.VendorName != "" || cfg.Modbus.ProductCode != "" || cfg.Modbus.Revision != ""
+	if deviceID {
+		fcs = append(fcs, fcEncapsulatedInterface)
+	}
+	codes := make([]int, 0, len(fcs))
+	for _, fc := range fcs {
+		if !slices.Contains(cfg.Server.UnsupportedFunctions, int(fc)) {
+			codes = append(codes, int(fc))
+		}
+	}
+	sort.Ints(codes)
+
+	units := make([]int, 0, len(s.units))
+	for id := range s.units {
+		units = append(units, int(id))
+	}
+	sort.Ints(units)
+
+	faults := []string{}
+	for name, enabled := range map[string]bool{
+		"write_delay":                len(cfg.Modbus.DelayedRegisters) > 0,
+		"exception_map":              len(cfg.Modbus.ExceptionMap) > 0,
+		"request_timeout":            cfg.Server.RequestTimeout > 0,
+		"max_connection_duration":    cfg.Server.MaxConnectionDuration > 0,
+		"max_lifetime":               cfg.Server.MaxLifetime > 0,
+		"max_concurrent_requests":    cfg.Modbus.MaxConcurrentRequests > 0,
+		"global_rate_limit":          cfg.Modbus.GlobalRateLimit > 0,
+		"reject_uninitialized_reads": cfg.Modbus.RejectUninitializedReads,
+	} {
+		if enabled {
+			faults = append(faults, name)
+		}
+	}
+	sort.Strings(faults)
+
+	return admin.Capabilities{
+		FunctionCodes:        codes,
+		Units:                units,
+		MultiUnit:            len(units) > 1,
+		TLS:                  false,
+		Persistence:          cfg.Modbus.StateFile != "",
+		Simulations:          len(cfg.Modbus.Simulations),
+		FaultInjection:       faults,
+		DeviceIdentification: deviceID,
+		ReadOnly:             replica,
+		Standby:              cfg.Standby.Enabled,
+		Replica:              replica,
+		Syslog:               cfg.Logging.Syslog != nil,
+		WebSocket:            cfg.Admin.Enabled && cfg.Admin.WebSocketPath != "",
+	}
+}
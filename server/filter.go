@@ -0,0 +1,60 @@
+// filter.go - Client IP allow/deny filtering
+package server
+
+import (
+	"SPModbus/config"
+	"net"
+	"net/netip"
+)
+
+// ipFilter decides whether a client may connect. A deny match always rejects;
+// when an allow list is configured the client must also match it.
+type ipFilter struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// newIPFilter builds a filter from the server config. The prefixes were
+// checked by Config.Validate, so parse errors only occur for configs built in
+// code and are treated as an empty list.
+func newIPFilter(cfg config.ServerConfig) *ipFilter {
+	allow, _ := config.ParsePrefixes(cfg.AllowCIDRs)
+	deny, _ := config.ParsePrefixes(cfg.DenyCIDRs)
+	return &ipFilter{allow: allow, deny: deny}
+}
+
+func (f *ipFilter) allowed(addr net.Addr) bool {
+	if len(f.allow) == 0 && len(f.deny) == 0 {
+		return true
+	}
+
+	var ipAddr net.IP
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ipAddr = a.IP
+	case *net.UDPAddr:
+		ipAddr = a.IP
+	default:
+		return false
+	}
+	ip, ok := netip.AddrFromSlice(ipAddr)
+	if !ok {
+		return false
+	}
+	ip = ip.Unmap()
+
+	for _, p := range f.deny {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, p := range f.allow {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
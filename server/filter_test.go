@@ -0,0 +1,66 @@
+// filter_test.go - Client IP filter tests
+package server
+
+import (
+	"SPModbus/config"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestIPFilter tests allow and deny list matching
+func TestIPFilter(t *testing.T) {
+	tests := []struct {
+		name  string
+		allow []string
+		deny  []string
+		ip    string
+		want  bool
+	}{
+		{"NoLists", nil, nil, "203.0.113.9", true},
+		{"AllowMatch", []string{"10.0.0.0/8"}, nil, "10.1.2.3", true},
+		{"AllowMiss", []string{"10.0.0.0/8"}, nil, "192.168.1.1", false},
+		{"DenyMatch", nil, []string{"192.168.1.0/24"}, "192.168.1.50", false},
+		{"DenyMiss", nil, []string{"192.168.1.0/24"}, "192.168.2.50", true},
+		{"DenyWinsOverAllow", []string{"10.0.0.0/8"}, []string{"10.0.0.5"}, "10.0.0.5", false},
+		{"BareIP", []string{"127.0.0.1"}, nil, "127.0.0.1", true},
+		{"IPv6", []string{"fd00::/8"}, nil, "fd12::1", true},
+		{"IPv4MappedIPv6", []string{"10.0.0.0/8"}, nil, "::ffff:10.0.0.1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newIPFilter(config.ServerConfig{AllowCIDRs: tt.allow, DenyCIDRs: tt.deny})
+			if got := f.allowed(&net.TCPAddr{IP: net.ParseIP(tt.ip), Port: 50000}); got != tt.want {
+				t.Fatalf("Expected allowed=%v for %s, got %v", tt.want, tt.ip, got)
+			}
+		})
+	}
+}
+
+// TestIPFilterRejectsConnection tests that a denied client is disconnected
+// before any request is served
+func TestIPFilterRejectsConnection(t *testing.T) {
+	s := newTestServer(t, &config.Config{
+		Server: config.ServerConfig{MaxClients: 5, DenyCIDRs: []string{"127.0.0.0/8"}},
+		Modbus: config.ModbusConfig{UnitID: 1, MaxRegisters: 200, CounterAddress: 10},
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go s.acceptClients(listener)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("Expected denied connection to be closed")
+	}
+}
@@ -0,0 +1,68 @@
+// lifecycle.go - Lifecycle state of the server for monitoring
+package server
+
+import (
+	"SPModbus/admin"
+	"time"
+)
+
+// State is where the server is in its lifecycle.
+type State string
+
+const (
+	StateStarting State = "starting" // not serving yet; also while standing by or preloading a replica
+	StateDegraded State = "degraded" // a start attempt failed and retries are pending
+	StateRunning  State = "running"
+	StateStopped  State = "stopped"
+)
+
+// stateChange is the current state and when it was entered.
+type stateChange struct {
+	state State
+	since time.Time
+}
+
+// State returns the server's current lifecycle state.
+func (s *ModbusServer) State() State {
+	return s.state.Load().state
+}
+
+// Health reports the lifecycle state for GET /health.
+func (s *ModbusServer) Health() admin.Health {
+	c := s.state.Load()
+	return admin.Health{State: string(c.state), Since: c.since}
+}
+
+// setState moves the server to state and logs the transition. Entering
+// degraded is logged as a warning so it stands out from transient retries.
+// Stopped is final: a start attempt finishing after a concurrent Stop can't
+// report the server running again.
+func (s *ModbusServer) setState(state State) {
+	next := &stateChange{state: state, since: time.Now()}
+	prev := s.state.Load()
+	for {
+		if prev != nil && prev.state == StateStopped {
+			return
+		}
+		if s.state.CompareAndSwap(prev, next) {
+			break
+		}
+		prev = s.state.Load()
+	}
+	if prev != nil && prev.state == state {
+		return
+	}
+
+	data := map[string]interface{}{
+		"state": state,
+	}
+	if prev != nil {
+		data["previous"] = prev.state
+		data["duration"] = time.Since(prev.since).String()
+	}
+	if state == StateDegraded {
+		s.logger.Warn("Server degraded", data)
+	} else {
+		s.logger.Info("Server state changed", data)
+	}
+}
@@ -0,0 +1,112 @@
+// listen.go - Binding the Modbus listeners, one or both IP stacks
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"syscall"
+)
+
+// binding is one address the server listens on.
+type binding struct {
+	stack   string // "ipv4" or "ipv6" for dual-stack, "" otherwise
+	network string
+	address string
+}
+
+// bindings returns the addresses to listen on: the configured one, or with
+// the dual-stack shorthand the IPv6 and IPv4 wildcard addresses. Networks
+// ending in 6 make Go set IPV6_V6ONLY, so the IPv6 socket leaves IPv4 to
+// the second one.
+func (s *ModbusServer) bindings(ctx context.Context) ([]binding, error) {
+	network := s.config.Server.Network()
+	if !s.config.Server.DualStack() {
+		hostPort, err := s.listenAddress(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return []binding{{network: network, address: hostPort}}, nil
+	}
+
+	port := strconv.Itoa(s.config.Server.Port)
+	return []binding{
+		{stack: "ipv6", network: network + "6", address: net.JoinHostPort("::", port)},
+		{stack: "ipv4", network: network + "4", address: net.JoinHostPort("0.0.0.0", port)},
+	}, nil
+}
+
+// listen binds every address, returning the listeners in tcp mode and the
+// packet connections in udp mode. In dual-stack mode a stack that can't be
+// bound, e.g. because IPv6 is disabled or the port is taken for it, is
+// skipped with a warning as long as the other one is bound. With port 0 the
+// second stack reuses the port the first one was given.
+func (s *ModbusServer) listen(bindings []binding) ([]net.Listener, []net.PacketConn, error) {
+	var listeners []net.Listener
+	var packets []net.PacketConn
+	var bound []string
+	var errs []error
+	port := ""
+	for _, b := range bindings {
+		address := b.address
+		if port != "" {
+			host, _, _ := net.SplitHostPort(address)
+			address = net.JoinHostPort(host, port)
+		}
+
+		var local net.Addr
+		if s.config.Server.Network() == "udp" {
+			conn, err := net.ListenPacket(b.network, address)
+			if err = bindError(b, err); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			packets = append(packets, conn)
+			local = conn.LocalAddr()
+		} else {
+			listener, err := net.Listen(b.network, address)
+			if err = bindError(b, err); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			listeners = append(listeners, listener)
+			local = listener.Addr()
+		}
+
+		if b.stack != "" {
+			bound = append(bound, b.stack)
+			_, port, _ = net.SplitHostPort(local.String())
+		}
+	}
+
+	if len(listeners) == 0 && len(packets) == 0 {
+		return nil, nil, fmt.Errorf("failed to start server: %w", errors.Join(errs...))
+	}
+	if s.config.Server.DualStack() {
+		for _, err := range errs {
+			s.logger.Warn("IP stack not bound, continuing without it", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+		s.logger.Info("Dual-stack listening", map[string]interface{}{
+			"stacks": bound,
+			"port":   port,
+		})
+	}
+	return listeners, packets, nil
+}
+
+// bindError names the stack a failed binding was for. An address already in
+// use on one stack usually means the other stack's socket already covers it
+// through v4-mapped addresses.
+func bindError(b binding, err error) error {
+	if err == nil || b.stack == "" {
+		return err
+	}
+	if errors.Is(err, syscall.EADDRINUSE) {
+		return fmt.Errorf("%s: %w (another socket, possibly a v4-mapped IPv6 one, holds the port)", b.stack, err)
+	}
+	return fmt.Errorf("%s: %w", b.stack, err)
+}
@@ -0,0 +1,82 @@
+// metrics.go - Metrics snapshot shared by the health log, stats dump and admin API
+package server
+
+import (
+	"SPModbus/admin"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// MetricsSnapshot collects every server counter into one snapshot. All
+// metric reporting is built from it so the outputs can't drift apart.
+func (s *ModbusServer) MetricsSnapshot() admin.Metrics {
+	now := time.Now()
+	m := admin.Metrics{
+		Version:   admin.MetricsVersion,
+		Timestamp: now,
+		Uptime:    now.Sub(s.handler.GetStats().StartTime),
+		Functions: make(map[string]uint64),
+		Units:     make(map[int]admin.UnitMetrics, len(s.units)),
+	}
+	state := s.state.Load()
+	m.State, m.StateSince = string(state.state), state.since
+
+	for id, h := range s.units {
+		stats := h.GetStats()
+		m.RequestsHandled += stats.RequestsHandled
+		m.Errors += stats.Errors
+		m.CounterOverflows += stats.CounterOverflows
+		m.Units[int(id)] = admin.UnitMetrics{
+			RequestsHandled:     stats.RequestsHandled,
+			Errors:              stats.Errors,
+			CounterOverflows:    stats.CounterOverflows,
+			FirstRequestLatency: stats.FirstRequestLatency,
+			Maintenance:         h.InMaintenance(),
+		}
+	}
+
+	for fc := range s.functions {
+		if n := s.functions[fc].Load(); n > 0 {
+			m.Functions[fmt.Sprintf("0x%02x", fc)] = n
+		}
+	}
+
+	s.mu.Lock()
+	m.Connections = len(s.clients)
+	s.mu.Unlock()
+
+	return m
+}
+
+// statsFields returns the totals of a snapshot as log data.
+func statsFields(m admin.Metrics) map[string]interface{} {
+	return map[string]interface{}{
+		"requests_handled": m.RequestsHandled,
+		"errors":           m.Errors,
+		"uptime":           m.Uptime.String(),
+		"state":            m.State,
+	}
+}
+
+// DumpStats logs the full server stats at INFO regardless of the log level.
+func (s *ModbusServer) DumpStats() {
+	m := s.MetricsSnapshot()
+	fields := statsFields(m)
+
+	units := make(map[string]interface{}, len(m.Units))
+	for id, u := range m.Units {
+		units[strconv.Itoa(id)] = map[string]interface{}{
+			"requests_handled":  u.RequestsHandled,
+			"errors":            u.Errors,
+			"counter_overflows": u.CounterOverflows,
+			"first_request":     u.FirstRequestLatency.String(),
+			"maintenance":       u.Maintenance,
+		}
+	}
+	fields["units"] = units
+	fields["functions"] = m.Functions
+	fields["clients"] = m.Connections
+
+	s.logger.Always("Stats dump", fields)
+}
@@ -0,0 +1,486 @@
+// protocol.go - Modbus PDU decoding and dispatch to the request handler
+package server
+
+import (
+	"SPModbus/handler"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"slices"
+	"time"
+
+	"github.com/simonvetter/modbus"
+)
+
+const (
+	fcReadCoils              uint8 = 0x01
+	fcReadDiscreteInputs     uint8 = 0x02
+	fcReadHoldingRegisters   uint8 = 0x03
+	fcReadInputRegisters     uint8 = 0x04
+	fcWriteSingleCoil        uint8 = 0x05
+	fcWriteSingleRegister    uint8 = 0x06
+	fcDiagnostics            uint8 = 0x08
+	fcWriteMultipleCoils     uint8 = 0x0f
+	fcWriteMultipleRegisters uint8 = 0x10
+	fcEncapsulatedInterface  uint8 = 0x2b
+
+	meiReadDeviceID uint8 = 0x0e
+
+	// Basic identification, stream and individual access
+	deviceIDConformity uint8 = 0x81
+
+	exIllegalFunction         uint8 = 0x01
+	exIllegalDataAddress      uint8 = 0x02
+	exIllegalDataValue        uint8 = 0x03
+	exServerDeviceFailure     uint8 = 0x04
+	exAcknowledge             uint8 = 0x05
+	exServerDeviceBusy        uint8 = 0x06
+	exMemoryParityError       uint8 = 0x08
+	exGWPathUnavailable       uint8 = 0x0a
+	exGWTargetFailedToRespond uint8 = 0x0b
+)
+
+var errProtocol = errors.New("protocol error")
+
+// dispatch decodes a request PDU, invokes the handler and encodes the
+// response. Validation mirrors the modbus library's server, except that
+// zero quantities are passed to the handler and quantities above the Modbus
+// limit are answered with illegal data value, like a real device, instead of
+// dropping the connection. A non-nil error means the
+// connection should be closed. Requests running longer than RequestTimeout
+// are answered with server device busy; the handler call is left to finish
+// in the background and its result discarded, and a write still waiting for
+// the register lock is dropped rather than applied.
+func (s *ModbusServer) dispatch(clientAddr string, req *pdu) (*pdu, error) {
+	s.functions[req.functionCode].Add(1)
+
+	timeout := time.Duration(s.config.Server.RequestTimeout)
+	if timeout <= 0 {
+		return s.safeHandle(context.Background(), clientAddr, req)
+	}
+
+	type result struct {
+		res *pdu
+		err error
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	done := make(chan result, 1)
+	go func() {
+		res, err := s.safeHandle(ctx, clientAddr, req)
+		done <- result{res, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.res, r.err
+	case <-ctx.Done():
+		s.unitHandler(req.unitID).RecordError()
+		s.logger.Warn("Request timed out", map[string]interface{}{
+			"client":   clientAddr,
+			"unit_id":  req.unitID,
+			"function": req.functionCode,
+			"timeout":  timeout.String(),
+		})
+		return exceptionResponse(req, exServerDeviceBusy), nil
+	}
+}
+
+// safeHandle runs a request through the handler. A panicking handler is
+// answered with a server device failure so one bad request can't take the
+// connection down.
+func (s *ModbusServer) safeHandle(ctx context.Context, clientAddr string, req *pdu) (res *pdu, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.unitHandler(req.unitID).RecordError()
+			s.logger.Error("Recovered from handler panic", map[string]interface{}{
+				"client":   clientAddr,
+				"unit_id":  req.unitID,
+				"function": req.functionCode,
+				"panic":    fmt.Sprint(r),
+				"stack":    string(debug.Stack()),
+			})
+			res, err = exceptionResponse(req, exServerDeviceFailure), nil
+		}
+	}()
+
+	res, err = s.handleRequest(ctx, clientAddr, req)
+	if err != nil {
+		if errors.Is(err, errProtocol) {
+			return nil, err
+		}
+		return exceptionResponse(req, exceptionCode(err)), nil
+	}
+	return res, nil
+}
+
+func (s *ModbusServer) handleRequest(ctx context.Context, clientAddr string, req *pdu) (*pdu, error) {
+	p := req.payload
+	h := s.unitHandler(req.unitID)
+
+	if slices.Contains(s.config.Server.UnsupportedFunctions, int(req.functionCode)) {
+		h.RecordError()
+		s.logger.Info("Unsupported function code requested", map[string]interface{}{
+			"client":   clientAddr,
+			"unit_id":  req.unitID,
+			"function": req.functionCode,
+		})
+		return nil, modbus.ErrIllegalFunction
+	}
+
+	switch req.functionCode {
+	case fcReadCoils, fcReadDiscreteInputs:
+		if len(p) != 4 {
+			return nil, errProtocol
+		}
+		addr, quantity := be16(p[0:2]), be16(p[2:4])
+		if quantity > 2000 {
+			h.RecordError()
+			return nil, modbus.ErrIllegalDataValue
+		}
+		if uint32(addr)+uint32(quantity) > 0x10000 {
+			return nil, modbus.ErrIllegalDataAddress
+		}
+
+		var bits []bool
+		var err error
+		if req.functionCode == fcReadCoils {
+			bits, err = h.HandleCoilsContext(ctx, &modbus.CoilsRequest{
+				ClientAddr: clientAddr,
+				UnitId:     req.unitID,
+				Addr:       addr,
+				Quantity:   quantity,
+			})
+		} else {
+			bits, err = h.HandleDiscreteInputs(&modbus.DiscreteInputsRequest{
+				ClientAddr: clientAddr,
+				UnitId:     req.unitID,
+				Addr:       addr,
+				Quantity:   quantity,
+			})
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(bits) != int(quantity) {
+			return nil, fmt.Errorf("handler returned %d values, expected %d", len(bits), quantity)
+		}
+
+		packed := encodeBools(bits)
+		return response(req, append([]byte{uint8(len(packed))}, packed...)), nil
+
+	case fcWriteSingleCoil:
+		if len(p) != 4 {
+			return nil, errProtocol
+		}
+		if (p[2] != 0xff && p[2] != 0x00) || p[3] != 0x00 {
+			return nil, errProtocol
+		}
+
+		_, err := h.HandleCoilsContext(ctx, &modbus.CoilsRequest{
+			ClientAddr: clientAddr,
+			UnitId:     req.unitID,
+			Addr:       be16(p[0:2]),
+			Quantity:   1,
+			IsWrite:    true,
+			Args:       []bool{p[2] == 0xff},
+		})
+		if err != nil {
+			return nil, err
+		}
+		return response(req, p[0:4]), nil
+
+	case fcWriteMultipleCoils:
+		if len(p) < 5 {
+			return nil, errProtocol
+		}
+		addr, quantity := be16(p[0:2]), be16(p[2:4])
+		if quantity > 0x7b0 {
+			h.RecordError()
+			return nil, modbus.ErrIllegalDataValue
+		}
+		if uint32(addr)+uint32(quantity) > 0x10000 {
+			return nil, modbus.ErrIllegalDataAddress
+		}
+		byteCount := (int(quantity) + 7) / 8
+		if int(p[4]) != byteCount || len(p)-5 != byteCount {
+			return nil, errProtocol
+		}
+
+		_, err := h.HandleCoilsContext(ctx, &modbus.CoilsRequest{
+			ClientAddr: clientAddr,
+			UnitId:     req.unitID,
+			Addr:       addr,
+			Quantity:   quantity,
+			IsWrite:    true,
+			Args:       decodeBools(quantity, p[5:]),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return response(req, p[0:4]), nil
+
+	case fcReadHoldingRegisters, fcReadInputRegisters:
+		if len(p) != 4 {
+			return nil, errProtocol
+		}
+		addr, quantity := be16(p[0:2]), be16(p[2:4])
+		if quantity > 0x7d {
+			h.RecordError()
+			return nil, modbus.ErrIllegalDataValue
+		}
+		if uint32(addr)+uint32(quantity) > 0x10000 {
+			return nil, modbus.ErrIllegalDataAddress
+		}
+
+		var regs []uint16
+		var err error
+		if req.functionCode == fcReadHoldingRegisters {
+			regs, err = h.HandleHoldingRegistersContext(ctx, &modbus.HoldingRegistersRequest{
+				ClientAddr: clientAddr,
+				UnitId:     req.unitID,
+				Addr:       addr,
+				Quantity:   quantity,
+			})
+		} else {
+			regs, err = h.HandleInputRegisters(&modbus.InputRegistersRequest{
+				ClientAddr: clientAddr,
+				UnitId:     req.unitID,
+				Addr:       addr,
+				Quantity:   quantity,
+			})
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(regs) != int(quantity) {
+			return nil, fmt.Errorf("handler returned %d registers, expected %d", len(regs), quantity)
+		}
+		regs = s.swapRegisters(regs)
+
+		return response(req, append([]byte{uint8(len(regs) * 2)}, encodeUint16s(regs)...)), nil
+
+	case fcWriteSingleRegister:
+		if len(p) != 4 {
+			return nil, errProtocol
+		}
+
+		_, err := h.HandleHoldingRegistersContext(ctx, &modbus.HoldingRegistersRequest{
+			ClientAddr: clientAddr,
+			UnitId:     req.unitID,
+			Addr:       be16(p[0:2]),
+			Quantity:   1,
+			IsWrite:    true,
+			Args:       []uint16{be16(p[2:4])},
+		})
+		if err != nil {
+			return nil, err
+		}
+		return response(req, p[0:4]), nil
+
+	case fcWriteMultipleRegisters:
+		if len(p) < 5 {
+			return nil, errProtocol
+		}
+		addr, quantity := be16(p[0:2]), be16(p[2:4])
+		if quantity > 0x7b {
+			h.RecordError()
+			return nil, modbus.ErrIllegalDataValue
+		}
+		if uint32(addr)+uint32(quantity) > 0x10000 {
+			return nil, modbus.ErrIllegalDataAddress
+		}
+		if int(p[4]) != int(quantity)*2 || len(p)-5 != int(quantity)*2 {
+			return nil, errProtocol
+		}
+
+		_, err := h.HandleHoldingRegistersContext(ctx, &modbus.HoldingRegistersRequest{
+			ClientAddr: clientAddr,
+			UnitId:     req.unitID,
+			Addr:       addr,
+			Quantity:   quantity,
+			IsWrite:    true,
+			Args:       decodeUint16s(p[5:]),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return response(req, p[0:4]), nil
+
+	case fcDiagnostics:
+		if len(p) < 2 {
+			return nil, errProtocol
+		}
+
+		data, err := h.HandleDiagnostics(&handler.DiagnosticsRequest{
+			ClientAddr:  clientAddr,
+			UnitId:      req.unitID,
+			SubFunction: be16(p[0:2]),
+			Data:        p[2:],
+		})
+		if err != nil {
+			return nil, err
+		}
+		return response(req, append(append([]byte(nil), p[0:2]...), data...)), nil
+
+	case fcEncapsulatedInterface:
+		if len(p) < 1 {
+			return nil, errProtocol
+		}
+		if p[0] != meiReadDeviceID {
+			return nil, modbus.ErrIllegalFunction
+		}
+		if len(p) != 3 {
+			return nil, errProtocol
+		}
+
+		objects, err := h.HandleDeviceIdentification(&handler.DeviceIdentificationRequest{
+			ClientAddr:   clientAddr,
+			UnitId:       req.unitID,
+			ReadDeviceID: p[1],
+			ObjectID:     p[2],
+		})
+		if err != nil {
+			return nil, err
+		}
+		return response(req, encodeDeviceID(p[1], objects)), nil
+	}
+
+	// Nothing implements this code, e.g. a write to input registers or
+	// discrete inputs, which Modbus has no function for; log it so the
+	// refusal doesn't depend on the master noticing the exception
+	s.logger.Warn("Unknown function code requested", map[string]interface{}{
+		"client":   clientAddr,
+		"unit_id":  req.unitID,
+		"function": req.functionCode,
+	})
+	return nil, modbus.ErrIllegalFunction
+}
+
+func response(req *pdu, payload []byte) *pdu {
+	return &pdu{
+		unitID:       req.unitID,
+		functionCode: req.functionCode,
+		payload:      append([]byte(nil), payload...),
+	}
+}
+
+func exceptionResponse(req *pdu, code uint8) *pdu {
+	return &pdu{
+		unitID:       req.unitID,
+		functionCode: 0x80 | req.functionCode,
+		payload:      []byte{code},
+	}
+}
+
+// exceptionCode maps handler errors to Modbus exception codes. Unknown errors
+// are reported as a server device failure.
+func exceptionCode(err error) uint8 {
+	switch err {
+	case modbus.ErrIllegalFunction:
+		return exIllegalFunction
+	case modbus.ErrIllegalDataAddress:
+		return exIllegalDataAddress
+	case modbus.ErrIllegalDataValue:
+		return exIllegalDataValue
+	case modbus.ErrAcknowledge:
+		return exAcknowledge
+	case modbus.ErrServerDeviceBusy:
+		return exServerDeviceBusy
+	case modbus.ErrMemoryParityError:
+		return exMemoryParityError
+	case modbus.ErrGWPathUnavailable:
+		return exGWPathUnavailable
+	case modbus.ErrGWTargetFailedToRespond:
+		return exGWTargetFailedToRespond
+	default:
+		return exServerDeviceFailure
+	}
+}
+
+// encodeDeviceID builds a read device identification response. Objects that
+// don't fit in one PDU are left for a follow-up request via more follows and
+// the next object ID.
+func encodeDeviceID(code uint8, objects []handler.DeviceObject) []byte {
+	out := []byte{meiReadDeviceID, code, deviceIDConformity, 0x00, 0x00, 0x00}
+	room := maxPDULength - 1 - len(out)
+
+	count := 0
+	for _, obj := range objects {
+		if 2+len(obj.Value) > room {
+			out[3], out[4] = 0xff, obj.ID
+			break
+		}
+		out = append(out, obj.ID, uint8(len(obj.Value)))
+		out = append(out, obj.Value...)
+		room -= 2 + len(obj.Value)
+		count++
+	}
+	out[5] = uint8(count)
+	return out
+}
+
+// swapRegisters applies the configured device-wide swapping to a register
+// read response. Only outgoing read responses are swapped: writes are stored
+// as sent and the registers themselves keep their configured order. Word
+// swapping exchanges each pair of registers; a trailing odd register is left
+// in place. The handler's slice is not modified.
+func (s *ModbusServer) swapRegisters(regs []uint16) []uint16 {
+	swapWords, swapBytes := s.config.Server.SwapWords, s.config.Server.SwapBytes
+	if !swapWords && !swapBytes {
+		return regs
+	}
+
+	out := append([]uint16(nil), regs...)
+	if swapWords {
+		for i := 0; i+1 < len(out); i += 2 {
+			out[i], out[i+1] = out[i+1], out[i]
+		}
+	}
+	if swapBytes {
+		for i, v := range out {
+			out[i] = v<<8 | v>>8
+		}
+	}
+	return out
+}
+
+func be16(b []byte) uint16 {
+	return binary.BigEndian.Uint16(b)
+}
+
+func encodeUint16s(values []uint16) []byte {
+	out := make([]byte, 2*len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint16(out[2*i:], v)
+	}
+	return out
+}
+
+func decodeUint16s(b []byte) []uint16 {
+	out := make([]uint16, len(b)/2)
+	for i := range out {
+		out[i] = binary.BigEndian.Uint16(b[2*i:])
+	}
+	return out
+}
+
+func encodeBools(bits []bool) []byte {
+	out := make([]byte, (len(bits)+7)/8)
+	for i, bit := range bits {
+		if bit {
+			out[i/8] |= 1 << (uint(i) % 8)
+		}
+	}
+	return out
+}
+
+func decodeBools(quantity uint16, b []byte) []bool {
+	out := make([]bool, quantity)
+	for i := range out {
+		out[i] = b[i/8]&(1<<(uint(i)%8)) != 0
+	}
+	return out
+}
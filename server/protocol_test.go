@@ -0,0 +1,587 @@
+// protocol_test.go - PDU dispatch tests
+package server
+
+import (
+	"SPModbus/config"
+	"SPModbus/handler"
+	"SPModbus/mlog"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestZeroQuantityException tests that a zero-quantity read gets an exception
+// response rather than closing the connection
+func TestZeroQuantityException(t *testing.T) {
+	s := newTestServer(t, &config.Config{
+		Modbus: config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   200,
+			CounterAddress: 10,
+		},
+	})
+
+	res, err := s.dispatch("test", &pdu{
+		unitID:       1,
+		functionCode: fcReadHoldingRegisters,
+		payload:      []byte{0x00, 0x00, 0x00, 0x00},
+	})
+	if err != nil {
+		t.Fatalf("Expected exception response, got protocol error %v", err)
+	}
+	if res.functionCode != 0x80|fcReadHoldingRegisters || !bytes.Equal(res.payload, []byte{exIllegalDataValue}) {
+		t.Fatalf("Expected illegal data value exception, got fc=%#x payload=%x", res.functionCode, res.payload)
+	}
+}
+
+// TestQuantityAboveSpecException tests that requests above the Modbus
+// quantity limits get an exception response rather than closing the
+// connection
+func TestQuantityAboveSpecException(t *testing.T) {
+	s := newTestServer(t, &config.Config{
+		Modbus: config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   4000,
+			CounterAddress: 10,
+		},
+	})
+
+	for name, req := range map[string]*pdu{
+		"ReadCoils":              {functionCode: fcReadCoils, payload: []byte{0x00, 0x00, 0x07, 0xd1}},
+		"ReadDiscreteInputs":     {functionCode: fcReadDiscreteInputs, payload: []byte{0x00, 0x00, 0x07, 0xd1}},
+		"ReadHoldingRegisters":   {functionCode: fcReadHoldingRegisters, payload: []byte{0x00, 0x00, 0x00, 0x7e}},
+		"ReadInputRegisters":     {functionCode: fcReadInputRegisters, payload: []byte{0x00, 0x00, 0x00, 0x7e}},
+		"WriteMultipleCoils":     {functionCode: fcWriteMultipleCoils, payload: []byte{0x00, 0x00, 0x07, 0xb1, 0xf7}},
+		"WriteMultipleRegisters": {functionCode: fcWriteMultipleRegisters, payload: []byte{0x00, 0x00, 0x00, 0x7c, 0xf8}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req.unitID = 1
+			res, err := s.dispatch("test", req)
+			if err != nil {
+				t.Fatalf("Expected exception response, got protocol error %v", err)
+			}
+			if res.functionCode != 0x80|req.functionCode || !bytes.Equal(res.payload, []byte{exIllegalDataValue}) {
+				t.Fatalf("Expected illegal data value exception, got fc=%#x payload=%x", res.functionCode, res.payload)
+			}
+		})
+	}
+}
+
+// TestPerUnitInitialData tests that each unit starts from its own initial
+// data, falling back to the modbus section's
+func TestPerUnitInitialData(t *testing.T) {
+	s := newTestServer(t, &config.Config{
+		Modbus: config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   200,
+			CounterAddress: 10,
+			InitialData:    []config.RegisterValue{{Type: "holding", Address: 20, Value: 100}},
+			Units: []config.UnitConfig{
+				{UnitID: 2, InitialData: []config.RegisterValue{{Type: "holding", Address: 20, Value: 200}}},
+				{UnitID: 3, MaxRegisters: 50, InitialData: []config.RegisterValue{
+					{Type: "holding", Address: 20, Value: 300},
+					{Type: "coil", Address: 5, Value: 1},
+				}},
+				{UnitID: 4},
+			},
+		},
+	})
+
+	for unitID, want := range map[uint8]uint16{1: 100, 2: 200, 3: 300, 4: 100} {
+		res, err := s.dispatch("test", &pdu{
+			unitID:       unitID,
+			functionCode: fcReadHoldingRegisters,
+			payload:      []byte{0x00, 20, 0x00, 0x01},
+		})
+		if err != nil {
+			t.Fatalf("Unit %d: dispatch failed: %v", unitID, err)
+		}
+		if !bytes.Equal(res.payload, []byte{0x02, byte(want >> 8), byte(want)}) {
+			t.Fatalf("Unit %d: expected register 20 = %d, got payload %x", unitID, want, res.payload)
+		}
+	}
+
+	if bits, _ := s.units[3].ReadBits("coil", 5, 1); !bits[0] {
+		t.Fatal("Expected unit 3 coil 5 to be set")
+	}
+	if bits, _ := s.units[2].ReadBits("coil", 5, 1); bits[0] {
+		t.Fatal("Expected unit 2 coil 5 to be clear")
+	}
+}
+
+// TestUnitConcurrencyIsolation tests that saturating one unit's in-flight
+// limit leaves the other units responsive
+func TestUnitConcurrencyIsolation(t *testing.T) {
+	s := newTestServer(t, &config.Config{
+		Modbus: config.ModbusConfig{
+			UnitID:                1,
+			MaxRegisters:          200,
+			CounterAddress:        10,
+			MaxConcurrentRequests: 1,
+			Units:                 []config.UnitConfig{{UnitID: 2}},
+		},
+	})
+
+	// A computed register that blocks keeps unit 1's only slot busy
+	entered := make(chan struct{})
+	unblock := make(chan struct{})
+	if err := s.units[1].RegisterComputed(50, func(addr uint16, regs handler.RegisterReader) uint16 {
+		close(entered)
+		<-unblock
+		return 0
+	}); err != nil {
+		t.Fatalf("Failed to register computed register: %v", err)
+	}
+
+	read := func(unitID uint8, addr uint16) *pdu {
+		res, err := s.dispatch("test", &pdu{
+			unitID:       unitID,
+			functionCode: fcReadHoldingRegisters,
+			payload:      []byte{byte(addr >> 8), byte(addr), 0x00, 0x01},
+		})
+		if err != nil {
+			t.Errorf("Unexpected protocol error: %v", err)
+		}
+		return res
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		read(1, 50)
+	}()
+	<-entered
+
+	t.Run("SaturatedUnitBusy", func(t *testing.T) {
+		res := read(1, 20)
+		if res.functionCode != 0x80|fcReadHoldingRegisters || !bytes.Equal(res.payload, []byte{exServerDeviceBusy}) {
+			t.Fatalf("Expected server device busy exception, got fc=%#x payload=%x", res.functionCode, res.payload)
+		}
+	})
+
+	t.Run("OtherUnitResponds", func(t *testing.T) {
+		res := read(2, 20)
+		if res.functionCode != fcReadHoldingRegisters {
+			t.Fatalf("Expected unit 2 to respond, got fc=%#x payload=%x", res.functionCode, res.payload)
+		}
+	})
+
+	close(unblock)
+	<-done
+
+	t.Run("SlotReleased", func(t *testing.T) {
+		res := read(1, 20)
+		if res.functionCode != fcReadHoldingRegisters {
+			t.Fatalf("Expected unit 1 to respond after release, got fc=%#x payload=%x", res.functionCode, res.payload)
+		}
+	})
+}
+
+// TestHandlerPanicRecovery tests that a panicking handler is answered with a
+// server device failure and the server keeps serving requests
+func TestHandlerPanicRecovery(t *testing.T) {
+	s := newTestServer(t, &config.Config{
+		Modbus: config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   200,
+			CounterAddress: 10,
+		},
+	})
+
+	if err := s.handler.RegisterComputed(50, func(addr uint16, regs handler.RegisterReader) uint16 {
+		panic("computed register failed")
+	}); err != nil {
+		t.Fatalf("Failed to register computed register: %v", err)
+	}
+
+	read := func(addr uint16) *pdu {
+		res, err := s.dispatch("test", &pdu{
+			unitID:       1,
+			functionCode: fcReadHoldingRegisters,
+			payload:      []byte{byte(addr >> 8), byte(addr), 0x00, 0x01},
+		})
+		if err != nil {
+			t.Fatalf("Expected exception response, got protocol error %v", err)
+		}
+		return res
+	}
+
+	res := read(50)
+	if res.functionCode != 0x80|fcReadHoldingRegisters || !bytes.Equal(res.payload, []byte{exServerDeviceFailure}) {
+		t.Fatalf("Expected server device failure exception, got fc=%#x payload=%x", res.functionCode, res.payload)
+	}
+	if errs := s.handler.GetStats().Errors; errs != 1 {
+		t.Fatalf("Expected the panic to be counted as an error, got %d errors", errs)
+	}
+
+	// The handler lock must have been released during the panic
+	if res := read(20); res.functionCode != fcReadHoldingRegisters {
+		t.Fatalf("Expected server to keep serving requests, got fc=%#x payload=%x", res.functionCode, res.payload)
+	}
+}
+
+// TestRequestTimeout tests that a request exceeding RequestTimeout is answered
+// with server device busy without waiting for the handler
+func TestRequestTimeout(t *testing.T) {
+	s := newTestServer(t, &config.Config{
+		Server: config.ServerConfig{RequestTimeout: config.Duration(100 * time.Millisecond)},
+		Modbus: config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   200,
+			CounterAddress: 10,
+		},
+	})
+
+	release := make(chan struct{})
+	defer close(release)
+	if err := s.handler.RegisterComputed(50, func(addr uint16, regs handler.RegisterReader) uint16 {
+		<-release
+		return 0
+	}); err != nil {
+		t.Fatalf("Failed to register computed register: %v", err)
+	}
+
+	start := time.Now()
+	res, err := s.dispatch("test", &pdu{
+		unitID:       1,
+		functionCode: fcReadHoldingRegisters,
+		payload:      []byte{0x00, 50, 0x00, 0x01},
+	})
+	if err != nil {
+		t.Fatalf("Expected exception response, got protocol error %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected timeout after about 100ms, waited %v", elapsed)
+	}
+	if res.functionCode != 0x80|fcReadHoldingRegisters || !bytes.Equal(res.payload, []byte{exServerDeviceBusy}) {
+		t.Fatalf("Expected server device busy exception, got fc=%#x payload=%x", res.functionCode, res.payload)
+	}
+}
+
+// TestRequestTimeoutDropsWrite tests that a write timing out while waiting for
+// the register lock is not applied once the lock is released
+func TestRequestTimeoutDropsWrite(t *testing.T) {
+	s := newTestServer(t, &config.Config{
+		Server: config.ServerConfig{RequestTimeout: config.Duration(100 * time.Millisecond)},
+		Modbus: config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   200,
+			CounterAddress: 10,
+		},
+	})
+
+	// A read of the computed register holds the read lock until released
+	release := make(chan struct{})
+	if err := s.handler.RegisterComputed(50, func(addr uint16, regs handler.RegisterReader) uint16 {
+		<-release
+		return 0
+	}); err != nil {
+		t.Fatalf("Failed to register computed register: %v", err)
+	}
+	go s.dispatch("reader", &pdu{unitID: 1, functionCode: fcReadHoldingRegisters, payload: []byte{0x00, 50, 0x00, 0x01}})
+	time.Sleep(20 * time.Millisecond)
+
+	res, err := s.dispatch("writer", &pdu{
+		unitID:       1,
+		functionCode: fcWriteSingleRegister,
+		payload:      []byte{0x00, 60, 0x12, 0x34},
+	})
+	if err != nil {
+		t.Fatalf("Expected exception response, got protocol error %v", err)
+	}
+	if res.functionCode != 0x80|fcWriteSingleRegister || !bytes.Equal(res.payload, []byte{exServerDeviceBusy}) {
+		t.Fatalf("Expected server device busy exception, got fc=%#x payload=%x", res.functionCode, res.payload)
+	}
+
+	// The waiting write gets the lock now, and must find its request expired
+	close(release)
+	time.Sleep(50 * time.Millisecond)
+	res, err = s.dispatch("test", &pdu{
+		unitID:       1,
+		functionCode: fcReadHoldingRegisters,
+		payload:      []byte{0x00, 60, 0x00, 0x01},
+	})
+	if err != nil {
+		t.Fatalf("Failed to read register: %v", err)
+	}
+	if !bytes.Equal(res.payload, []byte{0x02, 0x00, 0x00}) {
+		t.Fatalf("Expected register 60 to keep 0 after the timed out write, got %x", res.payload)
+	}
+}
+
+// TestDeviceIdentification tests that read device identification returns the
+// configured objects in the MEI object format
+func TestDeviceIdentification(t *testing.T) {
+	s := newTestServer(t, &config.Config{
+		Modbus: config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   200,
+			CounterAddress: 10,
+			VendorName:     "Acme",
+			ProductCode:    "SIM-42",
+			Revision:       "1.2",
+		},
+	})
+
+	read := func(code, objectID uint8) *pdu {
+		res, err := s.dispatch("test", &pdu{
+			unitID:       1,
+			functionCode: fcEncapsulatedInterface,
+			payload:      []byte{meiReadDeviceID, code, objectID},
+		})
+		if err != nil {
+			t.Fatalf("Expected response, got protocol error %v", err)
+		}
+		return res
+	}
+
+	t.Run("BasicStream", func(t *testing.T) {
+		want := []byte{0x0e, 0x01, 0x81, 0x00, 0x00, 0x03,
+			0x00, 4, 'A', 'c', 'm', 'e',
+			0x01, 6, 'S', 'I', 'M', '-', '4', '2',
+			0x02, 3, '1', '.', '2',
+		}
+		res := read(0x01, 0x00)
+		if res.functionCode != fcEncapsulatedInterface || !bytes.Equal(res.payload, want) {
+			t.Fatalf("Expected payload %x, got fc=%#x payload=%x", want, res.functionCode, res.payload)
+		}
+	})
+
+	t.Run("UnknownStartObjectRestarts", func(t *testing.T) {
+		res := read(0x01, 0x50)
+		if len(res.payload) < 6 || res.payload[5] != 3 || res.payload[6] != 0x00 {
+			t.Fatalf("Expected all three objects from object 0, got payload=%x", res.payload)
+		}
+	})
+
+	t.Run("Individual", func(t *testing.T) {
+		want := []byte{0x0e, 0x04, 0x81, 0x00, 0x00, 0x01, 0x02, 3, '1', '.', '2'}
+		res := read(0x04, 0x02)
+		if !bytes.Equal(res.payload, want) {
+			t.Fatalf("Expected payload %x, got %x", want, res.payload)
+		}
+	})
+
+	t.Run("IndividualUnknownObject", func(t *testing.T) {
+		res := read(0x04, 0x03)
+		if res.functionCode != 0x80|fcEncapsulatedInterface || !bytes.Equal(res.payload, []byte{exIllegalDataAddress}) {
+			t.Fatalf("Expected illegal data address exception, got fc=%#x payload=%x", res.functionCode, res.payload)
+		}
+	})
+
+	t.Run("InvalidCode", func(t *testing.T) {
+		res := read(0x05, 0x00)
+		if res.functionCode != 0x80|fcEncapsulatedInterface || !bytes.Equal(res.payload, []byte{exIllegalDataValue}) {
+			t.Fatalf("Expected illegal data value exception, got fc=%#x payload=%x", res.functionCode, res.payload)
+		}
+	})
+}
+
+// TestDeviceIdentificationMoreFollows tests that objects that don't fit in
+// one response are deferred with more follows set
+func TestDeviceIdentificationMoreFollows(t *testing.T) {
+	long := strings.Repeat("x", config.MaxDeviceIDLength)
+	objects := []handler.DeviceObject{{ID: 0, Value: long}, {ID: 1, Value: "next"}}
+
+	out := encodeDeviceID(0x01, objects)
+	if len(out)+1 > maxPDULength {
+		t.Fatalf("Response of %d bytes exceeds the PDU limit", len(out)+1)
+	}
+	if out[3] != 0xff || out[4] != 0x01 || out[5] != 1 {
+		t.Fatalf("Expected more follows with next object 1 and one object, got %x", out[:6])
+	}
+}
+
+// TestResponseSwap tests word, byte and combined swapping of read responses
+func TestResponseSwap(t *testing.T) {
+	tests := []struct {
+		name      string
+		swapWords bool
+		swapBytes bool
+		want      []byte
+	}{
+		{"None", false, false, []byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66}},
+		{"WordsOnly", true, false, []byte{0x33, 0x44, 0x11, 0x22, 0x55, 0x66}},
+		{"BytesOnly", false, true, []byte{0x22, 0x11, 0x44, 0x33, 0x66, 0x55}},
+		{"Both", true, true, []byte{0x44, 0x33, 0x22, 0x11, 0x66, 0x55}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, &config.Config{
+				Server: config.ServerConfig{SwapWords: tt.swapWords, SwapBytes: tt.swapBytes},
+				Modbus: config.ModbusConfig{
+					UnitID:         1,
+					MaxRegisters:   200,
+					CounterAddress: 10,
+				},
+			})
+			for i, v := range []uint16{0x1122, 0x3344, 0x5566} {
+				if err := s.handler.SetRegister("holding", uint16(50+i), v); err != nil {
+					t.Fatalf("Failed to set register: %v", err)
+				}
+			}
+
+			res, err := s.dispatch("test", &pdu{
+				unitID:       1,
+				functionCode: fcReadHoldingRegisters,
+				payload:      []byte{0x00, 50, 0x00, 0x03},
+			})
+			if err != nil {
+				t.Fatalf("Dispatch failed: %v", err)
+			}
+			want := append([]byte{6}, tt.want...)
+			if !bytes.Equal(res.payload, want) {
+				t.Fatalf("Expected payload %x, got %x", want, res.payload)
+			}
+
+			// The stored registers keep their order
+			if regs, _ := s.handler.ReadRegisters("holding", 50, 1); regs[0] != 0x1122 {
+				t.Fatalf("Expected stored register 0x1122, got %#x", regs[0])
+			}
+		})
+	}
+}
+
+// TestUnsupportedFunctions tests that listed function codes are answered with
+// illegal function and logged, including implemented ones
+func TestUnsupportedFunctions(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "test.jsonl")
+	logger, err := mlog.NewLogger(config.LoggingConfig{
+		Level:   "INFO",
+		File:    logFile,
+		Console: false,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	defer logger.Close()
+
+	s := NewModbusServer(&config.Config{
+		Server: config.ServerConfig{UnsupportedFunctions: []int{0x16, 0x17, int(fcWriteMultipleCoils)}},
+		Modbus: config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   200,
+			CounterAddress: 10,
+		},
+	}, logger)
+
+	requests := []*pdu{
+		{unitID: 1, functionCode: 0x16, payload: []byte{0x00, 0x14, 0xff, 0x00, 0x00, 0x01}},
+		{unitID: 1, functionCode: fcWriteMultipleCoils, payload: []byte{0x00, 0x00, 0x00, 0x01, 0x01, 0x01}},
+		{unitID: 1, functionCode: 0x18, payload: []byte{0x00, 0x00}},
+	}
+	for _, req := range requests {
+		res, err := s.dispatch("192.0.2.1:5020", req)
+		if err != nil {
+			t.Fatalf("Expected exception response for %#x, got protocol error %v", req.functionCode, err)
+		}
+		if res.functionCode != 0x80|req.functionCode || !bytes.Equal(res.payload, []byte{exIllegalFunction}) {
+			t.Fatalf("Expected illegal function for %#x, got fc=%#x payload=%x", req.functionCode, res.functionCode, res.payload)
+		}
+	}
+	if coils, _ := s.handler.ReadBits("coil", 0, 1); coils[0] {
+		t.Fatal("Expected the unsupported coil write to be ignored")
+	}
+	if caps := s.Capabilities(); slices.Contains(caps.FunctionCodes, int(fcWriteMultipleCoils)) {
+		t.Fatalf("Expected 0x0f left out of the capabilities, got %v", caps.FunctionCodes)
+	}
+
+	logs, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	var logged []float64
+	for _, line := range strings.Split(strings.TrimSpace(string(logs)), "\n") {
+		var entry mlog.LogEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Invalid log line %q: %v", line, err)
+		}
+		if entry.Message == "Unsupported function code requested" {
+			if entry.Data["client"] != "192.0.2.1:5020" {
+				t.Errorf("Expected the client address logged, got %v", entry.Data)
+			}
+			logged = append(logged, entry.Data["function"].(float64))
+		}
+	}
+	// Unlisted unknown codes get their own entry, checked in TestUnknownFunctionCode
+	if !slices.Equal(logged, []float64{0x16, 0x0f}) {
+		t.Fatalf("Expected entries for 0x16 and 0x0f, got %v", logged)
+	}
+	if stats := s.handler.GetStats(); stats.Errors != 2 {
+		t.Fatalf("Expected 2 errors counted, got %d", stats.Errors)
+	}
+}
+
+// TestUnknownFunctionCode tests that a raw frame with a code nothing
+// implements, here a made-up write to input registers, is answered with
+// Illegal Function, logged and leaves the registers alone
+func TestUnknownFunctionCode(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "test.jsonl")
+	logger, err := mlog.NewLogger(config.LoggingConfig{
+		Level:   "INFO",
+		File:    logFile,
+		Console: false,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	defer logger.Close()
+
+	s := NewModbusServer(&config.Config{
+		Modbus: config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   200,
+			CounterAddress: 10,
+			InitialData:    []config.RegisterValue{{Type: "input", Address: 20, Value: 7}},
+		},
+	}, logger)
+
+	// 0x44 has no meaning in Modbus; the payload mimics a single register write
+	txnID, req, err := readFrame(bytes.NewReader([]byte{0x00, 0x2a, 0x00, 0x00, 0x00, 0x06, 0x01, 0x44, 0x00, 0x14, 0x00, 0x63}))
+	if err != nil {
+		t.Fatalf("Failed to read frame: %v", err)
+	}
+	res, err := s.dispatch("192.0.2.1:5020", req)
+	if err != nil {
+		t.Fatalf("Expected exception response, got protocol error %v", err)
+	}
+	var out bytes.Buffer
+	if err := writeFrame(&out, txnID, res); err != nil {
+		t.Fatalf("Failed to write frame: %v", err)
+	}
+	want := []byte{0x00, 0x2a, 0x00, 0x00, 0x00, 0x03, 0x01, 0xc4, exIllegalFunction}
+	if !bytes.Equal(out.Bytes(), want) {
+		t.Fatalf("Expected response % x, got % x", want, out.Bytes())
+	}
+	if regs, _ := s.handler.ReadRegisters("input", 20, 1); regs[0] != 7 {
+		t.Fatalf("Expected input register to keep 7, got %d", regs[0])
+	}
+
+	logs, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	var found bool
+	for _, line := range strings.Split(strings.TrimSpace(string(logs)), "\n") {
+		var entry mlog.LogEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Invalid log line %q: %v", line, err)
+		}
+		if entry.Message != "Unknown function code requested" {
+			continue
+		}
+		found = true
+		if entry.Level != "WARN" || entry.Data["client"] != "192.0.2.1:5020" || entry.Data["function"] != float64(0x44) {
+			t.Fatalf("Unexpected log entry %+v", entry)
+		}
+	}
+	if !found {
+		t.Fatalf("Expected an entry for the unknown code, got %s", logs)
+	}
+}
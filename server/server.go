@@ -2,48 +2,217 @@
 package server
 
 import (
+	"SPModbus/admin"
 	"SPModbus/config"
 	"SPModbus/handler"
 	"SPModbus/mlog"
 	"context"
+	"errors"
 	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+)
+
+// ErrMaxRetriesExceeded is returned by Start when every start attempt failed.
+var ErrMaxRetriesExceeded = errors.New("max retries exceeded")
+
+// ErrMaxLifetimeReached is reported on Errors when the server has run for
+// its configured MaxLifetime.
+var ErrMaxLifetimeReached = errors.New("max lifetime reached")
+
+// SignalError is the cancellation cause of a context cancelled by a
+// termination signal. Start returns it when the signal arrives during startup
+// and ShutdownFor reports it as ReasonSignal.
+type SignalError struct {
+	Signal os.Signal
+}
+
+func (e SignalError) Error() string {
+	return e.Signal.String()
+}
+
+// ShutdownReason says why the server stopped.
+type ShutdownReason string
 
-	"github.com/simonvetter/modbus"
+const (
+	ReasonSignal           ShutdownReason = "signal"
+	ReasonContextCancelled ShutdownReason = "context_cancelled"
+	ReasonFatalError       ShutdownReason = "fatal_error"
+	ReasonMaxRetries       ShutdownReason = "max_retries_exceeded"
+	ReasonMaxLifetime      ShutdownReason = "max_lifetime"
 )
 
+// Shutdown describes a shutdown for the closing log entry. Detail carries
+// the signal name or error message.
+type Shutdown struct {
+	Reason ShutdownReason
+	Detail string
+}
+
+// ShutdownFor classifies an error returned by Start.
+func ShutdownFor(err error) Shutdown {
+	var sig SignalError
+	switch {
+	case errors.As(err, &sig):
+		return Shutdown{Reason: ReasonSignal, Detail: err.Error()}
+	case errors.Is(err, ErrMaxRetriesExceeded):
+		return Shutdown{Reason: ReasonMaxRetries, Detail: err.Error()}
+	case errors.Is(err, ErrMaxLifetimeReached):
+		return Shutdown{Reason: ReasonMaxLifetime, Detail: err.Error()}
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return Shutdown{Reason: ReasonContextCancelled, Detail: err.Error()}
+	default:
+		return Shutdown{Reason: ReasonFatalError, Detail: err.Error()}
+	}
+}
+
 type ModbusServer struct {
-	config  *config.Config
-	logger  *mlog.Logger
-	handler *handler.ModbusHandler
-	server  *modbus.ModbusServer
-	wg      sync.WaitGroup
+	config    *config.Config
+	logger    *mlog.Logger
+	handler   *handler.ModbusHandler
+	units     map[uint8]*handler.ModbusHandler
+	simulator *handler.Simulator
+	replica   *handler.ReplicaHandler // nil unless replica mode is enabled
+	admin     *admin.Server
+	listeners []net.Listener   // nil in udp mode
+	packets   []net.PacketConn // nil in tcp mode
+	filter    *ipFilter
+	cancel    context.CancelFunc
+	errs      chan error
+	wg        sync.WaitGroup
+	mu        sync.Mutex
+	clients   map[*clientConn]struct{}
+	tunnels   int                // open WebSocket tunnels, counted against max_clients with clients
+	functions [256]atomic.Uint64 // requests per function code
+	updated   atomic.Int64       // unix nanoseconds of the last updater tick, 0 before the first
+	state     atomic.Pointer[stateChange]
+	adminUp   bool // admin API started; only touched by the start loop
 }
 
 func NewModbusServer(config *config.Config, logger *mlog.Logger) *ModbusServer {
-	handler := handler.NewModbusHandler(config.Modbus, logger)
+	// Every random number generator derives from one seed, logged so any run
+	// can be reproduced by configuring it
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	logger.Info("Random seed", map[string]interface{}{
+		"seed":       seed,
+		"configured": config.Seed != 0,
+	})
+	modbusConfig := config.Modbus
+	modbusConfig.Seed = seed
+
+	h := handler.NewModbusHandler(modbusConfig, logger)
+
+	s := &ModbusServer{
+		config:    config,
+		logger:    logger,
+		handler:   h,
+		simulator: handler.NewSimulator(modbusConfig, h, logger),
+		units:     map[uint8]*handler.ModbusHandler{config.Modbus.UnitID: h},
+		filter:    newIPFilter(config.Server),
+		errs:      make(chan error, 1),
+		clients:   make(map[*clientConn]struct{}),
+	}
+	s.state.Store(&stateChange{state: StateStarting, since: time.Now()})
 
-	return &ModbusServer{
-		config:  config,
-		logger:  logger,
-		handler: handler,
+	for _, u := range config.Modbus.Units {
+		s.units[u.UnitID] = handler.NewModbusHandler(modbusConfig.ForUnit(u), logger)
+		s.units[u.UnitID].ShareRateLimit(h)
 	}
+
+	if config.Replica.Enabled {
+		s.replica = handler.NewReplicaHandler(config.Replica, h)
+	}
+
+	if config.Modbus.StateFile != "" {
+		s.loadState()
+	}
+
+	if config.Admin.Enabled {
+		s.admin = admin.NewServer(config, h, logger)
+		s.admin.SetConnections(s)
+		s.admin.SetCapabilities(s)
+		s.admin.SetMetrics(s)
+		s.admin.SetHealth(s)
+		s.admin.SetTunnel(s)
+	}
+
+	return s
 }
 
+// Start starts listening, retrying as configured, and returns once the server
+// is running. Background work stops when ctx is cancelled or Stop is called.
+// A standby instance returns immediately and starts serving in the
+// background once its peer fails; errors from that start go to Errors.
+// With MaxLifetime set, the context is cancelled once it has passed and
+// ErrMaxLifetimeReached is reported on Errors. Cancelling ctx during the
+// start retries stops them right away; Start then returns the cancellation
+// cause, e.g. a SignalError.
 func (s *ModbusServer) Start(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	s.mu.Lock()
+	s.cancel = cancel
+	s.mu.Unlock()
+
+	if lifetime := time.Duration(s.config.Server.MaxLifetime); lifetime > 0 {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.runLifetimeLimit(ctx, cancel, lifetime)
+		}()
+	}
+
+	if !s.config.Standby.Enabled {
+		return s.start(ctx)
+	}
+
+	s.logger.Info("Standing by", map[string]interface{}{
+		"peer":  s.config.Standby.Peer,
+		"lease": time.Duration(s.config.Standby.LeaseTimeout).String(),
+	})
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := s.waitForTakeover(ctx); err != nil {
+			return
+		}
+		if err := s.start(ctx); err != nil {
+			select {
+			case s.errs <- err:
+			default:
+			}
+		}
+	}()
+	return nil
+}
+
+// Errors reports failures that stop the server after Start has returned.
+func (s *ModbusServer) Errors() <-chan error {
+	return s.errs
+}
+
+func (s *ModbusServer) start(ctx context.Context) error {
 	retryCount := 0
 
 	for {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return context.Cause(ctx)
 		default:
 		}
 
 		if retryCount > 0 {
 			if retryCount >= s.config.Server.MaxRetries {
-				return fmt.Errorf("max retries (%d) exceeded", s.config.Server.MaxRetries)
+				return fmt.Errorf("%w (%d)", ErrMaxRetriesExceeded, s.config.Server.MaxRetries)
 			}
 
 			s.logger.Warn("Retrying server start", map[string]interface{}{
@@ -53,7 +222,7 @@ func (s *ModbusServer) Start(ctx context.Context) error {
 
 			select {
 			case <-ctx.Done():
-				return ctx.Err()
+				return context.Cause(ctx)
 			case <-time.After(time.Duration(s.config.Server.RetryDelay) * time.Second):
 			}
 		}
@@ -63,37 +232,135 @@ func (s *ModbusServer) Start(ctx context.Context) error {
 				"error":   err.Error(),
 				"attempt": retryCount + 1,
 			})
+			s.setState(StateDegraded)
 			retryCount++
 			continue
 		}
 
-		// If we get here, server started successfully
+		// If we get here, server started successfully. A replica with
+		// preload ranges is running once they are cached. A Stop may have
+		// cancelled the start meanwhile, which leaves the server stopped.
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		if s.replica == nil || s.replica.Ready() {
+			s.setState(StateRunning)
+		} else {
+			s.logger.Info("Waiting for replica preload", map[string]interface{}{
+				"ranges": len(s.config.Replica.PreloadRanges),
+			})
+		}
 		return nil
 	}
 }
 
+// listenAddress validates the configured address and resolves hostnames, so
+// a bad address fails with a clear error before binding. IPv6 literals are
+// bracketed by JoinHostPort.
+func (s *ModbusServer) listenAddress(ctx context.Context) (string, error) {
+	host, err := s.config.Server.Host()
+	if err != nil {
+		return "", err
+	}
+
+	if host != "" && net.ParseIP(host) == nil {
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve server address '%s': %w", host, err)
+		}
+		s.logger.Debug("Resolved server address", map[string]interface{}{
+			"host":      host,
+			"addresses": addrs,
+		})
+		host = addrs[0]
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(s.config.Server.Port)), nil
+}
+
 func (s *ModbusServer) startServer(ctx context.Context) error {
-	// Create modbus server
-	address := fmt.Sprintf("tcp://%s:%d", s.config.Server.Address, s.config.Server.Port)
+	// The admin API comes up first and stays up across retries, so a
+	// degraded server can be seen on GET /health
+	if s.admin != nil && !s.adminUp {
+		if err := s.admin.Start(); err != nil {
+			return err
+		}
+		s.adminUp = true
+	}
 
-	server, err := modbus.NewServer(&modbus.ServerConfiguration{
-		URL:        address,
-		Timeout:    time.Duration(s.config.Server.Timeout) * time.Second,
-		MaxClients: s.config.Server.MaxClients,
-	}, s.handler)
+	bindings, err := s.bindings(ctx)
+	if err != nil {
+		return err
+	}
+	addresses := make([]string, len(bindings))
+	for i, b := range bindings {
+		addresses[i] = s.config.Server.Network() + "://" + b.address
+	}
 
+	s.logger.Info("Starting server", map[string]interface{}{
+		"address": strings.Join(addresses, ", "),
+	})
+
+	// Start server
+	listeners, packets, err := s.listen(bindings)
 	if err != nil {
-		return fmt.Errorf("failed to create server: %w", err)
+		return err
 	}
 
-	s.server = server
+	s.mu.Lock()
+	s.listeners = listeners
+	s.packets = packets
+	s.mu.Unlock()
+
+	// A replica serves clients once its preload ranges are cached, so no
+	// client read reaches a cold cache; until then connections wait in the
+	// listen backlog and datagrams in the socket buffer
+	serve := func() {
+		for _, conn := range packets {
+			go s.serveUDP(conn)
+		}
+		for _, listener := range listeners {
+			go s.acceptClients(listener)
+		}
+	}
 
-	// Start register updater
+	// Start register updater, or mirror the primary when running as a replica
 	s.wg.Add(1)
-	go func() {
-		defer s.wg.Done()
-		s.runRegisterUpdater(ctx)
-	}()
+	if s.replica != nil {
+		s.logger.Info("Serving as read-only replica", map[string]interface{}{
+			"primary":  s.config.Replica.Primary,
+			"interval": time.Duration(s.config.Replica.RefreshInterval).String(),
+		})
+		go func() {
+			defer s.wg.Done()
+			s.replica.Run(ctx)
+		}()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			select {
+			case <-s.replica.Preloaded():
+				if ctx.Err() == nil {
+					serve()
+					s.setState(StateRunning)
+				}
+			case <-ctx.Done():
+			}
+		}()
+	} else {
+		serve()
+		go func() {
+			defer s.wg.Done()
+			s.runRegisterUpdater(ctx)
+		}()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.runUpdaterWatchdog(ctx)
+		}()
+	}
 
 	// Start health checker
 	s.wg.Add(1)
@@ -102,27 +369,45 @@ func (s *ModbusServer) startServer(ctx context.Context) error {
 		s.runHealthChecker(ctx)
 	}()
 
-	s.logger.Info("Starting server", map[string]interface{}{
-		"address": address,
-	})
-
-	// Start server
-	if err := server.Start(); err != nil {
-		return fmt.Errorf("failed to start server: %w", err)
+	ready := time.Now()
+	for _, h := range s.units {
+		h.MarkReady(ready)
 	}
 
 	s.logger.Info("Server started successfully", map[string]interface{}{"startup": "server running"})
-
-	// Wait for context cancellation
-	<-ctx.Done()
 	return nil
 }
 
-func (s *ModbusServer) Stop(ctx context.Context) error {
-	s.logger.Info("Stopping server", map[string]interface{}{})
+// Stop shuts the server down and writes a closing log entry with the reason
+// and final stats.
+func (s *ModbusServer) Stop(ctx context.Context, shutdown Shutdown) error {
+	s.logger.Info("Stopping server", map[string]interface{}{
+		"reason": shutdown.Reason,
+		"detail": shutdown.Detail,
+	})
 
-	if s.server != nil {
-		s.server.Stop()
+	s.mu.Lock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+	for _, listener := range s.listeners {
+		listener.Close()
+	}
+	for _, conn := range s.packets {
+		conn.Close()
+	}
+	for conn := range s.clients {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.setState(StateStopped)
+
+	if s.admin != nil {
+		if err := s.admin.Stop(ctx); err != nil {
+			s.logger.Warn("Admin API shutdown failed", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
 	}
 
 	// Wait for goroutines to finish
@@ -140,26 +425,116 @@ func (s *ModbusServer) Stop(ctx context.Context) error {
 		return ctx.Err()
 	}
 
+	if s.config.Modbus.StateFile != "" {
+		if err := s.saveState(); err != nil {
+			s.logger.Error("Failed to save register state", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+	}
+
+	fields := statsFields(s.MetricsSnapshot())
+	fields["reason"] = shutdown.Reason
+	fields["detail"] = shutdown.Detail
+	s.logger.Info("Server stopped", fields)
+
 	return nil
 }
 
+// Connections lists the connected clients, oldest first.
+func (s *ModbusServer) Connections() []admin.ConnectionInfo {
+	s.mu.Lock()
+	conns := make([]admin.ConnectionInfo, 0, len(s.clients))
+	for c := range s.clients {
+		conns = append(conns, c.info())
+	}
+	s.mu.Unlock()
+
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].ConnectedAt.Before(conns[j].ConnectedAt)
+	})
+	return conns
+}
+
+// unitHandler returns the handler serving a unit ID. Unknown units go to the
+// primary handler, which rejects them.
+func (s *ModbusServer) unitHandler(unitID uint8) *handler.ModbusHandler {
+	if h, ok := s.units[unitID]; ok {
+		return h
+	}
+	return s.handler
+}
+
+// ToggleMaintenance flips every unit in or out of maintenance mode.
+func (s *ModbusServer) ToggleMaintenance() {
+	enabled := !s.handler.InMaintenance()
+	for _, h := range s.units {
+		h.SetMaintenance(enabled)
+	}
+}
+
 func (s *ModbusServer) runRegisterUpdater(ctx context.Context) {
-	ticker := time.NewTicker(time.Duration(s.config.Modbus.UpdateInterval) * time.Second)
+	ticker := time.NewTicker(time.Duration(s.config.Modbus.UpdateInterval))
 	defer ticker.Stop()
 
+	// Leave simulated registers at their safe values however the updater exits
+	defer s.simulator.Shutdown()
+
 	s.logger.Debug("Register updater started", nil)
 
+	// Hold the counter at its initial value for the configured grace period
+	if delay := time.Duration(s.config.Modbus.CounterStartDelay); delay > 0 {
+		s.logger.Debug("Delaying first counter increment", map[string]interface{}{
+			"delay": delay.String(),
+		})
+
+		select {
+		case <-ctx.Done():
+			s.logger.Debug("Register updater stopping", nil)
+			return
+		case <-time.After(delay):
+		}
+		ticker.Reset(time.Duration(s.config.Modbus.UpdateInterval))
+	}
+	s.updated.Store(time.Now().UnixNano())
+
 	for {
 		select {
 		case <-ctx.Done():
 			s.logger.Debug("Register updater stopping", nil)
 			return
-		case <-ticker.C:
-			s.handler.UpdateCounter()
+		case now := <-ticker.C:
+			for _, h := range s.units {
+				h.UpdateCounter()
+			}
+			s.simulator.Step(now)
+			s.updated.Store(time.Now().UnixNano())
 		}
 	}
 }
 
+// runLifetimeLimit cancels the server context once lifetime has passed.
+func (s *ModbusServer) runLifetimeLimit(ctx context.Context, cancel context.CancelFunc, lifetime time.Duration) {
+	timer := time.NewTimer(lifetime)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return
+	case <-timer.C:
+	}
+
+	s.logger.Info("Max lifetime reached, shutting down", map[string]interface{}{
+		"max_lifetime": lifetime.String(),
+	})
+	cancel()
+
+	select {
+	case s.errs <- ErrMaxLifetimeReached:
+	default:
+	}
+}
+
 func (s *ModbusServer) runHealthChecker(ctx context.Context) {
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
@@ -169,12 +544,7 @@ func (s *ModbusServer) runHealthChecker(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			stats := s.handler.GetStats()
-			s.logger.Info("Health check", map[string]interface{}{
-				"requests_handled": stats.RequestsHandled,
-				"errors":           stats.Errors,
-				"uptime":           time.Since(stats.StartTime).String(),
-			})
+			s.logger.Info("Health check", statsFields(s.MetricsSnapshot()))
 		}
 	}
 }
@@ -0,0 +1,1383 @@
+// server_test.go - Server lifecycle tests
+package server
+
+import (
+	"SPModbus/admin"
+	"SPModbus/config"
+	"SPModbus/handler"
+	"SPModbus/mlog"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/simonvetter/modbus"
+)
+
+func newTestServer(t testing.TB, cfg *config.Config) *ModbusServer {
+	t.Helper()
+
+	logger, err := mlog.NewLogger(config.LoggingConfig{
+		Level:   "ERROR",
+		Console: false,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	t.Cleanup(logger.Close)
+
+	return NewModbusServer(cfg, logger)
+}
+
+func readCounter(t *testing.T, s *ModbusServer) uint16 {
+	t.Helper()
+
+	res, err := s.handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{
+		UnitId:   s.config.Modbus.UnitID,
+		Addr:     s.config.Modbus.CounterAddress,
+		Quantity: 1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to read counter: %v", err)
+	}
+	return res[0]
+}
+
+// TestCounterStartDelay tests that the counter holds still during the grace period
+func TestCounterStartDelay(t *testing.T) {
+	s := newTestServer(t, &config.Config{
+		Modbus: config.ModbusConfig{
+			UnitID:            1,
+			MaxRegisters:      200,
+			CounterAddress:    10,
+			UpdateInterval:    config.Duration(time.Second),
+			CounterStartDelay: config.Duration(time.Second),
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.runRegisterUpdater(ctx)
+
+	// Without the delay the first increment would land at 1s
+	time.Sleep(1500 * time.Millisecond)
+	if v := readCounter(t, s); v != 0 {
+		t.Fatalf("Expected counter to stay at 0 during delay, got %d", v)
+	}
+
+	time.Sleep(1200 * time.Millisecond)
+	if v := readCounter(t, s); v == 0 {
+		t.Fatalf("Expected counter to advance after delay, still 0")
+	}
+}
+
+// TestSubSecondUpdateInterval tests that the counter follows sub-second intervals
+func TestSubSecondUpdateInterval(t *testing.T) {
+	s := newTestServer(t, &config.Config{
+		Modbus: config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   200,
+			CounterAddress: 10,
+			UpdateInterval: config.Duration(100 * time.Millisecond),
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.runRegisterUpdater(ctx)
+
+	time.Sleep(550 * time.Millisecond)
+	if v := readCounter(t, s); v < 3 || v > 6 {
+		t.Fatalf("Expected about 5 increments in 550ms, got %d", v)
+	}
+}
+
+// TestStartStop tests that Start returns once listening and Stop shuts down
+// the background goroutines
+func TestStartStop(t *testing.T) {
+	s := newTestServer(t, &config.Config{
+		Server: config.ServerConfig{Address: "127.0.0.1", Port: 0, MaxClients: 1, MaxRetries: 1},
+		Modbus: config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   200,
+			CounterAddress: 10,
+			UpdateInterval: config.Duration(time.Second),
+		},
+	})
+
+	started := make(chan error, 1)
+	go func() { started <- s.Start(context.Background()) }()
+
+	select {
+	case err := <-started:
+		if err != nil {
+			t.Fatalf("Start failed: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Start did not return after the server started")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.Stop(ctx, Shutdown{Reason: ReasonSignal, Detail: "interrupt"}); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+}
+
+// TestStartCancelledDuringRetry tests that a signal arriving while Start
+// waits to retry stops the retries right away
+func TestStartCancelledDuringRetry(t *testing.T) {
+	// Hold the port so every start attempt fails
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer busy.Close()
+
+	s := newTestServer(t, &config.Config{
+		Server: config.ServerConfig{
+			Address:    "127.0.0.1",
+			Port:       busy.Addr().(*net.TCPAddr).Port,
+			MaxClients: 1,
+			MaxRetries: 5,
+			RetryDelay: 60,
+		},
+		Modbus: config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   200,
+			CounterAddress: 10,
+			UpdateInterval: config.Duration(time.Second),
+		},
+	})
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+
+	started := make(chan error, 1)
+	go func() { started <- s.Start(ctx) }()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel(SignalError{Signal: syscall.SIGTERM})
+
+	select {
+	case err := <-started:
+		var sig SignalError
+		if !errors.As(err, &sig) || sig.Signal != syscall.SIGTERM {
+			t.Fatalf("Expected SignalError for SIGTERM, got %v", err)
+		}
+		if got := ShutdownFor(err); got.Reason != ReasonSignal || got.Detail != syscall.SIGTERM.String() {
+			t.Fatalf("Expected signal shutdown, got %+v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Start did not return promptly after cancellation")
+	}
+
+	stopCtx, stopCancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer stopCancel()
+	if err := s.Stop(stopCtx, ShutdownFor(context.Cause(ctx))); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+}
+
+// TestShutdownFor tests the classification of Start errors
+func TestShutdownFor(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want ShutdownReason
+	}{
+		{"MaxRetries", fmt.Errorf("%w (3)", ErrMaxRetriesExceeded), ReasonMaxRetries},
+		{"MaxLifetime", ErrMaxLifetimeReached, ReasonMaxLifetime},
+		{"Cancelled", context.Canceled, ReasonContextCancelled},
+		{"Signal", SignalError{Signal: syscall.SIGTERM}, ReasonSignal},
+		{"Other", errors.New("listen failed"), ReasonFatalError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ShutdownFor(tt.err); got.Reason != tt.want || got.Detail != tt.err.Error() {
+				t.Fatalf("Expected reason %s with detail %q, got %+v", tt.want, tt.err.Error(), got)
+			}
+		})
+	}
+}
+
+// TestListenAddress tests IPv4, IPv6 and hostname listen addresses
+func TestListenAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		want    string
+		wantErr bool
+	}{
+		{"AllInterfaces", "", ":1502", false},
+		{"IPv4", "127.0.0.1", "127.0.0.1:1502", false},
+		{"IPv6", "::1", "[::1]:1502", false},
+		{"IPv6Bracketed", "[::1]", "[::1]:1502", false},
+		{"IPv6Unspecified", "::", "[::]:1502", false},
+		{"WithPort", "127.0.0.1:1502", "", true},
+		{"BracketedHostname", "[localhost]", "", true},
+		{"InvalidHostname", "bad host!", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, &config.Config{
+				Server: config.ServerConfig{Address: tt.address, Port: 1502},
+				Modbus: config.ModbusConfig{UnitID: 1, MaxRegisters: 200, CounterAddress: 10},
+			})
+
+			got, err := s.listenAddress(context.Background())
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Expected error for address %q, got %q", tt.address, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error for address %q: %v", tt.address, err)
+			}
+			if got != tt.want {
+				t.Fatalf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("Hostname", func(t *testing.T) {
+		s := newTestServer(t, &config.Config{
+			Server: config.ServerConfig{Address: "localhost", Port: 1502},
+			Modbus: config.ModbusConfig{UnitID: 1, MaxRegisters: 200, CounterAddress: 10},
+		})
+
+		got, err := s.listenAddress(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to resolve localhost: %v", err)
+		}
+		host, _, err := net.SplitHostPort(got)
+		if err != nil || net.ParseIP(host) == nil || !net.ParseIP(host).IsLoopback() {
+			t.Fatalf("Expected localhost to resolve to a loopback address, got %q", got)
+		}
+	})
+}
+
+// TestDumpStats tests that the stats dump is logged even when INFO is filtered
+func TestDumpStats(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "test.jsonl")
+	logger, err := mlog.NewLogger(config.LoggingConfig{
+		Level:   "ERROR",
+		File:    logFile,
+		Console: false,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	defer logger.Close()
+
+	s := NewModbusServer(&config.Config{
+		Modbus: config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   200,
+			CounterAddress: 10,
+			Units:          []config.UnitConfig{{UnitID: 2}},
+		},
+	}, logger)
+
+	for _, unitID := range []uint8{1, 2, 2} {
+		if _, err := s.dispatch("test", &pdu{
+			unitID:       unitID,
+			functionCode: fcReadHoldingRegisters,
+			payload:      []byte{0x00, 0x00, 0x00, 0x01},
+		}); err != nil {
+			t.Fatalf("Dispatch failed: %v", err)
+		}
+	}
+	s.DumpStats()
+
+	logs, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	var entry mlog.LogEntry
+	if err := json.Unmarshal(logs, &entry); err != nil {
+		t.Fatalf("Expected a single stats entry, got %s", logs)
+	}
+	if entry.Message != "Stats dump" || entry.Level != "INFO" {
+		t.Fatalf("Expected INFO stats dump, got %s", logs)
+	}
+
+	functions := entry.Data["functions"].(map[string]interface{})
+	if functions["0x03"] != float64(3) {
+		t.Fatalf("Expected 3 reads counted for 0x03, got %v", functions)
+	}
+	units := entry.Data["units"].(map[string]interface{})
+	if u := units["2"].(map[string]interface{}); u["requests_handled"] != float64(2) {
+		t.Fatalf("Expected 2 requests on unit 2, got %v", u)
+	}
+	if entry.Data["clients"] != float64(0) {
+		t.Fatalf("Expected 0 clients, got %v", entry.Data["clients"])
+	}
+}
+
+// TestMetricsSnapshot tests that the snapshot reflects recorded activity
+func TestMetricsSnapshot(t *testing.T) {
+	s := newTestServer(t, &config.Config{
+		Modbus: config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   200,
+			CounterAddress: 10,
+			Units:          []config.UnitConfig{{UnitID: 2}},
+		},
+	})
+
+	if m := s.MetricsSnapshot(); m.Version != admin.MetricsVersion || m.RequestsHandled != 0 || len(m.Functions) != 0 {
+		t.Fatalf("Expected an empty version %d snapshot, got %+v", admin.MetricsVersion, m)
+	}
+
+	for _, req := range []*pdu{
+		{unitID: 1, functionCode: fcReadHoldingRegisters, payload: []byte{0x00, 0x00, 0x00, 0x01}},
+		{unitID: 2, functionCode: fcReadHoldingRegisters, payload: []byte{0x00, 0x00, 0x00, 0x01}},
+		{unitID: 2, functionCode: fcWriteSingleRegister, payload: []byte{0x00, 0x05, 0x00, 0x2a}},
+		{unitID: 2, functionCode: fcReadHoldingRegisters, payload: []byte{0x01, 0x00, 0x00, 0x01}}, // out of bounds
+	} {
+		if _, err := s.dispatch("test", req); err != nil {
+			t.Fatalf("Dispatch failed: %v", err)
+		}
+	}
+
+	m := s.MetricsSnapshot()
+	if m.RequestsHandled != 4 || m.Errors != 1 {
+		t.Fatalf("Expected 4 requests and 1 error, got %d and %d", m.RequestsHandled, m.Errors)
+	}
+	if m.Functions["0x03"] != 3 || m.Functions["0x06"] != 1 {
+		t.Fatalf("Unexpected function counts %v", m.Functions)
+	}
+	if u := m.Units[2]; u.RequestsHandled != 3 || u.Errors != 1 {
+		t.Fatalf("Expected 3 requests and 1 error on unit 2, got %+v", u)
+	}
+	if u := m.Units[1]; u.RequestsHandled != 1 || u.FirstRequestLatency <= 0 {
+		t.Fatalf("Expected 1 request with a first request latency on unit 1, got %+v", u)
+	}
+	if m.Connections != 0 || m.Uptime <= 0 {
+		t.Fatalf("Expected no connections and a positive uptime, got %d and %v", m.Connections, m.Uptime)
+	}
+}
+
+// TestMaxLifetime tests that the server reports its lifetime limit and can
+// then be stopped normally
+func TestMaxLifetime(t *testing.T) {
+	s := newTestServer(t, &config.Config{
+		Server: config.ServerConfig{
+			Address:     "127.0.0.1",
+			Port:        0,
+			MaxClients:  1,
+			MaxRetries:  1,
+			MaxLifetime: config.Duration(200 * time.Millisecond),
+		},
+		Modbus: config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   200,
+			CounterAddress: 10,
+			UpdateInterval: config.Duration(time.Second),
+		},
+	})
+
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	select {
+	case err := <-s.Errors():
+		if got := ShutdownFor(err); got.Reason != ReasonMaxLifetime {
+			t.Fatalf("Expected max lifetime shutdown, got %+v", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Lifetime limit did not trigger")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.Stop(ctx, ShutdownFor(ErrMaxLifetimeReached)); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+}
+
+// TestConnections tests that connected clients are tracked with their activity
+func TestConnections(t *testing.T) {
+	s := newTestServer(t, &config.Config{
+		Server: config.ServerConfig{Address: "127.0.0.1", Port: 0, MaxClients: 4, MaxRetries: 1},
+		Modbus: config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   200,
+			CounterAddress: 10,
+			UpdateInterval: config.Duration(time.Second),
+		},
+	})
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		s.Stop(ctx, Shutdown{Reason: ReasonSignal})
+	}()
+
+	s.mu.Lock()
+	url := "tcp://" + s.listeners[0].Addr().String()
+	s.mu.Unlock()
+
+	client, err := modbus.NewClient(&modbus.ClientConfiguration{URL: url, Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if err := client.Open(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := client.ReadRegisters(0, 1, modbus.HOLDING_REGISTER); err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+	}
+
+	conns := s.Connections()
+	if len(conns) != 1 {
+		t.Fatalf("Expected 1 connection, got %d", len(conns))
+	}
+	if conns[0].Requests != 3 || conns[0].LastActivity.Before(conns[0].ConnectedAt) {
+		t.Fatalf("Unexpected connection info %+v", conns[0])
+	}
+
+	client.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(s.Connections()) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Closed connection still listed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestStateFile tests that register state saved on Stop is restored by the
+// next server
+func TestStateFile(t *testing.T) {
+	cfg := &config.Config{
+		Modbus: config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   200,
+			CounterAddress: 10,
+			StateFile:      filepath.Join(t.TempDir(), "state.json"),
+			Units:          []config.UnitConfig{{UnitID: 2}},
+		},
+	}
+
+	first := newTestServer(t, cfg)
+	first.units[1].SetRegister("holding", 50, 1234)
+	first.units[2].SetRegister("input", 60, 5678)
+	if err := first.Stop(context.Background(), Shutdown{Reason: ReasonSignal}); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+
+	second := newTestServer(t, cfg)
+	if regs, _ := second.units[1].ReadRegisters("holding", 50, 1); regs[0] != 1234 {
+		t.Fatalf("Expected unit 1 register 50 restored to 1234, got %d", regs[0])
+	}
+	if regs, _ := second.units[2].ReadRegisters("input", 60, 1); regs[0] != 5678 {
+		t.Fatalf("Expected unit 2 input 60 restored to 5678, got %d", regs[0])
+	}
+}
+
+// TestMaxConnectionDuration tests that a busy connection is closed at the limit
+func TestMaxConnectionDuration(t *testing.T) {
+	s := newTestServer(t, &config.Config{
+		Server: config.ServerConfig{
+			Address:               "127.0.0.1",
+			Port:                  0,
+			MaxClients:            1,
+			MaxRetries:            1,
+			MaxConnectionDuration: config.Duration(300 * time.Millisecond),
+		},
+		Modbus: config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   200,
+			CounterAddress: 10,
+			UpdateInterval: config.Duration(time.Second),
+		},
+	})
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer s.Stop(context.Background(), Shutdown{Reason: ReasonSignal})
+
+	s.mu.Lock()
+	url := "tcp://" + s.listeners[0].Addr().String()
+	s.mu.Unlock()
+
+	client, err := modbus.NewClient(&modbus.ClientConfiguration{URL: url, Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if err := client.Open(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	// Keep the connection busy; reads must work until the limit
+	start := time.Now()
+	for {
+		_, err := client.ReadRegisters(0, 1, modbus.HOLDING_REGISTER)
+		elapsed := time.Since(start)
+		if err != nil {
+			if elapsed < 250*time.Millisecond {
+				t.Fatalf("Connection closed after %v, before the limit: %v", elapsed, err)
+			}
+			break
+		}
+		if elapsed > 2*time.Second {
+			t.Fatalf("Connection still open after %v", elapsed)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// TestMinInterval tests that a request arriving sooner than the minimum
+// interval after the previous one on the same connection is answered busy.
+func TestMinInterval(t *testing.T) {
+	s := newTestServer(t, &config.Config{
+		Server: config.ServerConfig{
+			Address:     "127.0.0.1",
+			Port:        0,
+			MaxClients:  2,
+			MaxRetries:  1,
+			MinInterval: config.Duration(200 * time.Millisecond),
+		},
+		Modbus: config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   200,
+			CounterAddress: 10,
+			UpdateInterval: config.Duration(time.Second),
+		},
+	})
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer s.Stop(context.Background(), Shutdown{Reason: ReasonSignal})
+
+	s.mu.Lock()
+	url := "tcp://" + s.listeners[0].Addr().String()
+	s.mu.Unlock()
+
+	client, err := modbus.NewClient(&modbus.ClientConfiguration{URL: url, Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if err := client.Open(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	t.Run("first request", func(t *testing.T) {
+		if _, err := client.ReadRegisters(0, 1, modbus.HOLDING_REGISTER); err != nil {
+			t.Fatalf("Expected first read to succeed, got %v", err)
+		}
+	})
+
+	t.Run("too soon", func(t *testing.T) {
+		_, err := client.ReadRegisters(0, 1, modbus.HOLDING_REGISTER)
+		if !errors.Is(err, modbus.ErrServerDeviceBusy) {
+			t.Fatalf("Expected ErrServerDeviceBusy, got %v", err)
+		}
+	})
+
+	t.Run("after the interval", func(t *testing.T) {
+		time.Sleep(300 * time.Millisecond)
+		if _, err := client.ReadRegisters(0, 1, modbus.HOLDING_REGISTER); err != nil {
+			t.Fatalf("Expected read after the interval to succeed, got %v", err)
+		}
+	})
+
+	t.Run("separate connection", func(t *testing.T) {
+		other, err := modbus.NewClient(&modbus.ClientConfiguration{URL: url, Timeout: time.Second})
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		if err := other.Open(); err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer other.Close()
+		if _, err := other.ReadRegisters(0, 1, modbus.HOLDING_REGISTER); err != nil {
+			t.Fatalf("Expected first read on a new connection to succeed, got %v", err)
+		}
+	})
+}
+
+// TestUpdaterWatchdog tests that a register updater blocked on the register
+// lock is reported as stuck, and its recovery once the lock is released
+func TestUpdaterWatchdog(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "test.jsonl")
+	logger, err := mlog.NewLogger(config.LoggingConfig{
+		Level:   "INFO",
+		File:    logFile,
+		Console: false,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	defer logger.Close()
+
+	s := NewModbusServer(&config.Config{
+		Server: config.ServerConfig{Address: "127.0.0.1", Port: 0, MaxClients: 1, MaxRetries: 1},
+		Modbus: config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   200,
+			CounterAddress: 10,
+			UpdateInterval: config.Duration(20 * time.Millisecond),
+		},
+	}, logger)
+
+	// A computed register is evaluated under the register lock, so a slow
+	// one stalls the updater like a slow client write would
+	block := make(chan struct{})
+	if err := s.handler.RegisterComputed(50, func(uint16, handler.RegisterReader) uint16 {
+		<-block
+		return 0
+	}); err != nil {
+		t.Fatalf("RegisterComputed failed: %v", err)
+	}
+
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer s.Stop(context.Background(), Shutdown{Reason: ReasonSignal})
+
+	read := make(chan struct{})
+	go func() {
+		defer close(read)
+		s.handler.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{UnitId: 1, Addr: 50, Quantity: 1})
+	}()
+
+	waitForLog := func(t *testing.T, message string) mlog.LogEntry {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			logs, _ := os.ReadFile(logFile)
+			for _, line := range strings.Split(string(logs), "\n") {
+				var entry mlog.LogEntry
+				if json.Unmarshal([]byte(line), &entry) == nil && entry.Message == message {
+					return entry
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatalf("Expected %q to be logged", message)
+		return mlog.LogEntry{}
+	}
+
+	t.Run("Stuck", func(t *testing.T) {
+		entry := waitForLog(t, "Register updater stuck, counter not advancing")
+		if entry.Level != "WARN" {
+			t.Fatalf("Expected WARN, got %s", entry.Level)
+		}
+		if cause := entry.Data["suspected_cause"]; cause != "register lock held, e.g. by a slow request" {
+			t.Fatalf("Expected the register lock as suspected cause, got %v", cause)
+		}
+	})
+
+	t.Run("Recovered", func(t *testing.T) {
+		close(block)
+		<-read
+		waitForLog(t, "Register updater recovered")
+	})
+}
+
+// TestUDPMode tests serving Modbus requests over UDP
+func TestUDPMode(t *testing.T) {
+	s := newTestServer(t, &config.Config{
+		Server: config.ServerConfig{
+			Address:    "127.0.0.1",
+			Port:       0,
+			MaxClients: 2,
+			MaxRetries: 1,
+			Mode:       "udp",
+		},
+		Modbus: config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   200,
+			CounterAddress: 10,
+			UpdateInterval: config.Duration(time.Second),
+			InitialData:    []config.RegisterValue{{Type: "holding", Address: 100, Value: 2024}},
+		},
+	})
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer s.Stop(context.Background(), Shutdown{Reason: ReasonSignal})
+
+	s.mu.Lock()
+	url := "udp://" + s.packets[0].LocalAddr().String()
+	s.mu.Unlock()
+
+	client, err := modbus.NewClient(&modbus.ClientConfiguration{URL: url, Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if err := client.Open(); err != nil {
+		t.Fatalf("Failed to open client: %v", err)
+	}
+	defer client.Close()
+
+	t.Run("Read", func(t *testing.T) {
+		v, err := client.ReadRegister(100, modbus.HOLDING_REGISTER)
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if v != 2024 {
+			t.Fatalf("Expected 2024, got %d", v)
+		}
+	})
+
+	t.Run("Write", func(t *testing.T) {
+		if err := client.WriteRegister(101, 7); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		if v, err := client.ReadRegister(101, modbus.HOLDING_REGISTER); err != nil || v != 7 {
+			t.Fatalf("Expected 7, got %d (%v)", v, err)
+		}
+	})
+
+	t.Run("Exception", func(t *testing.T) {
+		if _, err := client.ReadRegister(500, modbus.HOLDING_REGISTER); !errors.Is(err, modbus.ErrIllegalDataAddress) {
+			t.Fatalf("Expected ErrIllegalDataAddress, got %v", err)
+		}
+	})
+
+	raw, err := net.Dial("udp", strings.TrimPrefix(url, "udp://"))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer raw.Close()
+
+	t.Run("TrailingBytes", func(t *testing.T) {
+		raw.Write([]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x64, 0x00, 0x01, 0xff})
+		raw.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		if n, err := raw.Read(make([]byte, 16)); err == nil {
+			t.Fatalf("Expected a datagram with trailing bytes to be dropped, got %d bytes", n)
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+		if err := s.handler.RegisterComputed(50, func(addr uint16, regs handler.RegisterReader) uint16 {
+			<-release
+			return 0
+		}); err != nil {
+			t.Fatalf("Failed to register computed register: %v", err)
+		}
+
+		// A datagram stuck on the computed register doesn't hold up the next
+		raw.Write([]byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x32, 0x00, 0x01})
+		time.Sleep(20 * time.Millisecond)
+		if v, err := client.ReadRegister(100, modbus.HOLDING_REGISTER); err != nil || v != 2024 {
+			t.Fatalf("Expected 2024 while another datagram is handled, got %d (%v)", v, err)
+		}
+	})
+}
+
+// TestSeed tests that two servers with the same seed produce identical
+// register sequences across the init pattern and simulations
+func TestSeed(t *testing.T) {
+	run := func(seed int64) [][]uint16 {
+		s := newTestServer(t, &config.Config{
+			Seed: seed,
+			Modbus: config.ModbusConfig{
+				UnitID:         1,
+				MaxRegisters:   50,
+				CounterAddress: 49,
+				InitPattern:    "random",
+				Units:          []config.UnitConfig{{UnitID: 2}},
+				Simulations: []config.SimulatedRegister{
+					{Type: "input", Address: 10, Base: 1000, Noise: &config.NoiseConfig{Magnitude: 50}},
+					{Type: "holding", Address: 20, Base: 500, Spike: &config.SpikeConfig{Probability: 0.3, Magnitude: 100, Duration: 1}},
+				},
+			},
+		})
+
+		var sequence [][]uint16
+		for _, id := range []uint8{1, 2} {
+			regs, err := s.unitHandler(id).ReadRegisters("holding", 0, 40)
+			if err != nil {
+				t.Fatalf("Read failed: %v", err)
+			}
+			sequence = append(sequence, regs)
+		}
+
+		start := time.Unix(0, 0)
+		for i := 0; i < 20; i++ {
+			s.simulator.Step(start.Add(time.Duration(i) * 500 * time.Millisecond))
+			input, _ := s.handler.ReadRegisters("input", 10, 1)
+			holding, _ := s.handler.ReadRegisters("holding", 20, 1)
+			sequence = append(sequence, []uint16{input[0], holding[0]})
+		}
+		return sequence
+	}
+
+	first, second := run(42), run(42)
+	if !slices.EqualFunc(first, second, slices.Equal) {
+		t.Fatalf("Expected identical sequences for the same seed, got %v and %v", first, second)
+	}
+	if slices.Equal(first[0], first[1]) {
+		t.Fatal("Expected units to get different random init values")
+	}
+	if other := run(43); slices.EqualFunc(first, other, slices.Equal) {
+		t.Fatal("Expected a different seed to produce a different sequence")
+	}
+}
+
+// TestReplica tests that a replica serves the primary's registers and
+// rejects client writes
+func TestReplica(t *testing.T) {
+	primary := newTestServer(t, &config.Config{
+		Server: config.ServerConfig{Address: "127.0.0.1", Port: 0, MaxClients: 2, MaxRetries: 1},
+		Modbus: config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   300,
+			CounterAddress: 10,
+			UpdateInterval: config.Duration(time.Hour),
+			InitialData: []config.RegisterValue{
+				{Type: "holding", Address: 250, Value: 42},
+				{Type: "input", Address: 5, Value: 7},
+				{Type: "coil", Address: 3, Value: 1},
+			},
+		},
+	})
+	if err := primary.Start(context.Background()); err != nil {
+		t.Fatalf("Primary start failed: %v", err)
+	}
+	defer primary.Stop(context.Background(), Shutdown{Reason: ReasonSignal})
+
+	primary.mu.Lock()
+	primaryURL := "tcp://" + primary.listeners[0].Addr().String()
+	primary.mu.Unlock()
+
+	replica := newTestServer(t, &config.Config{
+		Server: config.ServerConfig{Address: "127.0.0.1", Port: 0, MaxClients: 2, MaxRetries: 1},
+		Modbus: config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   300,
+			CounterAddress: 10,
+			UpdateInterval: config.Duration(time.Hour),
+		},
+		Replica: config.ReplicaConfig{
+			Enabled:         true,
+			Primary:         primaryURL,
+			RefreshInterval: config.Duration(50 * time.Millisecond),
+		},
+	})
+	if err := replica.Start(context.Background()); err != nil {
+		t.Fatalf("Replica start failed: %v", err)
+	}
+	defer replica.Stop(context.Background(), Shutdown{Reason: ReasonSignal})
+
+	replica.mu.Lock()
+	replicaURL := "tcp://" + replica.listeners[0].Addr().String()
+	replica.mu.Unlock()
+
+	client, err := modbus.NewClient(&modbus.ClientConfiguration{URL: replicaURL, Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if err := client.Open(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	t.Run("Mirrored", func(t *testing.T) {
+		deadline := time.Now().Add(2 * time.Second)
+		for !replica.replica.Synced() {
+			if time.Now().After(deadline) {
+				t.Fatal("Replica never synced with the primary")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if v, err := client.ReadRegister(250, modbus.HOLDING_REGISTER); err != nil || v != 42 {
+			t.Fatalf("Expected holding 250 to be 42, got %d (%v)", v, err)
+		}
+		if v, err := client.ReadRegister(5, modbus.INPUT_REGISTER); err != nil || v != 7 {
+			t.Fatalf("Expected input 5 to be 7, got %d (%v)", v, err)
+		}
+		if v, err := client.ReadCoil(3); err != nil || !v {
+			t.Fatalf("Expected coil 3 to be set, got %v (%v)", v, err)
+		}
+	})
+
+	t.Run("FollowsPrimary", func(t *testing.T) {
+		if err := primary.handler.SetRegister("holding", 250, 43); err != nil {
+			t.Fatalf("Failed to update primary: %v", err)
+		}
+
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			v, err := client.ReadRegister(250, modbus.HOLDING_REGISTER)
+			if err == nil && v == 43 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected replica to pick up 43, got %d (%v)", v, err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	t.Run("WritesRejected", func(t *testing.T) {
+		if err := client.WriteRegister(250, 1); err != modbus.ErrIllegalFunction {
+			t.Fatalf("Expected ErrIllegalFunction for register write, got %v", err)
+		}
+		if err := client.WriteCoil(3, false); err != modbus.ErrIllegalFunction {
+			t.Fatalf("Expected ErrIllegalFunction for coil write, got %v", err)
+		}
+
+		regs, _ := primary.handler.ReadRegisters("holding", 250, 1)
+		if regs[0] != 43 {
+			t.Fatalf("Expected primary to keep 43, got %d", regs[0])
+		}
+	})
+}
+
+// TestReplicaPreload tests that a replica with preload ranges reports itself
+// starting until they are read from the primary, and running afterwards
+func TestReplicaPreload(t *testing.T) {
+	// Reserve a port for the primary, which only comes up later
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve a port: %v", err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	replica := newTestServer(t, &config.Config{
+		Server: config.ServerConfig{Address: "127.0.0.1", Port: 0, MaxClients: 2, MaxRetries: 1},
+		Modbus: config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   300,
+			CounterAddress: 10,
+			UpdateInterval: config.Duration(time.Hour),
+		},
+		Replica: config.ReplicaConfig{
+			Enabled:         true,
+			Primary:         "tcp://127.0.0.1:" + strconv.Itoa(port),
+			RefreshInterval: config.Duration(50 * time.Millisecond),
+			PreloadRanges:   []config.PreloadRange{{Type: "holding", Address: 200, Count: 100}},
+		},
+	})
+	if err := replica.Start(context.Background()); err != nil {
+		t.Fatalf("Replica start failed: %v", err)
+	}
+	defer replica.Stop(context.Background(), Shutdown{Reason: ReasonSignal})
+
+	time.Sleep(100 * time.Millisecond)
+	if state := replica.Health().State; state != string(StateStarting) {
+		t.Fatalf("Expected the replica to be starting before preload, got %s", state)
+	}
+
+	// Clients queue until the preload completes, then read the warm cache
+	replica.mu.Lock()
+	url := "tcp://" + replica.listeners[0].Addr().String()
+	replica.mu.Unlock()
+	client, err := modbus.NewClient(&modbus.ClientConfiguration{URL: url, Timeout: 3 * time.Second})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if err := client.Open(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+	read := make(chan uint16, 1)
+	go func() {
+		v, _ := client.ReadRegister(250, modbus.HOLDING_REGISTER)
+		read <- v
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	primary := newTestServer(t, &config.Config{
+		Server: config.ServerConfig{Address: "127.0.0.1", Port: port, MaxClients: 2, MaxRetries: 1},
+		Modbus: config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   300,
+			CounterAddress: 10,
+			UpdateInterval: config.Duration(time.Hour),
+			InitialData:    []config.RegisterValue{{Type: "holding", Address: 250, Value: 42}},
+		},
+	})
+	if err := primary.Start(context.Background()); err != nil {
+		t.Fatalf("Primary start failed: %v", err)
+	}
+	defer primary.Stop(context.Background(), Shutdown{Reason: ReasonSignal})
+
+	select {
+	case <-replica.replica.Preloaded():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Replica never completed the preload")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for replica.Health().State != string(StateRunning) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the replica to be running after preload, got %s", replica.Health().State)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	regs, err := replica.handler.ReadRegisters("holding", 250, 1)
+	if err != nil || regs[0] != 42 {
+		t.Fatalf("Expected the preloaded holding 250 to be 42, got %v (%v)", regs, err)
+	}
+	if v := <-read; v != 42 {
+		t.Fatalf("Expected the client queued during preload to read 42, got %d", v)
+	}
+}
+
+// TestCapabilities tests that capabilities follow the active configuration
+func TestCapabilities(t *testing.T) {
+	t.Run("Default", func(t *testing.T) {
+		s := newTestServer(t, &config.Config{
+			Modbus: config.ModbusConfig{UnitID: 1, MaxRegisters: 200, CounterAddress: 10},
+		})
+		caps := s.Capabilities()
+
+		want := []int{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x08, 0x0f, 0x10}
+		if !slices.Equal(caps.FunctionCodes, want) {
+			t.Fatalf("Expected function codes %v, got %v", want, caps.FunctionCodes)
+		}
+		if caps.MultiUnit || caps.Persistence || caps.DeviceIdentification || len(caps.FaultInjection) != 0 {
+			t.Fatalf("Expected no optional features, got %+v", caps)
+		}
+	})
+
+	t.Run("Configured", func(t *testing.T) {
+		s := newTestServer(t, &config.Config{
+			Server: config.ServerConfig{RequestTimeout: config.Duration(time.Second)},
+			Modbus: config.ModbusConfig{
+				UnitID:           1,
+				MaxRegisters:     200,
+				CounterAddress:   10,
+				VendorName:       "EZModbus",
+				Units:            []config.UnitConfig{{UnitID: 2}},
+				DelayedRegisters: []uint16{20},
+				Simulations:      []config.SimulatedRegister{{Type: "input", Address: 5, Base: 100}},
+			},
+			Replica: config.ReplicaConfig{Enabled: true, Primary: "tcp://127.0.0.1:1", RefreshInterval: config.Duration(time.Second)},
+		})
+		caps := s.Capabilities()
+
+		want := []int{0x01, 0x02, 0x03, 0x04, 0x08, 0x2b}
+		if !slices.Equal(caps.FunctionCodes, want) {
+			t.Fatalf("Expected function codes %v, got %v", want, caps.FunctionCodes)
+		}
+		if !slices.Equal(caps.Units, []int{1, 2}) || !caps.MultiUnit {
+			t.Fatalf("Expected units 1 and 2, got %v", caps.Units)
+		}
+		if !slices.Equal(caps.FaultInjection, []string{"request_timeout", "write_delay"}) {
+			t.Fatalf("Unexpected fault injection %v", caps.FaultInjection)
+		}
+		if !caps.DeviceIdentification || !caps.ReadOnly || !caps.Replica || caps.Simulations != 1 {
+			t.Fatalf("Unexpected capabilities %+v", caps)
+		}
+	})
+}
+
+// TestSimulatorSafeValues tests that stopping the server leaves simulated
+// registers at their safe values
+func TestSimulatorSafeValues(t *testing.T) {
+	safe := uint16(0)
+	s := newTestServer(t, &config.Config{
+		Server: config.ServerConfig{Address: "127.0.0.1", Port: 0, MaxClients: 1, MaxRetries: 1},
+		Modbus: config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   200,
+			CounterAddress: 10,
+			UpdateInterval: config.Duration(20 * time.Millisecond),
+			Simulations: []config.SimulatedRegister{
+				{Type: "input", Address: 20, Base: 500, SafeValue: &safe},
+				{Type: "holding", Address: 30, Base: 700, SafeValue: &safe},
+				{Type: "input", Address: 21, Base: 900},
+			},
+		},
+	})
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	// Let the simulator run so the registers hold their base values
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		regs, _ := s.handler.ReadRegisters("input", 20, 1)
+		if regs[0] == 500 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Simulator never set input 20, got %d", regs[0])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := s.Stop(context.Background(), Shutdown{Reason: ReasonSignal}); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+
+	for _, tt := range []struct {
+		regType string
+		addr    uint16
+		want    uint16
+	}{
+		{"input", 20, 0},
+		{"holding", 30, 0},
+		{"input", 21, 900},
+	} {
+		regs, _ := s.handler.ReadRegisters(tt.regType, tt.addr, 1)
+		if regs[0] != tt.want {
+			t.Fatalf("Expected %s %d to be %d after stop, got %d", tt.regType, tt.addr, tt.want, regs[0])
+		}
+	}
+}
+
+// TestCapture tests capturing a running device's registers as initial data
+func TestCapture(t *testing.T) {
+	device := newTestServer(t, &config.Config{
+		Server: config.ServerConfig{Address: "127.0.0.1", Port: 0, MaxClients: 1, MaxRetries: 1},
+		Modbus: config.ModbusConfig{
+			UnitID:         3,
+			MaxRegisters:   200,
+			CounterAddress: 10,
+			UpdateInterval: config.Duration(time.Hour),
+			InitialData: []config.RegisterValue{
+				{Type: "holding", Address: 100, Value: 2024},
+				{Type: "input", Address: 150, Value: 7},
+				{Type: "coil", Address: 2, Value: 1},
+			},
+		},
+	})
+	if err := device.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer device.Stop(context.Background(), Shutdown{Reason: ReasonSignal})
+
+	device.mu.Lock()
+	url := "tcp://" + device.listeners[0].Addr().String()
+	device.mu.Unlock()
+
+	ranges, err := handler.ParseCaptureRanges("holding:99-100,input:150,coil:0-3")
+	if err != nil {
+		t.Fatalf("Failed to parse ranges: %v", err)
+	}
+	values, err := handler.Capture(url, 3, ranges, time.Second)
+	if err != nil {
+		t.Fatalf("Capture failed: %v", err)
+	}
+
+	want := []config.RegisterValue{
+		{Type: "holding", Address: 99, Value: 0},
+		{Type: "holding", Address: 100, Value: 2024},
+		{Type: "input", Address: 150, Value: 7},
+		{Type: "coil", Address: 0, Value: 0},
+		{Type: "coil", Address: 1, Value: 0},
+		{Type: "coil", Address: 2, Value: 1},
+		{Type: "coil", Address: 3, Value: 0},
+	}
+	if len(values) != len(want) {
+		t.Fatalf("Expected %d values, got %v", len(want), values)
+	}
+	for i := range want {
+		if values[i].Type != want[i].Type || values[i].Address != want[i].Address || values[i].Value != want[i].Value {
+			t.Fatalf("Value %d: expected %+v, got %+v", i, want[i], values[i])
+		}
+	}
+
+	t.Run("OutOfRange", func(t *testing.T) {
+		ranges, _ := handler.ParseCaptureRanges("holding:190-210")
+		if _, err := handler.Capture(url, 3, ranges, time.Second); err == nil {
+			t.Fatal("Expected capture beyond the device's registers to fail")
+		}
+	})
+}
+
+// TestLifecycleState tests the state transitions from starting through
+// degraded and running to stopped, and their report on GET /health
+func TestLifecycleState(t *testing.T) {
+	// Hold the port so the first start attempt fails
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	s := newTestServer(t, &config.Config{
+		Server: config.ServerConfig{
+			Address:    "127.0.0.1",
+			Port:       busy.Addr().(*net.TCPAddr).Port,
+			MaxClients: 1,
+			MaxRetries: 5,
+			RetryDelay: 1,
+		},
+		Modbus: config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   200,
+			CounterAddress: 10,
+			UpdateInterval: config.Duration(time.Second),
+		},
+		Admin: config.AdminConfig{Enabled: true, Address: "127.0.0.1:0"},
+	})
+
+	health := func() (int, admin.Health) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.admin.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+		var h admin.Health
+		if err := json.Unmarshal(rec.Body.Bytes(), &h); err != nil {
+			t.Fatalf("Invalid health response %q: %v", rec.Body.String(), err)
+		}
+		return rec.Code, h
+	}
+
+	if state := s.State(); state != StateStarting {
+		t.Fatalf("Expected starting before Start, got %s", state)
+	}
+
+	started := make(chan error, 1)
+	go func() { started <- s.Start(context.Background()) }()
+
+	deadline := time.Now().Add(time.Second)
+	for s.State() != StateDegraded && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if code, h := health(); code != http.StatusServiceUnavailable || h.State != string(StateDegraded) {
+		t.Fatalf("Expected 503 degraded after a failed attempt, got %d %+v", code, h)
+	}
+	if m := s.MetricsSnapshot(); m.State != string(StateDegraded) || m.StateSince.IsZero() {
+		t.Fatalf("Expected degraded in metrics, got %q since %v", m.State, m.StateSince)
+	}
+
+	busy.Close()
+	select {
+	case err := <-started:
+		if err != nil {
+			t.Fatalf("Expected the retry to succeed, got %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Start did not return after the port was freed")
+	}
+	if code, h := health(); code != http.StatusOK || h.State != string(StateRunning) {
+		t.Fatalf("Expected 200 running, got %d %+v", code, h)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.Stop(ctx, Shutdown{Reason: ReasonContextCancelled}); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	if state := s.State(); state != StateStopped {
+		t.Fatalf("Expected stopped after Stop, got %s", state)
+	}
+
+	// A start attempt finishing after Stop can't revive the state
+	s.setState(StateRunning)
+	if state := s.State(); state != StateStopped {
+		t.Fatalf("Expected stopped to be final, got %s", state)
+	}
+}
+
+// TestDualStack tests listening on IPv4 and IPv6 with the "*" address
+// shorthand, and falling back to one stack when the other can't be bound
+func TestDualStack(t *testing.T) {
+	if l, err := net.Listen("tcp6", "[::1]:0"); err != nil {
+		t.Skipf("IPv6 loopback not available: %v", err)
+	} else {
+		l.Close()
+	}
+
+	start := func(t *testing.T, port int) *ModbusServer {
+		s := newTestServer(t, &config.Config{
+			Server: config.ServerConfig{Address: "*", Port: port, MaxClients: 5, MaxRetries: 1},
+			Modbus: config.ModbusConfig{
+				UnitID:         1,
+				MaxRegisters:   200,
+				CounterAddress: 10,
+				UpdateInterval: config.Duration(time.Second),
+				InitialData:    []config.RegisterValue{{Type: "holding", Address: 100, Value: 2024}},
+			},
+		})
+		if err := s.Start(context.Background()); err != nil {
+			t.Fatalf("Start failed: %v", err)
+		}
+		t.Cleanup(func() { s.Stop(context.Background(), Shutdown{Reason: ReasonSignal}) })
+		return s
+	}
+
+	read := func(t *testing.T, host, port string) error {
+		client, err := modbus.NewClient(&modbus.ClientConfiguration{
+			URL:     "tcp://" + net.JoinHostPort(host, port),
+			Timeout: time.Second,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		if err := client.Open(); err != nil {
+			return err
+		}
+		defer client.Close()
+
+		v, err := client.ReadRegister(100, modbus.HOLDING_REGISTER)
+		if err == nil && v != 2024 {
+			t.Fatalf("Expected 2024 over %s, got %d", host, v)
+		}
+		return err
+	}
+
+	t.Run("BothStacks", func(t *testing.T) {
+		s := start(t, 0)
+
+		s.mu.Lock()
+		listeners := len(s.listeners)
+		_, port, _ := net.SplitHostPort(s.listeners[0].Addr().String())
+		s.mu.Unlock()
+		if listeners != 2 {
+			t.Fatalf("Expected 2 listeners, got %d", listeners)
+		}
+
+		for _, host := range []string{"127.0.0.1", "::1"} {
+			if err := read(t, host, port); err != nil {
+				t.Fatalf("Read over %s failed: %v", host, err)
+			}
+		}
+	})
+
+	t.Run("IPv4Taken", func(t *testing.T) {
+		taken, err := net.Listen("tcp4", "0.0.0.0:0")
+		if err != nil {
+			t.Fatalf("Failed to bind IPv4 port: %v", err)
+		}
+		defer taken.Close()
+		port := taken.Addr().(*net.TCPAddr).Port
+
+		s := start(t, port)
+
+		s.mu.Lock()
+		listeners := len(s.listeners)
+		s.mu.Unlock()
+		if listeners != 1 {
+			t.Fatalf("Expected only the IPv6 listener, got %d", listeners)
+		}
+		if err := read(t, "::1", strconv.Itoa(port)); err != nil {
+			t.Fatalf("Read over IPv6 failed: %v", err)
+		}
+	})
+}
@@ -0,0 +1,115 @@
+// standby.go - Warm standby: serve only once the active peer stops answering
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// waitForTakeover probes the peer every ProbeInterval and returns once it has
+// been unreachable for LeaseTimeout. It returns ctx.Err() when cancelled.
+func (s *ModbusServer) waitForTakeover(ctx context.Context) error {
+	cfg := s.config.Standby
+	interval := time.Duration(cfg.ProbeInterval)
+	lease := time.Duration(cfg.LeaseTimeout)
+
+	p := &peerProbe{addr: cfg.Peer, unitID: s.config.Modbus.UnitID, timeout: interval}
+	defer p.close()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastSeen := time.Now()
+	reachable := true
+	for {
+		if err := p.ping(); err != nil {
+			if reachable {
+				s.logger.Warn("Standby peer unreachable", map[string]interface{}{
+					"peer":  cfg.Peer,
+					"error": err.Error(),
+				})
+				reachable = false
+			}
+			if time.Since(lastSeen) >= lease {
+				s.logger.Warn("Standby lease expired, taking over", map[string]interface{}{
+					"peer":  cfg.Peer,
+					"lease": lease.String(),
+				})
+				return nil
+			}
+		} else {
+			if !reachable {
+				s.logger.Info("Standby peer reachable again", map[string]interface{}{
+					"peer": cfg.Peer,
+				})
+				reachable = true
+			}
+			lastSeen = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// peerProbe checks that the peer is serving by sending a diagnostics
+// loopback over a connection kept open between probes.
+type peerProbe struct {
+	addr    string
+	unitID  uint8
+	timeout time.Duration
+	conn    net.Conn
+	txnID   uint16
+}
+
+func (p *peerProbe) ping() error {
+	if p.conn == nil {
+		conn, err := net.DialTimeout("tcp", p.addr, p.timeout)
+		if err != nil {
+			return err
+		}
+		p.conn = conn
+	}
+
+	if err := p.exchange(); err != nil {
+		p.close()
+		return err
+	}
+	return nil
+}
+
+func (p *peerProbe) exchange() error {
+	p.txnID++
+	p.conn.SetDeadline(time.Now().Add(p.timeout))
+
+	req := &pdu{
+		unitID:       p.unitID,
+		functionCode: fcDiagnostics,
+		payload:      []byte{0x00, 0x00, 0xbe, 0xef},
+	}
+	if err := writeFrame(p.conn, p.txnID, req); err != nil {
+		return err
+	}
+
+	// Any answer, even an exception, shows the peer is serving
+	txnID, _, err := readFrame(p.conn)
+	if err != nil {
+		return err
+	}
+	if txnID != p.txnID {
+		return fmt.Errorf("unexpected transaction ID %d, expected %d", txnID, p.txnID)
+	}
+	return nil
+}
+
+func (p *peerProbe) close() {
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+}
@@ -0,0 +1,72 @@
+// standby_test.go - Warm standby tests
+package server
+
+import (
+	"SPModbus/config"
+	"context"
+	"testing"
+	"time"
+)
+
+func listening(s *ModbusServer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.listeners) > 0
+}
+
+// TestStandbyTakeover tests that a standby instance stays passive while its
+// peer answers and takes over once the peer's lease expires
+func TestStandbyTakeover(t *testing.T) {
+	modbusCfg := config.ModbusConfig{
+		UnitID:         1,
+		MaxRegisters:   200,
+		CounterAddress: 10,
+		UpdateInterval: config.Duration(time.Second),
+	}
+
+	active := newTestServer(t, &config.Config{
+		Server: config.ServerConfig{Address: "127.0.0.1", Port: 0, MaxClients: 5, MaxRetries: 1},
+		Modbus: modbusCfg,
+	})
+	if err := active.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start active server: %v", err)
+	}
+
+	standby := newTestServer(t, &config.Config{
+		Server: config.ServerConfig{Address: "127.0.0.1", Port: 0, MaxClients: 5, MaxRetries: 1},
+		Modbus: modbusCfg,
+		Standby: config.StandbyConfig{
+			Enabled:       true,
+			Peer:          active.listeners[0].Addr().String(),
+			LeaseTimeout:  config.Duration(300 * time.Millisecond),
+			ProbeInterval: config.Duration(50 * time.Millisecond),
+		},
+	})
+	if err := standby.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start standby server: %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		standby.Stop(ctx, Shutdown{Reason: ReasonSignal})
+	}()
+
+	time.Sleep(600 * time.Millisecond)
+	if listening(standby) {
+		t.Fatalf("Expected standby to stay passive while the peer answers")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := active.Stop(ctx, Shutdown{Reason: ReasonSignal}); err != nil {
+		t.Fatalf("Failed to stop active server: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !listening(standby) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected standby to take over after the lease expired")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
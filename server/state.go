@@ -0,0 +1,88 @@
+// state.go - Loading and saving the register state file
+package server
+
+import (
+	"SPModbus/handler"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// stateFile is the on-disk format of modbus.state_file: one snapshot per
+// unit, keyed by unit ID.
+type stateFile struct {
+	Units map[string]handler.Snapshot `json:"units"`
+}
+
+// loadState merges the state file, if there is one, into every unit. A
+// missing file is the normal first run; an unreadable one is logged and the
+// units keep their initial data.
+func (s *ModbusServer) loadState() {
+	path := s.config.Modbus.StateFile
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.logger.Info("No register state file yet, using initial data", map[string]interface{}{
+			"file": path,
+		})
+		return
+	}
+
+	var state stateFile
+	if err == nil {
+		err = json.Unmarshal(data, &state)
+	}
+	if err != nil {
+		s.logger.Error("Failed to load register state, using initial data", map[string]interface{}{
+			"file":  path,
+			"error": err.Error(),
+		})
+		return
+	}
+
+	for id, h := range s.units {
+		if snap, ok := state.Units[strconv.Itoa(int(id))]; ok {
+			h.LoadState(snap)
+		}
+	}
+}
+
+// saveState writes the register state of every unit. The file is replaced
+// atomically so a crash mid-write leaves the previous state intact.
+func (s *ModbusServer) saveState() error {
+	path := s.config.Modbus.StateFile
+	state := stateFile{Units: make(map[string]handler.Snapshot, len(s.units))}
+	for id, h := range s.units {
+		state.Units[strconv.Itoa(int(id))] = h.Snapshot()
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode register state: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to save register state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save register state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save register state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to save register state: %w", err)
+	}
+
+	s.logger.Info("Register state saved", map[string]interface{}{
+		"file":  path,
+		"units": len(state.Units),
+	})
+	return nil
+}
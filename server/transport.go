@@ -0,0 +1,245 @@
+// transport.go - Modbus TCP listener and MBAP framing
+package server
+
+import (
+	"SPModbus/admin"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	mbapHeaderLength = 7
+	maxPDULength     = 253
+)
+
+type pdu struct {
+	unitID       uint8
+	functionCode uint8
+	payload      []byte
+}
+
+// clientConn wraps an accepted connection with the activity shown by the
+// admin API. The counters are updated by the connection's own goroutine and
+// read concurrently, hence atomic.
+type clientConn struct {
+	net.Conn
+	connectedAt  time.Time
+	lastActivity atomic.Int64 // unix nanoseconds
+	requests     atomic.Uint64
+}
+
+func newClientConn(conn net.Conn) *clientConn {
+	c := &clientConn{Conn: conn, connectedAt: time.Now()}
+	c.lastActivity.Store(c.connectedAt.UnixNano())
+	return c
+}
+
+// closeAfter closes the connection once d has passed since it was accepted,
+// calling onClose first. The returned timer must be stopped when the
+// connection ends on its own.
+func (c *clientConn) closeAfter(d time.Duration, onClose func()) *time.Timer {
+	return time.AfterFunc(d-time.Since(c.connectedAt), func() {
+		onClose()
+		c.Close()
+	})
+}
+
+// served records a request received on the connection and returns the time
+// since the previous request, with repeat false for the first one.
+func (c *clientConn) served() (gap time.Duration, repeat bool) {
+	now := time.Now()
+	prev := c.lastActivity.Swap(now.UnixNano())
+	return now.Sub(time.Unix(0, prev)), c.requests.Add(1) > 1
+}
+
+func (c *clientConn) info() admin.ConnectionInfo {
+	return admin.ConnectionInfo{
+		Remote:       c.RemoteAddr().String(),
+		ConnectedAt:  c.connectedAt,
+		LastActivity: time.Unix(0, c.lastActivity.Load()),
+		Requests:     c.requests.Load(),
+	}
+}
+
+// acceptClients accepts connections until the listener is closed. The accept
+// loop lives here rather than in the modbus library so the server can observe
+// connection lifecycle events.
+func (s *ModbusServer) acceptClients(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.logger.Warn("Failed to accept client connection", map[string]interface{}{
+				"error": err.Error(),
+			})
+			continue
+		}
+
+		if !s.filter.allowed(conn.RemoteAddr()) {
+			s.logger.Warn("Client IP not allowed, rejecting connection", map[string]interface{}{
+				"client": conn.RemoteAddr().String(),
+			})
+			conn.Close()
+			continue
+		}
+
+		client := newClientConn(conn)
+		s.mu.Lock()
+		accepted := uint(len(s.clients)+s.tunnels) < s.config.Server.MaxClients
+		if accepted {
+			s.clients[client] = struct{}{}
+		}
+		s.mu.Unlock()
+
+		if !accepted {
+			s.logger.Warn("Max clients reached, rejecting connection", map[string]interface{}{
+				"client": conn.RemoteAddr().String(),
+				"max":    s.config.Server.MaxClients,
+			})
+			conn.Close()
+			continue
+		}
+
+		go s.handleClient(client)
+	}
+}
+
+// handleClient serves requests from a single connection until it is closed,
+// times out or sends a malformed frame.
+func (s *ModbusServer) handleClient(conn *clientConn) {
+	clientAddr := conn.RemoteAddr().String()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, conn)
+		s.mu.Unlock()
+		conn.Close()
+		for _, h := range s.units {
+			h.OnDisconnect(clientAddr)
+		}
+
+		s.logger.Debug("Client disconnected", map[string]interface{}{
+			"client": clientAddr,
+		})
+	}()
+
+	s.logger.Debug("Client connected", map[string]interface{}{
+		"client": clientAddr,
+	})
+	for _, h := range s.units {
+		h.OnConnect(clientAddr)
+	}
+
+	if limit := time.Duration(s.config.Server.MaxConnectionDuration); limit > 0 {
+		timer := conn.closeAfter(limit, func() {
+			s.logger.Info("Connection reached max duration, closing", map[string]interface{}{
+				"client":   clientAddr,
+				"duration": limit.String(),
+				"requests": conn.requests.Load(),
+			})
+		})
+		defer timer.Stop()
+	}
+
+	timeout := time.Duration(s.config.Server.Timeout) * time.Second
+	minInterval := time.Duration(s.config.Server.MinInterval)
+
+	for {
+		if timeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(timeout))
+		}
+
+		txnID, req, err := readFrame(conn)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				s.logger.Debug("Closing client connection", map[string]interface{}{
+					"client": clientAddr,
+					"reason": err.Error(),
+				})
+			}
+			return
+		}
+		gap, repeat := conn.served()
+
+		// Like a slow device, answer busy when polled faster than MinInterval.
+		// Rejected requests still count as the previous request.
+		var res *pdu
+		if minInterval > 0 && repeat && gap < minInterval {
+			s.unitHandler(req.unitID).RecordError()
+			s.logger.Debug("Request too soon after the previous one", map[string]interface{}{
+				"client":       clientAddr,
+				"gap":          gap.String(),
+				"min_interval": minInterval.String(),
+			})
+			res = exceptionResponse(req, exServerDeviceBusy)
+		} else if res, err = s.dispatch(clientAddr, req); err != nil {
+			s.logger.Warn("Protocol error, closing connection", map[string]interface{}{
+				"client":   clientAddr,
+				"function": req.functionCode,
+				"error":    err.Error(),
+			})
+			return
+		}
+
+		if err := writeFrame(conn, txnID, res); err != nil {
+			s.logger.Warn("Failed to write response", map[string]interface{}{
+				"client": clientAddr,
+				"error":  err.Error(),
+			})
+			return
+		}
+	}
+}
+
+// readFrame reads one MBAP-framed request and returns its transaction ID and PDU.
+func readFrame(r io.Reader) (uint16, *pdu, error) {
+	header := make([]byte, mbapHeaderLength)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+
+	txnID := binary.BigEndian.Uint16(header[0:2])
+	protocolID := binary.BigEndian.Uint16(header[2:4])
+	length := int(binary.BigEndian.Uint16(header[4:6]))
+
+	if protocolID != 0 {
+		return 0, nil, fmt.Errorf("unknown protocol identifier %d", protocolID)
+	}
+
+	// length covers the unit ID, function code and payload
+	if length < 2 || length > maxPDULength+1 {
+		return 0, nil, fmt.Errorf("invalid MBAP length %d", length)
+	}
+
+	body := make([]byte, length-1)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+
+	return txnID, &pdu{
+		unitID:       header[6],
+		functionCode: body[0],
+		payload:      body[1:],
+	}, nil
+}
+
+// writeFrame writes a PDU wrapped in an MBAP header.
+func writeFrame(w io.Writer, txnID uint16, p *pdu) error {
+	frame := make([]byte, mbapHeaderLength, mbapHeaderLength+1+len(p.payload))
+	binary.BigEndian.PutUint16(frame[0:2], txnID)
+	binary.BigEndian.PutUint16(frame[2:4], 0)
+	binary.BigEndian.PutUint16(frame[4:6], uint16(2+len(p.payload)))
+	frame[6] = p.unitID
+	frame = append(frame, p.functionCode)
+	frame = append(frame, p.payload...)
+
+	_, err := w.Write(frame)
+	return err
+}
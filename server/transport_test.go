@@ -0,0 +1,157 @@
+// transport_test.go - MBAP framing tests
+package server
+
+import (
+	"SPModbus/config"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestReadFrame tests MBAP header validation and short reads
+func TestReadFrame(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		txnID, req, err := readFrame(bytes.NewReader([]byte{0xbe, 0xef, 0x00, 0x00, 0x00, 0x06, 0x05, 0x03, 0x00, 0x14, 0x00, 0x02}))
+		if err != nil {
+			t.Fatalf("Expected a valid frame, got %v", err)
+		}
+		if txnID != 0xbeef {
+			t.Fatalf("Expected transaction ID 0xbeef, got %#x", txnID)
+		}
+		if req.unitID != 5 || req.functionCode != fcReadHoldingRegisters || !bytes.Equal(req.payload, []byte{0x00, 0x14, 0x00, 0x02}) {
+			t.Fatalf("Unexpected PDU unit=%d fc=%#x payload=%x", req.unitID, req.functionCode, req.payload)
+		}
+	})
+
+	for name, frame := range map[string][]byte{
+		"ProtocolID": {0x00, 0x01, 0x00, 0x05, 0x00, 0x06, 0x01, 0x03, 0x00, 0x14, 0x00, 0x02},
+		"LengthZero": {0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x01},
+		"LengthOne":  {0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x01},
+		"Length255":  {0x00, 0x01, 0x00, 0x00, 0x00, 0xff, 0x01},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, _, err := readFrame(bytes.NewReader(frame)); err == nil || errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Fatalf("Expected the header to be rejected, got %v", err)
+			}
+		})
+	}
+
+	t.Run("Length254", func(t *testing.T) {
+		frame := append([]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0xfe, 0x01, 0x10}, make([]byte, 252)...)
+		if _, req, err := readFrame(bytes.NewReader(frame)); err != nil || len(req.payload) != 252 {
+			t.Fatalf("Expected the largest frame to be read, got %v", err)
+		}
+	})
+
+	t.Run("ShortHeader", func(t *testing.T) {
+		_, _, err := readFrame(bytes.NewReader([]byte{0x00, 0x01, 0x00, 0x00}))
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("Expected ErrUnexpectedEOF, got %v", err)
+		}
+	})
+
+	t.Run("ShortBody", func(t *testing.T) {
+		_, _, err := readFrame(bytes.NewReader([]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00}))
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("Expected ErrUnexpectedEOF, got %v", err)
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		if _, _, err := readFrame(bytes.NewReader(nil)); !errors.Is(err, io.EOF) {
+			t.Fatalf("Expected EOF, got %v", err)
+		}
+	})
+}
+
+// TestWriteFrame tests that the MBAP header echoes the transaction ID and
+// covers the unit ID, function code and payload
+func TestWriteFrame(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeFrame(&buf, 0xbeef, &pdu{unitID: 5, functionCode: 0x83, payload: []byte{exIllegalDataAddress}}); err != nil {
+		t.Fatalf("Failed to write frame: %v", err)
+	}
+	want := []byte{0xbe, 0xef, 0x00, 0x00, 0x00, 0x03, 0x05, 0x83, exIllegalDataAddress}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("Expected frame % x, got % x", want, buf.Bytes())
+	}
+}
+
+// TestTCPFraming tests framing on a live connection: responses echo the
+// request's transaction ID and a malformed header closes the connection
+func TestTCPFraming(t *testing.T) {
+	s := newTestServer(t, &config.Config{
+		Server: config.ServerConfig{
+			Address:    "127.0.0.1",
+			Port:       0,
+			MaxClients: 2,
+			MaxRetries: 1,
+		},
+		Modbus: config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   200,
+			CounterAddress: 10,
+			UpdateInterval: config.Duration(time.Second),
+			InitialData:    []config.RegisterValue{{Type: "holding", Address: 20, Value: 0x1234}},
+		},
+	})
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer s.Stop(context.Background(), Shutdown{Reason: ReasonSignal})
+
+	s.mu.Lock()
+	addr := s.listeners[0].Addr().String()
+	s.mu.Unlock()
+
+	dial := func(t *testing.T) net.Conn {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		return conn
+	}
+
+	t.Run("TransactionID", func(t *testing.T) {
+		conn := dial(t)
+		for _, txnID := range []byte{0x01, 0x7f} {
+			conn.Write([]byte{0xab, txnID, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x14, 0x00, 0x01})
+			res := make([]byte, 11)
+			if _, err := io.ReadFull(conn, res); err != nil {
+				t.Fatalf("Failed to read response: %v", err)
+			}
+			want := []byte{0xab, txnID, 0x00, 0x00, 0x00, 0x05, 0x01, 0x03, 0x02, 0x12, 0x34}
+			if !bytes.Equal(res, want) {
+				t.Fatalf("Expected response % x, got % x", want, res)
+			}
+		}
+	})
+
+	t.Run("SplitFrame", func(t *testing.T) {
+		conn := dial(t)
+		conn.Write([]byte{0x00, 0x09, 0x00, 0x00})
+		time.Sleep(50 * time.Millisecond)
+		conn.Write([]byte{0x00, 0x06, 0x01, 0x03, 0x00, 0x14, 0x00, 0x01})
+		res := make([]byte, 11)
+		if _, err := io.ReadFull(conn, res); err != nil {
+			t.Fatalf("Expected a response to a frame split across writes, got %v", err)
+		}
+		if res[1] != 0x09 {
+			t.Fatalf("Expected transaction ID 9, got %d", res[1])
+		}
+	})
+
+	t.Run("BadProtocolID", func(t *testing.T) {
+		conn := dial(t)
+		conn.Write([]byte{0x00, 0x01, 0x00, 0x05, 0x00, 0x06, 0x01, 0x03, 0x00, 0x14, 0x00, 0x01})
+		if n, err := conn.Read(make([]byte, 16)); err == nil {
+			t.Fatalf("Expected the connection to be closed, got %d bytes", n)
+		}
+	})
+}
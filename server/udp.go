@@ -0,0 +1,85 @@
+// udp.go - Modbus over UDP, one MBAP frame per datagram
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// serveUDP answers requests arriving as UDP datagrams until conn is closed.
+// Each datagram carries one MBAP frame and is answered with one datagram.
+// Datagrams are handled concurrently, at most max_clients at a time; when
+// all workers are busy, reading waits and further datagrams queue in the
+// socket buffer. UDP has no connections, so min_interval, timeout and
+// max_connection_duration don't apply; the IP filter does.
+func (s *ModbusServer) serveUDP(conn net.PacketConn) {
+	workers := make(chan struct{}, max(s.config.Server.MaxClients, 1))
+	for {
+		// One byte past the largest frame, so longer datagrams are detected
+		// instead of being truncated to a valid frame
+		buf := make([]byte, mbapHeaderLength+maxPDULength+1)
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.logger.Warn("Failed to read datagram", map[string]interface{}{
+				"error": err.Error(),
+			})
+			continue
+		}
+
+		if !s.filter.allowed(addr) {
+			s.logger.Warn("Client IP not allowed, dropping datagram", map[string]interface{}{
+				"client": addr.String(),
+			})
+			continue
+		}
+
+		workers <- struct{}{}
+		go func() {
+			defer func() { <-workers }()
+			s.answerDatagram(conn, addr, buf[:n])
+		}()
+	}
+}
+
+// answerDatagram answers one datagram. Datagrams that aren't exactly one MBAP
+// frame are dropped.
+func (s *ModbusServer) answerDatagram(conn net.PacketConn, addr net.Addr, datagram []byte) {
+	clientAddr := addr.String()
+
+	r := bytes.NewReader(datagram)
+	txnID, req, err := readFrame(r)
+	if err == nil && r.Len() > 0 {
+		err = fmt.Errorf("%d bytes after the MBAP frame", r.Len())
+	}
+	if err != nil {
+		s.logger.Debug("Dropping malformed datagram", map[string]interface{}{
+			"client": clientAddr,
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	res, err := s.dispatch(clientAddr, req)
+	if err != nil {
+		s.logger.Warn("Protocol error, dropping datagram", map[string]interface{}{
+			"client":   clientAddr,
+			"function": req.functionCode,
+			"error":    err.Error(),
+		})
+		return
+	}
+
+	var frame bytes.Buffer
+	writeFrame(&frame, txnID, res)
+	if _, err := conn.WriteTo(frame.Bytes(), addr); err != nil && !errors.Is(err, net.ErrClosed) {
+		s.logger.Warn("Failed to write response", map[string]interface{}{
+			"client": clientAddr,
+			"error":  err.Error(),
+		})
+	}
+}
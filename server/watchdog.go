@@ -0,0 +1,66 @@
+// watchdog.go - Detection of a stalled register updater
+package server
+
+import (
+	"context"
+	"time"
+)
+
+// updaterStallIntervals is how many update intervals the updater may miss
+// before it is reported as stuck.
+const updaterStallIntervals = 5
+
+// runUpdaterWatchdog warns when the register updater hasn't completed a tick
+// for updaterStallIntervals update intervals, which otherwise only shows as a
+// counter that silently stops advancing.
+func (s *ModbusServer) runUpdaterWatchdog(ctx context.Context) {
+	interval := time.Duration(s.config.Modbus.UpdateInterval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	stalled := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			stalled = s.checkUpdater(now, interval*updaterStallIntervals, stalled)
+		}
+	}
+}
+
+// checkUpdater logs a stall once when the last updater tick is older than
+// threshold, and the recovery once it ticks again. It returns whether the
+// updater is stalled.
+func (s *ModbusServer) checkUpdater(now time.Time, threshold time.Duration, stalled bool) bool {
+	last := s.updated.Load()
+	if last == 0 {
+		// Not started yet, e.g. during the counter start delay
+		return false
+	}
+
+	since := now.Sub(time.Unix(0, last))
+	if since <= threshold {
+		if stalled {
+			s.logger.Info("Register updater recovered", nil)
+		}
+		return false
+	}
+	if stalled {
+		return true
+	}
+
+	cause := "updater goroutine not scheduled"
+	for _, h := range s.units {
+		if h.LockContended() {
+			cause = "register lock held, e.g. by a slow request"
+			break
+		}
+	}
+	s.logger.Warn("Register updater stuck, counter not advancing", map[string]interface{}{
+		"since":           since.Round(time.Millisecond).String(),
+		"update_interval": time.Duration(s.config.Modbus.UpdateInterval).String(),
+		"suspected_cause": cause,
+	})
+	return true
+}
@@ -0,0 +1,85 @@
+// websocket.go - Modbus/TCP frames tunneled through the admin API
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+)
+
+// OpenTunnel admits a WebSocket client of the admin API as a Modbus client.
+// Tunnels count against max_clients together with the Modbus listener's
+// connections, and the admin API applies timeout to them; min_interval and
+// max_connection_duration don't apply. The IP filter does, and a replica
+// refuses tunnels until its preload is complete.
+func (s *ModbusServer) OpenTunnel(clientAddr string) error {
+	addr, err := net.ResolveTCPAddr("tcp", clientAddr)
+	if err != nil || !s.filter.allowed(addr) {
+		s.logger.Warn("Client IP not allowed, refusing tunnel", map[string]interface{}{
+			"client": clientAddr,
+		})
+		return fmt.Errorf("client %s not allowed", clientAddr)
+	}
+
+	if s.replica != nil && !s.replica.Ready() {
+		return fmt.Errorf("replica preload pending")
+	}
+
+	s.mu.Lock()
+	accepted := uint(len(s.clients)+s.tunnels) < s.config.Server.MaxClients
+	if accepted {
+		s.tunnels++
+	}
+	s.mu.Unlock()
+
+	if !accepted {
+		s.logger.Warn("Max clients reached, refusing tunnel", map[string]interface{}{
+			"client": clientAddr,
+			"max":    s.config.Server.MaxClients,
+		})
+		return fmt.Errorf("max clients reached")
+	}
+
+	for _, h := range s.units {
+		h.OnConnect(clientAddr)
+	}
+	return nil
+}
+
+// HandleFrame answers one MBAP frame received over a tunnel. Anything after
+// the frame is an error, as each message carries exactly one.
+func (s *ModbusServer) HandleFrame(clientAddr string, frame []byte) ([]byte, error) {
+	r := bytes.NewReader(frame)
+	txnID, req, err := readFrame(r)
+	if err != nil {
+		return nil, fmt.Errorf("malformed frame: %w", err)
+	}
+	if r.Len() > 0 {
+		return nil, fmt.Errorf("%d bytes after the frame", r.Len())
+	}
+
+	res, err := s.dispatch(clientAddr, req)
+	if err != nil {
+		s.logger.Warn("Protocol error, closing tunnel", map[string]interface{}{
+			"client":   clientAddr,
+			"function": req.functionCode,
+			"error":    err.Error(),
+		})
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	writeFrame(&out, txnID, res)
+	return out.Bytes(), nil
+}
+
+// CloseTunnel ends a tunnel's client session.
+func (s *ModbusServer) CloseTunnel(clientAddr string) {
+	s.mu.Lock()
+	s.tunnels--
+	s.mu.Unlock()
+
+	for _, h := range s.units {
+		h.OnDisconnect(clientAddr)
+	}
+}
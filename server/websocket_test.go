@@ -0,0 +1,219 @@
+package server
+
+import (
+	"SPModbus/config"
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wsClient is a minimal WebSocket client sending masked binary frames.
+type wsClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialWebSocket(t *testing.T, url, path string) *wsClient {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req, _ := http.NewRequest(http.MethodGet, url+path, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Accept-Encoding", "gzip")
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("Failed to send handshake: %v", err)
+	}
+
+	r := bufio.NewReader(conn)
+	res, err := http.ReadResponse(r, req)
+	if err != nil {
+		t.Fatalf("Failed to read handshake: %v", err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected 101, got %d", res.StatusCode)
+	}
+	sum := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	if accept := res.Header.Get("Sec-WebSocket-Accept"); accept != base64.StdEncoding.EncodeToString(sum[:]) {
+		t.Fatalf("Unexpected Sec-WebSocket-Accept %q", accept)
+	}
+	return &wsClient{conn: conn, r: r}
+}
+
+func (c *wsClient) send(t *testing.T, op byte, payload []byte) {
+	t.Helper()
+
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	frame := []byte{0x80 | op, 0x80 | byte(len(payload))}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		t.Fatalf("Failed to send frame: %v", err)
+	}
+}
+
+func (c *wsClient) receive(t *testing.T) (byte, []byte) {
+	t.Helper()
+
+	var header [2]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		t.Fatalf("Failed to read frame: %v", err)
+	}
+	length := int(header[1] & 0x7f)
+	if length == 126 {
+		var ext [2]byte
+		io.ReadFull(c.r, ext[:])
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		t.Fatalf("Failed to read payload: %v", err)
+	}
+	return header[0] & 0x0f, payload
+}
+
+// TestWebSocket tests Modbus/TCP frames tunneled over the admin API's
+// WebSocket endpoint
+func TestWebSocket(t *testing.T) {
+	s := newTestServer(t, &config.Config{
+		Modbus: config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   200,
+			CounterAddress: 10,
+			InitialData: []config.RegisterValue{
+				{Type: "holding", Address: 20, Value: 0x1234},
+				{Type: "holding", Address: 21, Value: 0xabcd},
+			},
+		},
+		Server: config.ServerConfig{MaxClients: 10},
+		Admin:  config.AdminConfig{Enabled: true, Address: "127.0.0.1:0", WebSocketPath: "/modbus"},
+	})
+	ts := httptest.NewServer(s.admin.Handler())
+	defer ts.Close()
+
+	t.Run("ReadHolding", func(t *testing.T) {
+		c := dialWebSocket(t, ts.URL, "/modbus")
+
+		// Read Holding Registers 20-21 of unit 1, transaction 0x0102
+		c.send(t, 0x2, []byte{0x01, 0x02, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x14, 0x00, 0x02})
+		op, frame := c.receive(t)
+		if op != 0x2 {
+			t.Fatalf("Expected a binary message, got opcode %d", op)
+		}
+		want := []byte{0x01, 0x02, 0x00, 0x00, 0x00, 0x07, 0x01, 0x03, 0x04, 0x12, 0x34, 0xab, 0xcd}
+		if !bytes.Equal(frame, want) {
+			t.Fatalf("Expected response frame % x, got % x", want, frame)
+		}
+	})
+
+	t.Run("Exception", func(t *testing.T) {
+		c := dialWebSocket(t, ts.URL, "/modbus")
+
+		c.send(t, 0x2, []byte{0x00, 0x07, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x01, 0x00, 0x00, 0x01})
+		_, frame := c.receive(t)
+		want := []byte{0x00, 0x07, 0x00, 0x00, 0x00, 0x03, 0x01, 0x83, exIllegalDataAddress}
+		if !bytes.Equal(frame, want) {
+			t.Fatalf("Expected exception frame % x, got % x", want, frame)
+		}
+	})
+
+	t.Run("Ping", func(t *testing.T) {
+		c := dialWebSocket(t, ts.URL, "/modbus")
+
+		c.send(t, 0x9, []byte("hi"))
+		if op, payload := c.receive(t); op != 0xa || string(payload) != "hi" {
+			t.Fatalf("Expected a pong echoing the ping, got opcode %d %q", op, payload)
+		}
+	})
+
+	t.Run("MalformedFrame", func(t *testing.T) {
+		c := dialWebSocket(t, ts.URL, "/modbus")
+
+		c.send(t, 0x2, []byte{0x00, 0x01, 0x00, 0x05, 0x00, 0x06, 0x01, 0x03, 0x00, 0x14, 0x00, 0x02})
+		op, payload := c.receive(t)
+		if op != 0x8 || len(payload) < 2 || binary.BigEndian.Uint16(payload) != 1007 {
+			t.Fatalf("Expected a close with status 1007, got opcode %d % x", op, payload)
+		}
+	})
+
+	t.Run("NotUpgraded", func(t *testing.T) {
+		res, err := http.Get(ts.URL + "/modbus")
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected 400 without an upgrade, got %d", res.StatusCode)
+		}
+	})
+}
+
+// TestWebSocketLimits tests that tunnels count against max_clients and are
+// closed after the server's timeout without a request
+func TestWebSocketLimits(t *testing.T) {
+	s := newTestServer(t, &config.Config{
+		Modbus: config.ModbusConfig{
+			UnitID:         1,
+			MaxRegisters:   200,
+			CounterAddress: 10,
+		},
+		Server: config.ServerConfig{MaxClients: 1, Timeout: 1},
+		Admin:  config.AdminConfig{Enabled: true, Address: "127.0.0.1:0", WebSocketPath: "/modbus"},
+	})
+	ts := httptest.NewServer(s.admin.Handler())
+	defer ts.Close()
+
+	c := dialWebSocket(t, ts.URL, "/modbus")
+
+	t.Run("MaxClients", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/modbus", nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusForbidden {
+			t.Fatalf("Expected 403 with max_clients tunnels open, got %d", res.StatusCode)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		c.conn.SetDeadline(time.Now().Add(3 * time.Second))
+		op, payload := c.receive(t)
+		if op != 0x8 || len(payload) < 2 || binary.BigEndian.Uint16(payload) != 1001 {
+			t.Fatalf("Expected a close with status 1001 after the timeout, got opcode %d % x", op, payload)
+		}
+
+		// The closed tunnel no longer counts against max_clients
+		time.Sleep(50 * time.Millisecond)
+		dialWebSocket(t, ts.URL, "/modbus")
+	})
+}
@@ -0,0 +1,44 @@
+// testhelpers.go - Assertions on multi-register values for tests
+package testhelpers
+
+import (
+	"math"
+	"testing"
+)
+
+// Float32 decodes a register pair, high word first.
+func Float32(words []uint16) float32 {
+	return math.Float32frombits(uint32(words[0])<<16 | uint32(words[1]))
+}
+
+// Int32 decodes a register pair, high word first.
+func Int32(words []uint16) int32 {
+	return int32(uint32(words[0])<<16 | uint32(words[1]))
+}
+
+// AssertFloat32 fails the test unless words holds exactly two registers
+// encoding a float32 within tolerance of want.
+func AssertFloat32(t testing.TB, words []uint16, want float32, tolerance float64) {
+	t.Helper()
+
+	if len(words) != 2 {
+		t.Fatalf("Expected 2 registers for a float32, got %d", len(words))
+	}
+	got := Float32(words)
+	if math.IsNaN(float64(got)) || math.Abs(float64(got)-float64(want)) > tolerance {
+		t.Fatalf("Expected float32 %v (±%v), got %v from registers %#04x %#04x", want, tolerance, got, words[0], words[1])
+	}
+}
+
+// AssertInt32 fails the test unless words holds exactly two registers
+// encoding want.
+func AssertInt32(t testing.TB, words []uint16, want int32) {
+	t.Helper()
+
+	if len(words) != 2 {
+		t.Fatalf("Expected 2 registers for an int32, got %d", len(words))
+	}
+	if got := Int32(words); got != want {
+		t.Fatalf("Expected int32 %d, got %d from registers %#04x %#04x", want, got, words[0], words[1])
+	}
+}